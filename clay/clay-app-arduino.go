@@ -49,11 +49,16 @@ func ParseArchBoardNameAndMax() (string, string, int) {
 	return arch, boardName, matches
 }
 
+const (
+	defaultSerialPort = "/dev/ttyUSB0"
+	defaultSerialBaud = 115200
+)
+
 func ParsePortAndBaud() (string, int) {
 	var port string
 	var baud int
-	flag.StringVar(&port, "p", "/dev/ttyUSB0", "Serial port (e.g. /dev/ttyUSB0)")
-	flag.IntVar(&baud, "b", 115200, "Baud rate (e.g. 115200)")
+	flag.StringVar(&port, "p", defaultSerialPort, "Serial port (e.g. /dev/ttyUSB0)")
+	flag.IntVar(&baud, "b", defaultSerialBaud, "Baud rate (e.g. 115200)")
 	flag.Parse()
 	return port, baud
 }
@@ -0,0 +1,53 @@
+package clay
+
+import (
+	"fmt"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/objcache"
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// Cache runs a compilation cache command, 'stats' prints the statistics and 'clear'
+// removes all cached files.
+func (a *App) Cache(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("cache requires a command (stats or clear)")
+	}
+
+	cache, err := objcache.Open(objcache.DefaultDirpath(), objcache.DefaultMaxSizeFromEnv())
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "stats":
+		stats, numFiles := cache.Stats()
+		corepkg.LogInfof("Cache directory: %s", cache.Dirpath())
+		corepkg.LogInfof("Cache size:      %s (%d files, max %s)", formatSize(stats.Size), numFiles, formatSize(cache.MaxSize()))
+		corepkg.LogInfof("Hits:            %d", stats.Hits)
+		corepkg.LogInfof("Misses:          %d", stats.Misses)
+		corepkg.LogInfof("Hit rate:        %.1f%%", stats.HitRate())
+		corepkg.LogInfof("Stored:          %d", stats.Stores)
+		corepkg.LogInfof("Evicted:         %d", stats.Evictions)
+	case "clear":
+		if err := cache.Clear(); err != nil {
+			return err
+		}
+		corepkg.LogInfof("Cleared the compilation cache in %s", cache.Dirpath())
+	default:
+		return fmt.Errorf("unknown cache command %q (stats or clear)", args[0])
+	}
+	return nil
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1024*1024*1024:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1024*1024*1024))
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%d B", size)
+}
@@ -7,9 +7,15 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/deptrackr"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/diagnostics"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/objcache"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/worker"
 	corepkg "github.com/jurgen-kluft/go-core"
 	"github.com/jurgen-kluft/go-ide/denv"
 )
@@ -24,6 +30,7 @@ type AppConfig struct {
 	TargetArch  string `json:"arch,omitempty"`
 	TargetBuild string `json:"build,omitempty"`
 	TargetBoard string `json:"board,omitempty"`
+	Toolchain   string `json:"toolchain,omitempty"` // Custom toolchain descriptor, a file or a name (empty = the toolchain of the target)
 }
 
 func (c *AppConfig) Equal(other *AppConfig) bool {
@@ -31,26 +38,71 @@ func (c *AppConfig) Equal(other *AppConfig) bool {
 		c.TargetOs == other.TargetOs &&
 		c.TargetArch == other.TargetArch &&
 		c.TargetBuild == other.TargetBuild &&
-		c.TargetBoard == other.TargetBoard
+		c.TargetBoard == other.TargetBoard &&
+		c.Toolchain == other.Toolchain
+}
+
+// BuildOptions are command-line options of the build command, they are not
+// persisted in clay.json.
+type BuildOptions struct {
+	Jobs         int                   // Maximum number of files to compile in parallel (0 = number of CPUs)
+	ContentHash  bool                  // Detect changed files by their content instead of their modification time
+	Cache        bool                  // Use the compilation cache that is shared by all build directories
+	CompileDb    bool                  // Also write the compilation database (build/compile_commands.json)
+	KeepGoing    bool                  // Keep building the projects that do not depend on a project that failed
+	LogFormat    string                // 'text' (default) or 'json', json writes the build events to stdout
+	EventsPath   string                // Write the build events as JSON lines to this file (empty = no file)
+	Explain      bool                  // Report why objects, archives and executables are out of date
+	Unity        int                   // Combine the C++ source files of a project into this many unity files (0 = disabled, -1 = the setting of the project)
+	UnityExclude string                // Comma separated globs of source files that are not part of a unity file
+	Sanitize     []toolchain.Sanitizer // Compile and link with these sanitizers, they have their own build directory
+	Coverage     bool                  // Compile and link with coverage instrumentation (clay coverage), it has its own build directory
+	TracePath    string                // Write the spans of the build as a Chrome trace to this file (empty = no trace)
+	Slowest      int                   // List this many of the slowest compiled files at the end of a build (0 = none)
+	TimeTrace    bool                  // Let clang write a time trace per source file (-ftime-trace)
+	Workers      []string              // Addresses (host:port) of the workers that compile the source files (empty = compile locally)
+	SarifPath    string                // Write the errors and warnings of a build as SARIF 2.1 to this file (empty = no file)
+	MaxWarnings  int                   // Fail the build when it has more warnings than this (-1 = no limit)
 }
 
 type App struct {
-	Pkg         *denv.Package
-	PkgVars     *corepkg.Vars
-	Config      *AppConfig
-	BuildTarget denv.BuildTarget
-	BuildConfig denv.BuildConfig
+	Pkg             *denv.Package
+	PkgVars         *corepkg.Vars
+	Config          *AppConfig
+	Options         BuildOptions
+	TestOptions     TestOptions
+	WatchOptions    WatchOptions
+	CoverageOptions CoverageOptions
+	DepsOptions     DepsOptions
+	BuildTarget     denv.BuildTarget
+	BuildConfig     denv.BuildConfig
+	customToolchain *toolchain.CustomToolchainDescriptor // The toolchain descriptor selected with --toolchain (nil = the toolchain of the target)
+	eventsOpen      bool                                 // The build event reporters are opened (once)
+	coverage        toolchain.CoverageCollector          // Collects the coverage of the unittests that run (clay coverage)
+	trace           *events.TraceReporter                // Collects the spans of the build, with --trace or --slowest
+	diagnostics     *diagnostics.Collector               // Collects the errors and warnings of the compile and link steps of the build
 }
 
 func NewApp(pkg *denv.Package) *App {
-	return &App{Pkg: pkg, Config: &AppConfig{}}
+	return &App{Pkg: pkg, Config: &AppConfig{}, Options: BuildOptions{Unity: -1}}
 }
 
 func GetBuildDirname(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) string {
 	return buildTarget.Os().String() + "-" + buildTarget.Arch().String() + "-" + buildConfig.String()
 }
 
+// GetBuildPath returns the build directory of a config and target (subdir), a build with
+// sanitizers gets its own directory, e.g. 'linux-x64-debug-asan-ubsan', as does a build
+// with coverage instrumentation, e.g. 'linux-x64-debug-test-cov', or with a custom
+// toolchain, e.g. 'linux-arm64-debug-aarch64-linux-gnu'.
 func (a *App) GetBuildPath(subdir string) string {
+	if a.customToolchain != nil {
+		subdir += "-" + a.customToolchain.Name
+	}
+	subdir += toolchain.SanitizersSuffix(a.Options.Sanitize)
+	if a.Options.Coverage {
+		subdir += toolchain.CoverageSuffix
+	}
 	var buildPath string
 	if len(a.Config.TargetBoard) > 0 {
 		buildPath = filepath.Join("build", subdir, a.Config.TargetBoard)
@@ -112,10 +164,34 @@ func ClayAppMain(pkg *denv.Package) {
 	case "generate":
 		err = app.Generate(os.Args[1:])
 	case "build":
-		ParseProjectNameAndConfig(app)
+		ParseBuildOptionsAndConfig(app)
 		if !app.Build() {
 			err = fmt.Errorf("build failed")
 		}
+	case "run":
+		ParseBuildOptionsAndConfig(app)
+		var exitCode int
+		if exitCode, err = app.Run(flag.Args()); err == nil && exitCode != 0 {
+			exit(exitCode)
+		}
+	case "test":
+		ParseTestOptionsAndConfig(app)
+		err = app.Test()
+	case "coverage":
+		ParseCoverageOptionsAndConfig(app)
+		err = app.Coverage()
+	case "watch":
+		ParseWatchOptionsAndConfig(app)
+		err = app.Watch()
+	case "compdb":
+		ParseProjectNameAndConfig(app)
+		err = app.CompileDb()
+	case "cache":
+		err = app.Cache(os.Args[1:])
+	case "worker":
+		err = app.Worker(ParseWorkerOptions())
+	case "deps":
+		err = app.Deps(ParseDepsOptionsAndConfig(app))
 	case "build-info":
 		ParseProjectNameAndConfig(app)
 		err = app.BuildInfo()
@@ -149,8 +225,17 @@ func ClayAppMain(pkg *denv.Package) {
 	}
 
 	if err != nil {
-		corepkg.LogFatalf("Error: %v", err)
+		corepkg.LogErrorf(err, "Error")
+		exit(1)
 	}
+	events.Close()
+}
+
+// exit closes the event reporters and exits with the exit code. os.Exit does not return, so
+// the trace (--trace) and the event file (--events) are completed here.
+func exit(code int) {
+	events.Close()
+	os.Exit(code)
 }
 
 func UsageApp() {
@@ -158,7 +243,15 @@ func UsageApp() {
 	corepkg.LogInfo("Commands:")
 	corepkg.LogInfo("  generate --dev=vs2022 [--arch <arch>] [--build <config>] [-p <startup project>]")
 	corepkg.LogInfo("  build-info -p <name> --build <config> --arch <arch>")
-	corepkg.LogInfo("  build -p <name> --arch <arch> --build <config> --board <board>")
+	corepkg.LogInfo("  build -p <name> --arch <arch> --build <config> --board <board> [-j <jobs>] [-k] [--content-hash] [--cache] [--compdb] [--log-format json] [--events <file>] [--explain] [--unity[=N]] [--sanitize <list>] [--trace <file>] [--slowest <N>] [--time-trace] [--toolchain <file|name>] [--workers <host:port,...>] [--sarif <file>] [--max-warnings <N>]")
+	corepkg.LogInfo("  run -p <name> --arch <arch> --build <config> --board <board> [-- args...]")
+	corepkg.LogInfo("  test -p <name> --arch <arch> --build <config> [--timeout <duration>] [--junit <file>] [--json <file>]")
+	corepkg.LogInfo("  coverage -p <name> --arch <arch> --build <config> [--all] [--timeout <duration>] (writes build/<target>-cov/coverage)")
+	corepkg.LogInfo("  watch -p <name> --arch <arch> --build <config> [--interval <duration>] [--test] (rebuilds on changes)")
+	corepkg.LogInfo("  compdb -p <name> --arch <arch> --build <config> --board <board> (writes build/compile_commands.json)")
+	corepkg.LogInfo("  cache stats|clear (statistics of, or clears, the compilation cache)")
+	corepkg.LogInfo("  worker [--listen <host:port>] [-j <jobs>] [--allow <compilers>] (compiles for clay build --workers)")
+	corepkg.LogInfo("  deps who-includes|of|top --arch <arch> --build <config> [--max <N>] [<file>] (header impact analysis)")
 	corepkg.LogInfo("  clean -p <name> --arch <arch> --build <config> --board <board>")
 	corepkg.LogInfo("  flash -p <name> --arch <arch> --build <config> --board <board>")
 	corepkg.LogInfo("  identify  (identifies connected ESP32 board)")
@@ -171,6 +264,31 @@ func UsageApp() {
 	corepkg.LogInfo("  board             Board name for Arduino (e.g. esp32, c3, s3, xiao_esp32c3) ")
 	corepkg.LogInfo("  matches           Maximum number of boards to list")
 	corepkg.LogInfo("  arch              Architecture for listing flash sizes (esp32 or esp8266)")
+	corepkg.LogInfo("  -j, --jobs        Number of files to compile in parallel (default: number of CPUs)")
+	corepkg.LogInfo("  -k, --keep-going  Keep building the projects that do not depend on a failed project")
+	corepkg.LogInfo("  --content-hash    Detect changed files by content (mtime, size and digest) instead of mtime only")
+	corepkg.LogInfo("  --compdb          Also write the compilation database (build/compile_commands.json)")
+	corepkg.LogInfo("  --cache           Use the compilation cache (CLAY_CACHE_DIR, CLAY_CACHE_MAX_SIZE)")
+	corepkg.LogInfo("  --log-format      Log format, text (default) or json (build events as JSON lines on stdout)")
+	corepkg.LogInfo("  --events          Write the build events as JSON lines to a file")
+	corepkg.LogInfo("  --explain         Report why objects, archives and executables are out of date")
+	corepkg.LogInfo("  --unity[=N]       Combine the C++ source files of a project into N unity files (default: 8, 0 = disabled)")
+	corepkg.LogInfo("  --unity-exclude   Comma separated globs of source files that are not part of a unity file")
+	corepkg.LogInfo("  --sanitize        Build with sanitizers (address, undefined, thread), e.g. --sanitize=address,undefined")
+	corepkg.LogInfo("  --trace           Write the compile, archive, link, copy and burn spans as a Chrome trace to a file")
+	corepkg.LogInfo("  --slowest         List the N slowest compiled files at the end of a build (default with --trace: 10)")
+	corepkg.LogInfo("  --time-trace      Let clang write a time trace per source file (-ftime-trace), listed with the slowest files")
+	corepkg.LogInfo("  --toolchain       Build with a custom toolchain, a JSON descriptor file or name (e.g. aarch64-linux-gnu, arm-none-eabi, riscv64-linux-gnu)")
+	corepkg.LogInfo("  --sarif           Write the errors and warnings of the compile and link steps as SARIF 2.1 to a file")
+	corepkg.LogInfo("  --max-warnings    Fail the build when it has more than N warnings (default: no limit)")
+	corepkg.LogInfo("  --workers         Compile on workers (clay worker), a comma separated list of host:port")
+	corepkg.LogInfo("  --listen          Address a worker listens on (default: 127.0.0.1:7700)")
+	corepkg.LogInfo("  --allow           Comma separated globs of the compilers a worker runs (default: gcc, g++, clang, ...)")
+	corepkg.LogInfo("  --interval        Time between two polls of the watched files (default: 500ms)")
+	corepkg.LogInfo("  --test            Build and run the unittests after every change (watch)")
+	corepkg.LogInfo("  --timeout         Maximum duration of a single unittest (default: 10m)")
+	corepkg.LogInfo("  --junit, --json   Write a JUnit XML report or JSON summary of the unittests to a file")
+	corepkg.LogInfo("  --all             Report the coverage of the dependencies and unittests too (coverage)")
 	corepkg.LogInfo("  --help            Show this help message")
 	corepkg.LogInfo("  --version         Show version information")
 
@@ -181,6 +299,27 @@ func UsageApp() {
 	corepkg.LogInfo("  clay build-info --build debug --arch esp32 --board esp32s3")
 	corepkg.LogInfo("  clay build")
 	corepkg.LogInfo("  clay build --build debug --arch esp32 --board esp32s3")
+	corepkg.LogInfo("  clay build -j 4")
+	corepkg.LogInfo("  clay build --cache")
+	corepkg.LogInfo("  clay build --log-format json")
+	corepkg.LogInfo("  clay build --explain")
+	corepkg.LogInfo("  clay build --unity=4 --unity-exclude \"**/platform_*.cpp\"")
+	corepkg.LogInfo("  clay build --trace build/trace.json --slowest 20")
+	corepkg.LogInfo("  clay build --sarif build/clay.sarif --max-warnings 0")
+	corepkg.LogInfo("  clay build --os linux --arch arm64 --toolchain aarch64-linux-gnu")
+	corepkg.LogInfo("  clay build --toolchain toolchains/my-gcc.json")
+	corepkg.LogInfo("  clay cache stats")
+	corepkg.LogInfo("  clay worker --listen 192.168.1.10:7700 -j 16    (the port must not be reachable from untrusted hosts)")
+	corepkg.LogInfo("  clay build -j 32 --workers 192.168.1.10:7700,192.168.1.11:7700")
+	corepkg.LogInfo("  clay deps who-includes ccore/c_target.h")
+	corepkg.LogInfo("  clay deps of source/main/cpp/c_debug.cpp")
+	corepkg.LogInfo("  clay deps top --max 10")
+	corepkg.LogInfo("  clay run -p myapp -- --verbose input.txt")
+	corepkg.LogInfo("  clay test --build debug-dev-test --junit build/junit.xml")
+	corepkg.LogInfo("  clay test --build debug-dev-test --sanitize=address,undefined")
+	corepkg.LogInfo("  clay coverage --build debug-dev-test")
+	corepkg.LogInfo("  clay watch --build debug-dev-test --test")
+	corepkg.LogInfo("  clay compdb --build debug --arch esp32 --board esp32s3")
 	corepkg.LogInfo("  clay clean --build debug --arch esp32 --board esp32s3")
 	corepkg.LogInfo("  clay flash --build debug-dev --arch esp32 --board esp32s3")
 	corepkg.LogInfo("  clay list-libraries")
@@ -189,12 +328,46 @@ func UsageApp() {
 	corepkg.LogInfo("  clay list-flash-sizes --arch <arch> --board esp32")
 }
 
+// ParseBuildOptionsAndConfig registers the options of the build command and then
+// parses the command-line using ParseProjectNameAndConfig.
+func ParseBuildOptionsAndConfig(app *App) {
+	numCPU := runtime.NumCPU()
+	flag.IntVar(&app.Options.Jobs, "jobs", numCPU, "Number of files to compile in parallel")
+	flag.IntVar(&app.Options.Jobs, "j", numCPU, "Number of files to compile in parallel (shorthand)")
+	flag.BoolVar(&app.Options.KeepGoing, "keep-going", false, "Keep building the projects that do not depend on a failed project")
+	flag.BoolVar(&app.Options.KeepGoing, "k", false, "Keep building the projects that do not depend on a failed project (shorthand)")
+	flag.BoolVar(&app.Options.ContentHash, "content-hash", false, "Detect changed files by their content instead of their modification time")
+	flag.BoolVar(&app.Options.CompileDb, "compdb", false, "Also write the compilation database (build/compile_commands.json)")
+	flag.BoolVar(&app.Options.Cache, "cache", false, "Use the compilation cache that is shared by all build directories")
+	flag.StringVar(&app.Options.LogFormat, "log-format", "text", "Log format, 'text' or 'json' (build events as JSON lines on stdout)")
+	flag.StringVar(&app.Options.EventsPath, "events", "", "Write the build events as JSON lines to this file")
+	flag.BoolVar(&app.Options.Explain, "explain", false, "Report why objects, archives and executables are out of date")
+	app.Options.Unity = -1
+	flag.Var(unityFlag{files: &app.Options.Unity}, "unity", "Combine the C++ source files of a project into N unity files (--unity = 8, --unity=0 = disabled)")
+	flag.Func("sanitize", "Build with sanitizers, a comma separated list of address, undefined and thread", func(value string) (err error) {
+		app.Options.Sanitize, err = toolchain.ParseSanitizers(value)
+		return err
+	})
+	flag.StringVar(&app.Options.UnityExclude, "unity-exclude", "", "Comma separated globs of source files that are not part of a unity file")
+	flag.StringVar(&app.Options.TracePath, "trace", "", "Write the spans of the build as a Chrome trace (Perfetto, chrome://tracing) to this file")
+	flag.IntVar(&app.Options.Slowest, "slowest", 0, "List this many of the slowest compiled files at the end of a build (--trace = 10)")
+	flag.BoolVar(&app.Options.TimeTrace, "time-trace", false, "Let clang write a time trace per source file (-ftime-trace)")
+	flag.StringVar(&app.Options.SarifPath, "sarif", "", "Write the errors and warnings of the compile and link steps as SARIF 2.1 to this file")
+	flag.IntVar(&app.Options.MaxWarnings, "max-warnings", -1, "Fail the build when it has more warnings than this (-1 = no limit)")
+	flag.Func("workers", "Compile on workers (clay worker), a comma separated list of host:port", func(value string) (err error) {
+		app.Options.Workers, err = worker.ParseAddresses(value)
+		return err
+	})
+	ParseProjectNameAndConfig(app)
+}
+
 func ParseProjectNameAndConfig(app *App) {
 	flag.StringVar(&app.Config.ProjectName, "p", "", "Name of the project")
 	flag.StringVar(&app.Config.TargetOs, "os", "", "Target OS (windows, darwin, linux, arduino)")
 	flag.StringVar(&app.Config.TargetBuild, "build", "", "Format 'build' or 'build-variant', e.g. debug, debug-dev-none, release-dev-none, debug-dev-test)")
 	flag.StringVar(&app.Config.TargetArch, "arch", "", "Cpu Architecture (amd64, x64, arm64, esp32, esp8266)")
 	flag.StringVar(&app.Config.TargetBoard, "board", "", "Board name (s3, c3, xiao-c3, ...)")
+	flag.StringVar(&app.Config.Toolchain, "toolchain", "", "Custom toolchain, a descriptor file or name (e.g. aarch64-linux-gnu), 'none' for the toolchain of the target")
 	flag.Parse()
 
 	app.Config.TargetArch = strings.ToLower(app.Config.TargetArch)
@@ -230,6 +403,13 @@ func ParseProjectNameAndConfig(app *App) {
 		}
 	}
 
+	// The custom toolchain is kept until it is changed or the target OS is changed
+	if len(app.Config.Toolchain) == 0 && len(app.Config.TargetOs) == 0 {
+		app.Config.Toolchain = loadedConfig.Toolchain
+	} else if app.Config.Toolchain == "none" {
+		app.Config.Toolchain = ""
+	}
+
 	if len(app.Config.TargetOs) == 0 {
 		app.Config.TargetOs = loadedConfig.TargetOs
 	} else {
@@ -322,6 +502,14 @@ func ParseProjectNameAndConfig(app *App) {
 	if len(app.Config.TargetBoard) > 0 {
 		corepkg.LogInfof("Board: %s", app.Config.TargetBoard)
 	}
+	if len(app.Config.Toolchain) > 0 {
+		descriptor, err := toolchain.LoadCustomToolchainDescriptor(app.Config.Toolchain)
+		if err != nil {
+			corepkg.LogFatalf("Failed to load toolchain: %v", err)
+		}
+		app.customToolchain = descriptor
+		corepkg.LogInfof("Toolchain: %s", descriptor.Name)
+	}
 
 	app.BuildConfig = denv.BuildConfigFromString(app.Config.TargetBuild)
 	buildTargetStr := fmt.Sprintf("%s(%s)", app.Config.TargetOs, app.Config.TargetArch)
@@ -329,45 +517,159 @@ func ParseProjectNameAndConfig(app *App) {
 }
 
 func (a *App) Build() (success bool) {
+	_, _, success = a.build(func(prj *Project) bool { return prj.IsExecutable() })
+	return success
+}
+
+// build builds all the libraries and the executables selected by isSelected, when a
+// project name is given only the executable with the closest matching name is built.
+// It returns the executables that were built and the build path.
+func (a *App) build(isSelected func(prj *Project) bool) (executables []*Project, buildPath string, success bool) {
+	return a.buildAffected(isSelected, nil)
+}
+
+// buildAffected is build, but only the projects for which isAffected returns true are
+// built (nil = all projects), the other projects are assumed to be up to date.
+func (a *App) buildAffected(isSelected func(prj *Project) bool, isAffected func(prj *Project) bool) (executables []*Project, buildPath string, success bool) {
+	toolchain.SetMaxJobs(a.Options.Jobs)
+	if a.Options.ContentHash {
+		deptrackr.SetDefaultChangeMode(deptrackr.ChangeModeContent)
+	}
+
+	if err := a.openEventReporters(); err != nil {
+		corepkg.LogError(err, "Failed to open the build event stream")
+		return nil, "", false
+	}
+
+	var outOfDate int
+	buildEvent := &events.Event{Kind: events.BuildStart, Target: a.BuildTarget.String(), Config: a.BuildConfig.String()}
+	events.Emit(buildEvent)
+	defer func() {
+		var buildErr error
+		if !success {
+			buildErr = fmt.Errorf("build failed")
+		}
+		buildEndEvent := buildEvent.End(buildErr)
+		buildEndEvent.OutOfDate = outOfDate
+		events.Emit(buildEndEvent)
+		a.logSlowestFiles()
+		a.reportDiagnostics()
+	}()
+
 	// Create the build directory
-	buildPath := a.GetBuildPath(GetBuildDirname(a.BuildConfig, a.BuildTarget))
+	buildPath = a.GetBuildPath(GetBuildDirname(a.BuildConfig, a.BuildTarget))
 	os.MkdirAll(buildPath+"/", os.ModePerm)
 
 	prjs, err := a.CreateProjects(a.BuildTarget, a.BuildConfig)
 	if err != nil {
 		corepkg.LogError(err, "Failed to create projects")
-		return false
+		return nil, buildPath, false
 	}
 
 	for _, prj := range prjs {
-		a.SetToolchain(prj, buildPath)
+		if err := a.SetToolchain(prj, buildPath); err != nil {
+			return nil, buildPath, false
+		}
+		prj.Explain = a.Options.Explain
+		prj.Diagnostics = a.diagnostics
+		a.applyUnityOptions(prj)
 	}
 
-	var numberOfProjects int
-	var numberOfNoMatchConfigs int
-	var outOfDate int
+	if a.Options.CompileDb {
+		if err := a.writeCompileDb(prjs, buildPath); err != nil {
+			corepkg.LogError(err, "Failed to write the compilation database")
+			return nil, buildPath, false
+		}
+	}
+
+	if a.Options.Cache {
+		cache, err := objcache.Open(objcache.DefaultDirpath(), objcache.DefaultMaxSizeFromEnv())
+		if err != nil {
+			corepkg.LogError(err, "Failed to open the compilation cache")
+			return nil, buildPath, false
+		}
+		defer func() {
+			if err := cache.Close(); err != nil {
+				corepkg.LogError(err, "Failed to close the compilation cache")
+			}
+		}()
+		for _, prj := range prjs {
+			prj.Cache = cache
+		}
+	}
+
+	if len(a.Options.Workers) > 0 {
+		workers := worker.NewPool(a.Options.Workers)
+		workers.OnDown = func(address string, err error) {
+			corepkg.LogErrorf(err, "Worker %s is not used for the rest of the build", address)
+		}
+		defer func() {
+			if compiled := workers.Compiled(); compiled > 0 {
+				corepkg.LogInfof("Compiled %d files on the workers", compiled)
+			}
+			workers.Close()
+		}()
+		for _, prj := range prjs {
+			prj.Workers = workers
+		}
+	}
 
-	// First build libraries
+	// Build the libraries and the selected executables, independent projects are built in parallel
+	executables = a.selectExecutables(prjs, isSelected)
+	toBuild := make([]*Project, 0, len(prjs))
 	for _, prj := range prjs {
 		if prj.DevProject.BuildType.IsLibrary() && prj.CanBuildFor(a.BuildConfig, a.BuildTarget) {
-			numberOfProjects++
-			if buildOutOfDate, buildError := prj.Build(a.BuildConfig, a.BuildTarget, buildPath); buildError {
-				return false
-			} else {
-				outOfDate += buildOutOfDate
-			}
-		} else {
-			numberOfNoMatchConfigs++
+			toBuild = append(toBuild, prj)
 		}
 	}
+	toBuild = append(toBuild, executables...)
+	if isAffected != nil {
+		toBuild = slices.DeleteFunc(toBuild, func(prj *Project) bool { return !isAffected(prj) })
+	}
+
+	numberOfProjects := len(toBuild)
+	var ok bool
+	outOfDate, ok = buildProjectGraph(toBuild, a.Options.KeepGoing, func(prj *Project) (int, bool) {
+		return prj.Build(a.BuildConfig, a.BuildTarget, buildPath)
+	})
+	if !ok || a.tooManyWarnings() {
+		return nil, buildPath, false
+	}
 
-	// If we have a project name, we should reduce the list of executable projects to only that one
+	if outOfDate == 0 && numberOfProjects > 0 {
+		corepkg.LogInfo("Nothing to build, everything is up to date")
+	} else if numberOfProjects == 0 {
+		corepkg.LogError(fmt.Errorf("!"), "No matching project configurations found")
+	}
+
+	return executables, buildPath, true
+}
+
+// applyUnityOptions applies --unity and --unity-exclude to a project, --unity overrides the
+// number of unity files that the package sets for the project, also to disable it (--unity=0).
+func (a *App) applyUnityOptions(prj *Project) {
+	if a.Options.Unity >= 0 {
+		prj.Unity = a.Options.Unity
+	} else if prj.Unity < 0 {
+		prj.Unity = 0
+	}
+	for _, glob := range strings.Split(a.Options.UnityExclude, ",") {
+		if glob = strings.TrimSpace(glob); len(glob) > 0 {
+			prj.UnityExclude = append(prj.UnityExclude, glob)
+		}
+	}
+}
+
+// selectExecutables returns the executable projects selected by isSelected that can be
+// built for the target and config. If we have a project name, the list is reduced to
+// the project with the closest matching name.
+func (a *App) selectExecutables(prjs []*Project, isSelected func(prj *Project) bool) []*Project {
 	filteredProjects := []*Project{}
 	if a.Config.ProjectName != "*" && a.Config.ProjectName != "" {
 		projectNames := []string{}
 		projectMap := map[string]*Project{}
 		for _, prj := range prjs {
-			if prj.IsExecutable() && prj.CanBuildFor(a.BuildConfig, a.BuildTarget) {
+			if prj.IsExecutable() && isSelected(prj) && prj.CanBuildFor(a.BuildConfig, a.BuildTarget) {
 				projectNames = append(projectNames, prj.DevProject.Name)
 				projectMap[prj.DevProject.Name] = prj
 			}
@@ -380,29 +682,12 @@ func (a *App) Build() (success bool) {
 		}
 	} else {
 		for _, prj := range prjs {
-			if prj.IsExecutable() && prj.CanBuildFor(a.BuildConfig, a.BuildTarget) {
+			if prj.IsExecutable() && isSelected(prj) && prj.CanBuildFor(a.BuildConfig, a.BuildTarget) {
 				filteredProjects = append(filteredProjects, prj)
 			}
 		}
 	}
-
-	// Then build applications
-	for _, prj := range filteredProjects {
-		numberOfProjects++
-		if buildOutOfDate, buildErr := prj.Build(a.BuildConfig, a.BuildTarget, buildPath); buildErr {
-			return false
-		} else {
-			outOfDate += buildOutOfDate
-		}
-	}
-
-	if outOfDate == 0 && numberOfProjects > 0 {
-		corepkg.LogInfo("Nothing to build, everything is up to date")
-	} else if numberOfProjects == 0 {
-		corepkg.LogError(fmt.Errorf("!"), "No matching project configurations found")
-	}
-
-	return true
+	return filteredProjects
 }
 
 func (a *App) Clean() error {
@@ -549,10 +834,23 @@ func (a *App) CreateProjects(buildTarget denv.BuildTarget, buildConfig denv.Buil
 		}
 	}
 
-	// Glob all source files for each project
+	// A shared library and the static libraries that are linked into it are compiled as
+	// position independent code
+	for _, prj := range projects {
+		if prj.IsSharedLibrary() {
+			prj.setPositionIndependent()
+		}
+	}
+
+	// Glob all source files for each project and apply the settings of the package
+	if a.PkgVars == nil {
+		a.PkgVars = a.prepareToolchainVars()
+	}
 	exclusionFilter := denv.NewExclusionFilter(buildTarget)
 	for _, prj := range projects {
 		prj.GlobSourceFiles(exclusionFilter.IsExcluded)
+		prj.SetPchHeader(a.PkgVars)
+		prj.SetUnity(a.PkgVars)
 	}
 
 	// Collect all variables for each project
@@ -573,12 +871,18 @@ func (a *App) prepareToolchainVars() (vars *corepkg.Vars) {
 	} else if a.BuildTarget.Mac() {
 		vars = corepkg.NewVars(corepkg.VarsFormatCurlyBraces)
 		a.Pkg.GetVars(a.BuildTarget, a.BuildConfig, a.Config.TargetBoard, vars)
+	} else if a.BuildTarget.Linux() {
+		// Recipes not provided by the package are defaulted by the Linux toolchain
+		vars = corepkg.NewVars(corepkg.VarsFormatCurlyBraces)
+		a.Pkg.GetVars(a.BuildTarget, a.BuildConfig, a.Config.TargetBoard, vars)
 	}
 	return vars
 }
 
 func (a *App) createToolchain(projectName string, projectBuildPath string) (tc toolchain.Environment, err error) {
-	if a.BuildTarget.Arduino() && a.BuildTarget.Esp32() {
+	if a.customToolchain != nil {
+		return toolchain.NewCustomToolchain(a.customToolchain, projectName, projectBuildPath, a.Config.TargetArch), nil
+	} else if a.BuildTarget.Arduino() && a.BuildTarget.Esp32() {
 		return toolchain.NewArduinoEsp32Toolchainv2(a.PkgVars.Copy(), projectName, projectBuildPath), nil
 	} else if a.BuildTarget.Arduino() && a.BuildTarget.Esp8266() {
 		return toolchain.NewArduinoEsp8266Toolchain(a.PkgVars.Copy(), projectName, projectBuildPath), nil
@@ -588,6 +892,9 @@ func (a *App) createToolchain(projectName string, projectBuildPath string) (tc t
 	} else if a.BuildTarget.Mac() {
 		arch := runtime.GOARCH
 		return toolchain.NewDarwinClangv2(a.PkgVars.Copy(), projectName, projectBuildPath, arch), nil
+	} else if a.BuildTarget.Linux() {
+		arch := runtime.GOARCH
+		return toolchain.NewLinuxGcc(a.PkgVars.Copy(), projectName, projectBuildPath, arch), nil
 	} else {
 		err = corepkg.LogErrorf(os.ErrNotExist, "error, %s as a build target on %s is not supported", a.BuildTarget.Os().String(), runtime.GOOS)
 	}
@@ -602,6 +909,38 @@ func (a *App) SetToolchain(p *Project, buildPath string) (err error) {
 	if err != nil {
 		return err
 	}
+	if len(a.Options.Sanitize) > 0 {
+		sanitizerSupport, ok := tc.(toolchain.SanitizerSupport)
+		if !ok {
+			return corepkg.LogErrorf(os.ErrInvalid, "error, sanitizers are not supported for %s", a.BuildTarget.Os().String())
+		}
+		if err := sanitizerSupport.SetSanitizers(a.Options.Sanitize); err != nil {
+			return corepkg.LogError(err, "error, failed to build with sanitizers")
+		}
+	}
+	if a.Options.TimeTrace {
+		timeTraceSupport, ok := tc.(toolchain.TimeTraceSupport)
+		if !ok {
+			return corepkg.LogErrorf(os.ErrInvalid, "error, a time trace per source file is not supported for %s", a.BuildTarget.Os().String())
+		}
+		if err := timeTraceSupport.SetTimeTrace(); err != nil {
+			return corepkg.LogError(err, "error, failed to build with a time trace per source file")
+		}
+	}
+	if a.Options.Coverage {
+		coverageSupport, ok := tc.(toolchain.CoverageSupport)
+		if !ok {
+			return corepkg.LogErrorf(os.ErrInvalid, "error, coverage is not supported for %s", a.BuildTarget.Os().String())
+		}
+		if err := coverageSupport.SetCoverage(); err != nil {
+			return corepkg.LogError(err, "error, failed to build with coverage")
+		}
+	}
+	if p.Pic {
+		if positionIndependentSupport, ok := tc.(toolchain.PositionIndependentSupport); ok {
+			positionIndependentSupport.SetPositionIndependent(p.IsSharedLibrary())
+		}
+	}
 	p.Toolchain = tc
 	return nil
 }
@@ -0,0 +1,178 @@
+package clay
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/coverage"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+// CoverageOptions are command-line options of the coverage command
+type CoverageOptions struct {
+	All bool // Report the coverage of all the source files, also of the dependencies and unittests
+}
+
+// ParseCoverageOptionsAndConfig registers the options of the coverage command, which are
+// the options of the test command plus the coverage options.
+func ParseCoverageOptionsAndConfig(app *App) {
+	flag.BoolVar(&app.CoverageOptions.All, "all", false, "Report the coverage of the dependencies and unittests too")
+	ParseTestOptionsAndConfig(app)
+}
+
+// Coverage builds the unittest projects with coverage instrumentation, in a build directory
+// of their own, runs them and writes the coverage to '<build>/coverage' as an lcov tracefile
+// (coverage.info), a Cobertura XML report (coverage.xml) and a plain-text summary per file
+// and per project (coverage.txt). The reports are also written when a unittest failed, it
+// then still returns an error.
+func (a *App) Coverage() error {
+	a.Options.Coverage = true
+	unittests, buildPath, ok := a.build(func(prj *Project) bool { return prj.DevProject.BuildType.IsUnittest() })
+	if !ok {
+		return fmt.Errorf("build failed")
+	}
+	if len(unittests) == 0 {
+		return fmt.Errorf("no unittest projects found for %s", filepath.Base(buildPath))
+	}
+
+	coverageSupport, ok := unittests[0].Toolchain.(toolchain.CoverageSupport)
+	if !ok {
+		return corepkg.LogErrorf(os.ErrInvalid, "error, coverage is not supported for %s", a.BuildTarget.Os().String())
+	}
+	collector := coverageSupport.NewCoverageCollector(buildPath)
+	if err := collector.Reset(); err != nil {
+		return corepkg.LogError(err, "error, failed to remove the coverage profiles of an earlier run")
+	}
+
+	a.coverage = collector
+	testErr := a.runUnittests(unittests, buildPath)
+	a.coverage = nil
+
+	executables := make([]string, 0, len(unittests))
+	for _, prj := range unittests {
+		executables = append(executables, prj.GetExecutableFilepath(a.BuildConfig, a.BuildTarget, buildPath))
+	}
+	report, err := collector.Collect(executables)
+	if err != nil {
+		return corepkg.LogError(err, "error, failed to collect the coverage")
+	}
+
+	prjs, err := a.CreateProjects(a.BuildTarget, a.BuildConfig)
+	if err != nil {
+		return err
+	}
+	assignCoverageProjects(report, a.coverageSourceDirs(prjs))
+
+	if err := a.writeCoverageReports(report, filepath.Join(buildPath, "coverage")); err != nil {
+		return err
+	}
+	return testErr
+}
+
+// runEnv returns the environment for running an executable, which is the environment of
+// the sanitizers plus the environment of the coverage collector. It returns nil when the
+// executable gets the environment of clay.
+func (a *App) runEnv(executable string) []string {
+	env := a.sanitizerEnv()
+	if a.coverage != nil {
+		if coverageEnv := a.coverage.Env(executable); len(coverageEnv) > 0 {
+			if env == nil {
+				env = os.Environ()
+			}
+			env = append(env, coverageEnv...)
+		}
+	}
+	return env
+}
+
+// coverageSourceDirs returns the absolute source directories to report the coverage of, and
+// for each the project it belongs to. These are the source directories of the package's own
+// main app, main lib and libraries, or of every project with --all.
+func (a *App) coverageSourceDirs(prjs []*Project) map[string]string {
+	own := map[string]bool{}
+	for _, devPrjs := range [][]*denv.DevProject{a.Pkg.GetMainApp(), a.Pkg.GetMainLib(), a.Pkg.GetLibraries()} {
+		for _, devPrj := range devPrjs {
+			own[devPrj.Name] = true
+		}
+	}
+
+	sourceDirs := map[string]string{}
+	for _, prj := range prjs {
+		if !a.CoverageOptions.All && !own[prj.DevProject.Name] {
+			continue
+		}
+		for _, srcDir := range prj.DevProject.SourceDirs {
+			if dirpath, err := filepath.Abs(corepkg.PathNormalize(srcDir.Path.String())); err == nil {
+				sourceDirs[dirpath] = prj.DevProject.Name
+			}
+		}
+	}
+	return sourceDirs
+}
+
+// assignCoverageProjects sets the project of every source file in the report to the project
+// of the (deepest) source directory it is in, the source files that are not in any of the
+// source directories, e.g. system headers, are removed.
+func assignCoverageProjects(report *coverage.Report, sourceDirs map[string]string) {
+	report.Filter(func(file *coverage.File) bool {
+		matched := ""
+		for dirpath, project := range sourceDirs {
+			if strings.HasPrefix(file.Path, dirpath+string(filepath.Separator)) && len(dirpath) > len(matched) {
+				matched = dirpath
+				file.Project = project
+			}
+		}
+		return len(matched) > 0
+	})
+}
+
+// writeCoverageReports writes the reports to the coverage directory and logs the summary,
+// the paths in the Cobertura report and in the summary are relative to the package.
+func (a *App) writeCoverageReports(report *coverage.Report, coverageDirpath string) error {
+	if err := os.MkdirAll(coverageDirpath, os.ModePerm); err != nil {
+		return err
+	}
+	sourceDirpath, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	writeReport := func(filename string, write func(w io.Writer) error) error {
+		reportFilepath := filepath.Join(coverageDirpath, filename)
+		file, err := os.Create(reportFilepath)
+		if err != nil {
+			return corepkg.LogErrorf(err, "error, failed to create %q", reportFilepath)
+		}
+		defer file.Close()
+		if err := write(file); err != nil {
+			return corepkg.LogErrorf(err, "error, failed to write %q", reportFilepath)
+		}
+		return nil
+	}
+
+	if err := writeReport("coverage.info", report.WriteLcov); err != nil {
+		return err
+	}
+	if err := writeReport("coverage.xml", func(w io.Writer) error { return report.WriteCobertura(w, sourceDirpath, time.Now()) }); err != nil {
+		return err
+	}
+
+	summary := &strings.Builder{}
+	if err := report.WriteSummary(summary, sourceDirpath); err != nil {
+		return err
+	}
+	if err := writeReport("coverage.txt", func(w io.Writer) error { _, err := io.WriteString(w, summary.String()); return err }); err != nil {
+		return err
+	}
+
+	corepkg.LogInfof("Coverage of %d source files:\n%s", len(report.Files), summary.String())
+	corepkg.LogInfof("Coverage reports written to %s (coverage.info, coverage.xml and coverage.txt)", coverageDirpath)
+	return nil
+}
@@ -0,0 +1,44 @@
+package clay
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/coverage"
+)
+
+func TestCoverageBuildPath(t *testing.T) {
+	app := NewApp(nil)
+	app.Options.Coverage = true
+	if buildPath := app.GetBuildPath("linux-x64-debug-test"); buildPath != filepath.Join("build", "linux-x64-debug-test-cov") {
+		t.Errorf("expected a build directory of its own for coverage, got %q", buildPath)
+	}
+}
+
+func TestAssignCoverageProjects(t *testing.T) {
+	root := filepath.FromSlash("/pkg")
+	report := coverage.NewReport()
+	report.File(filepath.Join(root, "source", "main", "cpp", "lib.cpp")).AddLine(1, 1)
+	report.File(filepath.Join(root, "source", "main", "cpp", "tool", "main.cpp")).AddLine(1, 1)
+	report.File(filepath.Join(root, "source", "test", "cpp", "test_lib.cpp")).AddLine(1, 1)
+	report.File(filepath.FromSlash("/usr/include/c++/12/vector")).AddLine(1, 1)
+
+	sourceDirs := map[string]string{
+		filepath.Join(root, "source", "main", "cpp"):         "mylib",
+		filepath.Join(root, "source", "main", "cpp", "tool"): "mytool",
+	}
+	assignCoverageProjects(report, sourceDirs)
+
+	expected := map[string]string{
+		filepath.Join(root, "source", "main", "cpp", "lib.cpp"):          "mylib",
+		filepath.Join(root, "source", "main", "cpp", "tool", "main.cpp"): "mytool",
+	}
+	if len(report.Files) != len(expected) {
+		t.Errorf("expected only the files in the source directories, got %d files", len(report.Files))
+	}
+	for path, project := range expected {
+		if file, ok := report.Files[path]; !ok || file.Project != project {
+			t.Errorf("expected %q to belong to project %q", path, project)
+		}
+	}
+}
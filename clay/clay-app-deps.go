@@ -0,0 +1,264 @@
+package clay
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// DepsOptions are command-line options of the deps command
+type DepsOptions struct {
+	Max int // Maximum number of headers listed by 'deps top'
+}
+
+// ParseDepsOptionsAndConfig consumes the deps command (who-includes, of or top) and parses
+// the options, it returns the command and its arguments.
+func ParseDepsOptionsAndConfig(app *App) (command string, args []string) {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1:1], os.Args[2:]...)
+	}
+	flag.IntVar(&app.DepsOptions.Max, "max", 20, "Maximum number of headers listed by 'deps top'")
+	ParseProjectNameAndConfig(app)
+	return command, flag.Args()
+}
+
+// Deps runs a header impact analysis command on the dependency trackers of the projects,
+// which know the headers of every translation unit of the last build:
+//   - 'who-includes <header>' lists the translation units and projects that depend on a header
+//   - 'of <source>' lists the headers that a source file depends on
+//   - 'top' ranks the headers by the number of translation units that depend on them
+//
+// A header or source file can be given by (the end of) its path, e.g. 'ccore/c_target.h'.
+func (a *App) Deps(command string, args []string) error {
+	switch command {
+	case "who-includes", "of":
+		if len(args) != 1 {
+			return fmt.Errorf("deps %s requires a single file", command)
+		}
+	case "top":
+	default:
+		return fmt.Errorf("unknown deps command %q (who-includes, of or top)", command)
+	}
+
+	graph, err := a.loadDepsGraph()
+	if err != nil {
+		return err
+	}
+	if len(graph.units) == 0 {
+		return fmt.Errorf("no dependency information found, build first with the same --build, --arch and --board")
+	}
+
+	switch command {
+	case "who-includes":
+		headers := graph.matchHeaders(args[0])
+		if len(headers) == 0 {
+			return fmt.Errorf("no translation unit depends on %q", args[0])
+		}
+		for _, header := range headers {
+			units := graph.whoIncludes(header)
+			corepkg.LogInfof("%s is included by %d translation units of %d projects:", relPath(header), len(units), countProjects(units))
+			for _, unit := range units {
+				corepkg.LogInfof("  %s: %s", unit.project, relPath(unit.source))
+			}
+		}
+	case "of":
+		units := graph.matchSources(args[0])
+		if len(units) == 0 {
+			return fmt.Errorf("no translation unit found for %q", args[0])
+		}
+		for _, unit := range units {
+			corepkg.LogInfof("%s (project %s) depends on %d headers:", relPath(unit.source), unit.project, len(unit.headers))
+			for _, header := range unit.headers {
+				corepkg.LogInfof("  %s", relPath(header))
+			}
+		}
+	case "top":
+		corepkg.LogInfof("Headers by the number of translation units that depend on them (%d translation units):", len(graph.units))
+		corepkg.LogInfof("  %6s  %8s  %s", "Units", "Projects", "Header")
+		for _, fanOut := range graph.top(a.DepsOptions.Max) {
+			corepkg.LogInfof("  %6d  %8d  %s", fanOut.units, fanOut.projects, relPath(fanOut.header))
+		}
+	}
+	return nil
+}
+
+// depsUnit is a translation unit, a source file that is compiled into an object file, with
+// the headers it depends on (directly and indirectly).
+type depsUnit struct {
+	project string
+	source  string
+	object  string
+	headers []string
+}
+
+// depsGraph holds the translation units of all the projects
+type depsGraph struct {
+	units []*depsUnit
+}
+
+// depsSourceExts are the extensions of the source files of translation units
+var depsSourceExts = []string{".c", ".cc", ".cpp", ".cxx", ".c++", ".m", ".mm"}
+
+// depsIgnoredExts are the extensions of dependencies that are not headers, e.g. the
+// precompiled header or the response file that an object file depends on
+var depsIgnoredExts = []string{".gch", ".pch", ".o", ".obj", ".rsp"}
+
+// addItem adds an item of a dependency tracker, when it is an object file. An object file
+// depends on its source file and on the headers, an archive or executable only depends on
+// object files and libraries.
+func (g *depsGraph) addItem(project string, item string, deps []string) {
+	unit := &depsUnit{project: project, object: item}
+	for _, dep := range deps {
+		ext := strings.ToLower(filepath.Ext(dep))
+		if slices.Contains(depsIgnoredExts, ext) {
+			continue
+		}
+		if abs, err := filepath.Abs(dep); err == nil {
+			dep = abs
+		}
+		if len(unit.source) == 0 && slices.Contains(depsSourceExts, ext) {
+			unit.source = dep
+		} else if !slices.Contains(unit.headers, dep) {
+			unit.headers = append(unit.headers, dep)
+		}
+	}
+	if len(unit.source) > 0 {
+		slices.Sort(unit.headers)
+		g.units = append(g.units, unit)
+	}
+}
+
+// matchesPath returns true when the path is the query or ends with the query as a whole
+// path component, e.g. 'ccore/c_target.h' matches '/src/ccore/include/ccore/c_target.h'.
+func matchesPath(path string, query string) bool {
+	path = filepath.ToSlash(path)
+	query = strings.TrimPrefix(filepath.ToSlash(query), "./")
+	if abs, err := filepath.Abs(query); err == nil && filepath.ToSlash(abs) == path {
+		return true
+	}
+	return path == query || strings.HasSuffix(path, "/"+query)
+}
+
+// matchHeaders returns the headers that match the query, sorted
+func (g *depsGraph) matchHeaders(query string) []string {
+	headers := []string{}
+	for _, unit := range g.units {
+		for _, header := range unit.headers {
+			if matchesPath(header, query) && !slices.Contains(headers, header) {
+				headers = append(headers, header)
+			}
+		}
+	}
+	slices.Sort(headers)
+	return headers
+}
+
+// matchSources returns the translation units of which the source file matches the query
+func (g *depsGraph) matchSources(query string) []*depsUnit {
+	units := []*depsUnit{}
+	for _, unit := range g.units {
+		if matchesPath(unit.source, query) {
+			units = append(units, unit)
+		}
+	}
+	return units
+}
+
+// whoIncludes returns the translation units that depend on the header, ordered by project
+// and source file
+func (g *depsGraph) whoIncludes(header string) []*depsUnit {
+	units := []*depsUnit{}
+	for _, unit := range g.units {
+		if _, found := slices.BinarySearch(unit.headers, header); found {
+			units = append(units, unit)
+		}
+	}
+	slices.SortFunc(units, func(a, b *depsUnit) int {
+		return cmp.Or(cmp.Compare(a.project, b.project), cmp.Compare(a.source, b.source))
+	})
+	return units
+}
+
+// headerFanOut is the number of translation units and projects that depend on a header
+type headerFanOut struct {
+	header   string
+	units    int
+	projects int
+}
+
+// top returns the n headers with the most translation units depending on them
+func (g *depsGraph) top(n int) []headerFanOut {
+	units := map[string]int{}
+	projects := map[string]map[string]bool{}
+	for _, unit := range g.units {
+		for _, header := range unit.headers {
+			units[header]++
+			if projects[header] == nil {
+				projects[header] = map[string]bool{}
+			}
+			projects[header][unit.project] = true
+		}
+	}
+
+	fanOuts := make([]headerFanOut, 0, len(units))
+	for header, count := range units {
+		fanOuts = append(fanOuts, headerFanOut{header: header, units: count, projects: len(projects[header])})
+	}
+	slices.SortFunc(fanOuts, func(a, b headerFanOut) int {
+		return cmp.Or(cmp.Compare(b.units, a.units), cmp.Compare(b.projects, a.projects), cmp.Compare(a.header, b.header))
+	})
+	return fanOuts[:min(n, len(fanOuts))]
+}
+
+func countProjects(units []*depsUnit) int {
+	projects := map[string]bool{}
+	for _, unit := range units {
+		projects[unit.project] = true
+	}
+	return len(projects)
+}
+
+// relPath returns the path relative to the current directory, when that is shorter
+func relPath(path string) string {
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && len(rel) < len(path) {
+			return rel
+		}
+	}
+	return path
+}
+
+// loadDepsGraph reads the dependency trackers of the projects (of the project given with
+// -p, or of all projects) in the build directory of the config and target.
+func (a *App) loadDepsGraph() (*depsGraph, error) {
+	buildPath := a.GetBuildPath(GetBuildDirname(a.BuildConfig, a.BuildTarget))
+	prjs, err := a.CreateProjects(a.BuildTarget, a.BuildConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &depsGraph{}
+	for _, prj := range prjs {
+		if !prj.CanBuildFor(a.BuildConfig, a.BuildTarget) {
+			continue
+		}
+		if len(a.Config.ProjectName) > 0 && a.Config.ProjectName != "*" && prj.DevProject.Name != a.Config.ProjectName {
+			continue
+		}
+		if err := a.SetToolchain(prj, buildPath); err != nil {
+			return nil, err
+		}
+		name := prj.DevProject.Name
+		prj.Toolchain.NewDependencyTracker(prj.GetBuildPath(buildPath)).ForEachItem(func(item string, deps []string) {
+			graph.addItem(name, item, deps)
+		})
+	}
+	return graph, nil
+}
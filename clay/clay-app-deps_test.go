@@ -0,0 +1,61 @@
+package clay
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestDepsGraph() *depsGraph {
+	path := func(p string) string { return filepath.FromSlash("/pkg/" + p) }
+	graph := &depsGraph{}
+	graph.addItem("ccore", path("build/ccore/c_debug.o"), []string{path("ccore/source/main/cpp/c_debug.cpp"), path("ccore/source/main/include/ccore/c_target.h"), path("ccore/source/main/include/ccore/c_debug.h")})
+	graph.addItem("ccore", path("build/ccore/c_memory.o"), []string{path("ccore/source/main/cpp/c_memory.cpp"), path("ccore/source/main/include/ccore/c_target.h"), path("build/ccore/pch.h.gch")})
+	graph.addItem("cbase", path("build/cbase/c_buffer.o"), []string{path("cbase/source/main/cpp/c_buffer.cpp"), path("ccore/source/main/include/ccore/c_target.h"), path("ccore/source/main/include/ccore/c_debug.h")})
+	graph.addItem("cbase", path("build/cbase/cbase.a"), []string{path("build/cbase/c_buffer.o")})
+	return graph
+}
+
+func TestDepsGraph(t *testing.T) {
+	graph := newTestDepsGraph()
+	if len(graph.units) != 3 {
+		t.Fatalf("expected 3 translation units (the archive is not one), got %d", len(graph.units))
+	}
+	if headers := graph.units[1].headers; len(headers) != 1 {
+		t.Errorf("expected the precompiled header to be ignored, got %v", headers)
+	}
+
+	headers := graph.matchHeaders("ccore/c_target.h")
+	if len(headers) != 1 {
+		t.Fatalf("expected a single header matching ccore/c_target.h, got %v", headers)
+	}
+	if units := graph.whoIncludes(headers[0]); len(units) != 3 || units[0].project != "cbase" || countProjects(units) != 2 {
+		t.Errorf("expected c_target.h to be included by 3 translation units of 2 projects, got %d", len(units))
+	}
+
+	units := graph.matchSources("c_debug.cpp")
+	if len(units) != 1 || len(units[0].headers) != 2 {
+		t.Errorf("expected c_debug.cpp to depend on 2 headers, got %v", units)
+	}
+
+	top := graph.top(1)
+	if len(top) != 1 || filepath.Base(top[0].header) != "c_target.h" || top[0].units != 3 || top[0].projects != 2 {
+		t.Errorf("expected c_target.h at the top with 3 translation units, got %v", top)
+	}
+}
+
+func TestDepsMatchesPath(t *testing.T) {
+	path := filepath.FromSlash("/pkg/ccore/source/main/include/ccore/c_target.h")
+	for query, expected := range map[string]bool{
+		"c_target.h":                      true,
+		"ccore/c_target.h":                true,
+		"include/ccore/c_target.h":        true,
+		"target.h":                        false,
+		"cbase/c_target.h":                false,
+		filepath.ToSlash(path):            true,
+		"/other/ccore/include/c_target.h": false,
+	} {
+		if matchesPath(path, query) != expected {
+			t.Errorf("expected matchesPath(%q) to be %v", query, expected)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package clay
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/diagnostics"
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// reportDiagnostics logs the number of errors and warnings per project of the build that
+// just ended, each diagnostic counted once per project, and writes them as SARIF (--sarif).
+func (a *App) reportDiagnostics() {
+	if a.diagnostics == nil {
+		return
+	}
+
+	if counts := a.diagnostics.Counts(); len(counts) > 0 {
+		errors, warnings := 0, 0
+		lines := &strings.Builder{}
+		for _, c := range counts {
+			project := c.Project
+			if len(project) == 0 {
+				project = "(unknown)"
+			}
+			fmt.Fprintf(lines, "\n  %s: %d errors, %d warnings", project, c.Errors, c.Warnings)
+			errors += c.Errors
+			warnings += c.Warnings
+		}
+		corepkg.LogInfof("Diagnostics: %d errors, %d warnings%s", errors, warnings, lines.String())
+	}
+
+	if len(a.Options.SarifPath) > 0 {
+		if err := diagnostics.WriteSarif(a.Options.SarifPath, a.diagnostics.Diagnostics(), "."); err != nil {
+			corepkg.LogErrorf(err, "Failed to write the SARIF file %q", a.Options.SarifPath)
+		}
+	}
+}
+
+// tooManyWarnings returns true when the build that just ended has more warnings than
+// allowed (--max-warnings).
+func (a *App) tooManyWarnings() bool {
+	if a.diagnostics == nil || a.Options.MaxWarnings < 0 {
+		return false
+	}
+	if warnings := a.diagnostics.Warnings(); warnings > a.Options.MaxWarnings {
+		corepkg.LogErrorf(fmt.Errorf("too many warnings"), "The build has %d warnings, at most %d are allowed", warnings, a.Options.MaxWarnings)
+		return true
+	}
+	return false
+}
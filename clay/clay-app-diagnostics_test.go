@@ -0,0 +1,119 @@
+package clay
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/diagnostics"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/objcache"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+func TestMaxWarnings(t *testing.T) {
+	app := NewApp(nil)
+	app.Options.MaxWarnings = 1
+	app.Options.SarifPath = filepath.Join(t.TempDir(), "clay.sarif")
+	app.diagnostics = diagnostics.NewCollector()
+
+	app.diagnostics.Report(&events.Event{Kind: events.BuildStart})
+	app.diagnostics.Report(&events.Event{Kind: events.CompileEnd, Project: "app", Message: "main.cpp:1:5: warning: unused variable 'x' [-Wunused-variable]\n"})
+	if app.tooManyWarnings() {
+		t.Errorf("expected 1 warning to be allowed")
+	}
+	app.diagnostics.Report(&events.Event{Kind: events.CompileEnd, Project: "app", Message: "main.cpp:2:5: warning: unused variable 'y' [-Wunused-variable]\n"})
+	if !app.tooManyWarnings() {
+		t.Errorf("expected 2 warnings to fail the build")
+	}
+
+	app.reportDiagnostics()
+	if !corepkg.FileExists(app.Options.SarifPath) {
+		t.Errorf("expected the SARIF file to be written")
+	}
+
+	app.Options.MaxWarnings = -1
+	if app.tooManyWarnings() {
+		t.Errorf("expected no limit on the number of warnings")
+	}
+}
+
+func TestCompileOutputReplay(t *testing.T) {
+	if _, err := exec.LookPath("g++"); err != nil {
+		t.Skip("g++ is not available")
+	}
+
+	dirpath := t.TempDir()
+	buildPath := filepath.Join(dirpath, "build")
+	sourceFiles := []SourceFile{{SrcAbsPath: filepath.Join(dirpath, "clean.cpp"), SrcRelPath: "clean.cpp"}, {SrcAbsPath: filepath.Join(dirpath, "warned.cpp"), SrcRelPath: "warned.cpp"}}
+	if err := os.WriteFile(sourceFiles[0].SrcAbsPath, []byte("int clean() { return 0; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sourceFiles[1].SrcAbsPath, []byte("#warning \"not done yet\"\nint warned() { return 0; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(buildPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := objcache.Open(filepath.Join(dirpath, "cache"), objcache.DefaultMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector := diagnostics.NewCollector()
+	events.AddReporter(collector)
+	defer events.Close()
+
+	gcc := toolchain.NewLinuxGcc(corepkg.NewVars(corepkg.VarsFormatCurlyBraces), "test", buildPath, "amd64")
+	build := func() int {
+		compiler := gcc.NewCompiler(denv.BuildConfig{}, denv.BuildTarget{})
+		compiler.SetupArgs("test", buildPath, []string{}, []string{})
+		cc := &CompileContext{buildPath: buildPath, projectName: "test", compiler: compiler, depTrackr: gcc.NewDependencyTracker(buildPath), cache: cache, diagnostics: collector}
+
+		events.Emit(&events.Event{Kind: events.BuildStart})
+		outOfDate := cc.collectFilesToCompile(sourceFiles)
+		if outOfDate > 0 && !cc.compile() {
+			t.Fatalf("expected the source files to compile")
+		}
+		cc.updateDependencyTracker()
+		if err := cc.saveDependencyTrackr(); err != nil {
+			t.Fatal(err)
+		}
+		return outOfDate
+	}
+
+	// The object file with warnings is tracked, the next build does not compile it but
+	// replays its warnings, so that a warning limit still counts them
+	for i := 0; i < 2; i++ {
+		if outOfDate := build(); outOfDate != 2-2*i {
+			t.Errorf("build %d: expected %d out-of-date source files, got %d", i, 2-2*i, outOfDate)
+		}
+		if warnings := collector.Warnings(); warnings != 1 {
+			t.Errorf("build %d: expected the warning to be counted, got %d warnings", i, warnings)
+		}
+	}
+	cleanObjFilepath := filepath.Join(buildPath, "clean.cpp.o")
+	warnedObjFilepath := filepath.Join(buildPath, "warned.cpp.o")
+	if corepkg.FileExists(outputFilepath(cleanObjFilepath)) || !corepkg.FileExists(outputFilepath(warnedObjFilepath)) {
+		t.Errorf("expected the compiler output to be kept next to the object file with warnings only")
+	}
+
+	// An object file restored from the compilation cache replays the warnings it was compiled with
+	trackrFilepaths, _ := filepath.Glob(filepath.Join(buildPath, "deptrackr*"))
+	for _, path := range append(trackrFilepaths, warnedObjFilepath, outputFilepath(warnedObjFilepath)) {
+		os.RemoveAll(path)
+	}
+	build()
+	if output := collector.CompileOutput(sourceFiles[1].SrcAbsPath); len(output) > 0 {
+		t.Errorf("expected the object file to be restored from the cache, it was compiled")
+	}
+	if warnings := collector.Warnings(); warnings != 1 {
+		t.Errorf("expected the warning of the restored object file to be counted, got %d warnings", warnings)
+	}
+	if !corepkg.FileExists(outputFilepath(warnedObjFilepath)) {
+		t.Errorf("expected the compiler output of the restored object file to be kept")
+	}
+}
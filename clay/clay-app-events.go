@@ -0,0 +1,127 @@
+package clay
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/diagnostics"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// openEventReporters sets up the build event stream, with '--log-format json' the events
+// are written to stdout as JSON lines and so are the log messages (as 'log' events), with
+// '--events <path>' the events are (also) written to a file and with '--trace <path>' the
+// spans of the build are written as a Chrome trace. The errors and warnings of the compile
+// and link steps are always collected. The reporters are opened once and stay open until
+// the app exits.
+func (a *App) openEventReporters() error {
+	if a.eventsOpen {
+		return nil // Already opened by a previous build, e.g. of clay watch
+	}
+	a.eventsOpen = true
+
+	switch a.Options.LogFormat {
+	case "", "text":
+	case "json":
+		events.AddReporter(events.NewJsonReporter(os.Stdout))
+		corepkg.SetLogger(&eventLogger{})
+	default:
+		return fmt.Errorf("unknown log format %q, expected 'text' or 'json'", a.Options.LogFormat)
+	}
+
+	if len(a.Options.EventsPath) > 0 {
+		f, err := os.Create(a.Options.EventsPath)
+		if err != nil {
+			return err
+		}
+		events.AddReporter(events.NewJsonFileReporter(f))
+	}
+
+	if len(a.Options.TracePath) > 0 || a.Options.Slowest > 0 {
+		if len(a.Options.TracePath) > 0 && a.Options.Slowest == 0 {
+			a.Options.Slowest = DefaultSlowestFiles
+		}
+		a.trace = events.NewTraceReporter(a.Options.TracePath)
+		events.AddReporter(a.trace)
+	}
+
+	a.diagnostics = diagnostics.NewCollector()
+	events.AddReporter(a.diagnostics)
+	return nil
+}
+
+// DefaultSlowestFiles is the number of slowest compiled files that are listed at the end
+// of a build with --trace, unless --slowest is given.
+const DefaultSlowestFiles = 10
+
+// logSlowestFiles lists the slowest compiled files of the build that just ended, each with
+// the time trace that clang wrote for it (--time-trace).
+func (a *App) logSlowestFiles() {
+	if a.trace == nil || a.Options.Slowest <= 0 {
+		return
+	}
+	spans := a.trace.Slowest(a.Options.Slowest)
+	if len(spans) == 0 {
+		return
+	}
+	lines := &strings.Builder{}
+	for _, span := range spans {
+		fmt.Fprintf(lines, "\n  %7.2fs  %s  %s", span.Duration.Seconds(), span.Project, span.File)
+		if len(span.TimeTrace) > 0 {
+			fmt.Fprintf(lines, " (time trace: %s)", span.TimeTrace)
+		}
+	}
+	corepkg.LogInfof("Slowest files:%s", lines.String())
+}
+
+// eventLogger is a corepkg.Logger that emits the log messages as 'log' events, so that
+// stdout only contains JSON lines.
+type eventLogger struct{}
+
+func (l *eventLogger) emit(level string, message string) {
+	events.Emit(&events.Event{Kind: events.Log, Level: level, Message: strings.TrimRight(message, "\n")})
+}
+
+func (l *eventLogger) LogInfo(message ...string) {
+	l.emit("info", strings.Join(message, ""))
+}
+
+func (l *eventLogger) LogInfof(format string, args ...any) {
+	l.emit("info", fmt.Sprintf(format, args...))
+}
+
+func (l *eventLogger) LogWarning(err error) {
+	l.emit("warning", err.Error())
+}
+
+func (l *eventLogger) LogWarningf(format string, args ...any) {
+	l.emit("warning", fmt.Sprintf(format, args...))
+}
+
+func (l *eventLogger) LogError(err error, msg ...string) error {
+	message := strings.Join(msg, " ")
+	if err != nil {
+		message = strings.TrimSpace(message + " " + err.Error())
+	}
+	l.emit("error", message)
+	return err
+}
+
+func (l *eventLogger) LogErrorf(err error, format string, args ...any) error {
+	message := fmt.Sprintf(format, args...)
+	if err != nil {
+		message += " - " + err.Error()
+	}
+	l.emit("error", message)
+	return err
+}
+
+func (l *eventLogger) LogFatal(err error) {
+	l.emit("fatal", err.Error())
+}
+
+func (l *eventLogger) LogFatalf(format string, args ...any) {
+	l.emit("fatal", fmt.Sprintf(format, args...))
+}
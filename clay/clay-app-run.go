@@ -0,0 +1,74 @@
+package clay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// Run builds the executable project (when out of date) and runs it with the project build
+// path as the working directory, this is where the Copy2Output files are copied to. The
+// standard input and output are forwarded and the exit code of the executable is returned.
+// For Arduino targets the executable is flashed and the serial monitor is attached.
+func (a *App) Run(args []string) (exitCode int, err error) {
+	executables, buildPath, ok := a.build(func(prj *Project) bool { return true })
+	if !ok {
+		return 1, fmt.Errorf("build failed")
+	}
+	if len(executables) == 0 {
+		return 1, fmt.Errorf("no executable project found to run")
+	}
+	if len(executables) > 1 {
+		names := make([]string, 0, len(executables))
+		for _, prj := range executables {
+			names = append(names, prj.DevProject.Name)
+		}
+		return 1, fmt.Errorf("please specify the project to run using -p <project>, one of: %s", strings.Join(names, ", "))
+	}
+	prj := executables[0]
+
+	if a.BuildTarget.Arduino() {
+		corepkg.LogInff("Flashing project: %s, config: %s", prj.DevProject.Name, a.BuildConfig.String())
+		startTime := time.Now()
+		if err := prj.Flash(a.BuildConfig, a.BuildTarget, buildPath); err != nil {
+			return 1, err
+		}
+		corepkg.LogInff("Flashing done ... (duration %s)", time.Since(startTime).Round(time.Second))
+		return 0, a.SerialMonitor(defaultSerialPort, defaultSerialBaud)
+	}
+
+	// The working directory changes, so the executable needs an absolute path
+	executable, err := filepath.Abs(prj.GetExecutableFilepath(a.BuildConfig, a.BuildTarget, buildPath))
+	if err != nil {
+		return 1, err
+	}
+
+	cmd := exec.Command(executable, args...)
+	cmd.Dir = prj.GetBuildPath(buildPath)
+	cmd.Env = a.sanitizerEnv()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// An interrupt (Ctrl-C) is also received by the executable, let the executable decide
+	// what to do with it instead of terminating clay while the executable is running.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return 1, err
+	}
+	return 0, nil
+}
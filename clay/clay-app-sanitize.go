@@ -0,0 +1,32 @@
+package clay
+
+import (
+	"os"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+)
+
+// sanitizerOptions are the options of the sanitizers for clay test and clay run, they
+// report a failure with a symbolized stack and stop the executable at the first error.
+var sanitizerOptions = map[toolchain.Sanitizer][2]string{
+	toolchain.SanitizerAddress:   {"ASAN_OPTIONS", "symbolize=1:detect_stack_use_after_return=1:check_initialization_order=1:strict_string_checks=1"},
+	toolchain.SanitizerUndefined: {"UBSAN_OPTIONS", "symbolize=1:print_stacktrace=1:halt_on_error=1"},
+	toolchain.SanitizerThread:    {"TSAN_OPTIONS", "symbolize=1:second_deadlock_stack=1:halt_on_error=1"},
+}
+
+// sanitizerEnv returns the environment for running an executable that is built with
+// sanitizers, an option that is already set in the environment is not changed. Without
+// sanitizers it returns nil, the executable then gets the environment of clay.
+func (a *App) sanitizerEnv() []string {
+	if len(a.Options.Sanitize) == 0 {
+		return nil
+	}
+	env := os.Environ()
+	for _, sanitizer := range a.Options.Sanitize {
+		option := sanitizerOptions[sanitizer]
+		if _, ok := os.LookupEnv(option[0]); !ok {
+			env = append(env, option[0]+"="+option[1])
+		}
+	}
+	return env
+}
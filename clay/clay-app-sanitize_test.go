@@ -0,0 +1,49 @@
+package clay
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+)
+
+func TestSanitizerBuildPath(t *testing.T) {
+	app := NewApp(nil)
+	if buildPath := app.GetBuildPath("linux-x64-debug"); buildPath != filepath.Join("build", "linux-x64-debug") {
+		t.Errorf("expected the normal build directory without sanitizers, got %q", buildPath)
+	}
+
+	sanitizers, err := toolchain.ParseSanitizers("undefined, address,undefined")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Options.Sanitize = sanitizers
+	if buildPath := app.GetBuildPath("linux-x64-debug"); buildPath != filepath.Join("build", "linux-x64-debug-asan-ubsan") {
+		t.Errorf("expected a build directory per combination of sanitizers, got %q", buildPath)
+	}
+
+	if _, err := toolchain.ParseSanitizers("address,thread"); err == nil {
+		t.Errorf("expected the address and thread sanitizers not to combine")
+	}
+	if _, err := toolchain.ParseSanitizers("memory"); err == nil {
+		t.Errorf("expected an unknown sanitizer to fail")
+	}
+}
+
+func TestSanitizerEnv(t *testing.T) {
+	app := NewApp(nil)
+	if env := app.sanitizerEnv(); env != nil {
+		t.Errorf("expected no environment without sanitizers, got %v", env)
+	}
+
+	t.Setenv("ASAN_OPTIONS", "detect_leaks=0")
+	app.Options.Sanitize = []toolchain.Sanitizer{toolchain.SanitizerAddress, toolchain.SanitizerUndefined}
+	env := app.sanitizerEnv()
+	if !slices.Contains(env, "ASAN_OPTIONS=detect_leaks=0") || slices.ContainsFunc(env, func(s string) bool { return s == "ASAN_OPTIONS="+sanitizerOptions[toolchain.SanitizerAddress][1] }) {
+		t.Errorf("expected ASAN_OPTIONS of the environment to be kept")
+	}
+	if !slices.Contains(env, "UBSAN_OPTIONS="+sanitizerOptions[toolchain.SanitizerUndefined][1]) {
+		t.Errorf("expected UBSAN_OPTIONS to be set")
+	}
+}
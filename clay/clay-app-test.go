@@ -0,0 +1,219 @@
+package clay
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// TestOptions are command-line options of the test command
+type TestOptions struct {
+	Timeout   time.Duration // Maximum duration of a single unittest executable
+	JUnitPath string        // Write a JUnit XML report to this file (empty = no report)
+	JsonPath  string        // Write a JSON summary to this file (empty = no summary)
+}
+
+// TestResult is the result of running a single unittest executable
+type TestResult struct {
+	Project    string        `json:"project"`
+	Executable string        `json:"executable"`
+	Passed     bool          `json:"passed"`
+	ExitCode   int           `json:"exit_code"`
+	TimedOut   bool          `json:"timed_out"`
+	Duration   time.Duration `json:"duration_ns"`
+	Output     string        `json:"output"`
+}
+
+// TestSummary is the result of running all the unittest executables
+type TestSummary struct {
+	Target  string        `json:"target"`
+	Config  string        `json:"config"`
+	Passed  int           `json:"passed"`
+	Failed  int           `json:"failed"`
+	Results []*TestResult `json:"results"`
+}
+
+// ParseTestOptionsAndConfig registers the options of the test command, which are the
+// options of the build command plus the test options.
+func ParseTestOptionsAndConfig(app *App) {
+	flag.DurationVar(&app.TestOptions.Timeout, "timeout", 10*time.Minute, "Maximum duration of a single unittest")
+	flag.StringVar(&app.TestOptions.JUnitPath, "junit", "", "Write a JUnit XML report to this file")
+	flag.StringVar(&app.TestOptions.JsonPath, "json", "", "Write a JSON summary to this file")
+	ParseBuildOptionsAndConfig(app)
+}
+
+// Test builds the unittest projects and runs each of them, it returns an error when
+// the build failed or any of the unittests failed.
+func (a *App) Test() error {
+	unittests, buildPath, ok := a.build(func(prj *Project) bool { return prj.DevProject.BuildType.IsUnittest() })
+	if !ok {
+		return fmt.Errorf("build failed")
+	}
+	if len(unittests) == 0 {
+		return fmt.Errorf("no unittest projects found for %s", filepath.Base(buildPath))
+	}
+	return a.runUnittests(unittests, buildPath)
+}
+
+// runUnittests runs the (built) unittest projects and writes the reports, it returns an
+// error when any of the unittests failed.
+func (a *App) runUnittests(unittests []*Project, buildPath string) error {
+	summary := &TestSummary{
+		Target:  a.BuildTarget.String(),
+		Config:  a.BuildConfig.String(),
+		Results: make([]*TestResult, 0, len(unittests)),
+	}
+	for _, prj := range unittests {
+		// The unittest runs in the build directory of its project, like 'clay run', so that it
+		// finds its data files (Copy2Output). The executable then needs an absolute path.
+		executable := prj.GetExecutableFilepath(a.BuildConfig, a.BuildTarget, buildPath)
+		if absExecutable, err := filepath.Abs(executable); err == nil {
+			executable = absExecutable
+		}
+		result := runUnittest(prj.DevProject.Name, executable, prj.GetBuildPath(buildPath), a.TestOptions.Timeout, a.runEnv(executable))
+		if result.Passed {
+			summary.Passed++
+			corepkg.LogInfof("PASS %s (%.2fs)", result.Project, result.Duration.Seconds())
+		} else {
+			summary.Failed++
+			reason := fmt.Sprintf("exit code %d", result.ExitCode)
+			if result.TimedOut {
+				reason = fmt.Sprintf("timed out after %s", a.TestOptions.Timeout)
+			}
+			corepkg.LogInfof("FAIL %s (%.2fs, %s), output:\n%s", result.Project, result.Duration.Seconds(), reason, result.Output)
+		}
+		summary.Results = append(summary.Results, result)
+	}
+
+	corepkg.LogInfof("Tests done: %d passed, %d failed", summary.Passed, summary.Failed)
+
+	if len(a.TestOptions.JUnitPath) > 0 {
+		if err := writeJUnitReport(a.TestOptions.JUnitPath, summary); err != nil {
+			return err
+		}
+	}
+	if len(a.TestOptions.JsonPath) > 0 {
+		content, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(a.TestOptions.JsonPath, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d unittests failed", summary.Failed, len(summary.Results))
+	}
+	return nil
+}
+
+// runUnittest runs a unittest executable in the directory dirpath, it passes when it exits
+// with code 0 within the timeout. A nil env runs it with the environment of clay.
+func runUnittest(name string, executable string, dirpath string, timeout time.Duration, env []string) *TestResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, executable)
+	cmd.Dir = dirpath
+	cmd.Env = env
+	cmd.WaitDelay = 5 * time.Second // Do not wait forever on child processes that keep the output open
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+
+	result := &TestResult{
+		Project:    name,
+		Executable: executable,
+		Duration:   time.Since(start),
+		Output:     string(out),
+		Passed:     err == nil,
+	}
+	if err != nil {
+		result.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.Output += err.Error()
+		}
+		result.TimedOut = ctx.Err() == context.DeadlineExceeded
+	}
+	return result
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// writeJUnitReport writes the summary as a JUnit XML report, each unittest executable
+// is a test case of a single test suite named after the target and config.
+func writeJUnitReport(reportFilepath string, summary *TestSummary) error {
+	suite := junitTestSuite{
+		Name:     summary.Target + "-" + summary.Config,
+		Tests:    len(summary.Results),
+		Failures: summary.Failed,
+	}
+
+	var total time.Duration
+	for _, result := range summary.Results {
+		testCase := junitTestCase{
+			Name:      result.Project,
+			Classname: suite.Name,
+			Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+			SystemOut: result.Output,
+		}
+		if !result.Passed {
+			message := fmt.Sprintf("exit code %d", result.ExitCode)
+			if result.TimedOut {
+				message = "timed out"
+			}
+			testCase.Failure = &junitFailure{Message: message, Content: result.Output}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+		total += result.Duration
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	report := junitTestSuites{Name: "clay", Tests: suite.Tests, Failures: suite.Failures, Suites: []junitTestSuite{suite}}
+	content, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(reportFilepath, append([]byte(xml.Header), content...), 0644)
+}
@@ -0,0 +1,81 @@
+package clay
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteJUnitReport(t *testing.T) {
+	summary := &TestSummary{
+		Target: "linux(x64)",
+		Config: "debug-dev-test",
+		Passed: 1,
+		Failed: 1,
+		Results: []*TestResult{
+			{Project: "unittest_a", Passed: true, Duration: 1500 * time.Millisecond, Output: "all tests passed"},
+			{Project: "unittest_b", Passed: false, ExitCode: 3, Duration: time.Second, Output: "expected <1> got <2>"},
+		},
+	}
+
+	reportFilepath := filepath.Join(t.TempDir(), "junit.xml")
+	if err := writeJUnitReport(reportFilepath, summary); err != nil {
+		t.Fatalf("writeJUnitReport() error = %v", err)
+	}
+
+	content, err := os.ReadFile(reportFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report junitTestSuites
+	if err := xml.Unmarshal(content, &report); err != nil {
+		t.Fatalf("invalid JUnit XML: %v", err)
+	}
+	if report.Tests != 2 || report.Failures != 1 || len(report.Suites) != 1 {
+		t.Fatalf("expected 2 tests and 1 failure in 1 suite, got %d tests, %d failures, %d suites", report.Tests, report.Failures, len(report.Suites))
+	}
+	testCases := report.Suites[0].TestCases
+	if testCases[0].Failure != nil || testCases[0].Time != "1.500" {
+		t.Errorf("expected unittest_a to pass in 1.500s, got %+v", testCases[0])
+	}
+	if testCases[1].Failure == nil || testCases[1].Failure.Message != "exit code 3" || !strings.Contains(testCases[1].Failure.Content, "expected <1>") {
+		t.Errorf("expected unittest_b to fail with its output, got %+v", testCases[1])
+	}
+}
+
+func TestRunUnittestMissingExecutable(t *testing.T) {
+	result := runUnittest("unittest_missing", filepath.Join(t.TempDir(), "unittest_missing"), "", time.Minute, nil)
+	if result.Passed || result.ExitCode != -1 || result.TimedOut {
+		t.Errorf("expected a missing executable to fail without timing out, got %+v", result)
+	}
+}
+
+func TestRunUnittestWorkingDirectory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the unittest is a shell script")
+	}
+
+	// The unittest finds its data file (Copy2Output) in the build directory of its project
+	buildPath := t.TempDir()
+	executable := filepath.Join(buildPath, "unittest_data")
+	if err := os.WriteFile(executable, []byte("#!/bin/sh\ntest -f data/test.txt\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(buildPath, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(buildPath, "data", "test.txt"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := runUnittest("unittest_data", executable, buildPath, time.Minute, nil); !result.Passed {
+		t.Errorf("expected the unittest to find its data in its build directory, got %+v", result)
+	}
+	if result := runUnittest("unittest_data", executable, t.TempDir(), time.Minute, nil); result.Passed {
+		t.Errorf("expected the unittest not to find its data in another directory, got %+v", result)
+	}
+}
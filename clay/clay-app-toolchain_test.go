@@ -0,0 +1,118 @@
+package clay
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+const testToolchainDescriptor = `{
+  "name": "test-gcc",
+  "vars": {
+    "compiler.prefix": ["test-"],
+    "recipe.c.pattern": ["{compiler.prefix}gcc", "-c", "{build.config.flags}", "{build.defines}", "{build.includes}"],
+    "recipe.cpp.pattern": ["{compiler.prefix}g++", "-c", "{build.config.flags}", "{build.defines}", "{build.includes}"],
+    "recipe.ar.pattern": ["{compiler.prefix}ar", "rcs"],
+    "recipe.link.pattern": ["{compiler.prefix}g++", "{library.paths}"]
+  },
+  "config.flags": {"debug": ["-O1"], "release": ["-O1"], "final": ["-O1"]},
+  "args": {
+    "compile": ["-MF", "{dep.file}", "-o", "{object.file}", "{source.file}"],
+    "archive": ["{archive.file}", "{object.files}"],
+    "link": ["-o", "{executable.file}", "{object.files}", "{archive.files}", "{library.files}"]
+  },
+  "extensions": {"executable": ".elf", "lib.prefix": ""},
+  "env": {"PATH": "{toolchain.dir}/bin"}
+}`
+
+func TestCustomToolchainDescriptors(t *testing.T) {
+	builtin := toolchain.BuiltinCustomToolchains()
+	for _, name := range []string{"aarch64-linux-gnu", "arm-none-eabi", "riscv64-linux-gnu"} {
+		if !slices.Contains(builtin, name) {
+			t.Errorf("expected %s to be a built-in toolchain, got %v", name, builtin)
+		}
+	}
+	for _, name := range builtin {
+		if descriptor, err := toolchain.LoadCustomToolchainDescriptor(name); err != nil {
+			t.Errorf("expected the built-in toolchain %s to load, got %v", name, err)
+		} else if descriptor.Name != name {
+			t.Errorf("expected the built-in toolchain %s to be named after its file, got %s", name, descriptor.Name)
+		}
+	}
+	if _, err := toolchain.LoadCustomToolchainDescriptor("no-such-toolchain"); err == nil {
+		t.Errorf("expected an unknown toolchain to fail")
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"name": "invalid"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toolchain.LoadCustomToolchainDescriptor(invalid); err == nil {
+		t.Errorf("expected a descriptor without recipes to fail")
+	}
+}
+
+func TestCustomToolchain(t *testing.T) {
+	dirpath := t.TempDir()
+	descriptorFilepath := filepath.Join(dirpath, "test-gcc.json")
+	if err := os.WriteFile(descriptorFilepath, []byte(testToolchainDescriptor), 0644); err != nil {
+		t.Fatal(err)
+	}
+	descriptor, err := toolchain.LoadCustomToolchainDescriptor(descriptorFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp(nil)
+	app.customToolchain = descriptor
+	if buildPath := app.GetBuildPath("linux-arm64-debug"); buildPath != filepath.Join("build", "linux-arm64-debug-test-gcc") {
+		t.Errorf("expected a build directory per custom toolchain, got %q", buildPath)
+	}
+
+	// The compiler is found in the PATH of the environment of the descriptor
+	compilerFilepath := filepath.Join(dirpath, "bin", "test-g++")
+	if runtime.GOOS != "windows" {
+		if err := os.MkdirAll(filepath.Dir(compilerFilepath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(compilerFilepath, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tc := toolchain.NewCustomToolchain(descriptor, "test", "build", "arm64")
+	compiler := tc.NewCompiler(denv.BuildConfig{}, denv.BuildTarget{})
+	compiler.SetupArgs("test", "build", []string{"NDEBUG"}, []string{"/src/include"})
+	if objFilepath := compiler.ObjFilepath("main.cpp"); objFilepath != "main.cpp.o" {
+		t.Errorf("expected the default object extension, got %q", objFilepath)
+	}
+	if depFilepath := compiler.DepFilepath("main.cpp"); depFilepath != "main.cpp.d" {
+		t.Errorf("expected the default dependency file extension, got %q", depFilepath)
+	}
+
+	compilerPath, compilerArgs := compiler.CompileCommand("/src/main.cpp", "main.cpp.o")
+	if runtime.GOOS != "windows" && compilerPath != compilerFilepath {
+		t.Errorf("expected the compiler to be found in the PATH of the toolchain, got %q", compilerPath)
+	}
+	expected := []string{"-c", "-O1", "-DNDEBUG", "-I/src/include", "-MF", "main.cpp.d", "-o", "main.cpp.o", "/src/main.cpp"}
+	if !slices.Equal(compilerArgs, expected) {
+		t.Errorf("expected the compile command %v, got %v", expected, compilerArgs)
+	}
+	if cPath, _ := compiler.CompileCommand("/src/util.c", "util.c.o"); filepath.Base(cPath) != "test-gcc" {
+		t.Errorf("expected C files to be compiled with the C recipe, got %q", cPath)
+	}
+
+	archiver := tc.NewArchiver(toolchain.ArchiverTypeStatic, denv.BuildConfig{}, denv.BuildTarget{})
+	if libFilepath := archiver.LibFilepath(filepath.Join("build", "test")); libFilepath != filepath.Join("build", "test.a") {
+		t.Errorf("expected the library prefix of the descriptor, got %q", libFilepath)
+	}
+	linker := tc.NewLinker(denv.BuildConfig{}, denv.BuildTarget{})
+	if linkedFilepath := linker.LinkedFilepath(filepath.Join("build", "test")); linkedFilepath != filepath.Join("build", "test.elf") {
+		t.Errorf("expected the executable extension of the descriptor, got %q", linkedFilepath)
+	}
+}
@@ -0,0 +1,266 @@
+package clay
+
+import (
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// WatchOptions are command-line options of the watch command
+type WatchOptions struct {
+	Interval time.Duration // Time between two polls of the watched files
+	Test     bool          // Run the unittests after every successful build
+}
+
+// ParseWatchOptionsAndConfig registers the options of the watch command, which are the
+// options of the test command (and thus the build command) plus the watch options.
+func ParseWatchOptionsAndConfig(app *App) {
+	flag.DurationVar(&app.WatchOptions.Interval, "interval", 500*time.Millisecond, "Time between two polls of the watched files")
+	flag.BoolVar(&app.WatchOptions.Test, "test", false, "Build and run the unittests after every change")
+	ParseTestOptionsAndConfig(app)
+}
+
+// Watch builds once and then polls the source directories and include directories of the
+// projects, and the headers known by their dependency trackers. After a change, once
+// no more changes are detected for one interval, the projects that use the changed files
+// and the projects that depend on them are rebuilt. Watch only returns on an error that
+// is not a build error, a failing build (or unittest) is logged and watching continues.
+func (a *App) Watch() error {
+	isSelected := func(prj *Project) bool { return prj.IsExecutable() }
+	if a.WatchOptions.Test {
+		isSelected = func(prj *Project) bool { return prj.DevProject.BuildType.IsUnittest() }
+	}
+
+	var isAffected func(prj *Project) bool // nil = all projects
+	var lastSnapshot map[string]watchedFileState
+	for {
+		executables, buildPath, ok := a.buildAffected(isSelected, isAffected)
+		if !ok {
+			corepkg.LogInfo("Build failed, waiting for changes ...")
+		} else if a.WatchOptions.Test && len(executables) > 0 {
+			if err := a.runUnittests(executables, buildPath); err != nil {
+				corepkg.LogError(err, "Unittests failed")
+			}
+		}
+
+		// The watched files are collected after every build, a build may have discovered
+		// new headers and new source files may have been added.
+		prjs, watched, err := a.collectWatchSet(buildPath)
+		if err != nil {
+			return err
+		}
+		corepkg.LogInfof("Watching %d directories and %d files, press Ctrl-C to stop", len(watched.dirs), len(watched.files))
+
+		// Files that changed during the build differ from the snapshot taken before the build
+		snapshot := watched.snapshot()
+		for path, state := range lastSnapshot {
+			if _, ok := snapshot[path]; ok {
+				snapshot[path] = state
+			}
+		}
+
+		var changed []string
+		changed, lastSnapshot = watched.waitForChanges(snapshot, a.WatchOptions.Interval)
+		corepkg.LogInfof("Changed: %s", strings.Join(changed, ", "))
+
+		// After a failed build everything is built again, when the build stopped at the first
+		// failure there can be projects that were not built at all.
+		isAffected = nil
+		if ok {
+			affected := watched.affectedProjects(prjs, changed)
+			isAffected = func(prj *Project) bool { return affected[prj.DevProject.Name] }
+		}
+	}
+}
+
+// watchSet is the set of directories and files that are watched, each with the names of
+// the projects that use them.
+type watchSet struct {
+	buildRoot string              // Files in the build directory are ignored, the build writes them
+	dirs      map[string][]string // Directories that are watched recursively
+	files     map[string][]string // Files that are watched, e.g. headers outside of the directories
+}
+
+type watchedFileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func newWatchSet(buildRoot string) *watchSet {
+	buildRoot, _ = filepath.Abs(buildRoot)
+	return &watchSet{buildRoot: buildRoot, dirs: map[string][]string{}, files: map[string][]string{}}
+}
+
+// collectWatchSet returns the projects and the directories and files they use, for every
+// project this is the source directories, its include directories and all the files
+// that are listed in its dependency tracker.
+func (a *App) collectWatchSet(buildPath string) ([]*Project, *watchSet, error) {
+	prjs, err := a.CreateProjects(a.BuildTarget, a.BuildConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	watched := newWatchSet("build")
+	for _, prj := range prjs {
+		if !prj.CanBuildFor(a.BuildConfig, a.BuildTarget) {
+			continue
+		}
+		if err := a.SetToolchain(prj, buildPath); err != nil {
+			return nil, nil, err
+		}
+
+		name := prj.DevProject.Name
+		for _, srcDir := range prj.DevProject.SourceDirs {
+			watched.addDir(corepkg.PathNormalize(srcDir.Path.String()), name)
+		}
+		if prjConfig := prj.GetConfig(a.BuildConfig); prjConfig != nil {
+			for _, incDir := range prjConfig.IncludeDirs {
+				watched.addDir(corepkg.PathNormalize(incDir.String()), name)
+			}
+		}
+		prj.Toolchain.NewDependencyTracker(prj.GetBuildPath(buildPath)).ForEachItem(func(item string, deps []string) {
+			watched.addFile(item, name)
+			for _, dep := range deps {
+				watched.addFile(dep, name)
+			}
+		})
+	}
+	return prjs, watched, nil
+}
+
+func (w *watchSet) addDir(dirpath string, project string) {
+	if dirpath, err := filepath.Abs(dirpath); err == nil && !w.isBuildOutput(dirpath) {
+		if !slices.Contains(w.dirs[dirpath], project) {
+			w.dirs[dirpath] = append(w.dirs[dirpath], project)
+		}
+	}
+}
+
+func (w *watchSet) addFile(path string, project string) {
+	if path, err := filepath.Abs(path); err == nil && !w.isBuildOutput(path) {
+		if !slices.Contains(w.files[path], project) {
+			w.files[path] = append(w.files[path], project)
+		}
+	}
+}
+
+func (w *watchSet) isBuildOutput(path string) bool {
+	return path == w.buildRoot || strings.HasPrefix(path, w.buildRoot+string(filepath.Separator))
+}
+
+// snapshot returns the state of all the files in the watched directories and of the
+// watched files, files that do not exist are not part of the snapshot.
+func (w *watchSet) snapshot() map[string]watchedFileState {
+	states := make(map[string]watchedFileState, len(w.files))
+	for dirpath := range w.dirs {
+		filepath.WalkDir(dirpath, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // The directory may not exist (yet)
+			}
+			if entry.IsDir() {
+				if path != dirpath && (strings.HasPrefix(entry.Name(), ".") || w.isBuildOutput(path)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info, err := entry.Info(); err == nil {
+				states[path] = watchedFileState{modTime: info.ModTime(), size: info.Size()}
+			}
+			return nil
+		})
+	}
+	for path := range w.files {
+		if _, ok := states[path]; ok {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			states[path] = watchedFileState{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return states
+}
+
+// waitForChanges polls until files have changed, added or removed compared to the snapshot
+// before, and then keeps polling until no more changes are detected, so that a burst of
+// changes (e.g. saving all files in an editor, switching a git branch) results in a single
+// build. It returns the changed files and the last snapshot.
+func (w *watchSet) waitForChanges(before map[string]watchedFileState, interval time.Duration) ([]string, map[string]watchedFileState) {
+	changed := map[string]bool{}
+	for {
+		time.Sleep(interval)
+		after := w.snapshot()
+		changes := changedFiles(before, after)
+		if len(changes) == 0 && len(changed) > 0 {
+			break
+		}
+		for _, path := range changes {
+			changed[path] = true
+		}
+		before = after
+	}
+
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths, before
+}
+
+// changedFiles returns the files that differ between two snapshots
+func changedFiles(before, after map[string]watchedFileState) []string {
+	changed := []string{}
+	for path, state := range after {
+		if prev, ok := before[path]; !ok || prev.size != state.size || !prev.modTime.Equal(state.modTime) {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	return changed
+}
+
+// affectedProjects returns the names of the projects that use any of the changed files,
+// together with the projects that (indirectly) depend on them.
+func (w *watchSet) affectedProjects(prjs []*Project, changed []string) map[string]bool {
+	affected := map[string]bool{}
+	for _, path := range changed {
+		for _, name := range w.files[path] {
+			affected[name] = true
+		}
+		for dirpath, names := range w.dirs {
+			if strings.HasPrefix(path, dirpath+string(filepath.Separator)) {
+				for _, name := range names {
+					affected[name] = true
+				}
+			}
+		}
+	}
+
+	// Add the dependents until nothing is added anymore
+	for added := true; added; {
+		added = false
+		for _, prj := range prjs {
+			if affected[prj.DevProject.Name] {
+				continue
+			}
+			for _, dep := range prj.Dependencies {
+				if affected[dep.DevProject.Name] {
+					affected[prj.DevProject.Name] = true
+					added = true
+					break
+				}
+			}
+		}
+	}
+	return affected
+}
@@ -0,0 +1,76 @@
+package clay
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+func TestWatchSetChanges(t *testing.T) {
+	workDir := t.TempDir()
+	srcDir := filepath.Join(workDir, "source")
+	buildDir := filepath.Join(workDir, "build")
+	for _, dir := range []string{srcDir, buildDir} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srcFilepath := filepath.Join(srcDir, "a.cpp")
+	hdrFilepath := filepath.Join(workDir, "sdk", "sdk.h")
+	objFilepath := filepath.Join(buildDir, "a.cpp.o")
+	for _, path := range []string{srcFilepath, hdrFilepath, objFilepath} {
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err := os.WriteFile(path, []byte("// "+path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watched := newWatchSet(buildDir)
+	watched.addDir(srcDir, "liba")
+	watched.addFile(hdrFilepath, "liba")
+	watched.addFile(objFilepath, "liba")
+	if len(watched.files) != 1 {
+		t.Fatalf("expected the object file in the build directory to be ignored, got %v", watched.files)
+	}
+
+	before := watched.snapshot()
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(hdrFilepath, later, later)
+	newFilepath := filepath.Join(srcDir, "b.cpp")
+	os.WriteFile(newFilepath, []byte("// b"), 0644)
+
+	changed := changedFiles(before, watched.snapshot())
+	slices.Sort(changed)
+	expected := []string{hdrFilepath, newFilepath}
+	slices.Sort(expected)
+	if !slices.Equal(changed, expected) {
+		t.Errorf("expected %v to be changed, got %v", expected, changed)
+	}
+}
+
+func TestWatchSetAffectedProjects(t *testing.T) {
+	libA := newTestProject("liba", denv.BuildTypeStaticLibrary)
+	libB := newTestProject("libb", denv.BuildTypeStaticLibrary)
+	shared := newTestProject("shared", denv.BuildTypeDynamicLibrary, libA)
+	plugin := newTestProject("plugin", denv.BuildTypeDynamicLibrary, shared)
+	prjs := []*Project{plugin, shared, libB, libA}
+
+	watched := newWatchSet(filepath.Join(t.TempDir(), "build"))
+	srcDir, _ := filepath.Abs("liba-source")
+	watched.addDir(srcDir, "liba")
+	watched.addFile("libb.h", "libb")
+
+	affected := watched.affectedProjects(prjs, []string{filepath.Join(srcDir, "a.cpp")})
+	for _, name := range []string{"liba", "shared", "plugin"} {
+		if !affected[name] {
+			t.Errorf("expected %s to be affected, got %v", name, affected)
+		}
+	}
+	if affected["libb"] {
+		t.Errorf("expected libb not to be affected, got %v", affected)
+	}
+}
@@ -0,0 +1,53 @@
+package clay
+
+import (
+	"flag"
+	"net"
+	"runtime"
+	"strings"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/worker"
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// WorkerOptions are command-line options of the worker command
+type WorkerOptions struct {
+	Address string // The address (host:port) the worker listens on
+	Jobs    int    // Maximum number of files to compile in parallel
+	Allow   string // Comma separated globs of the compiler executable names that the worker runs
+}
+
+// ParseWorkerOptions parses the options of the worker command
+func ParseWorkerOptions() WorkerOptions {
+	options := WorkerOptions{}
+	numCPU := runtime.NumCPU()
+	flag.StringVar(&options.Address, "listen", worker.DefaultAddress, "Address (host:port) to listen on for compile requests")
+	flag.IntVar(&options.Jobs, "jobs", numCPU, "Number of files to compile in parallel")
+	flag.IntVar(&options.Jobs, "j", numCPU, "Number of files to compile in parallel (shorthand)")
+	flag.StringVar(&options.Allow, "allow", strings.Join(worker.DefaultAllow, ","), "Comma separated globs of the compilers that are allowed")
+	flag.Parse()
+	return options
+}
+
+// Worker serves the compile requests of Clay clients (clay build --workers) until it is
+// stopped. The clients preprocess their source files, the worker only needs the compilers.
+// A worker only runs the code generation, warning and -std/-f/-m arguments that a client
+// sends, but it compiles for anyone that can connect, so it should only listen on a trusted
+// network.
+func (a *App) Worker(options WorkerOptions) error {
+	allow := []string{}
+	for _, pattern := range strings.Split(options.Allow, ",") {
+		if pattern = strings.TrimSpace(pattern); len(pattern) > 0 {
+			allow = append(allow, pattern)
+		}
+	}
+
+	listener, err := net.Listen("tcp", options.Address)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	corepkg.LogInfof("Worker listening on %s, compiling %d files in parallel with %s", listener.Addr(), options.Jobs, strings.Join(allow, ", "))
+	return worker.Serve(listener, worker.NewService(options.Jobs, allow))
+}
@@ -0,0 +1,112 @@
+package clay
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/worker"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+// compileEndReporter records the compile end events
+type compileEndReporter struct {
+	ends []*events.Event
+}
+
+func (r *compileEndReporter) Report(e *events.Event) {
+	if e.Kind == events.CompileEnd {
+		r.ends = append(r.ends, e)
+	}
+}
+
+func (r *compileEndReporter) Close() error { return nil }
+
+func TestRemoteCompiler(t *testing.T) {
+	if _, err := exec.LookPath("g++"); err != nil {
+		t.Skip("g++ is not available")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go worker.Serve(listener, worker.NewService(2, nil))
+
+	// An unreachable worker is only tried once, its files are compiled by the other worker
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable.Close()
+
+	dirpath := t.TempDir()
+	includeDirpath := filepath.Join(dirpath, "include")
+	buildPath := filepath.Join(dirpath, "build")
+	for path, content := range map[string]string{
+		filepath.Join(includeDirpath, "lib.h"): "#pragma once\nint lib_value();\n",
+		filepath.Join(dirpath, "lib.cpp"):      "#include \"lib.h\"\nint lib_value() { return LIB_VALUE; }\n",
+		filepath.Join(dirpath, "broken.cpp"):   "int broken() { return ; }\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(buildPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := worker.NewPool([]string{unreachable.Addr().String(), listener.Addr().String()})
+	defer pool.Close()
+	gcc := toolchain.NewLinuxGcc(corepkg.NewVars(corepkg.VarsFormatCurlyBraces), "test", buildPath, "amd64")
+	compiler := gcc.NewCompiler(denv.BuildConfig{}, denv.BuildTarget{})
+	compiler.SetupArgs("test", buildPath, []string{"LIB_VALUE=42"}, []string{includeDirpath})
+	remote := toolchain.NewRemoteCompiler(compiler, pool, "test", denv.BuildConfig{})
+
+	sources := []string{filepath.Join(dirpath, "lib.cpp"), filepath.Join(dirpath, "broken.cpp")}
+	objects := []string{filepath.Join(buildPath, "lib.cpp.o"), filepath.Join(buildPath, "broken.cpp.o")}
+	reporter := &compileEndReporter{}
+	events.AddReporter(reporter)
+	defer events.Close()
+	compiled, ok := remote.Compile(sources, objects)
+	if ok || !compiled[0] || compiled[1] {
+		t.Fatalf("expected lib.cpp to compile and broken.cpp to fail, got %v", compiled)
+	}
+
+	// The compile error of the worker is the result, broken.cpp is not compiled again locally
+	brokenEnds := []*events.Event{}
+	for _, e := range reporter.ends {
+		if e.File == sources[1] {
+			brokenEnds = append(brokenEnds, e)
+		}
+	}
+	if len(brokenEnds) != 1 || brokenEnds[0].Status != events.StatusFailed || brokenEnds[0].Worker == "" || !strings.Contains(brokenEnds[0].Message, "error") {
+		t.Errorf("expected broken.cpp to fail once on the worker, got %d compiles", len(brokenEnds))
+	}
+	if pool.Compiled() != 1 {
+		t.Errorf("expected lib.cpp to be compiled on the worker, %d files were compiled remotely", pool.Compiled())
+	}
+	if !corepkg.FileExists(objects[0]) {
+		t.Errorf("expected the object file %s", objects[0])
+	}
+
+	// The dependency file is written by the local preprocessor
+	depFilepath := filepath.Join(buildPath, compiler.DepFilepath("lib.cpp"))
+	content, err := os.ReadFile(depFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), objects[0]+":") || !strings.Contains(string(content), "lib.h") {
+		t.Errorf("expected the dependency file of the object file to list lib.h, got %q", string(content))
+	}
+}
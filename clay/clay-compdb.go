@@ -0,0 +1,116 @@
+package clay
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+// CompileCommand is an entry of a compilation database (compile_commands.json) as used
+// by clangd, CLion and VS Code.
+type CompileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Arguments []string `json:"arguments"`
+	Output    string   `json:"output"`
+}
+
+// CompileCommands returns the compile command of every source file of the project, the
+// compiler is setup in the same way as Build does. The precompiled header is not used, it
+// may not exist before the first build, its header is force-included instead.
+func (p *Project) CompileCommands(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget, buildPath string, directory string) []CompileCommand {
+	includes, defines := p.GetIncludesAndDefines(buildConfig, buildTarget)
+
+	projectBuildPath := p.GetBuildPath(buildPath)
+	compiler := p.Toolchain.NewCompiler(buildConfig, buildTarget)
+	compiler.SetupArgs(p.DevProject.Name, projectBuildPath, defines, includes)
+	pch := &toolchain.Pch{HeaderAbsFilepath: p.PchHeader}
+
+	commands := make([]CompileCommand, 0, len(p.SourceFiles))
+	for _, src := range p.SourceFiles {
+		objRelFilepath := filepath.Join(projectBuildPath, compiler.ObjFilepath(src.SrcRelPath))
+		compilerPath, compilerArgs := compiler.CompileCommand(src.SrcAbsPath, objRelFilepath)
+		if len(p.PchHeader) > 0 && pch.UsedBy(src.SrcAbsPath) {
+			// Force-include the header just before the source file
+			srcIndex := slices.Index(compilerArgs, src.SrcAbsPath)
+			if srcIndex < 0 {
+				srcIndex = len(compilerArgs)
+			}
+			compilerArgs = slices.Insert(compilerArgs, srcIndex, forceIncludeArgs(compilerPath, p.PchHeader)...)
+		}
+		commands = append(commands, CompileCommand{
+			Directory: directory,
+			File:      src.SrcAbsPath,
+			Arguments: append([]string{compilerPath}, compilerArgs...),
+			Output:    objRelFilepath,
+		})
+	}
+	return commands
+}
+
+// forceIncludeArgs returns the arguments that force-include a header, e.g. the header of the
+// precompiled header, which itself may not exist yet. Like clangd, the syntax follows from the
+// name of the compiler, 'cl' and 'clang-cl' use /FI and all other compilers -include.
+func forceIncludeArgs(compilerPath string, headerAbsFilepath string) []string {
+	name := compilerPath[strings.LastIndexAny(compilerPath, `/\`)+1:]
+	switch strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name))) {
+	case "cl", "clang-cl":
+		return []string{"/FI" + headerAbsFilepath}
+	}
+	return []string{"-include", headerAbsFilepath}
+}
+
+// CompileDb writes the compilation database of all the projects of the selected target,
+// config and board to build/compile_commands.json.
+func (a *App) CompileDb() error {
+	buildPath := a.GetBuildPath(GetBuildDirname(a.BuildConfig, a.BuildTarget))
+
+	prjs, err := a.CreateProjects(a.BuildTarget, a.BuildConfig)
+	if err != nil {
+		return err
+	}
+	for _, prj := range prjs {
+		if err := a.SetToolchain(prj, buildPath); err != nil {
+			return err
+		}
+	}
+
+	return a.writeCompileDb(prjs, buildPath)
+}
+
+func (a *App) writeCompileDb(prjs []*Project, buildPath string) error {
+	directory, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	commands := []CompileCommand{}
+	for _, prj := range prjs {
+		if prj.CanBuildFor(a.BuildConfig, a.BuildTarget) {
+			commands = append(commands, prj.CompileCommands(a.BuildConfig, a.BuildTarget, buildPath, directory)...)
+		}
+	}
+
+	content, err := json.MarshalIndent(commands, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// clangd looks for the compilation database in the 'build' directory of the workspace,
+	// the database is for the target, config and board that was selected last.
+	compileDbFilepath := filepath.Join("build", "compile_commands.json")
+	if err := os.MkdirAll(filepath.Dir(compileDbFilepath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(compileDbFilepath, content, 0644); err != nil {
+		return err
+	}
+	corepkg.LogInfof("Written %s (%d files, for %s)", compileDbFilepath, len(commands), buildPath)
+	return nil
+}
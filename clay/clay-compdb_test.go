@@ -0,0 +1,68 @@
+package clay
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+func TestCompileCommands(t *testing.T) {
+	dirpath := t.TempDir()
+	buildPath := filepath.Join(dirpath, "build")
+
+	prj := NewProjectFromDevProject(&denv.DevProject{Name: "test", BuildType: denv.BuildTypeStaticLibrary}, nil)
+	prj.Toolchain = toolchain.NewLinuxGcc(corepkg.NewVars(corepkg.VarsFormatCurlyBraces), "test", buildPath, "amd64")
+	prj.PchHeader = filepath.Join(dirpath, "src", "pch.h")
+	prj.AddSourceFile(filepath.Join(dirpath, "src", "main.cpp"), "main.cpp")
+	prj.AddSourceFile(filepath.Join(dirpath, "src", "util.c"), "util.c")
+
+	commands := prj.CompileCommands(denv.BuildConfig{}, denv.BuildTarget{}, buildPath, dirpath)
+	if len(commands) != 2 {
+		t.Fatalf("expected a compile command per source file, got %d", len(commands))
+	}
+
+	// The precompiled header does not exist before the first build, its header is force-included
+	cpp := commands[0]
+	if cpp.File != prj.SourceFiles[0].SrcAbsPath || cpp.Directory != dirpath || cpp.Output != filepath.Join(buildPath, "test", "main.cpp.o") {
+		t.Errorf("unexpected compile command %+v", cpp)
+	}
+	i := slices.Index(cpp.Arguments, "-include")
+	if i < 0 || cpp.Arguments[i+1] != prj.PchHeader || cpp.Arguments[i+2] != cpp.File {
+		t.Errorf("expected the header to be force-included before the source file, got %v", cpp.Arguments)
+	}
+	for _, arg := range cpp.Arguments {
+		if arg == "-include-pch" || arg == "-Winvalid-pch" || strings.HasSuffix(arg, ".gch") || strings.HasSuffix(arg, ".pch") {
+			t.Errorf("expected no precompiled header arguments, got %v", cpp.Arguments)
+		}
+	}
+	if corepkg.DirExists(filepath.Join(buildPath, "test", "pch")) {
+		t.Errorf("expected no precompiled header to be generated")
+	}
+
+	// The header is C++, a C source file does not include it
+	if slices.Contains(commands[1].Arguments, "-include") {
+		t.Errorf("expected a C source file not to force-include the header, got %v", commands[1].Arguments)
+	}
+}
+
+func TestForceIncludeArgs(t *testing.T) {
+	tests := []struct {
+		compilerPath string
+		expected     []string
+	}{
+		{"/usr/bin/g++", []string{"-include", "/src/pch.h"}},
+		{"clang++", []string{"-include", "/src/pch.h"}},
+		{`C:\msvc\bin\cl.exe`, []string{"/FI/src/pch.h"}},
+		{"clang-cl", []string{"/FI/src/pch.h"}},
+	}
+	for _, test := range tests {
+		if args := forceIncludeArgs(test.compilerPath, "/src/pch.h"); !slices.Equal(args, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.compilerPath, test.expected, args)
+		}
+	}
+}
@@ -1,11 +1,20 @@
 package clay
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jurgen-kluft/ccode/clay/toolchain"
 	"github.com/jurgen-kluft/ccode/clay/toolchain/deptrackr"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/diagnostics"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/objcache"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/worker"
 	"github.com/jurgen-kluft/go-ide/denv"
 
 	corepkg "github.com/jurgen-kluft/go-core"
@@ -14,12 +23,20 @@ import (
 // Project represents a C/C++ project that can be built using the Clay build system.
 // A project can be a library or an executable.
 type Project struct {
-	Toolchain    toolchain.Environment // Build environment for this project
-	DevProject   *denv.DevProject      // Development environment project (if any)
-	Config       []*denv.DevConfig     // Build configurations
-	SourceFiles  []SourceFile          // C/C++ Source files for the library
-	Dependencies []*Project            // Libraries that this project depends on
-	Frameworks   []string              // Frameworks to link against (for macOS)
+	Toolchain    toolchain.Environment  // Build environment for this project
+	DevProject   *denv.DevProject       // Development environment project (if any)
+	Config       []*denv.DevConfig      // Build configurations
+	SourceFiles  []SourceFile           // C/C++ Source files for the library
+	Dependencies []*Project             // Libraries that this project depends on
+	Frameworks   []string               // Frameworks to link against (for macOS)
+	Cache        *objcache.Cache        // Compilation cache shared by all build directories (nil = disabled)
+	Workers      *worker.Pool           // Workers that compile the preprocessed source files (nil = compile locally)
+	Explain      bool                   // Report why items are out of date (clay build --explain)
+	Pic          bool                   // Compiled as position independent code (-fPIC), the project is a shared library or is linked into one
+	Diagnostics  *diagnostics.Collector // Collects the compiler output, which is kept with every object file and replayed when it is not compiled (nil = not kept)
+	PchHeader    string                 // Header that is precompiled and force-included into every C++ source file (empty = none)
+	Unity        int                    // Number of unity translation units the C++ source files are combined into (0 = disabled, -1 = not set)
+	UnityExclude []string               // Globs of source files (relative paths) that are never part of a unity translation unit
 }
 
 func NewProjectFromDevProject(devPrj *denv.DevProject, configs []*denv.DevConfig) *Project {
@@ -29,6 +46,7 @@ func NewProjectFromDevProject(devPrj *denv.DevProject, configs []*denv.DevConfig
 		Toolchain:    nil,
 		SourceFiles:  []SourceFile{},
 		Dependencies: []*Project{},
+		Unity:        -1,
 	}
 }
 
@@ -41,6 +59,45 @@ func (p *Project) IsExecutable() bool {
 	return p.DevProject.BuildType.IsExecutable()
 }
 
+func (p *Project) IsSharedLibrary() bool {
+	return p.DevProject.BuildType.IsDynamicLibrary()
+}
+
+// ArchiverType returns the archiver that builds this library, a shared library is built
+// with the dynamic archiver.
+func (p *Project) ArchiverType() toolchain.ArchiverType {
+	if p.IsSharedLibrary() {
+		return toolchain.ArchiverTypeDynamic
+	}
+	return toolchain.ArchiverTypeStatic
+}
+
+// setPositionIndependent marks the project, and the libraries that are linked into it, to be
+// compiled as position independent code.
+func (p *Project) setPositionIndependent() {
+	if p.Pic {
+		return
+	}
+	p.Pic = true
+	for _, dep := range p.Dependencies {
+		if !dep.IsSharedLibrary() {
+			dep.setPositionIndependent()
+		}
+	}
+}
+
+// SharedLibraryDefine returns the name of a define derived from the project name,
+// e.g. 'my-lib' and '_EXPORTS' result in 'MY_LIB_EXPORTS'.
+func (p *Project) SharedLibraryDefine(suffix string) string {
+	name := []byte(strings.ToUpper(p.DevProject.Name))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name) + suffix
+}
+
 func (p *Project) GetOutputFilepath(buildPath, filename string) string {
 	return filepath.Join(buildPath, p.DevProject.Name, filename)
 }
@@ -49,6 +106,12 @@ func (p *Project) GetBuildPath(buildPath string) string {
 	return filepath.Join(buildPath, p.DevProject.Name)
 }
 
+// GetExecutableFilepath returns the filepath of the linked executable of this project
+func (p *Project) GetExecutableFilepath(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget, buildPath string) string {
+	linker := p.Toolchain.NewLinker(buildConfig, buildTarget)
+	return linker.LinkedFilepath(filepath.Join(p.GetBuildPath(buildPath), p.DevProject.Name))
+}
+
 func (p *Project) CanBuildFor(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) bool {
 	if !p.DevProject.BuildTargets.HasOverlap(buildTarget) {
 		return false
@@ -94,6 +157,59 @@ func (p *Project) GlobSourceFiles(isExcluded func(string) bool) {
 	}
 }
 
+// ProjectSetting returns the value of a setting of a project, which the package defines as
+// the variable '<project>.<setting>' in its vars function (denv.Package.SetGetVarsFunc), e.g.
+// 'mylib.pch.header'. It returns an empty string when the package does not set it.
+func ProjectSetting(vars *corepkg.Vars, projectName string, setting string) string {
+	if vars == nil {
+		return ""
+	}
+	return vars.GetFirstOrEmpty(projectName + "." + setting)
+}
+
+// SetPchHeader sets the precompiled header of the project to the header that the package
+// names with the setting 'pch.header'. An absolute path is used as is, a relative path is
+// looked up in the root of the source directories of the project, so 'pch.h' opts in to
+// precompiling the pch.h of the project.
+func (p *Project) SetPchHeader(vars *corepkg.Vars) {
+	pchHeader := ProjectSetting(vars, p.DevProject.Name, "pch.header")
+	if len(pchHeader) == 0 {
+		return
+	}
+	if filepath.IsAbs(pchHeader) {
+		p.PchHeader = pchHeader
+		return
+	}
+	for _, srcDir := range p.DevProject.SourceDirs {
+		pchHeaderFilepath := filepath.Join(corepkg.PathNormalize(srcDir.Path.String()), pchHeader)
+		if corepkg.FileExists(pchHeaderFilepath) {
+			if pchAbsHeader, err := filepath.Abs(pchHeaderFilepath); err == nil {
+				p.PchHeader = pchAbsHeader
+				return
+			}
+		}
+	}
+	corepkg.LogInfof("Precompiled header %q of project %s is not found in its source directories", pchHeader, p.DevProject.Name)
+}
+
+// SetUnity sets the number of unity translation units of the project to the setting 'unity'
+// of the package, and adds the globs of the setting 'unity.exclude' (comma separated) to the
+// source files that are never part of a unity translation unit.
+func (p *Project) SetUnity(vars *corepkg.Vars) {
+	if unity := ProjectSetting(vars, p.DevProject.Name, "unity"); len(unity) > 0 {
+		if files, err := strconv.Atoi(unity); err == nil && files >= 0 {
+			p.Unity = files
+		} else {
+			corepkg.LogInfof("Invalid number of unity files %q of project %s", unity, p.DevProject.Name)
+		}
+	}
+	for _, glob := range strings.Split(ProjectSetting(vars, p.DevProject.Name, "unity.exclude"), ",") {
+		if glob = strings.TrimSpace(glob); len(glob) > 0 {
+			p.UnityExclude = append(p.UnityExclude, glob)
+		}
+	}
+}
+
 func (p *Project) AddLibrary(lib *Project) {
 	p.Dependencies = append(p.Dependencies, lib)
 }
@@ -109,10 +225,20 @@ func (p *Project) GetConfig(buildConfig denv.BuildConfig) *denv.DevConfig {
 
 type CompileContext struct {
 	buildPath          string
+	projectName        string
+	explain            bool
+	diagnostics        *diagnostics.Collector // The compiler output of the compiled source files (nil = not kept)
 	toolchain          toolchain.Environment
 	compiler           toolchain.Compiler
+	pch                *toolchain.Pch // The precompiled header of the project (nil = none)
+	pchArgsHash        []byte
+	pchOutOfDate       bool
 	depTrackr          deptrackr.FileTrackr
+	cache              *objcache.Cache
 	srcFilesOutOfDate  []SourceFile
+	srcArgsOutOfDate   [][]byte   // The hash of the compiler command-line of each out-of-date source file
+	srcCacheKeys       [][]byte   // The compilation cache key of each out-of-date source file (nil = not cacheable)
+	srcDepsRestored    [][]string // The dependencies of each out-of-date source file restored from the cache
 	srcFilesCompiled   []bool
 	srcFilesUpToDate   []SourceFile
 	absSrcFilepaths    []string
@@ -129,12 +255,29 @@ func newCompileContext(buildPath string, project *Project, buildConfig denv.Buil
 	depTrackr := project.Toolchain.NewDependencyTracker(projectBuildPath)
 	numSourceFiles := len(project.SourceFiles)
 
+	var pch *toolchain.Pch
+	if len(project.PchHeader) > 0 {
+		if pch = compiler.SetupPch(project.PchHeader, projectBuildPath); pch == nil {
+			corepkg.LogInfof("Precompiled header %q is not used, the toolchain does not support it", project.PchHeader)
+		}
+	}
+	if project.Workers != nil {
+		compiler = toolchain.NewRemoteCompiler(compiler, project.Workers, project.DevProject.Name, buildConfig)
+	}
+
 	return &CompileContext{
 		buildPath:          projectBuildPath,
+		projectName:        project.DevProject.Name,
+		explain:            project.Explain,
+		diagnostics:        project.Diagnostics,
 		toolchain:          project.Toolchain,
 		depTrackr:          depTrackr,
+		cache:              project.Cache,
 		compiler:           compiler,
+		pch:                pch,
 		srcFilesOutOfDate:  make([]SourceFile, 0, numSourceFiles),
+		srcArgsOutOfDate:   make([][]byte, 0, numSourceFiles),
+		srcCacheKeys:       make([][]byte, 0, numSourceFiles),
 		srcFilesCompiled:   make([]bool, 0, numSourceFiles),
 		srcFilesUpToDate:   make([]SourceFile, 0, numSourceFiles),
 		absSrcFilepaths:    []string{},
@@ -143,31 +286,205 @@ func newCompileContext(buildPath string, project *Project, buildConfig denv.Buil
 	}
 }
 
-func (cc *CompileContext) queryItem(item string) bool {
-	return cc.depTrackr.QueryItem(item)
+// queryItem returns true when the item and its dependencies are up-to-date and the item
+// was produced with the same command-line (argsHash).
+func (cc *CompileContext) queryItem(item string, argsHash []byte) bool {
+	return cc.depTrackr.QueryItemWithExtraData(item, argsHash)
+}
+
+// explainItem reports why an out-of-date item is out of date (clay build --explain), as a
+// tree in the log and as an explain event. Dependencies that are rebuilt by this build,
+// e.g. the precompiled header, are reported as changed.
+func (cc *CompileContext) explainItem(item string, argsHash []byte, rebuiltDeps ...string) {
+	if !cc.explain {
+		return
+	}
+
+	explanations := cc.depTrackr.ExplainItem(item, argsHash)
+	for _, dep := range rebuiltDeps {
+		explanations = append(explanations, deptrackr.Explanation{Reason: deptrackr.ReasonChangedFile, File: dep})
+	}
+	cc.reportExplanations(item, explanations)
+}
+
+// queryMembersItem returns true when the item, e.g. an archive or an executable, is up-to-date
+// and was produced with the same command-line (argsHash) out of exactly the same members.
+func (cc *CompileContext) queryMembersItem(item string, argsHash []byte, members []string) bool {
+	return cc.depTrackr.QueryItemWithMembers(item, argsHash, members)
 }
 
-func (cc *CompileContext) trackOutOfDateItem(item string, deps []string) {
-	cc.depTrackr.AddItem(item, deps)
+// explainMembersItem is explainItem for an item that is queried with queryMembersItem, an
+// added, removed or reordered member is also reported.
+func (cc *CompileContext) explainMembersItem(item string, argsHash []byte, members []string) {
+	if !cc.explain {
+		return
+	}
+	cc.reportExplanations(item, cc.depTrackr.ExplainItemWithMembers(item, argsHash, members))
+}
+
+// reportExplanations reports why an item is out of date, as a tree in the log and as an
+// explain event.
+func (cc *CompileContext) reportExplanations(item string, explanations []deptrackr.Explanation) {
+	explainEvent := &events.Event{Kind: events.Explain, Project: cc.projectName, File: item}
+	tree := []string{fmt.Sprintf("Explain: %s is out of date", item)}
+	for _, explanation := range explanations {
+		tree = append(tree, fmt.Sprintf("  %s: %s", explanation.Reason, explanation.File))
+		explainEvent.Causes = append(explainEvent.Causes, events.Cause{Reason: string(explanation.Reason), File: explanation.File})
+	}
+	if len(explainEvent.Causes) == 0 {
+		tree = append(tree, "  no cause found")
+	}
+	corepkg.LogInfo(strings.Join(tree, "\n"))
+	events.Emit(explainEvent)
+}
+
+func (cc *CompileContext) trackOutOfDateItem(item string, argsHash []byte, deps []string) {
+	cc.depTrackr.AddItemWithExtraData(item, argsHash, deps)
 }
 
 func (cc *CompileContext) trackUpToDateItem(item string) {
 	cc.depTrackr.CopyItem(item)
 }
 
+// trackMembersItem tracks an item that is queried with queryMembersItem, together with the
+// digest of the content of its members.
+func (cc *CompileContext) trackMembersItem(item string, argsHash []byte, members []string) {
+	cc.depTrackr.AddItemWithMembers(item, argsHash, members)
+}
+
 func (cc *CompileContext) saveDependencyTrackr() error {
 	_, err := cc.depTrackr.Save()
 	return err
 }
 
+// collectPchToCompile checks if the precompiled header is out-of-date and needs to be
+// recompiled, the source files that use it are then out-of-date as well.
+func (cc *CompileContext) collectPchToCompile() {
+	if cc.pch == nil {
+		return
+	}
+	cc.pchArgsHash = cc.compiler.ArgsHash(cc.pch.SrcAbsFilepath, cc.pch.ObjRelFilepath)
+	if !cc.queryItem(cc.pch.ObjRelFilepath, cc.pchArgsHash) {
+		cc.explainItem(cc.pch.ObjRelFilepath, cc.pchArgsHash)
+		corepkg.DirMake(filepath.Dir(cc.pch.ObjRelFilepath))
+		cc.pchOutOfDate = true
+	}
+	if cc.pch.Link {
+		cc.allObjRelFilepaths = append(cc.allObjRelFilepaths, cc.pch.ObjRelFilepath)
+	}
+}
+
+// compilePch compiles the precompiled header when it is out-of-date and updates it in the
+// dependency tracker, it returns true when the precompiled header is up-to-date.
+func (cc *CompileContext) compilePch() bool {
+	if cc.pch == nil {
+		return true
+	}
+	if !cc.pchOutOfDate {
+		return true
+	}
+
+	if _, compileOk := cc.compiler.Compile([]string{cc.pch.SrcAbsFilepath}, []string{cc.pch.ObjRelFilepath}); !compileOk {
+		return false
+	}
+	_, depItems, err := cc.depTrackr.ParseDependencyFile(cc.pch.SrcAbsFilepath, cc.pch.ObjRelFilepath, cc.pch.DepRelFilepath)
+	if err != nil {
+		corepkg.LogErrorf(err, "Failed to parse dependency file %q", cc.pch.DepRelFilepath)
+		return false
+	}
+	cc.keepCompileOutput(cc.pch.SrcAbsFilepath, cc.pch.ObjRelFilepath)
+	cc.trackOutOfDateItem(cc.pch.ObjRelFilepath, cc.pchArgsHash, withResponseFile(cc.pch.ObjRelFilepath, depItems))
+	return true
+}
+
+// outputFilepath returns the file next to the object file that keeps the output of the
+// compiler (diagnostics), so that it is replayed when the object file is up-to-date.
+func outputFilepath(objRelFilepath string) string {
+	return objRelFilepath + ".out"
+}
+
+// keepCompileOutput writes the output of the compiler for a source file that compiled in
+// this build next to its object file, and returns it. Without output the file is removed.
+func (cc *CompileContext) keepCompileOutput(srcAbsFilepath string, objRelFilepath string) string {
+	output := ""
+	if cc.diagnostics != nil {
+		output = cc.diagnostics.CompileOutput(srcAbsFilepath)
+	}
+	cc.writeCompileOutput(objRelFilepath, output)
+	return output
+}
+
+func (cc *CompileContext) writeCompileOutput(objRelFilepath string, output string) {
+	if len(output) == 0 {
+		if err := os.Remove(outputFilepath(objRelFilepath)); err != nil && !os.IsNotExist(err) {
+			corepkg.LogErrorf(err, "Failed to remove %q", outputFilepath(objRelFilepath))
+		}
+	} else if err := os.WriteFile(outputFilepath(objRelFilepath), []byte(output), 0644); err != nil {
+		corepkg.LogErrorf(err, "Failed to write %q", outputFilepath(objRelFilepath))
+	}
+}
+
+// replayCompileOutput reports the output of the compiler for an object file that was not
+// compiled by this build, so that its warnings are counted as if it was (--max-warnings).
+func (cc *CompileContext) replayCompileOutput(srcAbsFilepath string, output string) {
+	if len(output) > 0 {
+		events.Emit(&events.Event{Kind: events.Replay, Project: cc.projectName, File: srcAbsFilepath, Message: output})
+	}
+}
+
+// replayKeptCompileOutput replays the output of the compiler that was kept next to an
+// up-to-date object file.
+func (cc *CompileContext) replayKeptCompileOutput(srcAbsFilepath string, objRelFilepath string) {
+	if output, err := os.ReadFile(outputFilepath(objRelFilepath)); err == nil {
+		cc.replayCompileOutput(srcAbsFilepath, string(output))
+	}
+}
+
+// withResponseFile adds the response file that the tool producing the output was run with,
+// if any, to the dependencies of the output, so that a changed response file rebuilds it.
+func withResponseFile(outputFilepath string, deps []string) []string {
+	if responseFilepath := toolchain.ResponseFilepath(outputFilepath); corepkg.FileExists(responseFilepath) {
+		return append(slices.Clone(deps), responseFilepath)
+	}
+	return deps
+}
+
+// usesPch returns true when the source file is compiled with the precompiled header
+func (cc *CompileContext) usesPch(src SourceFile) bool {
+	return cc.pch != nil && cc.pch.UsedBy(src.SrcAbsPath)
+}
+
+// withPchDependency adds the precompiled header to the dependencies of an object file of
+// a source file that uses it, so that a rebuilt precompiled header rebuilds the object file.
+func (cc *CompileContext) withPchDependency(src SourceFile, depItems []string) []string {
+	if cc.usesPch(src) && !slices.Contains(depItems, cc.pch.ObjRelFilepath) {
+		return append(depItems, cc.pch.ObjRelFilepath)
+	}
+	return depItems
+}
+
 // collectFilesToCompile checks which source files are out-of-date and need to be recompiled.
-// It returns the number of out-of-date source files.
+// It returns the number of out-of-date source files, including the precompiled header.
 func (cc *CompileContext) collectFilesToCompile(sourceFiles []SourceFile) int {
+	cc.collectPchToCompile()
+
 	for _, src := range sourceFiles {
 		srcObjRelPath := filepath.Join(cc.buildPath, cc.compiler.ObjFilepath(src.SrcRelPath))
-		if !cc.depTrackr.QueryItem(srcObjRelPath) {
+		argsHash := cc.compiler.ArgsHash(src.SrcAbsPath, srcObjRelPath)
+		if pchOutOfDate := cc.pchOutOfDate && cc.usesPch(src); pchOutOfDate || !cc.queryItem(srcObjRelPath, argsHash) {
+			if pchOutOfDate {
+				cc.explainItem(srcObjRelPath, argsHash, cc.pch.ObjRelFilepath)
+			} else {
+				cc.explainItem(srcObjRelPath, argsHash)
+			}
 			corepkg.DirMake(filepath.Dir(srcObjRelPath))
 			cc.srcFilesOutOfDate = append(cc.srcFilesOutOfDate, src)
+			cc.srcArgsOutOfDate = append(cc.srcArgsOutOfDate, argsHash)
+			if cc.cache != nil {
+				cc.srcCacheKeys = append(cc.srcCacheKeys, cc.cache.Key(cc.compiler.CacheKey(src.SrcAbsPath, srcObjRelPath), src.SrcAbsPath))
+			} else {
+				cc.srcCacheKeys = append(cc.srcCacheKeys, nil)
+			}
 		} else {
 			cc.srcFilesUpToDate = append(cc.srcFilesUpToDate, src)
 		}
@@ -181,26 +498,125 @@ func (cc *CompileContext) collectFilesToCompile(sourceFiles []SourceFile) int {
 		cc.objRelFilepaths[i] = filepath.Join(cc.buildPath, cc.compiler.ObjFilepath(src.SrcRelPath))
 	}
 
+	if cc.pchOutOfDate {
+		return len(cc.srcFilesOutOfDate) + 1
+	}
 	return len(cc.srcFilesOutOfDate)
 }
 
+func (cc *CompileContext) depRelFilepath(src SourceFile) string {
+	return filepath.Join(cc.buildPath, cc.compiler.DepFilepath(src.SrcRelPath))
+}
+
+// pruneOrphanedFiles removes the object files that the dependency tracker of the project
+// recorded in an earlier build but that no longer belong to any of its source files, e.g. of
+// a deleted source file, together with their dependency file and the files that the compiler
+// derived from such an object file (response file, coverage notes and data). Nothing else in
+// the build directory is touched, and nothing is pruned when the compiler does not give the
+// object and dependency files a suffix of their own.
+func (cc *CompileContext) pruneOrphanedFiles(sourceFiles []SourceFile) {
+	objSuffix := cc.compiler.ObjFilepath("")
+	depSuffix := cc.compiler.DepFilepath("")
+	if len(objSuffix) == 0 || len(depSuffix) == 0 {
+		return
+	}
+
+	owned := make(map[string]bool, len(sourceFiles)+1)
+	for _, src := range sourceFiles {
+		owned[filepath.Join(cc.buildPath, cc.compiler.ObjFilepath(src.SrcRelPath))] = true
+	}
+	if cc.pch != nil {
+		owned[cc.pch.ObjRelFilepath] = true
+	}
+
+	orphans := []string{}
+	cc.depTrackr.ForEachItem(func(item string, deps []string) {
+		stem, isObj := strings.CutSuffix(item, objSuffix)
+		if !isObj || owned[item] {
+			return
+		}
+		orphans = append(orphans, item, stem+depSuffix, toolchain.ResponseFilepath(item), outputFilepath(item), stem+".gcno", stem+".gcda")
+	})
+
+	removed := 0
+	for _, orphan := range orphans {
+		if err := os.Remove(orphan); err == nil {
+			removed++
+		} else if !os.IsNotExist(err) {
+			corepkg.LogErrorf(err, "Failed to remove orphaned file %q", orphan)
+		}
+	}
+	if removed > 0 {
+		corepkg.LogInfof("Removed %d orphaned object and dependency files of project %s", removed, cc.projectName)
+	}
+}
+
+// compile compiles the precompiled header, restores the out-of-date object files that are in
+// the compilation cache and compiles the others, it returns true when all source files
+// compiled successfully.
 func (cc *CompileContext) compile() bool {
-	var anyErrors bool
-	cc.srcFilesCompiled, anyErrors = cc.compiler.Compile(cc.absSrcFilepaths, cc.objRelFilepaths)
-	return anyErrors
+	if !cc.compilePch() {
+		return false
+	}
+
+	cc.srcFilesCompiled = make([]bool, len(cc.srcFilesOutOfDate))
+	cc.srcDepsRestored = make([][]string, len(cc.srcFilesOutOfDate))
+
+	absSrcFilepaths := make([]string, 0, len(cc.absSrcFilepaths))
+	objRelFilepaths := make([]string, 0, len(cc.objRelFilepaths))
+	compileIndices := make([]int, 0, len(cc.srcFilesOutOfDate))
+	for i, src := range cc.srcFilesOutOfDate {
+		if cc.srcCacheKeys[i] != nil {
+			if deps, output, ok := cc.cache.Restore(cc.srcCacheKeys[i], cc.objRelFilepaths[i], cc.depRelFilepath(src)); ok {
+				corepkg.LogInfof("Restored from cache %s", filepath.Base(src.SrcAbsPath))
+				cc.writeCompileOutput(cc.objRelFilepaths[i], output)
+				cc.replayCompileOutput(src.SrcAbsPath, output)
+				cc.srcFilesCompiled[i] = true
+				cc.srcDepsRestored[i] = deps
+				continue
+			}
+		}
+		absSrcFilepaths = append(absSrcFilepaths, cc.absSrcFilepaths[i])
+		objRelFilepaths = append(objRelFilepaths, cc.objRelFilepaths[i])
+		compileIndices = append(compileIndices, i)
+	}
+
+	if len(compileIndices) == 0 {
+		return true
+	}
+
+	compiled, compileOk := cc.compiler.Compile(absSrcFilepaths, objRelFilepaths)
+	for j, i := range compileIndices {
+		cc.srcFilesCompiled[i] = compiled[j]
+	}
+	return compileOk
 }
 
 func (cc *CompileContext) updateDependencyTracker() {
-	// Update the dependency tracker
+	// Update the dependency tracker, an out-of-date precompiled header is tracked when compiled
+	if cc.pch != nil && !cc.pchOutOfDate {
+		cc.trackUpToDateItem(cc.pch.ObjRelFilepath)
+		cc.replayKeptCompileOutput(cc.pch.SrcAbsFilepath, cc.pch.ObjRelFilepath)
+	}
 	for _, src := range cc.srcFilesUpToDate {
 		objRelFilepath := filepath.Join(cc.buildPath, cc.compiler.ObjFilepath(src.SrcRelPath))
 		cc.depTrackr.CopyItem(objRelFilepath)
+		cc.replayKeptCompileOutput(src.SrcAbsPath, objRelFilepath)
 	}
-	for _, src := range cc.srcFilesOutOfDate {
+	for i, src := range cc.srcFilesOutOfDate {
 		objRelFilepath := filepath.Join(cc.buildPath, cc.compiler.ObjFilepath(src.SrcRelPath))
-		depRelFilepath := filepath.Join(cc.buildPath, cc.compiler.DepFilepath(src.SrcRelPath))
-		if mainItem, depItems, err := cc.depTrackr.ParseDependencyFile(src.SrcAbsPath, objRelFilepath, depRelFilepath); err == nil {
-			cc.depTrackr.AddItem(mainItem, depItems)
+		depRelFilepath := cc.depRelFilepath(src)
+		if i < len(cc.srcDepsRestored) && cc.srcDepsRestored[i] != nil {
+			cc.trackOutOfDateItem(objRelFilepath, cc.srcArgsOutOfDate[i], withResponseFile(objRelFilepath, cc.withPchDependency(src, cc.srcDepsRestored[i])))
+		} else if mainItem, depItems, err := cc.depTrackr.ParseDependencyFile(src.SrcAbsPath, objRelFilepath, depRelFilepath); err == nil {
+			depItems = cc.withPchDependency(src, depItems)
+			cc.trackOutOfDateItem(mainItem, cc.srcArgsOutOfDate[i], withResponseFile(objRelFilepath, depItems))
+			output := cc.keepCompileOutput(src.SrcAbsPath, objRelFilepath)
+			if cc.srcCacheKeys[i] != nil && i < len(cc.srcFilesCompiled) && cc.srcFilesCompiled[i] {
+				if err := cc.cache.Store(cc.srcCacheKeys[i], objRelFilepath, depRelFilepath, depItems, output); err != nil {
+					corepkg.LogErrorf(err, "Failed to store %q in the compilation cache", objRelFilepath)
+				}
+			}
 		} else {
 			corepkg.LogErrorf(err, "Failed to parse dependency file %q", depRelFilepath)
 		}
@@ -234,6 +650,16 @@ func (p *Project) GetIncludesAndDefines(buildConfig denv.BuildConfig, buildTarge
 			}
 		}
 
+		// A shared library and its users know that the library is a shared library, the
+		// library itself also knows that it exports its symbols. Headers use these defines
+		// to mark the exported symbols (e.g. __declspec(dllexport/dllimport) or visibility).
+		if prj.IsSharedLibrary() {
+			defines.Add(prj.SharedLibraryDefine("_SHARED"))
+			if prj == p {
+				defines.Add(prj.SharedLibraryDefine("_EXPORTS"))
+			}
+		}
+
 		prjConfig := prj.GetConfig(buildConfig)
 		if prjConfig != nil {
 			for _, incDir := range prjConfig.IncludeDirs {
@@ -250,6 +676,18 @@ func (p *Project) GetIncludesAndDefines(buildConfig denv.BuildConfig, buildTarge
 }
 
 func (p *Project) Build(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget, buildPath string) (outOfDate int, err bool) {
+	projectEvent := &events.Event{Kind: events.ProjectStart, Config: buildConfig.String(), Project: p.DevProject.Name}
+	events.Emit(projectEvent)
+	defer func() {
+		var buildErr error
+		if err {
+			buildErr = fmt.Errorf("project %s failed to build", p.DevProject.Name)
+		}
+		projectEndEvent := projectEvent.End(buildErr)
+		projectEndEvent.OutOfDate = outOfDate
+		events.Emit(projectEndEvent)
+	}()
+
 	compilerContext := newCompileContext(buildPath, p, buildConfig, buildTarget)
 
 	projectBuildPath := p.GetBuildPath(buildPath)
@@ -266,13 +704,26 @@ func (p *Project) Build(buildConfig denv.BuildConfig, buildTarget denv.BuildTarg
 				return false
 			}
 			// TODO pass on the deptrackr, to avoid unnecessary copies
-			fileCommander.CopyDir(srcpgp.Path.String(), dstsubdir, fileFilter, func(dir string) bool { return true })
+			copyEvent := &events.Event{Kind: events.CopyStart, Config: buildConfig.String(), Project: p.DevProject.Name, File: dstsubdir}
+			events.Emit(copyEvent)
+			_, _, copyErr := fileCommander.CopyDir(srcpgp.Path.String(), dstsubdir, fileFilter, func(dir string) bool { return true })
+			events.Emit(copyEvent.End(copyErr))
 		}
 	}
 
 	buildStartTime := time.Now()
 
-	outOfDate = compilerContext.collectFilesToCompile(p.SourceFiles)
+	sourceFiles := p.SourceFiles
+	if p.Unity > 0 {
+		unitySourceFiles, unityErr := p.unitySourceFiles(projectBuildPath, p.Unity)
+		if unityErr != nil {
+			corepkg.LogErrorf(unityErr, "Failed to generate the unity files of project %s", p.DevProject.Name)
+			return 0, true
+		}
+		sourceFiles = unitySourceFiles
+	}
+
+	outOfDate = compilerContext.collectFilesToCompile(sourceFiles)
 	if outOfDate > 0 {
 		corepkg.LogInfof("Building project: %s, config: %s\n", p.DevProject.Name, buildConfig.String())
 		compileOk := compilerContext.compile()
@@ -285,8 +736,22 @@ func (p *Project) Build(buildConfig denv.BuildConfig, buildTarget denv.BuildTarg
 	} else {
 		compilerContext.updateDependencyTracker()
 	}
-
-	staticArchiver := p.Toolchain.NewArchiver(toolchain.ArchiverTypeStatic, buildConfig, buildTarget)
+	compilerContext.pruneOrphanedFiles(sourceFiles)
+
+	// Libraries of the dependencies (only those matching the build config), static archives
+	// or the libraries to link with of shared libraries.
+	dependencyLibs := make([]string, 0, len(p.Dependencies))
+	dependencyRuntimeFiles := make([]string, 0, len(p.Dependencies))
+	for _, dep := range p.Dependencies {
+		if dep.CanBuildFor(buildConfig, buildTarget) {
+			depArchiver := p.Toolchain.NewArchiver(dep.ArchiverType(), buildConfig, buildTarget)
+			libAbsFilepath := dep.GetOutputFilepath(buildPath, depArchiver.LibFilepath(dep.DevProject.Name))
+			dependencyLibs = append(dependencyLibs, libAbsFilepath)
+			if runtimeFilepath := depArchiver.RuntimeFilepath(libAbsFilepath); len(runtimeFilepath) > 0 {
+				dependencyRuntimeFiles = append(dependencyRuntimeFiles, runtimeFilepath)
+			}
+		}
+	}
 
 	if p.IsExecutable() {
 		linker := p.Toolchain.NewLinker(buildConfig, buildTarget)
@@ -294,55 +759,84 @@ func (p *Project) Build(buildConfig denv.BuildConfig, buildTarget denv.BuildTarg
 
 		executableOutputFilepath := linker.LinkedFilepath(filepath.Join(projectBuildPath, p.DevProject.Name))
 
-		if outOfDate > 0 || !compilerContext.queryItem(executableOutputFilepath) {
+		archivesToLink := dependencyLibs
+
+		// The members of the executable in link order, any change to them relinks it
+		linkMembers := append(slices.Clone(compilerContext.allObjRelFilepaths), archivesToLink...)
+
+		// Recompiled object files, or rebuilt archives, with the same content do not relink the
+		// executable (early cutoff).
+		linkArgsHash := linker.ArgsHash(compilerContext.allObjRelFilepaths, archivesToLink, executableOutputFilepath)
+		if !compilerContext.queryMembersItem(executableOutputFilepath, linkArgsHash, withResponseFile(executableOutputFilepath, linkMembers)) {
+			compilerContext.explainMembersItem(executableOutputFilepath, linkArgsHash, withResponseFile(executableOutputFilepath, linkMembers))
 			if outOfDate == 0 {
 				corepkg.LogInfof("Linking project: %s, config: %s\n", p.DevProject.Name, buildConfig.String())
 				buildStartTime = time.Now()
 				outOfDate += 1
 			}
 
-			// Project archive dependencies (only those matching the build config)
-			archivesToLink := make([]string, 0, len(p.Dependencies))
-			for _, dep := range p.Dependencies {
-				if dep.CanBuildFor(buildConfig, buildTarget) {
-					libAbsFilepath := dep.GetOutputFilepath(buildPath, staticArchiver.LibFilepath(dep.DevProject.Name))
-					archivesToLink = append(archivesToLink, libAbsFilepath)
-				}
-			}
-
 			// Link them all together into a single executable
-			if err := linker.Link(compilerContext.allObjRelFilepaths, archivesToLink, executableOutputFilepath); err != nil {
+			if err := p.runStep(events.LinkStart, buildConfig, executableOutputFilepath, func() error {
+				return linker.Link(compilerContext.allObjRelFilepaths, archivesToLink, executableOutputFilepath)
+			}); err != nil {
 				corepkg.LogErrorf(err, "Linking failed for project %s", p.DevProject.Name)
 				return outOfDate, true
 			}
 
-			// Make sure we also track the object files as dependencies for the executable
-			for _, objRelFilepath := range compilerContext.allObjRelFilepaths {
-				archivesToLink = append(archivesToLink, objRelFilepath)
-			}
-			compilerContext.trackOutOfDateItem(executableOutputFilepath, archivesToLink)
+			compilerContext.trackMembersItem(executableOutputFilepath, linkArgsHash, withResponseFile(executableOutputFilepath, linkMembers))
+		} else if outOfDate > 0 {
+			// The recompiled object files are unchanged, their new modification time is tracked
+			corepkg.LogInfof("Executable of project %s is up-to-date, the recompiled object files are unchanged", p.DevProject.Name)
+			compilerContext.trackMembersItem(executableOutputFilepath, linkArgsHash, withResponseFile(executableOutputFilepath, linkMembers))
 		} else {
 			compilerContext.trackUpToDateItem(executableOutputFilepath)
 		}
 
+		// Shared libraries are copied next to the executable, so that the executable can be
+		// started from the build directory.
+		for _, runtimeFilepath := range dependencyRuntimeFiles {
+			if err := copyFileIfChanged(runtimeFilepath, filepath.Join(filepath.Dir(executableOutputFilepath), filepath.Base(runtimeFilepath))); err != nil {
+				corepkg.LogErrorf(err, "Failed to copy %q next to the executable of project %s", runtimeFilepath, p.DevProject.Name)
+				return outOfDate, true
+			}
+		}
+
 	} else {
-		archiveOutputFilepath := p.GetOutputFilepath(buildPath, staticArchiver.LibFilepath(p.DevProject.Name))
-		if outOfDate > 0 || !compilerContext.queryItem(archiveOutputFilepath) {
+		archiver := p.Toolchain.NewArchiver(p.ArchiverType(), buildConfig, buildTarget)
+		archiveOutputFilepath := p.GetOutputFilepath(buildPath, archiver.LibFilepath(p.DevProject.Name))
+
+		archiver.SetupArgs()
+
+		// A shared library is linked, so it also needs the libraries of its dependencies. These
+		// are the members of the archive, any change to them re-archives it.
+		archiveInputFilepaths := compilerContext.allObjRelFilepaths
+		if p.IsSharedLibrary() {
+			archiveInputFilepaths = append(slices.Clone(archiveInputFilepaths), dependencyLibs...)
+		}
+
+		// Recompiled object files with the same content do not re-archive (early cutoff)
+		archiveArgsHash := archiver.ArgsHash(archiveInputFilepaths, archiveOutputFilepath)
+		if !compilerContext.queryMembersItem(archiveOutputFilepath, archiveArgsHash, withResponseFile(archiveOutputFilepath, archiveInputFilepaths)) {
+			compilerContext.explainMembersItem(archiveOutputFilepath, archiveArgsHash, withResponseFile(archiveOutputFilepath, archiveInputFilepaths))
 			if outOfDate == 0 {
 				corepkg.LogInfof("Archiving project: %s, config: %s\n", p.DevProject.Name, buildConfig.String())
 				buildStartTime = time.Now()
 				outOfDate += 1
 			}
 
-			staticArchiver.SetupArgs()
-
-			// Archive all object files into a static library using the static archiver
-			if err := staticArchiver.Archive(compilerContext.allObjRelFilepaths, archiveOutputFilepath); err != nil {
+			// Archive all object files into a static library, or link them into a shared library
+			if err := p.runStep(events.ArchiveStart, buildConfig, archiveOutputFilepath, func() error {
+				return archiver.Archive(archiveInputFilepaths, archiveOutputFilepath)
+			}); err != nil {
 				corepkg.LogErrorf(err, "Archiving failed for project %s", p.DevProject.Name)
 				return outOfDate, true
 			}
 
-			compilerContext.trackOutOfDateItem(archiveOutputFilepath, compilerContext.objRelFilepaths)
+			compilerContext.trackMembersItem(archiveOutputFilepath, archiveArgsHash, withResponseFile(archiveOutputFilepath, archiveInputFilepaths))
+		} else if outOfDate > 0 {
+			// The recompiled object files are unchanged, their new modification time is tracked
+			corepkg.LogInfof("Archive of project %s is up-to-date, the recompiled object files are unchanged", p.DevProject.Name)
+			compilerContext.trackMembersItem(archiveOutputFilepath, archiveArgsHash, withResponseFile(archiveOutputFilepath, archiveInputFilepaths))
 		} else {
 			compilerContext.trackUpToDateItem(archiveOutputFilepath)
 		}
@@ -356,16 +850,54 @@ func (p *Project) Build(buildConfig denv.BuildConfig, buildTarget denv.BuildTarg
 	if outOfDate > 0 {
 		seconds := float64(time.Since(buildStartTime).Milliseconds()) / 1000.0
 		corepkg.LogInfof("Building done ... (duration %.2f seconds)\n", seconds)
+	} else {
+		events.Emit(&events.Event{Kind: events.UpToDate, Config: buildConfig.String(), Project: p.DevProject.Name})
 	}
 
 	return outOfDate, false
 }
 
-func (p *Project) Flash(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget, buildPath string) error {
+// runStep runs the archive or link step of the project while occupying a slot of the
+// global job budget, the step is reported with a start and an end event.
+func (p *Project) runStep(startKind events.Kind, buildConfig denv.BuildConfig, outputFilepath string, step func() error) error {
+	stepEvent := &events.Event{Kind: startKind, Config: buildConfig.String(), Project: p.DevProject.Name, File: outputFilepath}
+	events.Emit(stepEvent)
+	err := toolchain.RunWithJobSlot(step)
+	events.Emit(stepEvent.End(err))
+	return err
+}
+
+// copyFileIfChanged copies a file when the destination does not exist or differs in
+// modification time or size.
+func copyFileIfChanged(srcFilepath string, dstFilepath string) error {
+	srcInfo, err := os.Stat(srcFilepath)
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(dstFilepath); err == nil && dstInfo.Size() == srcInfo.Size() && dstInfo.ModTime().Equal(srcInfo.ModTime()) {
+		return nil
+	}
+	content, err := os.ReadFile(srcFilepath)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dstFilepath, content, srcInfo.Mode()); err != nil {
+		return err
+	}
+	return os.Chtimes(dstFilepath, srcInfo.ModTime(), srcInfo.ModTime())
+}
+
+// Flash builds the image of the executable and burns it to the device, this is reported
+// with a burn start and end event.
+func (p *Project) Flash(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget, buildPath string) (err error) {
 	burner := p.Toolchain.NewBurner(buildConfig, buildTarget)
 
 	buildPath = p.GetBuildPath(buildPath)
 
+	burnEvent := &events.Event{Kind: events.BurnStart, Config: buildConfig.String(), Project: p.DevProject.Name, File: buildPath}
+	events.Emit(burnEvent)
+	defer func() { events.Emit(burnEvent.End(err)) }()
+
 	burner.SetupBuild(buildPath)
 	if err := burner.Build(); err != nil {
 		return err
@@ -0,0 +1,211 @@
+package clay
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+func TestPruneOrphanedFiles(t *testing.T) {
+	buildPath := t.TempDir()
+	srcPath := t.TempDir()
+	gcc := toolchain.NewLinuxGcc(corepkg.NewVars(corepkg.VarsFormatCurlyBraces), "test", buildPath, "amd64")
+
+	sourceFiles := []SourceFile{{SrcAbsPath: filepath.Join(srcPath, "a.cpp"), SrcRelPath: "a.cpp"}, {SrcAbsPath: filepath.Join(srcPath, "sub", "b.cpp"), SrcRelPath: filepath.Join("sub", "b.cpp")}}
+	tracked := []string{"a.cpp.o", filepath.Join("sub", "b.cpp.o"), "deleted.cpp.o", filepath.Join("sub", "old.c.o"), "test"}
+	kept := []string{"a.cpp.o", "a.cpp.d", "a.cpp.gcno", filepath.Join("sub", "b.cpp.o"), filepath.Join("sub", "b.cpp.d"), "test", "libtest.a", "untracked.cpp.o", "untracked.cpp.d", "deleted.cpp.json", filepath.Join("data", "blob.o")}
+	pruned := []string{"deleted.cpp.o", "deleted.cpp.d", "deleted.cpp.o.rsp", "deleted.cpp.gcno", "deleted.cpp.gcda", filepath.Join("sub", "old.c.o")}
+	for _, f := range append(kept, pruned...) {
+		path := filepath.Join(buildPath, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srcFilepath := filepath.Join(srcPath, "a.cpp")
+	if err := os.WriteFile(srcFilepath, []byte("int a;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The object files of an earlier build, including those of the source files that are gone
+	depTrackr := gcc.NewDependencyTracker(buildPath)
+	for _, f := range tracked {
+		if err := depTrackr.AddItem(filepath.Join(buildPath, f), []string{srcFilepath}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := depTrackr.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := &CompileContext{buildPath: buildPath, projectName: "test", compiler: gcc.NewCompiler(denv.BuildConfig{}, denv.BuildTarget{}), depTrackr: gcc.NewDependencyTracker(buildPath)}
+	cc.pruneOrphanedFiles(sourceFiles)
+
+	for _, f := range kept {
+		if !corepkg.FileExists(filepath.Join(buildPath, f)) {
+			t.Errorf("Expected %q to be kept", f)
+		}
+	}
+	for _, f := range pruned {
+		if corepkg.FileExists(filepath.Join(buildPath, f)) {
+			t.Errorf("Expected orphaned file %q to be removed", f)
+		}
+	}
+}
+
+func TestPchInvalidatesObjects(t *testing.T) {
+	if _, err := exec.LookPath("g++"); err != nil {
+		t.Skip("g++ is not available")
+	}
+
+	dirpath := t.TempDir()
+	buildPath := filepath.Join(dirpath, "build")
+	headerFilepath := filepath.Join(dirpath, "pch.h")
+	sourceFiles := []SourceFile{{SrcAbsPath: filepath.Join(dirpath, "main.cpp"), SrcRelPath: "main.cpp"}, {SrcAbsPath: filepath.Join(dirpath, "lib.c"), SrcRelPath: "lib.c"}}
+	for path, content := range map[string]string{
+		headerFilepath:            "#pragma once\nstatic const int value = 1;\n",
+		sourceFiles[0].SrcAbsPath: "int main() { return value; }\n",
+		sourceFiles[1].SrcAbsPath: "int lib() { return 0; }\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(buildPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	gcc := toolchain.NewLinuxGcc(corepkg.NewVars(corepkg.VarsFormatCurlyBraces), "test", buildPath, "amd64")
+	build := func() (cc *CompileContext, outOfDate int) {
+		compiler := gcc.NewCompiler(denv.BuildConfig{}, denv.BuildTarget{})
+		compiler.SetupArgs("test", buildPath, []string{}, []string{})
+		cc = &CompileContext{buildPath: buildPath, projectName: "test", compiler: compiler, depTrackr: gcc.NewDependencyTracker(buildPath)}
+		if cc.pch = compiler.SetupPch(headerFilepath, buildPath); cc.pch == nil {
+			t.Fatal("expected a precompiled header")
+		}
+		outOfDate = cc.collectFilesToCompile(sourceFiles)
+		if outOfDate > 0 && !cc.compile() {
+			t.Fatalf("expected the source files to compile")
+		}
+		cc.updateDependencyTracker()
+		if err := cc.saveDependencyTrackr(); err != nil {
+			t.Fatal(err)
+		}
+		return cc, outOfDate
+	}
+
+	cc, outOfDate := build()
+	if outOfDate != 3 {
+		t.Fatalf("expected the precompiled header and both source files to be compiled, got %d", outOfDate)
+	}
+	mainObjFilepath := filepath.Join(buildPath, "main.cpp.o")
+	numItems := 0
+	gcc.NewDependencyTracker(buildPath).ForEachItem(func(item string, deps []string) {
+		numItems++
+		if usesPch := slices.Contains(deps, cc.pch.ObjRelFilepath); usesPch != (item == mainObjFilepath) {
+			t.Errorf("expected only %s to depend on the precompiled header, %s depends on it = %v", mainObjFilepath, item, usesPch)
+		}
+	})
+	if numItems != 3 {
+		t.Errorf("expected the precompiled header and both object files to be tracked, got %d items", numItems)
+	}
+	if _, outOfDate := build(); outOfDate != 0 {
+		t.Errorf("expected everything to be up-to-date, got %d out-of-date files", outOfDate)
+	}
+
+	// A changed header rebuilds the precompiled header and the C++ source files that use it
+	if err := os.WriteFile(headerFilepath, []byte("#pragma once\nstatic const int value = 2;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(headerFilepath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	cc, outOfDate = build()
+	if outOfDate != 2 || !cc.pchOutOfDate || len(cc.srcFilesOutOfDate) != 1 || cc.srcFilesOutOfDate[0].SrcRelPath != "main.cpp" {
+		t.Errorf("expected the precompiled header and main.cpp to be rebuilt, got %d out-of-date files: %v", outOfDate, cc.srcFilesOutOfDate)
+	}
+}
+
+func TestPositionIndependent(t *testing.T) {
+	libA := newTestProject("liba", denv.BuildTypeStaticLibrary)
+	libB := newTestProject("libb", denv.BuildTypeStaticLibrary, libA)
+	libC := newTestProject("libc", denv.BuildTypeStaticLibrary)
+	shared := newTestProject("shared", denv.BuildTypeDynamicLibrary, libB)
+	for _, prj := range []*Project{libA, libB, libC, shared} {
+		if prj.IsSharedLibrary() {
+			prj.setPositionIndependent()
+		}
+	}
+	for prj, expected := range map[*Project]bool{libA: true, libB: true, libC: false, shared: true} {
+		if prj.Pic != expected {
+			t.Errorf("expected %s to be position independent = %v, got %v", prj.DevProject.Name, expected, prj.Pic)
+		}
+	}
+
+	// The package flags do not have -fPIC, the toolchain adds it
+	vars := corepkg.NewVars(corepkg.VarsFormatCurlyBraces)
+	vars.Set("compiler.cpp.flags", "-c", "-MMD")
+	gcc := toolchain.NewLinuxGcc(vars, "shared", t.TempDir(), "amd64")
+	gcc.SetPositionIndependent(true)
+	compiler := gcc.NewCompiler(denv.BuildConfig{}, denv.BuildTarget{})
+	compiler.SetupArgs("shared", t.TempDir(), []string{"SHARED_EXPORTS"}, []string{})
+	if _, args := compiler.CompileCommand("/src/shared.cpp", "shared.cpp.o"); !slices.Contains(args, "-fPIC") || !slices.Contains(args, "-fvisibility=hidden") {
+		t.Errorf("expected a shared library to be compiled with -fPIC and -fvisibility=hidden, got %v", args)
+	}
+
+	// The default flags already have -fPIC, a library linked into a shared library keeps its symbols visible
+	gcc = toolchain.NewLinuxGcc(corepkg.NewVars(corepkg.VarsFormatCurlyBraces), "libb", t.TempDir(), "amd64")
+	gcc.SetPositionIndependent(false)
+	compiler = gcc.NewCompiler(denv.BuildConfig{}, denv.BuildTarget{})
+	compiler.SetupArgs("libb", t.TempDir(), []string{}, []string{})
+	_, args := compiler.CompileCommand("/src/libb.cpp", "libb.cpp.o")
+	if pics := slices.DeleteFunc(slices.Clone(args), func(arg string) bool { return arg != "-fPIC" }); len(pics) != 1 || slices.Contains(args, "-fvisibility=hidden") {
+		t.Errorf("expected a library linked into a shared library to be compiled with -fPIC once, got %v", args)
+	}
+}
+
+func TestSetPchHeader(t *testing.T) {
+	dirpath := t.TempDir()
+	for _, name := range []string{"pch.h", "prefix.h"} {
+		if err := os.WriteFile(filepath.Join(dirpath, name), []byte("#pragma once\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newProject := func() *Project {
+		prj := newTestProject("lib", denv.BuildTypeStaticLibrary)
+		prj.DevProject.SourceDirs = []denv.PinnedGlobPath{{Path: denv.PinnedPath{Root: dirpath}, Glob: "**/*.cpp"}}
+		return prj
+	}
+
+	// A pch.h in a source directory is not used unless the package names it
+	vars := corepkg.NewVars(corepkg.VarsFormatCurlyBraces)
+	prj := newProject()
+	prj.SetPchHeader(vars)
+	if len(prj.PchHeader) != 0 {
+		t.Errorf("expected no precompiled header, got %q", prj.PchHeader)
+	}
+
+	vars.Set("lib.pch.header", "prefix.h")
+	prj = newProject()
+	prj.SetPchHeader(vars)
+	if prj.PchHeader != filepath.Join(dirpath, "prefix.h") {
+		t.Errorf("expected the header that the package names, got %q", prj.PchHeader)
+	}
+
+	vars.Set("lib.pch.header", "missing.h")
+	prj = newProject()
+	prj.SetPchHeader(vars)
+	if len(prj.PchHeader) != 0 {
+		t.Errorf("expected a missing header not to be used, got %q", prj.PchHeader)
+	}
+}
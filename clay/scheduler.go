@@ -0,0 +1,115 @@
+package clay
+
+import (
+	"sync"
+	"sync/atomic"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// buildState is the state of a project in the build graph
+type buildState int8
+
+const (
+	buildStatePending buildState = iota
+	buildStateSucceeded
+	buildStateFailed
+	buildStateSkipped // Not built, because a dependency failed or the build was stopped
+)
+
+type buildNode struct {
+	project   *Project
+	waitFor   []*buildNode  // The nodes that have to be built before this node can be built
+	done      chan struct{} // Closed when the node is built, failed or skipped
+	state     buildState
+	outOfDate int
+}
+
+// buildProjectGraph builds the projects in parallel following the dependency graph. A
+// static library does not use its dependencies, so it is built right away, executables
+// and shared libraries link their dependencies so they wait until those are built.
+// All the projects share the global job budget (see toolchain.SetMaxJobs), every compile,
+// archive and link occupies one job slot.
+// On the first failure no new projects are started, unless keepGoing is true in which
+// case only the projects that depend on the failed project are skipped.
+func buildProjectGraph(prjs []*Project, keepGoing bool, build func(prj *Project) (outOfDate int, buildErr bool)) (outOfDate int, success bool) {
+	nodes := make([]*buildNode, len(prjs))
+	nodeIndex := make(map[*Project]int, len(prjs))
+	for i, prj := range prjs {
+		nodes[i] = &buildNode{project: prj, done: make(chan struct{})}
+		nodeIndex[prj] = i
+	}
+
+	// Verify that the dependency graph does not contain a cycle
+	edges := make([]corepkg.Edge, 0, len(prjs)*2)
+	for i, prj := range prjs {
+		edges = append(edges, corepkg.Edge{S: corepkg.Vertex(i), D: corepkg.InvalidVertex})
+		for _, dep := range prj.Dependencies {
+			if j, ok := nodeIndex[dep]; ok {
+				edges = append(edges, corepkg.Edge{S: corepkg.Vertex(j), D: corepkg.Vertex(i)})
+			}
+		}
+	}
+	if _, err := corepkg.Toposort(edges); err != nil {
+		corepkg.LogError(err, "The project dependencies contain a cycle")
+		return 0, false
+	}
+
+	for _, node := range nodes {
+		if node.project.IsExecutable() || node.project.IsSharedLibrary() {
+			for _, dep := range node.project.Dependencies {
+				if j, ok := nodeIndex[dep]; ok {
+					node.waitFor = append(node.waitFor, nodes[j])
+				}
+			}
+		}
+	}
+
+	var stopped atomic.Bool
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *buildNode) {
+			defer wg.Done()
+			defer close(node.done)
+
+			for _, dep := range node.waitFor {
+				<-dep.done
+				if dep.state != buildStateSucceeded {
+					node.state = buildStateSkipped
+				}
+			}
+			if node.state == buildStateSkipped || stopped.Load() {
+				node.state = buildStateSkipped
+				return
+			}
+
+			prjOutOfDate, buildErr := build(node.project)
+			node.outOfDate = prjOutOfDate
+			if buildErr {
+				node.state = buildStateFailed
+				if !keepGoing {
+					stopped.Store(true)
+				}
+			} else {
+				node.state = buildStateSucceeded
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	success = true
+	for _, node := range nodes {
+		outOfDate += node.outOfDate
+		switch node.state {
+		case buildStateFailed:
+			success = false
+		case buildStateSkipped:
+			success = false
+			if keepGoing {
+				corepkg.LogInfof("Skipped project %s, one of its dependencies failed to build", node.project.DevProject.Name)
+			}
+		}
+	}
+	return outOfDate, success
+}
@@ -0,0 +1,131 @@
+package clay
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+func newTestProject(name string, buildType denv.BuildType, dependencies ...*Project) *Project {
+	prj := NewProjectFromDevProject(&denv.DevProject{Name: name, BuildType: buildType}, nil)
+	prj.Dependencies = dependencies
+	return prj
+}
+
+// testBuilder records the order in which projects are built, projects in 'fail' fail to build.
+type testBuilder struct {
+	mutex sync.Mutex
+	built []string
+	fail  map[string]bool
+}
+
+func (b *testBuilder) build(prj *Project) (int, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.built = append(b.built, prj.DevProject.Name)
+	return 1, b.fail[prj.DevProject.Name]
+}
+
+func (b *testBuilder) index(name string) int {
+	for i, built := range b.built {
+		if built == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestBuildProjectGraphOrder(t *testing.T) {
+	libA := newTestProject("liba", denv.BuildTypeStaticLibrary)
+	libB := newTestProject("libb", denv.BuildTypeStaticLibrary, libA)
+	shared := newTestProject("shared", denv.BuildTypeDynamicLibrary, libA, libB)
+	plugin := newTestProject("plugin", denv.BuildTypeDynamicLibrary, shared, libA, libB)
+
+	builder := &testBuilder{}
+	outOfDate, ok := buildProjectGraph([]*Project{plugin, shared, libB, libA}, false, builder.build)
+	if !ok || outOfDate != 4 {
+		t.Fatalf("expected 4 projects to be built successfully, got %d (ok = %v)", outOfDate, ok)
+	}
+	if len(builder.built) != 4 {
+		t.Fatalf("expected 4 projects to be built, got %v", builder.built)
+	}
+	for _, dep := range []string{"liba", "libb"} {
+		if builder.index(dep) > builder.index("shared") {
+			t.Errorf("expected %s to be built before shared, got %v", dep, builder.built)
+		}
+	}
+	if builder.index("shared") > builder.index("plugin") {
+		t.Errorf("expected shared to be built before plugin, got %v", builder.built)
+	}
+}
+
+func TestBuildProjectGraphFailure(t *testing.T) {
+	libA := newTestProject("liba", denv.BuildTypeStaticLibrary)
+	libB := newTestProject("libb", denv.BuildTypeStaticLibrary)
+	sharedA := newTestProject("shared_a", denv.BuildTypeDynamicLibrary, libA)
+	sharedB := newTestProject("shared_b", denv.BuildTypeDynamicLibrary, libB)
+	prjs := []*Project{libA, libB, sharedA, sharedB}
+
+	// Without --keep-going, shared_b may or may not be started before liba fails,
+	// but shared_a depends on liba and is never built.
+	builder := &testBuilder{fail: map[string]bool{"liba": true}}
+	if _, ok := buildProjectGraph(prjs, false, builder.build); ok {
+		t.Fatalf("expected the build to fail")
+	}
+	if builder.index("shared_a") >= 0 {
+		t.Errorf("expected shared_a to be skipped, got %v", builder.built)
+	}
+
+	// With --keep-going, everything that does not depend on liba is built
+	builder = &testBuilder{fail: map[string]bool{"liba": true}}
+	if _, ok := buildProjectGraph(prjs, true, builder.build); ok {
+		t.Fatalf("expected the build to fail")
+	}
+	if builder.index("shared_a") >= 0 {
+		t.Errorf("expected shared_a to be skipped, got %v", builder.built)
+	}
+	if builder.index("libb") < 0 || builder.index("shared_b") < 0 {
+		t.Errorf("expected libb and shared_b to be built, got %v", builder.built)
+	}
+}
+
+func TestBuildProjectGraphStopsOnFailure(t *testing.T) {
+	libA := newTestProject("liba", denv.BuildTypeStaticLibrary)
+	libB := newTestProject("libb", denv.BuildTypeStaticLibrary)
+	sharedB := newTestProject("shared_b", denv.BuildTypeDynamicLibrary, libB)
+
+	// libb finishes after liba failed, shared_b should then not be started
+	failed := make(chan struct{})
+	builder := &testBuilder{fail: map[string]bool{"liba": true}}
+	build := func(prj *Project) (int, bool) {
+		if prj == libB {
+			<-failed
+		}
+		outOfDate, buildErr := builder.build(prj)
+		if prj == libA {
+			defer close(failed)
+		}
+		return outOfDate, buildErr
+	}
+	if _, ok := buildProjectGraph([]*Project{libA, libB, sharedB}, false, build); ok {
+		t.Fatalf("expected the build to fail")
+	}
+	if builder.index("shared_b") >= 0 {
+		t.Errorf("expected shared_b not to be started after liba failed, got %v", builder.built)
+	}
+}
+
+func TestBuildProjectGraphCycle(t *testing.T) {
+	libA := newTestProject("liba", denv.BuildTypeDynamicLibrary)
+	libB := newTestProject("libb", denv.BuildTypeDynamicLibrary, libA)
+	libA.Dependencies = []*Project{libB}
+
+	builder := &testBuilder{}
+	if _, ok := buildProjectGraph([]*Project{libA, libB}, false, builder.build); ok {
+		t.Fatalf("expected a dependency cycle to fail the build")
+	}
+	if len(builder.built) != 0 {
+		t.Errorf("expected nothing to be built, got %v", builder.built)
+	}
+}
@@ -22,8 +22,20 @@ type Archiver interface {
 	// It should be called before using the Archive method.
 	SetupArgs()
 
+	// ArgsHash returns a hash of the fully resolved command-line that creates the archive.
+	// The hash is stored with the archive in the dependency tracker, any change to the
+	// command-line makes the archive out-of-date.
+	ArgsHash(inputObjAbsFilepaths []string, outputArchiveRelFilepath string) []byte
+
+	// RuntimeFilepath returns the file that an executable linking the library needs at
+	// runtime, e.g. "path/to/library/libname.so" or "path/to/library/name.dll". A static
+	// archive is not needed at runtime and returns an empty string.
+	RuntimeFilepath(libFilepath string) string
+
 	// Archive takes a list of input object file paths and an output archive file path.
 	// Both paths are relative to the build path.
+	// A dynamic archiver links a shared library, the input may then also contain the
+	// (static or import) libraries that the shared library depends on.
 	Archive(inputObjAbsFilepaths []string, outputArchiveRelFilepath string) error
 }
 
@@ -41,3 +53,8 @@ type Archiver interface {
 // up-to-date, because 'test1.o', 'test2.o' and 'test3.o' are up-to-date, 'test.a' is up-to-date as
 // well as 'test.a.d' (the dependency file for the archive).
 // Q: So how do we deal with a change in the list of object files belonging to an archive ?
+// A: The object files are the members of the archive and they are added, in order, as its
+//    dependencies. The archive is queried with QueryItemWithMembers, which also compares the
+//    members with the dependencies the archive was added with. Adding 'test4.o' (or removing
+//    'test3.o', or changing the order) thus makes the archive out-of-date. The same is done
+//    for an executable and the object files and libraries it links.
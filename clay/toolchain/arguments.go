@@ -0,0 +1,65 @@
+package toolchain
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// argumentsHash returns the hash of a fully resolved command-line. Compilers, archivers
+// and linkers store this hash with their output in the dependency tracker, a change in
+// the command-line (e.g. a define, an include directory or an optimization flag) will
+// then make the output out-of-date.
+func argumentsHash(toolPath string, toolArgs []string) []byte {
+	hasher := sha1.New()
+	hasher.Write([]byte(toolPath))
+	for _, arg := range toolArgs {
+		// Terminate each argument, so that {"-DA", "B"} and {"-D", "AB"} do not hash the same
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(arg))
+	}
+	return hasher.Sum(nil)
+}
+
+// compilerCacheKey returns the hash of the compiler identity and the command-line that
+// compiles a source file, used as the key of the compilation cache. The object file path
+// (and the paths derived from it, e.g. the dependency file) are replaced by a placeholder,
+// so that identical compiles in different build directories result in the same key.
+func compilerCacheKey(toolPath string, toolArgs []string, objRelFilepath string) []byte {
+	objPathNoExt := strings.TrimSuffix(objRelFilepath, filepath.Ext(objRelFilepath))
+
+	hasher := sha1.New()
+	hasher.Write(toolIdentity(toolPath))
+	for _, arg := range toolArgs {
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(strings.ReplaceAll(arg, objPathNoExt, "{obj}")))
+	}
+	return hasher.Sum(nil)
+}
+
+var toolIdentities sync.Map
+
+// toolIdentity identifies an executable by its path, size and modification time, so that
+// an updated compiler does not use the objects of its previous version.
+func toolIdentity(toolPath string) []byte {
+	if identity, ok := toolIdentities.Load(toolPath); ok {
+		return identity.([]byte)
+	}
+
+	identity := []byte(toolPath)
+	resolvedPath, err := exec.LookPath(toolPath)
+	if err == nil {
+		if info, err := os.Stat(resolvedPath); err == nil {
+			identity = append(identity, 0)
+			identity = append(identity, resolvedPath...)
+			identity = binary.LittleEndian.AppendUint64(identity, uint64(info.Size()))
+			identity = binary.LittleEndian.AppendUint64(identity, uint64(info.ModTime().UnixNano()))
+		}
+	}
+	toolIdentities.Store(toolPath, identity)
+	return identity
+}
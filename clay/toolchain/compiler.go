@@ -16,6 +16,26 @@ type Compiler interface {
 	// It should be called before using the Compile method.
 	SetupArgs(projectName string, buildPath string, defines []string, includes []string)
 
+	// SetupPch makes the C++ source files use a precompiled header that is compiled from
+	// the header, the header is force-included into every C++ source file. It should be
+	// called after SetupArgs, buildPath is the build directory of the project.
+	// Returns nil when the compiler does not support precompiled headers.
+	SetupPch(headerAbsFilepath string, buildPath string) *Pch
+
+	// ArgsHash returns a hash of the fully resolved command-line that compiles the source
+	// file into the object file. The hash is stored with the object file in the dependency
+	// tracker, any change to the command-line makes the object file out-of-date.
+	ArgsHash(sourceAbsFilepath string, objRelFilepath string) []byte
+
+	// CacheKey returns a hash of the compiler identity and the resolved command-line that
+	// compiles the source file, independent of the build directory of the object file.
+	// The compilation cache combines it with the content of the source file.
+	CacheKey(sourceAbsFilepath string, objRelFilepath string) []byte
+
+	// CompileCommand returns the fully resolved command-line that compiles the source file
+	// into the object file, e.g. for writing a compilation database (compile_commands.json).
+	CompileCommand(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string)
+
 	// Compile takes a list of input source file paths and output object file paths
 	// The source file paths may be absolute or relative to the build directory, however
 	// the object file paths should be relative to the build directory.
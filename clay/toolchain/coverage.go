@@ -0,0 +1,247 @@
+package toolchain
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/coverage"
+)
+
+// CoverageSuffix is the suffix of the build directory of a build with coverage
+// instrumentation, e.g. 'linux-x64-debug-test-cov'.
+const CoverageSuffix = "-cov"
+
+// CoverageSupport is implemented by the toolchains that can build with coverage
+// instrumentation (clay coverage).
+type CoverageSupport interface {
+	// SetCoverage makes the compilers, archivers and linkers of the toolchain instrument
+	// the code for coverage analysis.
+	SetCoverage() error
+
+	// NewCoverageCollector returns the collector of the profiles that the instrumented
+	// executables write, buildPath is the build directory of all the projects.
+	NewCoverageCollector(buildPath string) CoverageCollector
+}
+
+// CoverageCollector collects the profiles of the instrumented executables that have run
+// and turns them into the coverage of the source files.
+type CoverageCollector interface {
+	// Reset removes the profiles of an earlier run
+	Reset() error
+
+	// Env returns the environment variables to run an instrumented executable with
+	Env(executable string) []string
+
+	// Collect merges the profiles of the executables into the coverage of their source files
+	Collect(executables []string) (*coverage.Report, error)
+}
+
+// gccCoverageCompileArgs returns the arguments of gcc or clang to compile a source file
+// with coverage instrumentation.
+func gccCoverageCompileArgs(enabled bool, clang bool) []string {
+	if !enabled {
+		return nil
+	}
+	if clang {
+		return []string{"-fprofile-instr-generate", "-fcoverage-mapping"}
+	}
+	return []string{"--coverage"}
+}
+
+// gccCoverageLinkArgs returns the arguments of gcc or clang to link with the runtime of
+// the coverage instrumentation.
+func gccCoverageLinkArgs(enabled bool, clang bool) []string {
+	if !enabled {
+		return nil
+	}
+	if clang {
+		return []string{"-fprofile-instr-generate"}
+	}
+	return []string{"--coverage"}
+}
+
+// coverageToolPath returns the coverage tool that belongs to a compiler, e.g. 'gcov-12'
+// for 'g++-12' or 'llvm-cov-15' for 'clang++-15', so that the tool understands the
+// profiles of that compiler. The environment variable envVar overrides the tool.
+func coverageToolPath(envVar string, compilerPath string, tool string) string {
+	if path := os.Getenv(envVar); len(path) > 0 {
+		return path
+	}
+	base := filepath.Base(compilerPath)
+	for _, compiler := range []string{"clang++", "clang", "g++", "gcc", "c++", "cc"} {
+		if i := strings.LastIndex(base, compiler); i >= 0 {
+			versioned := filepath.Join(filepath.Dir(compilerPath), base[:i]+tool+base[i+len(compiler):])
+			if path, err := exec.LookPath(versioned); err == nil {
+				return path
+			}
+			if path, err := exec.LookPath(filepath.Base(versioned)); err == nil {
+				return path
+			}
+			break
+		}
+	}
+	return tool
+}
+
+// findFiles returns the files in a directory (recursive) that have the extension
+func findFiles(dirpath string, ext string) ([]string, error) {
+	files := []string{}
+	err := filepath.WalkDir(dirpath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ext {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return files, nil
+	}
+	return files, err
+}
+
+// runCoverageTool runs a coverage tool and returns its output, the error of a failing
+// tool includes what it wrote to stderr.
+func runCoverageTool(toolPath string, args ...string) ([]byte, error) {
+	cmd := exec.Command(toolPath, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w\n%s", filepath.Base(toolPath), err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// gcc, gcov
+
+// gccCoverageCollector collects the coverage of gcc, an instrumented object file has a
+// '.gcno' file next to it and the executables write the execution counts of it to a
+// '.gcda' file next to it, gcov merges them.
+type gccCoverageCollector struct {
+	gcovPath  string
+	buildPath string
+}
+
+func newGccCoverageCollector(compilerPath string, buildPath string) *gccCoverageCollector {
+	return &gccCoverageCollector{gcovPath: coverageToolPath("GCOV", compilerPath, "gcov"), buildPath: buildPath}
+}
+
+// Reset removes the '.gcda' files, the executables otherwise add to the counts of an earlier run
+func (c *gccCoverageCollector) Reset() error {
+	gcdaFilepaths, err := findFiles(c.buildPath, ".gcda")
+	if err != nil {
+		return err
+	}
+	for _, gcdaFilepath := range gcdaFilepaths {
+		if err := os.Remove(gcdaFilepath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *gccCoverageCollector) Env(executable string) []string {
+	return nil
+}
+
+// Collect runs gcov on all the '.gcno' files, so that the source files of object files
+// that did not run are part of the report as not covered.
+func (c *gccCoverageCollector) Collect(executables []string) (*coverage.Report, error) {
+	gcnoFilepaths, err := findFiles(c.buildPath, ".gcno")
+	if err != nil {
+		return nil, err
+	}
+
+	report := coverage.NewReport()
+	const batchSize = 100 // Limits the length of the command line
+	for start := 0; start < len(gcnoFilepaths); start += batchSize {
+		batch := gcnoFilepaths[start:min(start+batchSize, len(gcnoFilepaths))]
+		out, err := runCoverageTool(c.gcovPath, append([]string{"--json-format", "--stdout"}, batch...)...)
+		if err != nil {
+			return nil, err
+		}
+		if err := coverage.ReadGcovJson(bytes.NewReader(out), report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// clang, llvm-profdata and llvm-cov
+
+// llvmCoverageCollector collects the coverage of clang, the executables write a '.profraw'
+// file which llvm-profdata merges, llvm-cov then reads the coverage mapping from the
+// executables and exports the coverage as an lcov tracefile.
+type llvmCoverageCollector struct {
+	profdataCmd    []string
+	covCmd         []string
+	profileDirpath string
+}
+
+func newLlvmCoverageCollector(profdataCmd []string, covCmd []string, buildPath string) *llvmCoverageCollector {
+	return &llvmCoverageCollector{
+		profdataCmd:    profdataCmd,
+		covCmd:         covCmd,
+		profileDirpath: filepath.Join(buildPath, "coverage", "profiles"),
+	}
+}
+
+// Reset removes the directory with the profiles of an earlier run
+func (c *llvmCoverageCollector) Reset() error {
+	if err := os.RemoveAll(c.profileDirpath); err != nil {
+		return err
+	}
+	return os.MkdirAll(c.profileDirpath, 0755)
+}
+
+// Env writes the profile of every process of an executable to a file of its own
+func (c *llvmCoverageCollector) Env(executable string) []string {
+	profileDirpath, err := filepath.Abs(c.profileDirpath)
+	if err != nil {
+		profileDirpath = c.profileDirpath
+	}
+	return []string{"LLVM_PROFILE_FILE=" + filepath.Join(profileDirpath, filepath.Base(executable)+"-%p.profraw")}
+}
+
+func (c *llvmCoverageCollector) Collect(executables []string) (*coverage.Report, error) {
+	profrawFilepaths, err := findFiles(c.profileDirpath, ".profraw")
+	if err != nil {
+		return nil, err
+	}
+	if len(profrawFilepaths) == 0 || len(executables) == 0 {
+		return nil, fmt.Errorf("no coverage profiles found in %q", c.profileDirpath)
+	}
+
+	profdataFilepath := filepath.Join(c.profileDirpath, "merged.profdata")
+	args := append(slices.Clone(c.profdataCmd[1:]), "merge", "-sparse", "-o", profdataFilepath)
+	if _, err := runCoverageTool(c.profdataCmd[0], append(args, profrawFilepaths...)...); err != nil {
+		return nil, err
+	}
+
+	args = append(slices.Clone(c.covCmd[1:]), "export", "-format=lcov", "-instr-profile="+profdataFilepath, executables[0])
+	for _, executable := range executables[1:] {
+		args = append(args, "-object="+executable)
+	}
+	out, err := runCoverageTool(c.covCmd[0], args...)
+	if err != nil {
+		return nil, err
+	}
+
+	report := coverage.NewReport()
+	if err := coverage.ReadLcov(bytes.NewReader(out), report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
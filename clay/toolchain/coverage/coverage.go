@@ -0,0 +1,430 @@
+package coverage
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The line and function coverage of source files, collected from the profiles of gcc
+// (gcov) and clang (llvm-cov) and written as an lcov tracefile, a Cobertura XML report
+// and a plain-text summary.
+
+// Report is the coverage of a set of source files, the coverage of a source file that is
+// compiled into several object files (e.g. a header) is merged.
+type Report struct {
+	Files map[string]*File // The absolute path of a source file to its coverage
+}
+
+// File is the coverage of a single source file
+type File struct {
+	Path      string               // The absolute path of the source file
+	Project   string               // The project the source file belongs to
+	Lines     map[int]int64        // The line number to the number of times it was executed
+	Functions map[string]*Function // The (mangled) name to the function
+}
+
+// Function is the coverage of a single function
+type Function struct {
+	Name  string // The (mangled) name of the function
+	Line  int    // The line on which the function starts
+	Count int64  // The number of times the function was called
+}
+
+func NewReport() *Report {
+	return &Report{Files: map[string]*File{}}
+}
+
+// File returns the coverage of a source file, it is added when the report does not have it
+func (r *Report) File(path string) *File {
+	path = filepath.Clean(path)
+	file, ok := r.Files[path]
+	if !ok {
+		file = &File{Path: path, Lines: map[int]int64{}, Functions: map[string]*Function{}}
+		r.Files[path] = file
+	}
+	return file
+}
+
+// SortedFiles returns the files ordered by project and path
+func (r *Report) SortedFiles() []*File {
+	files := make([]*File, 0, len(r.Files))
+	for _, file := range r.Files {
+		files = append(files, file)
+	}
+	slices.SortFunc(files, func(a, b *File) int {
+		if c := strings.Compare(a.Project, b.Project); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	return files
+}
+
+// Filter removes the files for which keep returns false
+func (r *Report) Filter(keep func(file *File) bool) {
+	for path, file := range r.Files {
+		if !keep(file) {
+			delete(r.Files, path)
+		}
+	}
+}
+
+// AddLine adds the execution count of a line
+func (f *File) AddLine(line int, count int64) {
+	f.Lines[line] += count
+}
+
+// AddFunction adds the call count of a function
+func (f *File) AddFunction(name string, line int, count int64) {
+	if function, ok := f.Functions[name]; ok {
+		function.Count += count
+		return
+	}
+	f.Functions[name] = &Function{Name: name, Line: line, Count: count}
+}
+
+// LinesCovered returns the number of lines that are executed and the number of lines
+func (f *File) LinesCovered() (covered int, total int) {
+	for _, count := range f.Lines {
+		if count > 0 {
+			covered++
+		}
+	}
+	return covered, len(f.Lines)
+}
+
+// FunctionsCovered returns the number of functions that are called and the number of functions
+func (f *File) FunctionsCovered() (covered int, total int) {
+	for _, function := range f.Functions {
+		if function.Count > 0 {
+			covered++
+		}
+	}
+	return covered, len(f.Functions)
+}
+
+func (f *File) sortedLines() []int {
+	lines := make([]int, 0, len(f.Lines))
+	for line := range f.Lines {
+		lines = append(lines, line)
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+func (f *File) sortedFunctions() []*Function {
+	functions := make([]*Function, 0, len(f.Functions))
+	for _, function := range f.Functions {
+		functions = append(functions, function)
+	}
+	slices.SortFunc(functions, func(a, b *Function) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return functions
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Reading
+
+type gcovJsonLine struct {
+	LineNumber int   `json:"line_number"`
+	Count      int64 `json:"count"`
+}
+
+type gcovJsonFunction struct {
+	Name           string `json:"name"`
+	StartLine      int    `json:"start_line"`
+	ExecutionCount int64  `json:"execution_count"`
+}
+
+type gcovJsonFile struct {
+	File      string             `json:"file"`
+	Lines     []gcovJsonLine     `json:"lines"`
+	Functions []gcovJsonFunction `json:"functions"`
+}
+
+type gcovJson struct {
+	CurrentWorkingDirectory string         `json:"current_working_directory"`
+	Files                   []gcovJsonFile `json:"files"`
+}
+
+// ReadGcovJson adds the coverage of the output of 'gcov --json-format --stdout', which is
+// a JSON document per data file. A relative source file path is relative to the working
+// directory of the compiler.
+func ReadGcovJson(reader io.Reader, report *Report) error {
+	decoder := json.NewDecoder(reader)
+	for {
+		var doc gcovJson
+		if err := decoder.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("invalid gcov json: %w", err)
+		}
+		for _, docFile := range doc.Files {
+			path := docFile.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(doc.CurrentWorkingDirectory, path)
+			}
+			file := report.File(path)
+			for _, line := range docFile.Lines {
+				file.AddLine(line.LineNumber, line.Count)
+			}
+			for _, function := range docFile.Functions {
+				file.AddFunction(function.Name, function.StartLine, function.ExecutionCount)
+			}
+		}
+	}
+}
+
+// ReadLcov adds the coverage of an lcov tracefile, e.g. the output of 'llvm-cov export
+// -format=lcov'. Only the source file, function and line records are read.
+func ReadLcov(reader io.Reader, report *Report) error {
+	var file *File
+	functionLines := map[string]int{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		record, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		invalid := func() error { return fmt.Errorf("invalid lcov record on line %d: %q", lineNumber, scanner.Text()) }
+
+		switch record {
+		case "SF":
+			file = report.File(value)
+			clear(functionLines)
+		case "end_of_record":
+			file = nil
+		case "FN", "FNDA", "DA":
+			// The first field is a number, the second field is a function name (which can
+			// contain a comma) or the execution count of a line (followed by a checksum)
+			first, second, ok := strings.Cut(value, ",")
+			if file == nil || !ok {
+				return invalid()
+			}
+			number, err := strconv.ParseInt(first, 10, 64)
+			if err != nil {
+				return invalid()
+			}
+			switch record {
+			case "FN":
+				functionLines[second] = int(number)
+			case "FNDA":
+				file.AddFunction(second, functionLines[second], number)
+			case "DA":
+				// The execution count can be a float or a negative number in some lcov writers
+				second, _, _ = strings.Cut(second, ",")
+				count, err := strconv.ParseFloat(second, 64)
+				if err != nil {
+					return invalid()
+				}
+				file.AddLine(int(number), max(int64(count), 0))
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Writing
+
+// WriteLcov writes the report as an lcov tracefile, the test name of every record is the
+// project of the source file.
+func (r *Report) WriteLcov(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+	for _, file := range r.SortedFiles() {
+		fmt.Fprintf(w, "TN:%s\n", file.Project)
+		fmt.Fprintf(w, "SF:%s\n", file.Path)
+		functions := file.sortedFunctions()
+		for _, function := range functions {
+			fmt.Fprintf(w, "FN:%d,%s\n", function.Line, function.Name)
+		}
+		for _, function := range functions {
+			fmt.Fprintf(w, "FNDA:%d,%s\n", function.Count, function.Name)
+		}
+		functionsCovered, functionsTotal := file.FunctionsCovered()
+		fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", functionsTotal, functionsCovered)
+		for _, line := range file.sortedLines() {
+			fmt.Fprintf(w, "DA:%d,%d\n", line, file.Lines[line])
+		}
+		linesCovered, linesTotal := file.LinesCovered()
+		fmt.Fprintf(w, "LF:%d\nLH:%d\n", linesTotal, linesCovered)
+		fmt.Fprintln(w, "end_of_record")
+	}
+	return w.Flush()
+}
+
+type coberturaLine struct {
+	Number int    `xml:"number,attr"`
+	Hits   int64  `xml:"hits,attr"`
+	Branch string `xml:"branch,attr"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+// rate returns the fraction of covered items as a string, an empty set is fully covered
+func rate(covered int, total int) string {
+	if total == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(float64(covered)/float64(total), 'f', 4, 64)
+}
+
+// WriteCobertura writes the report as a Cobertura XML report, every project is a package
+// and every source file is a class. The filenames are relative to sourceDirpath, which is
+// the source of the report. There is no branch coverage, the branch rate is always 0.
+func (r *Report) WriteCobertura(writer io.Writer, sourceDirpath string, timestamp time.Time) error {
+	report := coberturaCoverage{
+		BranchRate: "0",
+		Complexity: "0",
+		Version:    "clay",
+		Timestamp:  timestamp.Unix(),
+		Sources:    []string{filepath.ToSlash(sourceDirpath)},
+	}
+
+	packageCovered, packageTotal := 0, 0
+	for _, file := range r.SortedFiles() {
+		if len(report.Packages) == 0 || report.Packages[len(report.Packages)-1].Name != file.Project {
+			packageCovered, packageTotal = 0, 0
+			report.Packages = append(report.Packages, coberturaPackage{Name: file.Project, BranchRate: "0", Complexity: "0"})
+		}
+		pkg := &report.Packages[len(report.Packages)-1]
+
+		filename := file.Path
+		if relPath, err := filepath.Rel(sourceDirpath, file.Path); err == nil {
+			filename = relPath
+		}
+		covered, total := file.LinesCovered()
+		class := coberturaClass{
+			Name:       filepath.Base(file.Path),
+			Filename:   filepath.ToSlash(filename),
+			LineRate:   rate(covered, total),
+			BranchRate: "0",
+			Complexity: "0",
+		}
+		for _, line := range file.sortedLines() {
+			class.Lines = append(class.Lines, coberturaLine{Number: line, Hits: file.Lines[line], Branch: "false"})
+		}
+		pkg.Classes = append(pkg.Classes, class)
+
+		packageCovered += covered
+		packageTotal += total
+		pkg.LineRate = rate(packageCovered, packageTotal)
+		report.LinesCovered += covered
+		report.LinesValid += total
+	}
+	report.LineRate = rate(report.LinesCovered, report.LinesValid)
+
+	content, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(writer, xml.Header+"<!DOCTYPE coverage SYSTEM \"http://cobertura.sourceforge.net/xml/coverage-04.dtd\">\n"); err != nil {
+		return err
+	}
+	_, err = writer.Write(append(content, '\n'))
+	return err
+}
+
+// summaryRow is a row of the summary, the coverage of a file, a project or the total
+type summaryRow struct {
+	name                                                       string
+	linesCovered, linesTotal, functionsCovered, functionsTotal int
+}
+
+func (s *summaryRow) add(file *File) {
+	linesCovered, linesTotal := file.LinesCovered()
+	functionsCovered, functionsTotal := file.FunctionsCovered()
+	s.linesCovered += linesCovered
+	s.linesTotal += linesTotal
+	s.functionsCovered += functionsCovered
+	s.functionsTotal += functionsTotal
+}
+
+func percentage(covered int, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%% (%d/%d)", 100*float64(covered)/float64(total), covered, total)
+}
+
+// WriteSummary writes the line and function coverage per file, per project and in total
+// as a plain-text table, the files are relative to sourceDirpath.
+func (r *Report) WriteSummary(writer io.Writer, sourceDirpath string) error {
+	files := []summaryRow{}
+	projects := []summaryRow{}
+	total := summaryRow{name: "Total"}
+	for _, file := range r.SortedFiles() {
+		name := file.Path
+		if relPath, err := filepath.Rel(sourceDirpath, file.Path); err == nil {
+			name = relPath
+		}
+		row := summaryRow{name: filepath.ToSlash(name)}
+		row.add(file)
+		files = append(files, row)
+
+		if len(projects) == 0 || projects[len(projects)-1].name != file.Project {
+			projects = append(projects, summaryRow{name: file.Project})
+		}
+		projects[len(projects)-1].add(file)
+		total.add(file)
+	}
+
+	w := bufio.NewWriter(writer)
+	writeTable := func(title string, rows []summaryRow) {
+		width := len(title)
+		for _, row := range rows {
+			width = max(width, len(row.name))
+		}
+		fmt.Fprintf(w, "%-*s  %-22s  %s\n", width, title, "Lines", "Functions")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%-*s  %-22s  %s\n", width, row.name, percentage(row.linesCovered, row.linesTotal), percentage(row.functionsCovered, row.functionsTotal))
+		}
+	}
+	writeTable("File", files)
+	fmt.Fprintln(w)
+	writeTable("Project", append(projects, total))
+	return w.Flush()
+}
@@ -0,0 +1,132 @@
+package coverage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGcovJson = `{"files": [{"file": "/pkg/src/a.cpp", "lines": [{"line_number": 2, "count": 1}, {"line_number": 3, "count": 0}], "functions": [{"name": "_Z1fi", "start_line": 2, "execution_count": 1}]}, {"file": "src/h.h", "lines": [{"line_number": 1, "count": 2}], "functions": []}], "current_working_directory": "/pkg", "data_file": "build/p/a.cpp.gcda"}
+{"files": [{"file": "/pkg/src/h.h", "lines": [{"line_number": 1, "count": 3}, {"line_number": 4, "count": 0}], "functions": []}], "current_working_directory": "/pkg", "data_file": "build/p/b.cpp.gcda"}
+`
+
+func TestReadGcovJson(t *testing.T) {
+	report := NewReport()
+	if err := ReadGcovJson(strings.NewReader(testGcovJson), report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(report.Files))
+	}
+
+	// A header that is part of several data files is merged
+	header := report.File("/pkg/src/h.h")
+	if header.Lines[1] != 5 || header.Lines[4] != 0 {
+		t.Errorf("expected the merged line counts of h.h, got %v", header.Lines)
+	}
+	if covered, total := header.LinesCovered(); covered != 1 || total != 2 {
+		t.Errorf("expected 1 of 2 lines of h.h covered, got %d of %d", covered, total)
+	}
+
+	source := report.File("/pkg/src/a.cpp")
+	if covered, total := source.FunctionsCovered(); covered != 1 || total != 1 {
+		t.Errorf("expected 1 of 1 functions of a.cpp covered, got %d of %d", covered, total)
+	}
+}
+
+func TestLcovRoundTrip(t *testing.T) {
+	report := NewReport()
+	file := report.File("/pkg/src/a.cpp")
+	file.Project = "mylib"
+	file.AddLine(2, 1)
+	file.AddLine(3, 0)
+	file.AddFunction("_Z1fi", 2, 1)
+	file.AddFunction("_Z1gi", 3, 0)
+
+	var buffer bytes.Buffer
+	if err := report.WriteLcov(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []string{"TN:mylib", "SF:/pkg/src/a.cpp", "FN:2,_Z1fi", "FNDA:0,_Z1gi", "FNF:2", "FNH:1", "DA:3,0", "LF:2", "LH:1", "end_of_record"} {
+		if !strings.Contains(buffer.String(), record+"\n") {
+			t.Errorf("expected the record %q in:\n%s", record, buffer.String())
+		}
+	}
+
+	read := NewReport()
+	if err := ReadLcov(&buffer, read); err != nil {
+		t.Fatal(err)
+	}
+	readFile := read.File("/pkg/src/a.cpp")
+	if readFile.Lines[2] != 1 || readFile.Lines[3] != 0 || len(readFile.Lines) != 2 {
+		t.Errorf("expected the lines to be read back, got %v", readFile.Lines)
+	}
+	if function := readFile.Functions["_Z1fi"]; function == nil || function.Line != 2 || function.Count != 1 {
+		t.Errorf("expected the function _Z1fi to be read back, got %+v", function)
+	}
+
+	if err := ReadLcov(strings.NewReader("DA:1,1\n"), NewReport()); err == nil {
+		t.Errorf("expected an error for a line record without a source file")
+	}
+}
+
+func TestWriteCobertura(t *testing.T) {
+	report := NewReport()
+	a := report.File("/pkg/source/main/cpp/a.cpp")
+	a.Project = "mylib"
+	a.AddLine(1, 4)
+	a.AddLine(2, 0)
+	b := report.File("/pkg/source/main/cpp/b.cpp")
+	b.Project = "mylib"
+	b.AddLine(1, 1)
+
+	var buffer bytes.Buffer
+	if err := report.WriteCobertura(&buffer, "/pkg", time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	xml := buffer.String()
+	for _, expected := range []string{
+		`<coverage line-rate="0.6667" branch-rate="0" lines-covered="2" lines-valid="3"`,
+		`timestamp="1700000000"`,
+		`<source>/pkg</source>`,
+		`<package name="mylib" line-rate="0.6667"`,
+		`<class name="a.cpp" filename="source/main/cpp/a.cpp" line-rate="0.5000"`,
+		`<line number="2" hits="0" branch="false"></line>`,
+	} {
+		if !strings.Contains(xml, expected) {
+			t.Errorf("expected %q in:\n%s", expected, xml)
+		}
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	report := NewReport()
+	a := report.File("/pkg/src/a.cpp")
+	a.Project = "mylib"
+	a.AddLine(1, 1)
+	a.AddLine(2, 0)
+	a.AddFunction("_Z1fi", 1, 1)
+	b := report.File("/pkg/src/b.cpp")
+	b.Project = "myapp"
+	b.AddLine(1, 1)
+
+	var buffer bytes.Buffer
+	if err := report.WriteSummary(&buffer, "/pkg"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	expected := []string{
+		"File       Lines                   Functions",
+		"src/b.cpp  100.0% (1/1)            -",
+		"src/a.cpp  50.0% (1/2)             100.0% (1/1)",
+		"",
+		"Project  Lines                   Functions",
+		"myapp    100.0% (1/1)            -",
+		"mylib    50.0% (1/2)             100.0% (1/1)",
+		"Total    66.7% (2/3)             100.0% (1/1)",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected the summary:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+}
@@ -0,0 +1,209 @@
+package deptrackr
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+)
+
+// ChangeMode determines how the file trackers detect that a file has changed.
+type ChangeMode uint8
+
+const (
+	// ChangeModeModTime detects a change by comparing the modification time of a file (in
+	// seconds). This is fast, but it does not see changes within the same second and it
+	// rebuilds when a file is touched but its content is unchanged.
+	ChangeModeModTime ChangeMode = iota
+
+	// ChangeModeContent stores the modification time (in nanoseconds), the size and a digest
+	// of the content of a file. The digest is only recomputed when the modification time or
+	// the size differ, a file is only considered changed when its content has changed.
+	// This also detects files that are restored with an older timestamp (e.g. git checkout).
+	ChangeModeContent
+)
+
+var defaultChangeMode = ChangeModeModTime
+
+// SetDefaultChangeMode sets the change mode of the file trackers that are loaded without
+// an explicit change mode.
+func SetDefaultChangeMode(mode ChangeMode) {
+	defaultChangeMode = mode
+}
+
+// DefaultChangeMode returns the change mode of the file trackers that are loaded without
+// an explicit change mode.
+func DefaultChangeMode() ChangeMode {
+	return defaultChangeMode
+}
+
+func (m ChangeMode) String() string {
+	switch m {
+	case ChangeModeModTime:
+		return "mtime"
+	case ChangeModeContent:
+		return "content"
+	default:
+		return "unknown"
+	}
+}
+
+// Layout of the change data of ChangeFlagContent:
+// - modification time in nanoseconds (8 bytes)
+// - file size (8 bytes)
+// - SHA1 digest of the file content (20 bytes)
+const (
+	contentChangeDataSize = 8 + 8 + sha1.Size
+)
+
+// changeFlagsOfMode returns the change flags an item is stored with in the given mode
+func changeFlagsOfMode(mode ChangeMode) uint8 {
+	if mode == ChangeModeContent {
+		return ChangeFlagContent
+	}
+	return ChangeFlagModTime
+}
+
+// fileChangeData returns the change flags and change data of a file. A file that does not
+// exist gets the current time as its modification time, which makes it out of date.
+func fileChangeData(mode ChangeMode, filepath string) (changeFlags uint8, changeData []byte) {
+	fileInfo, err := os.Stat(filepath)
+
+	if mode == ChangeModeContent {
+		changeData = make([]byte, contentChangeDataSize)
+		if err != nil {
+			binary.LittleEndian.PutUint64(changeData[0:], uint64(time.Now().UnixNano()))
+			binary.LittleEndian.PutUint64(changeData[8:], ^uint64(0))
+		} else {
+			binary.LittleEndian.PutUint64(changeData[0:], uint64(fileInfo.ModTime().UnixNano()))
+			binary.LittleEndian.PutUint64(changeData[8:], uint64(fileInfo.Size()))
+			if digest, err := fileContentDigest(filepath); err == nil {
+				copy(changeData[16:], digest)
+			}
+		}
+		return ChangeFlagContent, changeData
+	}
+
+	changeData = make([]byte, 8)
+	if err != nil {
+		binary.LittleEndian.PutUint64(changeData, uint64(time.Now().Unix()))
+	} else {
+		binary.LittleEndian.PutUint64(changeData, uint64(fileInfo.ModTime().Unix()))
+	}
+	return ChangeFlagModTime, changeData
+}
+
+// fileChangeData is fileChangeData for an item that is added to the database. In content mode
+// the digest of a file that was verified or hashed before in this session, with the same
+// modification time and size, is reused, so a header that many objects depend on is read once.
+func (d *trackr) fileChangeData(mode ChangeMode, filepath string) (changeFlags uint8, changeData []byte) {
+	if mode != ChangeModeContent {
+		return fileChangeData(mode, filepath)
+	}
+	fileInfo, err := os.Stat(filepath)
+	if err != nil {
+		return fileChangeData(mode, filepath)
+	}
+
+	modTime, size := uint64(fileInfo.ModTime().UnixNano()), uint64(fileInfo.Size())
+	changeData = make([]byte, contentChangeDataSize)
+	binary.LittleEndian.PutUint64(changeData[0:], modTime)
+	binary.LittleEndian.PutUint64(changeData[8:], size)
+	if digest, ok := d.digests[filepath]; ok && digest.modTime == modTime && digest.size == size {
+		copy(changeData[16:], digest.digest)
+	} else if digest, err := fileContentDigest(filepath); err == nil {
+		copy(changeData[16:], digest)
+		d.rememberDigest(filepath, modTime, size, digest)
+	}
+	return ChangeFlagContent, changeData
+}
+
+// contentDigest is the digest of the content of a file at a modification time and size
+type contentDigest struct {
+	modTime uint64
+	size    uint64
+	digest  []byte
+}
+
+type contentDigests map[string]contentDigest
+
+func (d *trackr) rememberDigest(filepath string, modTime uint64, size uint64, digest []byte) {
+	if d.digests == nil {
+		d.digests = contentDigests{}
+	}
+	d.digests[filepath] = contentDigest{modTime: modTime, size: size, digest: bytes.Clone(digest)}
+}
+
+// isFileUnchanged returns true when the file still matches the change data it was stored with.
+// An item that was stored in another mode than the current mode is considered changed, so that
+// switching the mode results in a clean rebuild.
+func isFileUnchanged(mode ChangeMode, filepath string, changeFlags uint8, changeData []byte) bool {
+	return verifyFileChange(nil, mode, filepath, changeFlags, changeData)
+}
+
+// isFileUnchanged is isFileUnchanged for the change data of an item of the database. In content
+// mode a file that has been touched without changing its content gets its modification time
+// refreshed in the change data, its digest is then not recomputed by the next query. The
+// database is marked as refreshed, so that the trackr saves it. The digest of an unchanged
+// file is remembered, see (*trackr).fileChangeData.
+func (d *trackr) isFileUnchanged(mode ChangeMode, filepath string, changeFlags uint8, changeData []byte) bool {
+	return verifyFileChange(d, mode, filepath, changeFlags, changeData)
+}
+
+// verifyFileChange returns true when the file still matches the change data, when d is not nil
+// the modification time of a touched but unchanged file is written into changeData.
+func verifyFileChange(d *trackr, mode ChangeMode, filepath string, changeFlags uint8, changeData []byte) bool {
+	if changeFlags != changeFlagsOfMode(mode) {
+		return false
+	}
+
+	fileInfo, err := os.Stat(filepath)
+	if err != nil {
+		return false
+	}
+
+	if changeFlags == ChangeFlagContent {
+		if len(changeData) != contentChangeDataSize {
+			return false
+		}
+		modTime, size := uint64(fileInfo.ModTime().UnixNano()), uint64(fileInfo.Size())
+		if size != binary.LittleEndian.Uint64(changeData[8:]) {
+			return false
+		}
+		if modTime != binary.LittleEndian.Uint64(changeData[0:]) {
+			// The file has been touched, only when the content differs it has changed
+			digest, err := fileContentDigest(filepath)
+			if err != nil || !bytes.Equal(digest, changeData[16:]) {
+				return false
+			}
+			if d != nil {
+				binary.LittleEndian.PutUint64(changeData[0:], modTime)
+				d.refreshed = true
+			}
+		}
+		if d != nil {
+			d.rememberDigest(filepath, modTime, size, changeData[16:])
+		}
+		return true
+	}
+
+	modTimeBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(modTimeBytes, uint64(fileInfo.ModTime().Unix()))
+	return bytes.Equal(modTimeBytes, changeData)
+}
+
+func fileContentDigest(filepath string) ([]byte, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
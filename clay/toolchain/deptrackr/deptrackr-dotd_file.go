@@ -3,12 +3,10 @@ package deptrackr
 import (
 	"bytes"
 	"crypto/sha1"
-	"encoding/binary"
 	"fmt"
 	"hash"
 	"os"
 	"strings"
-	"time"
 )
 
 type FileTrackr interface {
@@ -21,6 +19,27 @@ type FileTrackr interface {
 	ParseDependencyFile(srcFilepath, objFilepath, depFilepath string) (mainItem string, depItems []string, err error)
 	CopyItem(item string)
 
+	// ExplainItem returns why an item is out of date (nil or empty when it is up to date),
+	// data is the extra data the item is queried with (nil = no extra data).
+	ExplainItem(item string, data []byte) []Explanation
+
+	// QueryItemWithMembers is QueryItemWithExtraData for an item that is made out of an ordered
+	// list of members that it was added with as its dependencies, e.g. an archive and its object
+	// files. The item is also out of date when a member was added or removed or the order changed.
+	QueryItemWithMembers(item string, data []byte, members []string) bool
+
+	// AddItemWithMembers is AddItemWithExtraData for an item that is made out of an ordered list
+	// of members, the digest of the content of every member is recorded (whatever the change
+	// mode), so that a member that is rebuilt with the same content keeps the item up to date.
+	AddItemWithMembers(item string, data []byte, members []string) error
+
+	// ExplainItemWithMembers is ExplainItem for an item that is queried with QueryItemWithMembers
+	ExplainItemWithMembers(item string, data []byte, members []string) []Explanation
+
+	// ForEachItem calls fn for every item in the loaded database with its dependencies,
+	// e.g. an object file and its source file and headers.
+	ForEachItem(fn func(item string, deps []string))
+
 	Save() (int, error) // 0=none save (up-to-date), 1=changes saved (out-of-date), -1=error
 }
 
@@ -31,21 +50,28 @@ type depFileTracker struct {
 	currentState State
 	future       *trackr
 	hasher       hash.Hash
+	changeMode   ChangeMode
 }
 
+// LoadDepFileTrackr loads the file tracker using the default change mode, see SetDefaultChangeMode.
 func LoadDepFileTrackr(storageFilepath string) FileTrackr {
-	current := loadTrackr(storageFilepath, "file deptrackr, v1.0.4")
+	return LoadDepFileTrackrWithChangeMode(storageFilepath, DefaultChangeMode())
+}
+
+func LoadDepFileTrackrWithChangeMode(storageFilepath string, changeMode ChangeMode) FileTrackr {
+	current := loadTrackr(storageFilepath, "file deptrackr, v1.0.5")
 	tracker := current.newTrackr()
 	return &depFileTracker{
 		current:      current,
 		currentState: StateUpToDate, // Start with an up-to-date state
 		future:       tracker,
 		hasher:       sha1.New(),
+		changeMode:   changeMode,
 	}
 }
 
 func (d *depFileTracker) Save() (int, error) {
-	if d.currentState == StateUpToDate {
+	if d.currentState == StateUpToDate && !d.current.refreshed {
 		// If the current state is up to date, we do not need to save anything
 		return 0, nil
 	}
@@ -53,8 +79,13 @@ func (d *depFileTracker) Save() (int, error) {
 	return 1, err
 }
 
-// In short form, a .d file (items are separated by '\'):
-// <object-file>: \ <source-file> \ <header-file> \ ...
+func (d *depFileTracker) ExplainItem(item string, data []byte) []Explanation {
+	return explainFileItem(d.current, d.hasher, d.changeMode, item, data)
+}
+
+func (d *depFileTracker) ForEachItem(fn func(item string, deps []string)) {
+	forEachFileItem(d.current, fn)
+}
 
 func (d *depFileTracker) CopyItem(item string) {
 	d.hasher.Reset()
@@ -68,56 +99,84 @@ func (d *depFileTracker) ParseDependencyFile(srcFilepath, objFilepath, depFilepa
 	if err != nil {
 		return "", []string{}, err
 	}
+	mainItem, depItems = parseDotdContent(string(contentBytes))
+	return mainItem, depItems, nil
+}
 
-	type part struct {
-		from int
-		to   int
-	}
-
-	// Parse the .d file content
-	content := string(contentBytes)
-	var parts []part
-	current := part{from: 0, to: 0} // Start with an empty part
-	startPos := 0
-	for startPos < len(content) {
-		endPos := strings.Index(content[startPos:], "\\")
-		if endPos == -1 {
-			endPos = len(content)
-		} else {
-			endPos += startPos
-		}
-
-		// If we encounter a backslash, we assume the next part starts
-		// Figure out the 'to' index of the current part by stepping back
-		// ignoring any spaces, tabs, or newlines before the backslash
-		end := endPos - 1
-		for end >= 0 && (content[end] == ' ' || content[end] == '\t' || content[end] == '\n' || content[end] == '\r' || content[end] == ':') {
-			end-- // move back until we find a non-(space,tab,cr,ln,:) character
+// A .d file is a makefile rule, the object file is the target and the source file and
+// the header files are the prerequisites:
+//
+//	<object-file>: <source-file> <header-file> <header-file> \
+//	  <header-file> ...
+//
+// Clang tends to write one item per line, gcc writes as many items on a line as fit.
+// Items are separated by whitespace, a line ending in a '\' continues on the next line
+// and a space that is part of a path is escaped as '\ '. Only the first rule is parsed,
+// the phony rules that are emitted with -MP are ignored.
+func parseDotdContent(content string) (mainItem string, depItems []string) {
+	var item strings.Builder
+	haveMain := false
+
+	endItem := func() {
+		if item.Len() == 0 {
+			return
 		}
-		current.to = end + 1 // set the 'to' index of the last part
-		parts = append(parts, current)
-
-		// Now we need to find the beginning of the next part, but first
-		// skip space, tab, and newline characters after a backslash
-		begin := endPos + 1
-		for begin < len(content) && (content[begin] == ' ' || content[begin] == '\t' || content[begin] == '\n' || content[begin] == '\r') {
-			begin++ // move forward until we find a non-(space,tab,cr,ln) character
+		if !haveMain {
+			mainItem = item.String()
+			haveMain = true
+		} else {
+			depItems = append(depItems, item.String())
 		}
-		current = part{from: begin, to: begin} // Start a new part
-
-		startPos = begin // Set the index to continue scanning
+		item.Reset()
 	}
 
-	for i, p := range parts {
-		if i == 0 {
-			mainItem = content[p.from:p.to]
-		} else {
-			depItem := content[p.from:p.to]
-			depItems = append(depItems, depItem)
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '\\':
+			if i+1 < len(content) && (content[i+1] == '\n' || content[i+1] == '\r') {
+				// Line continuation
+				endItem()
+				i++
+				if content[i] == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+					i++
+				}
+			} else if i+1 < len(content) && (content[i+1] == ' ' || content[i+1] == '#') {
+				// Escaped space or hash as part of a path
+				i++
+				item.WriteByte(content[i])
+			} else {
+				// Any other backslash is part of the path (e.g. C:\path\to\file.h)
+				item.WriteByte(c)
+			}
+		case '$':
+			item.WriteByte(c)
+			if i+1 < len(content) && content[i+1] == '$' {
+				i++
+			}
+		case ':':
+			// The target ends with a ':' that is followed by whitespace, a ':' that is
+			// part of a path (e.g. C:/path/to/file.o) is not followed by whitespace.
+			if !haveMain && (i+1 == len(content) || content[i+1] == ' ' || content[i+1] == '\t' || content[i+1] == '\n' || content[i+1] == '\r' || content[i+1] == '\\') {
+				endItem()
+				haveMain = true
+			} else {
+				item.WriteByte(c)
+			}
+		case ' ', '\t':
+			endItem()
+		case '\n', '\r':
+			endItem()
+			if haveMain {
+				return mainItem, depItems
+			}
+		default:
+			item.WriteByte(c)
 		}
 	}
+	endItem()
 
-	return mainItem, depItems, nil
+	return mainItem, depItems
 }
 
 // item = depfileAbsFilepath
@@ -128,14 +187,8 @@ func (d *depFileTracker) addItem(item string, extra []byte, deps []string) error
 	d.hasher.Write([]byte(item))
 	mainHash := d.hasher.Sum(nil)
 
-	// For the 'change', we want the file modification time and hash it
-	modTimeBytes := make([]byte, 8)
-	fileInfo, err := os.Stat(item)
-	if err != nil {
-		binary.LittleEndian.PutUint64(modTimeBytes, uint64(time.Now().Unix())) // If the file does not exist, use the current time
-	} else {
-		binary.LittleEndian.PutUint64(modTimeBytes, uint64(fileInfo.ModTime().Unix()))
-	}
+	// For the 'change', we want the file modification time (and in content mode the size and digest)
+	changeFlags, changeData := d.current.fileChangeData(d.changeMode, item)
 
 	// We are adding a new item, so the trackr is marked as out of date
 	d.currentState = StateOutOfDate
@@ -144,9 +197,9 @@ func (d *depFileTracker) addItem(item string, extra []byte, deps []string) error
 		IdData:       []byte(item),
 		IdDigest:     mainHash,
 		IdFlags:      ItemFlagSourceFile,
-		ChangeData:   bytes.Clone(modTimeBytes),
-		ChangeDigest: nil, // mod-time is small enough, we do not need a hash
-		ChangeFlags:  ChangeFlagModTime,
+		ChangeData:   changeData,
+		ChangeDigest: nil, // change data is small enough, we do not need a hash
+		ChangeFlags:  changeFlags,
 	}
 
 	var depItems []ItemToAdd
@@ -158,21 +211,16 @@ func (d *depFileTracker) addItem(item string, extra []byte, deps []string) error
 		d.hasher.Write([]byte(depFilepath))
 		depDigest := d.hasher.Sum(nil)
 
-		// For the 'change', we want the file modification time and hash it
-		fileInfo, err = os.Stat(depFilepath)
-		if err != nil {
-			binary.LittleEndian.PutUint64(modTimeBytes, uint64(time.Now().Unix()))
-		} else {
-			binary.LittleEndian.PutUint64(modTimeBytes, uint64(fileInfo.ModTime().Unix()))
-		}
+		// For the 'change', we want the file modification time (and in content mode the size and digest)
+		depChangeFlags, depChangeData := d.current.fileChangeData(d.changeMode, depFilepath)
 
 		depItemToAdd := ItemToAdd{
 			IdDigest:     depDigest,
 			IdData:       []byte(depFilepath),
 			IdFlags:      ItemFlagDependency,
-			ChangeDigest: nil, // mod-time is small enough, we do not need a hash
-			ChangeData:   bytes.Clone(modTimeBytes),
-			ChangeFlags:  ChangeFlagModTime,
+			ChangeDigest: nil, // change data is small enough, we do not need a hash
+			ChangeData:   depChangeData,
+			ChangeFlags:  depChangeFlags,
 		}
 		depItems = append(depItems, depItemToAdd)
 	}
@@ -195,8 +243,6 @@ func (d *depFileTracker) QueryItem(item string) bool {
 	d.hasher.Write([]byte(item))
 	mainDigest := d.hasher.Sum(nil)
 
-	modTimeBytes := make([]byte, 8) // 8 bytes for the file modification time
-
 	state, err := d.current.QueryItem(mainDigest, true, func(itemState State, itemIdFlags uint8, itemIdData []byte, itemChangeFlags uint8, itemChangeData []byte) State {
 		if itemIdFlags&ItemFlagSourceFile == ItemFlagSourceFile || itemIdFlags&ItemFlagDependency == ItemFlagDependency {
 			// Items that have been gone through a query have been updated with their current state which
@@ -204,19 +250,11 @@ func (d *depFileTracker) QueryItem(item string) bool {
 			// if it is already known to be up to date or out of date.
 			// This is mainly relevant for dependency files, which can be shared between multiple main items.
 			if itemState == StateNone {
-				srcFileInfo, err := os.Stat(string(itemIdData))
-				if err == nil {
-					binary.LittleEndian.PutUint64(modTimeBytes, uint64(srcFileInfo.ModTime().Unix()))
-					if bytes.Equal(modTimeBytes, itemChangeData) {
-						return StateUpToDate
-					}
-					if cDeptrackrVerbose {
-						fmt.Printf("File %q is out of date\n", string(itemIdData))
-					}
-				} else {
-					if cDeptrackrVerbose {
-						fmt.Printf("File %q does not exist\n", string(itemIdData))
-					}
+				if d.current.isFileUnchanged(d.changeMode, string(itemIdData), itemChangeFlags, itemChangeData) {
+					return StateUpToDate
+				}
+				if cDeptrackrVerbose {
+					fmt.Printf("File %q is out of date\n", string(itemIdData))
 				}
 				return StateOutOfDate
 			}
@@ -243,8 +281,6 @@ func (d *depFileTracker) QueryItemWithExtraData(item string, data []byte) bool {
 	d.hasher.Write([]byte(item))
 	mainDigest := d.hasher.Sum(nil)
 
-	modTimeBytes := make([]byte, 8) // 8 bytes for the file modification time
-
 	state, err := d.current.QueryItemExtra(mainDigest, true, func(itemState State, itemIdFlags uint8, itemIdData []byte, itemExtraData []byte, itemChangeFlags uint8, itemChangeData []byte) State {
 		if itemIdFlags&ItemFlagSourceFile == ItemFlagSourceFile || itemIdFlags&ItemFlagDependency == ItemFlagDependency {
 			// Items that have been gone through a query have been updated with their current state which
@@ -252,15 +288,12 @@ func (d *depFileTracker) QueryItemWithExtraData(item string, data []byte) bool {
 			// if it is already known to be up to date or out of date.
 			// This is mainly relevant for dependency files, which can be shared between multiple main items.
 			if itemState == StateNone {
-				// Check if the itemExtraData matches the item extra data we are querying
+				// Check if the itemExtraData matches the item extra data we are querying, e.g. the hash
+				// of the command-line. An item that was added without extra data is out of date.
 				// Note: dependency items do not have extra data (nil or zero size)
-				if len(itemExtraData) == 0 || bytes.Equal(itemExtraData, data) {
-					srcFileInfo, err := os.Stat(string(itemIdData))
-					if err == nil {
-						binary.LittleEndian.PutUint64(modTimeBytes, uint64(srcFileInfo.ModTime().Unix()))
-						if bytes.Equal(modTimeBytes, itemChangeData) {
-							return StateUpToDate
-						}
+				if itemIdFlags == ItemFlagDependency || bytes.Equal(itemExtraData, data) {
+					if d.current.isFileUnchanged(d.changeMode, string(itemIdData), itemChangeFlags, itemChangeData) {
+						return StateUpToDate
 					}
 				}
 				return StateOutOfDate
@@ -278,3 +311,14 @@ func (d *depFileTracker) QueryItemWithExtraData(item string, data []byte) bool {
 
 	return state == StateUpToDate
 }
+
+// forEachFileItem calls fn for every item of the trackr with its dependencies as strings
+func forEachFileItem(t *trackr, fn func(item string, deps []string)) {
+	t.ForEachItem(func(itemIdData []byte, depsIdData [][]byte) {
+		deps := make([]string, 0, len(depsIdData))
+		for _, depIdData := range depsIdData {
+			deps = append(deps, string(depIdData))
+		}
+		fn(string(itemIdData), deps)
+	})
+}
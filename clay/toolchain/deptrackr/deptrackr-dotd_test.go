@@ -1,8 +1,11 @@
 package deptrackr
 
 import (
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadDotdDepTrackr(t *testing.T) {
@@ -25,3 +28,416 @@ func TestLoadDotdDepTrackr(t *testing.T) {
 		t.Fatalf("Expected to add item without error, but got: %v", err)
 	}
 }
+
+func TestParseDotdContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		mainItem string
+		depItems []string
+	}{
+		{
+			name:     "clang, one item per line",
+			content:  "build/main.cpp.o: \\\n  /src/main.cpp \\\n  /src/a.h \\\n  /src/b.h\n",
+			mainItem: "build/main.cpp.o",
+			depItems: []string{"/src/main.cpp", "/src/a.h", "/src/b.h"},
+		},
+		{
+			name:     "gcc, many items per line",
+			content:  "build/main.cpp.o: /src/main.cpp /src/a.h \\\n /src/b.h /src/c.h\n",
+			mainItem: "build/main.cpp.o",
+			depItems: []string{"/src/main.cpp", "/src/a.h", "/src/b.h", "/src/c.h"},
+		},
+		{
+			name:     "escaped spaces and phony targets",
+			content:  "build/main.cpp.o: /src/my\\ dir/main.cpp /src/a.h\r\n\r\n/src/a.h:\r\n",
+			mainItem: "build/main.cpp.o",
+			depItems: []string{"/src/my dir/main.cpp", "/src/a.h"},
+		},
+		{
+			name:     "windows paths",
+			content:  "C:/build/main.cpp.o: C:\\src\\main.cpp \\\n C:\\src\\a.h\n",
+			mainItem: "C:/build/main.cpp.o",
+			depItems: []string{"C:\\src\\main.cpp", "C:\\src\\a.h"},
+		},
+	}
+
+	for _, test := range tests {
+		mainItem, depItems := parseDotdContent(test.content)
+		if mainItem != test.mainItem {
+			t.Errorf("%s: expected main item %q, but got %q", test.name, test.mainItem, mainItem)
+		}
+		if len(depItems) != len(test.depItems) {
+			t.Fatalf("%s: expected %d dependencies, but got %d (%q)", test.name, len(test.depItems), len(depItems), depItems)
+		}
+		for i := range depItems {
+			if depItems[i] != test.depItems[i] {
+				t.Errorf("%s: expected dependency %q, but got %q", test.name, test.depItems[i], depItems[i])
+			}
+		}
+	}
+}
+
+func TestDotdDepTrackrExtraData(t *testing.T) {
+	buildDir := t.TempDir()
+	storageFilepath := filepath.Join(buildDir, "deptrackr")
+
+	objFilepath := filepath.Join(buildDir, "test.cpp.o")
+	hdrFilepath := filepath.Join(buildDir, "test.h")
+	libFilepath := filepath.Join(buildDir, "libtest.a")
+	for _, f := range []string{objFilepath, hdrFilepath, libFilepath} {
+		if err := os.WriteFile(f, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	argsHash := []byte("hash of the command-line")
+	d := LoadDepFileTrackr(storageFilepath)
+	d.AddItemWithExtraData(objFilepath, argsHash, []string{hdrFilepath})
+	d.AddItem(libFilepath, []string{objFilepath})
+	if _, err := d.Save(); err != nil {
+		t.Fatalf("Failed to save deptrackr: %v", err)
+	}
+
+	d = LoadDepFileTrackr(storageFilepath)
+	if !d.QueryItemWithExtraData(objFilepath, argsHash) {
+		t.Errorf("Expected %q to be up-to-date with identical extra data", objFilepath)
+	}
+
+	d = LoadDepFileTrackr(storageFilepath)
+	if d.QueryItemWithExtraData(objFilepath, []byte("hash of another command-line")) {
+		t.Errorf("Expected %q to be out-of-date with different extra data", objFilepath)
+	}
+
+	d = LoadDepFileTrackr(storageFilepath)
+	if d.QueryItemWithExtraData(libFilepath, argsHash) {
+		t.Errorf("Expected %q, added without extra data, to be out-of-date", libFilepath)
+	}
+}
+
+func TestDotdDepTrackrContentMode(t *testing.T) {
+	buildDir := t.TempDir()
+	storageFilepath := filepath.Join(buildDir, "deptrackr")
+
+	srcFilepath := filepath.Join(buildDir, "test.cpp")
+	hdrFilepath := filepath.Join(buildDir, "test.h")
+	for _, f := range []string{srcFilepath, hdrFilepath} {
+		if err := os.WriteFile(f, []byte("// "+f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeContent)
+	d.AddItem(srcFilepath, []string{hdrFilepath})
+	if _, err := d.Save(); err != nil {
+		t.Fatalf("Failed to save deptrackr: %v", err)
+	}
+
+	// Touching a file without changing its content keeps it up-to-date
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(hdrFilepath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	d = LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeContent)
+	if !d.QueryItem(srcFilepath) {
+		t.Errorf("Expected %q to be up-to-date after touching %q", srcFilepath, hdrFilepath)
+	}
+
+	// A tracker in another mode considers the items out-of-date
+	d = LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeModTime)
+	if d.QueryItem(srcFilepath) {
+		t.Errorf("Expected %q to be out-of-date when the change mode differs", srcFilepath)
+	}
+
+	// Changing the content (with the same size) makes it out-of-date
+	if err := os.WriteFile(hdrFilepath, []byte("// "+strings.ToUpper(hdrFilepath)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(hdrFilepath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	d = LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeContent)
+	if d.QueryItem(srcFilepath) {
+		t.Errorf("Expected %q to be out-of-date after changing the content of %q", srcFilepath, hdrFilepath)
+	}
+}
+
+func TestDotdDepTrackrContentModeRefresh(t *testing.T) {
+	buildDir := t.TempDir()
+	storageFilepath := filepath.Join(buildDir, "deptrackr")
+
+	srcFilepath := filepath.Join(buildDir, "test.cpp")
+	hdrFilepath := filepath.Join(buildDir, "test.h")
+	for _, f := range []string{srcFilepath, hdrFilepath} {
+		if err := os.WriteFile(f, []byte("// "+f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeContent)
+	d.AddItem(srcFilepath, []string{hdrFilepath})
+	if _, err := d.Save(); err != nil {
+		t.Fatalf("Failed to save deptrackr: %v", err)
+	}
+
+	// Touching a file without changing its content refreshes its modification time, an
+	// up-to-date item is then saved with the refreshed change data
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(hdrFilepath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	d = LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeContent)
+	if !d.QueryItem(srcFilepath) {
+		t.Errorf("Expected %q to be up-to-date after touching %q", srcFilepath, hdrFilepath)
+	}
+	d.CopyItem(srcFilepath)
+	if saved, err := d.Save(); err != nil || saved != 1 {
+		t.Fatalf("Expected the refreshed deptrackr to be saved, got %d (%v)", saved, err)
+	}
+
+	// The next query does not have to compute the digest again
+	d = LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeContent)
+	if !d.QueryItem(srcFilepath) {
+		t.Errorf("Expected %q to be up-to-date", srcFilepath)
+	}
+	if d.(*depFileTracker).current.refreshed {
+		t.Errorf("Expected the modification time of %q to be refreshed", hdrFilepath)
+	}
+	d.CopyItem(srcFilepath)
+	if saved, err := d.Save(); err != nil || saved != 0 {
+		t.Errorf("Expected an up-to-date deptrackr not to be saved, got %d (%v)", saved, err)
+	}
+}
+
+func TestContentModeDigestReuse(t *testing.T) {
+	hdrFilepath := filepath.Join(t.TempDir(), "test.h")
+	if err := os.WriteFile(hdrFilepath, []byte("// one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Stat(hdrFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A header that many objects depend on is only read once, the file is changed behind the
+	// back of the trackr with the same modification time and size to see that it is not read
+	// again
+	d := &trackr{}
+	_, first := d.fileChangeData(ChangeModeContent, hdrFilepath)
+	verified := &trackr{}
+	if !verified.isFileUnchanged(ChangeModeContent, hdrFilepath, ChangeFlagContent, first) {
+		t.Fatalf("Expected %q to be unchanged", hdrFilepath)
+	}
+	if err := os.WriteFile(hdrFilepath, []byte("// two"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(hdrFilepath, fileInfo.ModTime(), fileInfo.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if _, second := d.fileChangeData(ChangeModeContent, hdrFilepath); string(second) != string(first) {
+		t.Errorf("Expected the digest of %q to be reused", hdrFilepath)
+	}
+	if _, second := verified.fileChangeData(ChangeModeContent, hdrFilepath); string(second) != string(first) {
+		t.Errorf("Expected the digest of the verified %q to be reused", hdrFilepath)
+	}
+
+	// A different modification time computes the digest again
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(hdrFilepath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, third := d.fileChangeData(ChangeModeContent, hdrFilepath); string(third[16:]) == string(first[16:]) {
+		t.Errorf("Expected the digest of the changed %q to be computed again", hdrFilepath)
+	}
+}
+
+func TestDotdDepTrackrForEachItem(t *testing.T) {
+	buildDir := t.TempDir()
+	storageFilepath := filepath.Join(buildDir, "deptrackr")
+
+	srcFilepath := filepath.Join(buildDir, "test.cpp")
+	hdrFilepath := filepath.Join(buildDir, "test.h")
+	for _, f := range []string{srcFilepath, hdrFilepath} {
+		if err := os.WriteFile(f, []byte("// "+f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := LoadDepFileTrackr(storageFilepath)
+	d.AddItem(srcFilepath, []string{hdrFilepath})
+	if _, err := d.Save(); err != nil {
+		t.Fatalf("Failed to save deptrackr: %v", err)
+	}
+
+	items := map[string][]string{}
+	LoadDepFileTrackr(storageFilepath).ForEachItem(func(item string, deps []string) {
+		items[item] = deps
+	})
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %v", items)
+	}
+	if deps := items[srcFilepath]; len(deps) != 1 || deps[0] != hdrFilepath {
+		t.Errorf("Expected %q to depend on %q, got %v", srcFilepath, hdrFilepath, deps)
+	}
+}
+
+func TestDotdDepTrackrExplainItem(t *testing.T) {
+	buildDir := t.TempDir()
+	storageFilepath := filepath.Join(buildDir, "deptrackr")
+
+	objFilepath := filepath.Join(buildDir, "test.cpp.o")
+	hdrFilepath := filepath.Join(buildDir, "test.h")
+	otherFilepath := filepath.Join(buildDir, "other.h")
+	for _, f := range []string{objFilepath, hdrFilepath, otherFilepath} {
+		if err := os.WriteFile(f, []byte("// "+f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	argsHash := []byte("hash of the command-line")
+	if explanations := LoadDepFileTrackr(storageFilepath).ExplainItem(objFilepath, argsHash); len(explanations) != 1 || explanations[0].Reason != ReasonNewItem {
+		t.Errorf("Expected a new item without a database, got %v", explanations)
+	}
+
+	d := LoadDepFileTrackr(storageFilepath)
+	d.AddItemWithExtraData(objFilepath, argsHash, []string{hdrFilepath, otherFilepath})
+	if _, err := d.Save(); err != nil {
+		t.Fatalf("Failed to save deptrackr: %v", err)
+	}
+
+	d = LoadDepFileTrackr(storageFilepath)
+	if explanations := d.ExplainItem(objFilepath, argsHash); len(explanations) != 0 {
+		t.Errorf("Expected %q to be up-to-date, got %v", objFilepath, explanations)
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(hdrFilepath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(otherFilepath); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Explanation{
+		{Reason: ReasonChangedArgs, File: objFilepath},
+		{Reason: ReasonChangedFile, File: hdrFilepath},
+		{Reason: ReasonMissingFile, File: otherFilepath},
+	}
+	explanations := d.ExplainItem(objFilepath, []byte("hash of another command-line"))
+	if len(explanations) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, explanations)
+	}
+	for i := range expected {
+		if explanations[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], explanations[i])
+		}
+	}
+
+	if err := os.WriteFile(storageFilepath+".main.db", []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if explanations := LoadDepFileTrackr(storageFilepath).ExplainItem(objFilepath, argsHash); len(explanations) != 1 || explanations[0].Reason != ReasonCorruptDatabase {
+		t.Errorf("Expected a corrupt database, got %v", explanations)
+	}
+}
+
+func TestDotdDepTrackrMembers(t *testing.T) {
+	buildDir := t.TempDir()
+	storageFilepath := filepath.Join(buildDir, "deptrackr")
+
+	libFilepath := filepath.Join(buildDir, "libtest.a")
+	objFilepaths := []string{filepath.Join(buildDir, "a.cpp.o"), filepath.Join(buildDir, "b.cpp.o"), filepath.Join(buildDir, "c.cpp.o")}
+	for _, f := range append([]string{libFilepath}, objFilepaths...) {
+		if err := os.WriteFile(f, []byte("// "+f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	argsHash := []byte("hash of the command-line")
+	members := objFilepaths[:2]
+	d := LoadDepFileTrackr(storageFilepath)
+	d.AddItemWithExtraData(libFilepath, argsHash, members)
+	if _, err := d.Save(); err != nil {
+		t.Fatalf("Failed to save deptrackr: %v", err)
+	}
+
+	d = LoadDepFileTrackr(storageFilepath)
+	if !d.QueryItemWithMembers(libFilepath, argsHash, members) {
+		t.Errorf("Expected %q to be up-to-date with the same members, got %v", libFilepath, d.ExplainItemWithMembers(libFilepath, argsHash, members))
+	}
+
+	tests := []struct {
+		members  []string
+		expected []Explanation
+	}{
+		{objFilepaths, []Explanation{{Reason: ReasonAddedMember, File: objFilepaths[2]}}},
+		{objFilepaths[:1], []Explanation{{Reason: ReasonRemovedMember, File: objFilepaths[1]}}},
+		{[]string{objFilepaths[1], objFilepaths[0]}, []Explanation{{Reason: ReasonChangedOrder, File: libFilepath}}},
+	}
+	for _, test := range tests {
+		d = LoadDepFileTrackr(storageFilepath)
+		if d.QueryItemWithMembers(libFilepath, argsHash, test.members) {
+			t.Errorf("Expected %q to be out-of-date with members %v", libFilepath, test.members)
+		}
+		explanations := d.ExplainItemWithMembers(libFilepath, argsHash, test.members)
+		if len(explanations) != len(test.expected) || explanations[0] != test.expected[0] {
+			t.Errorf("Expected %v, got %v", test.expected, explanations)
+		}
+	}
+}
+
+func TestDotdDepTrackrMembersEarlyCutoff(t *testing.T) {
+	buildDir := t.TempDir()
+	storageFilepath := filepath.Join(buildDir, "deptrackr")
+
+	libFilepath := filepath.Join(buildDir, "libtest.a")
+	objFilepaths := []string{filepath.Join(buildDir, "a.cpp.o"), filepath.Join(buildDir, "b.cpp.o")}
+	for _, f := range append([]string{libFilepath}, objFilepaths...) {
+		if err := os.WriteFile(f, []byte("// "+f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The members are tracked by their content, also in the modification time mode
+	argsHash := []byte("hash of the command-line")
+	d := LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeModTime)
+	d.AddItemWithMembers(libFilepath, argsHash, objFilepaths)
+	if _, err := d.Save(); err != nil {
+		t.Fatalf("Failed to save deptrackr: %v", err)
+	}
+
+	// Recompiling an object file into the same bytes keeps the archive up-to-date
+	later := time.Now().Add(time.Hour)
+	if err := os.WriteFile(objFilepaths[0], []byte("// "+objFilepaths[0]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(objFilepaths[0], later, later); err != nil {
+		t.Fatal(err)
+	}
+	d = LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeModTime)
+	if !d.QueryItemWithMembers(libFilepath, argsHash, objFilepaths) {
+		t.Errorf("Expected %q to be up-to-date with an unchanged member, got %v", libFilepath, d.ExplainItemWithMembers(libFilepath, argsHash, objFilepaths))
+	}
+
+	// Tracking the item again records the new modification time and keeps the digest
+	d.AddItemWithMembers(libFilepath, argsHash, objFilepaths)
+	if _, err := d.Save(); err != nil {
+		t.Fatalf("Failed to save deptrackr: %v", err)
+	}
+	d = LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeModTime)
+	if !d.QueryItemWithMembers(libFilepath, argsHash, objFilepaths) {
+		t.Errorf("Expected %q to be up-to-date after tracking it again", libFilepath)
+	}
+
+	// An object file with other content makes the archive out-of-date
+	if err := os.WriteFile(objFilepaths[1], []byte("// "+strings.ToUpper(objFilepaths[1])), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d = LoadDepFileTrackrWithChangeMode(storageFilepath, ChangeModeModTime)
+	if d.QueryItemWithMembers(libFilepath, argsHash, objFilepaths) {
+		t.Errorf("Expected %q to be out-of-date after changing the content of %q", libFilepath, objFilepaths[1])
+	}
+	expected := Explanation{Reason: ReasonChangedFile, File: objFilepaths[1]}
+	if explanations := d.ExplainItemWithMembers(libFilepath, argsHash, objFilepaths); len(explanations) != 1 || explanations[0] != expected {
+		t.Errorf("Expected %v, got %v", []Explanation{expected}, explanations)
+	}
+}
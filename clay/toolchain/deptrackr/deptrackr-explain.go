@@ -0,0 +1,89 @@
+package deptrackr
+
+import (
+	"bytes"
+	"hash"
+	"os"
+)
+
+// Reason is why an item is out of date, see ExplainItem
+type Reason string
+
+const (
+	ReasonNewItem         Reason = "new"         // The item is not in the database
+	ReasonMissingFile     Reason = "missing"     // The item or a dependency does not exist
+	ReasonChangedFile     Reason = "changed"     // The item or a dependency has been modified
+	ReasonChangedArgs     Reason = "args"        // The extra data (e.g. the hash of the command-line) differs
+	ReasonChangedMode     Reason = "change-mode" // The item was stored with another change mode (e.g. --content-hash)
+	ReasonCorruptDatabase Reason = "corrupt-db"  // The database exists but could not be loaded
+	ReasonAddedMember     Reason = "added"       // A member was added to the item, e.g. an object file to an archive
+	ReasonRemovedMember   Reason = "removed"     // A member was removed from the item
+	ReasonChangedOrder    Reason = "order"       // The members of the item are the same but in another order
+)
+
+// Explanation is a cause of an item being out of date, File is the item itself or the
+// dependency that caused it.
+type Explanation struct {
+	Reason Reason `json:"reason"`
+	File   string `json:"file,omitempty"`
+}
+
+// fileChangeReason returns why a file does not match the change data it was stored with,
+// or an empty reason when the file is unchanged.
+func fileChangeReason(mode ChangeMode, filepath string, changeFlags uint8, changeData []byte) Reason {
+	if changeFlags != changeFlagsOfMode(mode) {
+		return ReasonChangedMode
+	}
+	if _, err := os.Stat(filepath); err != nil {
+		return ReasonMissingFile
+	}
+	if isFileUnchanged(mode, filepath, changeFlags, changeData) {
+		return ""
+	}
+	return ReasonChangedFile
+}
+
+// explainFileItem returns all the causes of a file item being out of date, the item and
+// every dependency are verified. When data is not nil the extra data of the item has to
+// match data. It does not change the state of the items, so it can be used after a query.
+func explainFileItem(d *trackr, hasher hash.Hash, mode ChangeMode, item string, data []byte) []Explanation {
+	if d.corrupt {
+		return []Explanation{{Reason: ReasonCorruptDatabase, File: d.storageFilepath}}
+	}
+
+	hasher.Reset()
+	hasher.Write([]byte(item))
+	itemIndex := d.DoesItemExistInDb(hasher.Sum(nil))
+	if itemIndex == NilIndex {
+		return []Explanation{{Reason: ReasonNewItem, File: item}}
+	}
+
+	explanations := []Explanation{}
+	if data != nil {
+		extraDataOffset := d.ItemExtraDataOffset[itemIndex]
+		extraData := d.Data[extraDataOffset : extraDataOffset+int32(d.ItemExtraDataSize[itemIndex])]
+		if !bytes.Equal(extraData, data) {
+			explanations = append(explanations, Explanation{Reason: ReasonChangedArgs, File: item})
+		}
+	}
+
+	verify := func(index int32) {
+		if d.ItemIdFlags[index]&(ItemFlagSourceFile|ItemFlagDependency|ItemFlagMember) == 0 {
+			return
+		}
+		changeDataOffset := d.ItemChangeDataOffset[index]
+		changeData := d.Data[changeDataOffset : changeDataOffset+int32(d.ItemChangeDataSize[index])]
+		filepath := string(d.itemIdData(index))
+		if reason := fileChangeReason(memberChangeMode(mode, d.ItemIdFlags[index]), filepath, d.ItemChangeFlags[index], changeData); reason != "" {
+			explanations = append(explanations, Explanation{Reason: reason, File: filepath})
+		}
+	}
+
+	verify(itemIndex)
+	depStart := d.ItemDepsStart[itemIndex]
+	depEnd := depStart + d.ItemDepsCount[itemIndex]
+	for _, depItemIndex := range d.Deps[depStart:depEnd] {
+		verify(depItemIndex)
+	}
+	return explanations
+}
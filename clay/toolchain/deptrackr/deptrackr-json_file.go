@@ -3,12 +3,10 @@ package deptrackr
 import (
 	"bytes"
 	"crypto/sha1"
-	"encoding/binary"
 	"fmt"
 	"hash"
 	"os"
 	"strings"
-	"time"
 )
 
 // The depFileTracker is using the output of compilers like gcc,
@@ -18,21 +16,28 @@ type jsonFileTracker struct {
 	currentState State
 	future       *trackr
 	hasher       hash.Hash
+	changeMode   ChangeMode
 }
 
+// LoadJsonFileTrackr loads the file tracker using the default change mode, see SetDefaultChangeMode.
 func LoadJsonFileTrackr(storageFilepath string) FileTrackr {
-	current := loadTrackr(storageFilepath, "file deptrackr, v1.0.4")
+	return LoadJsonFileTrackrWithChangeMode(storageFilepath, DefaultChangeMode())
+}
+
+func LoadJsonFileTrackrWithChangeMode(storageFilepath string, changeMode ChangeMode) FileTrackr {
+	current := loadTrackr(storageFilepath, "file deptrackr, v1.0.5")
 	tracker := current.newTrackr()
 	return &jsonFileTracker{
 		current:      current,
 		currentState: StateUpToDate, // Start with an up-to-date state
 		future:       tracker,
 		hasher:       sha1.New(),
+		changeMode:   changeMode,
 	}
 }
 
 func (d *jsonFileTracker) Save() (int, error) {
-	if d.currentState == StateUpToDate {
+	if d.currentState == StateUpToDate && !d.current.refreshed {
 		// If the current state is up to date, we do not need to save anything
 		return 0, nil
 	}
@@ -151,6 +156,14 @@ func (d *jsonFileTracker) ParseDependencyFile(srcFilepath, objFilepath, depFilep
 	return mainItem, depItems, nil
 }
 
+func (d *jsonFileTracker) ExplainItem(item string, data []byte) []Explanation {
+	return explainFileItem(d.current, d.hasher, d.changeMode, item, data)
+}
+
+func (d *jsonFileTracker) ForEachItem(fn func(item string, deps []string)) {
+	forEachFileItem(d.current, fn)
+}
+
 func (d *jsonFileTracker) CopyItem(item string) {
 	d.hasher.Reset()
 	d.hasher.Write([]byte(item))
@@ -166,14 +179,8 @@ func (d *jsonFileTracker) addItem(item string, extra []byte, deps []string) erro
 	d.hasher.Write([]byte(item))
 	mainHash := d.hasher.Sum(nil)
 
-	// For the 'change', we want the file modification time and hash it
-	modTimeBytes := make([]byte, 8)
-	fileInfo, err := os.Stat(item)
-	if err != nil {
-		binary.LittleEndian.PutUint64(modTimeBytes, uint64(time.Now().Unix())) // If the file does not exist, use the current time
-	} else {
-		binary.LittleEndian.PutUint64(modTimeBytes, uint64(fileInfo.ModTime().Unix()))
-	}
+	// For the 'change', we want the file modification time (and in content mode the size and digest)
+	changeFlags, changeData := d.current.fileChangeData(d.changeMode, item)
 
 	// We are adding a new item, so the trackr is marked as out of date
 	d.currentState = StateOutOfDate
@@ -182,9 +189,9 @@ func (d *jsonFileTracker) addItem(item string, extra []byte, deps []string) erro
 		IdData:       []byte(item),
 		IdDigest:     mainHash,
 		IdFlags:      ItemFlagSourceFile,
-		ChangeData:   bytes.Clone(modTimeBytes),
-		ChangeDigest: nil, // mod-time is small enough, we do not need a hash
-		ChangeFlags:  ChangeFlagModTime,
+		ChangeData:   changeData,
+		ChangeDigest: nil, // change data is small enough, we do not need a hash
+		ChangeFlags:  changeFlags,
 	}
 
 	var depItems []ItemToAdd
@@ -196,21 +203,16 @@ func (d *jsonFileTracker) addItem(item string, extra []byte, deps []string) erro
 		d.hasher.Write([]byte(depFilepath))
 		depDigest := d.hasher.Sum(nil)
 
-		// For the 'change', we want the file modification time and hash it
-		fileInfo, err = os.Stat(depFilepath)
-		if err != nil {
-			binary.LittleEndian.PutUint64(modTimeBytes, uint64(time.Now().Unix()))
-		} else {
-			binary.LittleEndian.PutUint64(modTimeBytes, uint64(fileInfo.ModTime().Unix()))
-		}
+		// For the 'change', we want the file modification time (and in content mode the size and digest)
+		depChangeFlags, depChangeData := d.current.fileChangeData(d.changeMode, depFilepath)
 
 		depItemToAdd := ItemToAdd{
 			IdDigest:     depDigest,
 			IdData:       []byte(depFilepath),
 			IdFlags:      ItemFlagDependency,
-			ChangeDigest: nil, // mod-time is small enough, we do not need a hash
-			ChangeData:   bytes.Clone(modTimeBytes),
-			ChangeFlags:  ChangeFlagModTime,
+			ChangeDigest: nil, // change data is small enough, we do not need a hash
+			ChangeData:   depChangeData,
+			ChangeFlags:  depChangeFlags,
 		}
 		depItems = append(depItems, depItemToAdd)
 	}
@@ -233,8 +235,6 @@ func (d *jsonFileTracker) QueryItem(item string) bool {
 	d.hasher.Write([]byte(item))
 	mainDigest := d.hasher.Sum(nil)
 
-	modTimeBytes := make([]byte, 8) // 8 bytes for the file modification time
-
 	state, err := d.current.QueryItem(mainDigest, true, func(itemState State, itemIdFlags uint8, itemIdData []byte, itemChangeFlags uint8, itemChangeData []byte) State {
 		if itemIdFlags&ItemFlagSourceFile == ItemFlagSourceFile || itemIdFlags&ItemFlagDependency == ItemFlagDependency {
 			// Items that have been gone through a query have been updated with their current state which
@@ -242,12 +242,11 @@ func (d *jsonFileTracker) QueryItem(item string) bool {
 			// if it is already known to be up to date or out of date.
 			// This is mainly relevant for dependency files, which can be shared between multiple main items.
 			if itemState == StateNone {
-				srcFileInfo, err := os.Stat(string(itemIdData))
-				if err == nil {
-					binary.LittleEndian.PutUint64(modTimeBytes, uint64(srcFileInfo.ModTime().Unix()))
-					if bytes.Equal(modTimeBytes, itemChangeData) {
-						return StateUpToDate
-					}
+				if d.current.isFileUnchanged(d.changeMode, string(itemIdData), itemChangeFlags, itemChangeData) {
+					return StateUpToDate
+				}
+				if cDeptrackrVerbose {
+					fmt.Printf("File %q is out of date\n", string(itemIdData))
 				}
 				return StateOutOfDate
 			}
@@ -274,8 +273,6 @@ func (d *jsonFileTracker) QueryItemWithExtraData(item string, data []byte) bool
 	d.hasher.Write([]byte(item))
 	mainDigest := d.hasher.Sum(nil)
 
-	modTimeBytes := make([]byte, 8) // 8 bytes for the file modification time
-
 	state, err := d.current.QueryItemExtra(mainDigest, true, func(itemState State, itemIdFlags uint8, itemIdData []byte, itemExtraData []byte, itemChangeFlags uint8, itemChangeData []byte) State {
 		if itemIdFlags&ItemFlagSourceFile == ItemFlagSourceFile || itemIdFlags&ItemFlagDependency == ItemFlagDependency {
 			// Items that have been gone through a query have been updated with their current state which
@@ -283,15 +280,12 @@ func (d *jsonFileTracker) QueryItemWithExtraData(item string, data []byte) bool
 			// if it is already known to be up to date or out of date.
 			// This is mainly relevant for dependency files, which can be shared between multiple main items.
 			if itemState == StateNone {
-				// Check if the itemExtraData matches the item extra data we are querying
+				// Check if the itemExtraData matches the item extra data we are querying, e.g. the hash
+				// of the command-line. An item that was added without extra data is out of date.
 				// Note: dependency items do not have extra data (nil or zero size)
-				if len(itemExtraData) == 0 || bytes.Equal(itemExtraData, data) {
-					srcFileInfo, err := os.Stat(string(itemIdData))
-					if err == nil {
-						binary.LittleEndian.PutUint64(modTimeBytes, uint64(srcFileInfo.ModTime().Unix()))
-						if bytes.Equal(modTimeBytes, itemChangeData) {
-							return StateUpToDate
-						}
+				if itemIdFlags == ItemFlagDependency || bytes.Equal(itemExtraData, data) {
+					if d.current.isFileUnchanged(d.changeMode, string(itemIdData), itemChangeFlags, itemChangeData) {
+						return StateUpToDate
 					}
 				}
 				return StateOutOfDate
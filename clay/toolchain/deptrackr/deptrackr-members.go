@@ -0,0 +1,212 @@
+package deptrackr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"os"
+	"slices"
+)
+
+// Members
+// An archive or an executable is made out of an ordered list of members, the object files
+// and libraries, which are added as its dependencies. Verifying the members is not enough
+// to know that such an item is up to date, a member may have been added (a new source file)
+// or removed (a deleted source file) or the order may have changed (the link order). Since
+// the dependencies are stored in the order they were added, the database knows the exact
+// member list that the item was last built from.
+//
+// Early cutoff
+// A member is stored with the digest of its content (ChangeFlagContent), whatever the change
+// mode of the trackr. An object file that is recompiled into the same bytes, e.g. after a
+// comment was edited in a header, does not make its archive out of date, and an archive that
+// is recreated with the same content does not relink the executables that use it.
+
+// itemDeps returns the dependencies of an item in the order they were added, and false
+// when the item is not in the database.
+func itemDeps(d *trackr, hasher hash.Hash, item string) ([]string, bool) {
+	hasher.Reset()
+	hasher.Write([]byte(item))
+	itemIndex := d.DoesItemExistInDb(hasher.Sum(nil))
+	if itemIndex == NilIndex {
+		return nil, false
+	}
+	depStart := d.ItemDepsStart[itemIndex]
+	depEnd := depStart + d.ItemDepsCount[itemIndex]
+	deps := make([]string, 0, depEnd-depStart)
+	for _, depItemIndex := range d.Deps[depStart:depEnd] {
+		deps = append(deps, string(d.itemIdData(depItemIndex)))
+	}
+	return deps, true
+}
+
+// hasMembers returns true when the item is in the database with exactly the members, in
+// the same order.
+func hasMembers(d *trackr, hasher hash.Hash, item string, members []string) bool {
+	deps, ok := itemDeps(d, hasher, item)
+	return ok && slices.Equal(deps, members)
+}
+
+// memberChangeMode returns the change mode an item is verified with, a member is always
+// verified by its content.
+func memberChangeMode(mode ChangeMode, itemIdFlags uint8) ChangeMode {
+	if itemIdFlags == ItemFlagMember {
+		return ChangeModeContent
+	}
+	return mode
+}
+
+// memberDigest returns the Id digest of a member, unique and not identical to the digest
+// of the same file as a main item or as a dependency.
+func memberDigest(hasher hash.Hash, member string) []byte {
+	hasher.Reset()
+	hasher.Write([]byte{'m', 'e', 'm'})
+	hasher.Write([]byte(member))
+	return hasher.Sum(nil)
+}
+
+// memberChangeData returns the change data of a member, the digest it was stored with in the
+// current database is reused when its modification time and size are unchanged. Only the
+// members that have been rebuilt are read to compute their digest.
+func memberChangeData(current *trackr, digest []byte, member string) []byte {
+	if memberIndex := current.DoesItemExistInDb(digest); memberIndex != NilIndex && current.ItemChangeFlags[memberIndex] == ChangeFlagContent {
+		changeDataOffset := current.ItemChangeDataOffset[memberIndex]
+		changeData := current.Data[changeDataOffset : changeDataOffset+int32(current.ItemChangeDataSize[memberIndex])]
+		if fileInfo, err := os.Stat(member); err == nil && len(changeData) == contentChangeDataSize &&
+			binary.LittleEndian.Uint64(changeData[0:]) == uint64(fileInfo.ModTime().UnixNano()) &&
+			binary.LittleEndian.Uint64(changeData[8:]) == uint64(fileInfo.Size()) {
+			return slices.Clone(changeData)
+		}
+	}
+	_, changeData := current.fileChangeData(ChangeModeContent, member)
+	return changeData
+}
+
+// addFileItemWithMembers adds an item with its members, in order, to the future database
+func addFileItemWithMembers(current *trackr, future *trackr, hasher hash.Hash, mode ChangeMode, item string, data []byte, members []string) {
+	hasher.Reset()
+	hasher.Write([]byte(item))
+	itemDigest := hasher.Sum(nil)
+
+	changeFlags, changeData := current.fileChangeData(mode, item)
+	itemToAdd := ItemToAdd{
+		IdData:      []byte(item),
+		IdDigest:    itemDigest,
+		IdFlags:     ItemFlagSourceFile,
+		ChangeData:  changeData,
+		ChangeFlags: changeFlags,
+	}
+
+	memberItems := make([]ItemToAdd, 0, len(members))
+	for _, member := range members {
+		digest := memberDigest(hasher, member)
+		memberItems = append(memberItems, ItemToAdd{
+			IdDigest:    digest,
+			IdData:      []byte(member),
+			IdFlags:     ItemFlagMember,
+			ChangeData:  memberChangeData(current, digest, member),
+			ChangeFlags: ChangeFlagContent,
+		})
+	}
+
+	if len(data) == 0 {
+		future.AddItem(itemToAdd, memberItems)
+	} else {
+		future.AddItemWithExtraData(itemToAdd, data, memberItems)
+	}
+}
+
+// queryFileItemWithMembers returns true when the item, with the extra data, and its members
+// are unchanged. The members are verified by their content, the item by the change mode.
+func queryFileItemWithMembers(d *trackr, hasher hash.Hash, mode ChangeMode, item string, data []byte, members []string) bool {
+	hasher.Reset()
+	hasher.Write([]byte(item))
+	itemDigest := hasher.Sum(nil)
+
+	state, err := d.QueryItemExtra(itemDigest, true, func(itemState State, itemIdFlags uint8, itemIdData []byte, itemExtraData []byte, itemChangeFlags uint8, itemChangeData []byte) State {
+		if itemState != StateNone {
+			return itemState
+		}
+		switch itemIdFlags {
+		case ItemFlagSourceFile:
+			if !bytes.Equal(itemExtraData, data) {
+				return StateOutOfDate
+			}
+		case ItemFlagDependency, ItemFlagMember:
+			// Note: an item that was added before members were tracked by their content has
+			// its members as dependencies, these are verified by the change mode.
+		default:
+			return StateUpToDate
+		}
+		if d.isFileUnchanged(memberChangeMode(mode, itemIdFlags), string(itemIdData), itemChangeFlags, itemChangeData) {
+			return StateUpToDate
+		}
+		return StateOutOfDate
+	})
+
+	if err != nil {
+		fmt.Println("Error querying item:", err)
+	}
+
+	return state == StateUpToDate && hasMembers(d, hasher, item, members)
+}
+
+// explainMembers returns the members that were added and removed, or when the members are
+// the same but in another order a single explanation for the item.
+func explainMembers(item string, stored []string, members []string) []Explanation {
+	explanations := []Explanation{}
+	for _, member := range members {
+		if !slices.Contains(stored, member) {
+			explanations = append(explanations, Explanation{Reason: ReasonAddedMember, File: member})
+		}
+	}
+	for _, member := range stored {
+		if !slices.Contains(members, member) {
+			explanations = append(explanations, Explanation{Reason: ReasonRemovedMember, File: member})
+		}
+	}
+	if len(explanations) == 0 && !slices.Equal(stored, members) {
+		explanations = append(explanations, Explanation{Reason: ReasonChangedOrder, File: item})
+	}
+	return explanations
+}
+
+// explainFileItemWithMembers is explainFileItem for an item that is made out of members
+func explainFileItemWithMembers(d *trackr, hasher hash.Hash, mode ChangeMode, item string, data []byte, members []string) []Explanation {
+	explanations := explainFileItem(d, hasher, mode, item, data)
+	if stored, ok := itemDeps(d, hasher, item); ok && !d.corrupt {
+		explanations = append(explanations, explainMembers(item, stored, members)...)
+	}
+	return explanations
+}
+
+func (d *depFileTracker) AddItemWithMembers(item string, data []byte, members []string) error {
+	// We are adding a new item, so the trackr is marked as out of date
+	d.currentState = StateOutOfDate
+	addFileItemWithMembers(d.current, d.future, d.hasher, d.changeMode, item, data, members)
+	return nil
+}
+
+func (d *depFileTracker) QueryItemWithMembers(item string, data []byte, members []string) bool {
+	return queryFileItemWithMembers(d.current, d.hasher, d.changeMode, item, data, members)
+}
+
+func (d *depFileTracker) ExplainItemWithMembers(item string, data []byte, members []string) []Explanation {
+	return explainFileItemWithMembers(d.current, d.hasher, d.changeMode, item, data, members)
+}
+
+func (d *jsonFileTracker) AddItemWithMembers(item string, data []byte, members []string) error {
+	// We are adding a new item, so the trackr is marked as out of date
+	d.currentState = StateOutOfDate
+	addFileItemWithMembers(d.current, d.future, d.hasher, d.changeMode, item, data, members)
+	return nil
+}
+
+func (d *jsonFileTracker) QueryItemWithMembers(item string, data []byte, members []string) bool {
+	return queryFileItemWithMembers(d.current, d.hasher, d.changeMode, item, data, members)
+}
+
+func (d *jsonFileTracker) ExplainItemWithMembers(item string, data []byte, members []string) []Explanation {
+	return explainFileItemWithMembers(d.current, d.hasher, d.changeMode, item, data, members)
+}
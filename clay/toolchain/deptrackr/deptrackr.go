@@ -60,6 +60,9 @@ func (s State) String() string {
 type trackr struct {
 	hasher               hash.Hash
 	readonly             bool                // If true, the database is read-only, we cannot add items
+	corrupt              bool                // If true, the database exists but could not be loaded
+	refreshed            bool                // If true, the change data of a touched but unchanged file was refreshed, see (*trackr).isFileUnchanged
+	digests              contentDigests      // The content digests of the files verified or hashed in this session, not saved to disk
 	scratchBuffer        *corepkg.BinaryBlob // A temporary byte buffer for hashing and other operations, not saved to disk
 	storageFilepath      string              // Filepath where we store the database file
 	signature            string              // max 32 characters signature, e.g. ".d deptracker v1.0.0"
@@ -527,6 +530,7 @@ func newDefaultTrackerOnError(storageFilepath string, signature string) *trackr
 	}
 	d := constructTrackr(storageFilepath, signature, 8, 8)
 	d.readonly = true // Set the trackr to read-only mode
+	d.corrupt = true
 	return d
 }
 
@@ -551,6 +555,9 @@ func loadTrackr(storageFilepath string, signature string) *trackr {
 
 	// On any error, we just create a new database
 	if err != nil {
+		if os.IsNotExist(err) {
+			return newDefaultTracker(storageFilepath, signature) // No database exists on disk (yet)
+		}
 		return newDefaultTrackerOnError(storageFilepath, signature)
 	}
 
@@ -558,7 +565,7 @@ func loadTrackr(storageFilepath string, signature string) *trackr {
 	dbFile, err := os.Open(mainDbFilepath)
 	if err != nil {
 		// No database exists on disk, so we just create an empty one
-		d := newDefaultTrackerOnError(storageFilepath, signature)
+		d := newDefaultTracker(storageFilepath, signature)
 		return d
 	}
 	defer dbFile.Close()
@@ -710,11 +717,13 @@ const (
 	ItemFlagSourceFile = 1
 	ItemFlagDependency = 2
 	ItemFlagString     = 3
+	ItemFlagMember     = 4 // A member of an archive or executable, its change data is always ChangeFlagContent
 )
 
 const (
 	ChangeFlagModTime = 1
 	ChangeFlagString  = 2
+	ChangeFlagContent = 3 // mod-time (ns), size and digest of the content, see ChangeModeContent
 )
 
 type ItemToAdd struct {
@@ -1004,6 +1013,32 @@ func (d *trackr) QueryItemExtra(itemHash []byte, verifyAll bool, verifyCb Verify
 	})
 }
 
+// ---------------------------------------------------------------------------------------------------------
+// ---------------------------------------------------------------------------------------------------------
+// ForEachItem calls the callback for every main item in the database (not the items that only exist as a
+// dependency) with the Id data of the item and the Id data of its dependencies.
+
+func (d *trackr) ForEachItem(cb func(itemIdData []byte, depsIdData [][]byte)) {
+	for itemIndex := range d.ItemIdFlags {
+		if d.ItemIdFlags[itemIndex] == ItemFlagDependency {
+			continue
+		}
+		depStart := d.ItemDepsStart[itemIndex]
+		depEnd := depStart + d.ItemDepsCount[itemIndex]
+		depsIdData := make([][]byte, 0, depEnd-depStart)
+		for _, depItemIndex := range d.Deps[depStart:depEnd] {
+			depsIdData = append(depsIdData, d.itemIdData(depItemIndex))
+		}
+		cb(d.itemIdData(int32(itemIndex)), depsIdData)
+	}
+}
+
+func (d *trackr) itemIdData(itemIndex int32) []byte {
+	itemIdDataOffset := d.ItemIdDataOffset[itemIndex]
+	itemIdDataSize := d.ItemIdDataSize[itemIndex]
+	return d.Data[itemIdDataOffset : itemIdDataOffset+itemIdDataSize]
+}
+
 // ---------------------------------------------------------------------------------------------------------
 // ---------------------------------------------------------------------------------------------------------
 // CopyItem copies an item from one trackr to another, this is used when an item is up-to-data in the
@@ -0,0 +1,184 @@
+package diagnostics
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+)
+
+// Severity of a diagnostic
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is an error or warning of a compiler or linker
+type Diagnostic struct {
+	Project  string // The project of the compile or link step
+	Severity string // SeverityError or SeverityWarning
+	File     string // The file the diagnostic is about, empty when it has no location (e.g. a linker error)
+	Line     int    // 0 = unknown
+	Column   int    // 0 = unknown
+	Code     string // e.g. '-Wunused-variable' (gcc, clang) or 'C4996' (msvc), empty when there is none
+	Message  string
+}
+
+// linkerName matches the executable names of GNU ld and lld, e.g. 'ld', 'ld.lld' or 'arm-none-eabi-ld'
+const linkerName = `(?:[\w.+-]+-)?(?:ld|ld\.lld|ld\.gold|ld\.bfd|ld64\.lld)(?:\.exe)?`
+
+var (
+	// gcc and clang, 'file:line:col: error: message [-Wflag]', the column is optional
+	gccPattern = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning): (.*?)(?: \[(-W[^\]]+|-fpermissive)\])?$`)
+	// msvc, 'file(line,col): error C1234: message', and its linker 'file : error LNK2019: message'
+	msvcPattern = regexp.MustCompile(`^(.+?)(?:\((\d+)(?:,(\d+))?\))? ?: (fatal error|error|warning) ([A-Z]+\d+): (.*)$`)
+	// GNU ld and lld, '/usr/bin/ld: warning: message' or 'ld.lld: error: message'
+	linkerPattern = regexp.MustCompile(`^(?:.*[/\\])?` + linkerName + `: (error|warning): (.*)$`)
+	// GNU ld, '[ld: ]file.cpp:12: undefined reference to ...' or 'file.o:(.text+0x1c): multiple definition of ...'
+	undefinedSymbolPattern = regexp.MustCompile(`^(?:(?:.*[/\\])?` + linkerName + `: )?(.+?):(?:(\d+)|\(\S+\)): (undefined reference to .*|multiple definition of .*)$`)
+)
+
+// Parse returns the errors and warnings in the output of a compiler or linker, notes and
+// the other lines of the output are skipped.
+func Parse(project string, output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if m := gccPattern.FindStringSubmatch(line); m != nil {
+			diagnostics = append(diagnostics, Diagnostic{Project: project, Severity: severity(m[4]), File: m[1], Line: atoi(m[2]), Column: atoi(m[3]), Code: m[6], Message: m[5]})
+		} else if m := msvcPattern.FindStringSubmatch(line); m != nil {
+			file := strings.TrimSpace(m[1])
+			if file == "LINK" || file == "cl" {
+				file = "" // e.g. 'LINK : fatal error LNK1104: cannot open file'
+			}
+			diagnostics = append(diagnostics, Diagnostic{Project: project, Severity: severity(m[4]), File: file, Line: atoi(m[2]), Column: atoi(m[3]), Code: m[5], Message: m[6]})
+		} else if m := linkerPattern.FindStringSubmatch(line); m != nil {
+			diagnostics = append(diagnostics, Diagnostic{Project: project, Severity: severity(m[1]), Message: m[2]})
+		} else if m := undefinedSymbolPattern.FindStringSubmatch(line); m != nil {
+			diagnostics = append(diagnostics, Diagnostic{Project: project, Severity: SeverityError, File: m[1], Line: atoi(m[2]), Message: m[3]})
+		}
+	}
+	return diagnostics
+}
+
+func severity(s string) string {
+	if s == "warning" {
+		return SeverityWarning
+	}
+	return SeverityError
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// Counts is the number of errors and warnings of a project
+type Counts struct {
+	Project  string
+	Errors   int
+	Warnings int
+}
+
+// Collector is an events.Reporter that collects the diagnostics of the compile and link
+// steps of the current build. A diagnostic is reported once per project, a warning in a
+// header is reported by every translation unit that includes it.
+type Collector struct {
+	diagnostics []Diagnostic
+	seen        map[Diagnostic]bool
+	steps       map[string]string // The project of every archive or link step, by output file
+	outputs     map[string]string // The compiler output of every source file that compiled
+	outputMutex sync.Mutex
+}
+
+// NewCollector returns a collector, it collects from the next build start event on.
+func NewCollector() *Collector {
+	return &Collector{seen: map[Diagnostic]bool{}, steps: map[string]string{}, outputs: map[string]string{}}
+}
+
+func (c *Collector) Report(e *events.Event) {
+	switch e.Kind {
+	case events.BuildStart:
+		c.diagnostics = c.diagnostics[:0]
+		clear(c.seen)
+		clear(c.steps)
+		c.outputMutex.Lock()
+		clear(c.outputs)
+		c.outputMutex.Unlock()
+	case events.ArchiveStart, events.LinkStart:
+		c.steps[e.File] = e.Project
+	case events.CompileEnd:
+		if e.Status != events.StatusFallback {
+			if e.Status != events.StatusFailed {
+				c.outputMutex.Lock()
+				c.outputs[e.File] = e.Message
+				c.outputMutex.Unlock()
+			}
+			c.add(Parse(e.Project, e.Message))
+		}
+	case events.Replay:
+		c.add(Parse(e.Project, e.Message))
+	case events.ToolOutput:
+		c.add(Parse(c.steps[e.File], e.Message))
+	}
+}
+
+func (c *Collector) add(diagnostics []Diagnostic) {
+	for _, d := range diagnostics {
+		if !c.seen[d] {
+			c.seen[d] = true
+			c.diagnostics = append(c.diagnostics, d)
+		}
+	}
+}
+
+func (c *Collector) Close() error {
+	return nil
+}
+
+// Diagnostics returns the diagnostics of the current build, in the order they were reported
+func (c *Collector) Diagnostics() []Diagnostic {
+	return c.diagnostics
+}
+
+// CompileOutput returns the output of the compiler for a source file that compiled in the
+// current build, empty when there was none.
+func (c *Collector) CompileOutput(srcFilepath string) string {
+	c.outputMutex.Lock()
+	defer c.outputMutex.Unlock()
+	return c.outputs[srcFilepath]
+}
+
+// Warnings returns the number of warnings of the current build
+func (c *Collector) Warnings() int {
+	warnings := 0
+	for _, d := range c.diagnostics {
+		if d.Severity == SeverityWarning {
+			warnings++
+		}
+	}
+	return warnings
+}
+
+// Counts returns the number of errors and warnings per project, ordered by project name
+func (c *Collector) Counts() []Counts {
+	counts := []Counts{}
+	for _, d := range c.diagnostics {
+		i := slices.IndexFunc(counts, func(pc Counts) bool { return pc.Project == d.Project })
+		if i < 0 {
+			i = len(counts)
+			counts = append(counts, Counts{Project: d.Project})
+		}
+		if d.Severity == SeverityError {
+			counts[i].Errors++
+		} else {
+			counts[i].Warnings++
+		}
+	}
+	slices.SortFunc(counts, func(a, b Counts) int { return cmp.Compare(a.Project, b.Project) })
+	return counts
+}
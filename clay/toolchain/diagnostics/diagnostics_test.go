@@ -0,0 +1,154 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+)
+
+const gccOutput = `a.cpp: In function 'int f()':
+a.cpp:1:34: error: expected ';' before '}' token
+    1 | int f(){ int unused = 1; return 0 }
+      |                                  ^~
+a.cpp:1:14: warning: unused variable 'unused' [-Wunused-variable]
+In file included from src/main.cpp:2:
+include/lib.h:7: warning: "LIB_VALUE" redefined
+src/main.cpp:3:10: fatal error: missing.h: No such file or directory
+src/main.cpp:3:10: note: some note
+`
+
+const msvcOutput = `main.cpp
+C:\src\main.cpp(12,5): error C2065: 'x': undeclared identifier
+C:\src\lib.h(3): warning C4996: 'strcpy': This function or variable may be unsafe.
+main.obj : error LNK2019: unresolved external symbol "void __cdecl g(void)" referenced in function main
+LINK : fatal error LNK1104: cannot open file 'missing.lib'
+`
+
+const ldOutput = `/usr/bin/ld: m.o: in function ` + "`main':" + `
+/tmp/dg/m.cpp:1: undefined reference to ` + "`g()'" + `
+/usr/bin/ld: d2.o:(.data+0x0): multiple definition of ` + "`x'; d1.o:(.data+0x0): first defined here" + `
+/usr/bin/ld: warning: creating DT_TEXTREL in a PIE
+ld.lld: error: undefined symbol: h
+collect2: error: ld returned 1 exit status
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected []Diagnostic
+	}{
+		{"gcc", gccOutput, []Diagnostic{
+			{Project: "p", Severity: SeverityError, File: "a.cpp", Line: 1, Column: 34, Message: "expected ';' before '}' token"},
+			{Project: "p", Severity: SeverityWarning, File: "a.cpp", Line: 1, Column: 14, Code: "-Wunused-variable", Message: "unused variable 'unused'"},
+			{Project: "p", Severity: SeverityWarning, File: "include/lib.h", Line: 7, Message: `"LIB_VALUE" redefined`},
+			{Project: "p", Severity: SeverityError, File: "src/main.cpp", Line: 3, Column: 10, Message: "missing.h: No such file or directory"},
+		}},
+		{"msvc", msvcOutput, []Diagnostic{
+			{Project: "p", Severity: SeverityError, File: `C:\src\main.cpp`, Line: 12, Column: 5, Code: "C2065", Message: "'x': undeclared identifier"},
+			{Project: "p", Severity: SeverityWarning, File: `C:\src\lib.h`, Line: 3, Code: "C4996", Message: "'strcpy': This function or variable may be unsafe."},
+			{Project: "p", Severity: SeverityError, File: "main.obj", Code: "LNK2019", Message: `unresolved external symbol "void __cdecl g(void)" referenced in function main`},
+			{Project: "p", Severity: SeverityError, Code: "LNK1104", Message: "cannot open file 'missing.lib'"},
+		}},
+		{"ld", ldOutput, []Diagnostic{
+			{Project: "p", Severity: SeverityError, File: "/tmp/dg/m.cpp", Line: 1, Message: "undefined reference to `g()'"},
+			{Project: "p", Severity: SeverityError, File: "d2.o", Message: "multiple definition of `x'; d1.o:(.data+0x0): first defined here"},
+			{Project: "p", Severity: SeverityWarning, Message: "creating DT_TEXTREL in a PIE"},
+			{Project: "p", Severity: SeverityError, Message: "undefined symbol: h"},
+		}},
+	}
+	for _, test := range tests {
+		diagnostics := Parse("p", test.output)
+		if len(diagnostics) != len(test.expected) {
+			t.Errorf("%s: expected %d diagnostics, got %d: %+v", test.name, len(test.expected), len(diagnostics), diagnostics)
+			continue
+		}
+		for i, d := range diagnostics {
+			if d != test.expected[i] {
+				t.Errorf("%s: expected %+v, got %+v", test.name, test.expected[i], d)
+			}
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	c.Report(&events.Event{Kind: events.BuildStart})
+	warning := "include/lib.h:7:3: warning: unused parameter 'x' [-Wunused-parameter]\n"
+	c.Report(&events.Event{Kind: events.CompileEnd, Project: "lib", File: "a.cpp", Message: warning})
+	c.Report(&events.Event{Kind: events.CompileEnd, Project: "lib", File: "b.cpp", Message: warning})
+	c.Report(&events.Event{Kind: events.CompileEnd, Project: "app", File: "main.cpp", Message: warning + "main.cpp:1:1: error: oops\n"})
+	c.Report(&events.Event{Kind: events.CompileEnd, Project: "app", File: "remote.cpp", Status: events.StatusFallback, Message: "main.cpp:1:1: error: remote\n"})
+	c.Report(&events.Event{Kind: events.LinkStart, Project: "app", File: "build/app"})
+	c.Report(&events.Event{Kind: events.ToolOutput, File: "build/app", Message: "/usr/bin/ld: warning: something\n"})
+
+	counts := c.Counts()
+	expected := []Counts{{Project: "app", Errors: 1, Warnings: 2}, {Project: "lib", Warnings: 1}}
+	if len(counts) != len(expected) || counts[0] != expected[0] || counts[1] != expected[1] {
+		t.Errorf("expected counts %+v, got %+v", expected, counts)
+	}
+	if warnings := c.Warnings(); warnings != 3 {
+		t.Errorf("expected 3 warnings, a warning once per project, got %d", warnings)
+	}
+
+	for file, expected := range map[string]string{"a.cpp": warning, "b.cpp": warning, "remote.cpp": "", "other.cpp": ""} {
+		if output := c.CompileOutput(file); output != expected {
+			t.Errorf("expected the compile output of %s to be %q, got %q", file, expected, output)
+		}
+	}
+
+	// The output of an object file that was not compiled is replayed, a diagnostic is still counted once per project
+	c.Report(&events.Event{Kind: events.Replay, Project: "app", File: "old.cpp", Message: warning + "old.cpp:2:1: warning: old [-Wold]\n"})
+	if warnings := c.Warnings(); warnings != 4 {
+		t.Errorf("expected 4 warnings after the replay, got %d", warnings)
+	}
+
+	c.Report(&events.Event{Kind: events.BuildStart})
+	if len(c.Diagnostics()) != 0 {
+		t.Errorf("expected a new build to start without diagnostics")
+	}
+	if len(c.CompileOutput("a.cpp")) != 0 {
+		t.Errorf("expected a new build to start without compile output")
+	}
+}
+
+func TestWriteSarif(t *testing.T) {
+	dirpath := t.TempDir()
+	sarifFilepath := filepath.Join(dirpath, "clay.sarif")
+	diagnostics := []Diagnostic{
+		{Project: "app", Severity: SeverityWarning, File: filepath.Join(dirpath, "src", "main.cpp"), Line: 3, Column: 5, Code: "-Wunused-variable", Message: "unused variable 'x'"},
+		{Project: "app", Severity: SeverityError, File: "/usr/include/stdio.h", Line: 10, Message: "oops"},
+		{Project: "app", Severity: SeverityError, Message: "undefined symbol: h"},
+	}
+	if err := WriteSarif(sarifFilepath, diagnostics, dirpath); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(sarifFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := sarifLog{}
+	if err := json.Unmarshal(content, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 3 {
+		t.Fatalf("expected one run with 3 results, got %s", content)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 1 || run.Tool.Driver.Rules[0].Id != "-Wunused-variable" {
+		t.Errorf("expected a rule per diagnostic code, got %+v", run.Tool.Driver.Rules)
+	}
+	if location := run.Results[0].Locations[0].PhysicalLocation; location.ArtifactLocation.Uri != "src/main.cpp" || location.ArtifactLocation.UriBaseId != "%SRCROOT%" || location.Region.StartLine != 3 {
+		t.Errorf("expected a location relative to the source root, got %+v", location)
+	}
+	if uri := run.Results[1].Locations[0].PhysicalLocation.ArtifactLocation.Uri; uri != "file:///usr/include/stdio.h" {
+		t.Errorf("expected an absolute file URI outside the source root, got %q", uri)
+	}
+	if run.Results[2].Level != "error" || len(run.Results[2].Locations) != 0 {
+		t.Errorf("expected a result without a location, got %+v", run.Results[2])
+	}
+}
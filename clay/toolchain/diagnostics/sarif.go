@@ -0,0 +1,137 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SARIF 2.1.0 (Static Analysis Results Interchange Format), the format that code scanning
+// tools (e.g. GitHub code scanning) import. Only the part that Clay writes is modelled.
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                   `json:"tool"`
+	OriginalUriBaseIds map[string]sarifArtifactLoc `json:"originalUriBaseIds,omitempty"`
+	Results            []sarifResult               `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationUri string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	Id string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleId     string            `json:"ruleId,omitempty"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLoc `json:"artifactLocation"`
+	Region           *sarifRegion     `json:"region,omitempty"`
+}
+
+type sarifArtifactLoc struct {
+	Uri       string `json:"uri"`
+	UriBaseId string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// WriteSarif writes the diagnostics as a SARIF 2.1.0 log to the file at path. The files of
+// the diagnostics in the root directory are relative to '%SRCROOT%' (the root directory),
+// the other files are absolute 'file://' URIs.
+func WriteSarif(path string, diagnostics []Diagnostic, rootDirpath string) error {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "clay", InformationUri: "https://github.com/jurgen-kluft/ccode"}},
+		Results: []sarifResult{},
+	}
+	if rootDirpath, err := filepath.Abs(rootDirpath); err == nil {
+		run.OriginalUriBaseIds = map[string]sarifArtifactLoc{"%SRCROOT%": {Uri: fileUri(rootDirpath) + "/"}}
+	}
+
+	for _, d := range diagnostics {
+		result := sarifResult{
+			RuleId:     d.Code,
+			Level:      d.Severity,
+			Message:    sarifMessage{Text: d.Message},
+			Properties: map[string]string{"project": d.Project},
+		}
+		if len(d.Code) > 0 && !slices.ContainsFunc(run.Tool.Driver.Rules, func(r sarifRule) bool { return r.Id == d.Code }) {
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{Id: d.Code})
+		}
+		if len(d.File) > 0 {
+			location := sarifPhysicalLocation{ArtifactLocation: artifactLocation(d.File, rootDirpath)}
+			if d.Line > 0 {
+				location.Region = &sarifRegion{StartLine: d.Line, StartColumn: d.Column}
+			}
+			result.Locations = []sarifLocation{{PhysicalLocation: location}}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	content, err := json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// artifactLocation returns the location of a file, relative to %SRCROOT% when the file is
+// in the root directory.
+func artifactLocation(file string, rootDirpath string) sarifArtifactLoc {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return sarifArtifactLoc{Uri: filepath.ToSlash(file)}
+	}
+	if absRoot, err := filepath.Abs(rootDirpath); err == nil {
+		if rel, err := filepath.Rel(absRoot, absFile); err == nil && !strings.HasPrefix(rel, "..") {
+			return sarifArtifactLoc{Uri: (&url.URL{Path: filepath.ToSlash(rel)}).String(), UriBaseId: "%SRCROOT%"}
+		}
+	}
+	return sarifArtifactLoc{Uri: fileUri(absFile)}
+}
+
+// fileUri returns the 'file://' URI of an absolute path
+func fileUri(absPath string) string {
+	path := filepath.ToSlash(absPath)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // e.g. 'C:/dir' on Windows
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
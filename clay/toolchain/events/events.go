@@ -0,0 +1,175 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A stream of build events, emitted by the app (build, projects, archiving, linking) and by
+// the toolchains (compiling), so that CI dashboards and editor integrations do not have to
+// scrape the log output.
+
+// Kind is the type of an event, a step that takes time has a start and an end event.
+type Kind string
+
+const (
+	BuildStart   Kind = "build_start"
+	BuildEnd     Kind = "build_end"
+	ProjectStart Kind = "project_start"
+	ProjectEnd   Kind = "project_end"
+	CompileStart Kind = "compile_start"
+	CompileEnd   Kind = "compile_end"
+	ArchiveStart Kind = "archive_start"
+	ArchiveEnd   Kind = "archive_end"
+	LinkStart    Kind = "link_start"
+	LinkEnd      Kind = "link_end"
+	CopyStart    Kind = "copy_start" // Copying the files of a project to the build directory
+	CopyEnd      Kind = "copy_end"
+	BurnStart    Kind = "burn_start" // Building the image of an executable and burning it to a device
+	BurnEnd      Kind = "burn_end"
+	UpToDate     Kind = "up_to_date"  // The project was up to date, nothing was built
+	Explain      Kind = "explain"     // Why an item is out of date, only emitted with --explain
+	ToolOutput   Kind = "tool_output" // The output of an archiver or linker, File is the output file of the archive or link step
+	Replay       Kind = "replay"      // The compiler output of an object file that was not compiled by this build (up to date or restored from the cache), File is the source file
+	Log          Kind = "log"         // A log message, only emitted when logging as events
+)
+
+const (
+	StatusOk       = "ok"
+	StatusFailed   = "failed"
+	StatusFallback = "fallback" // A remote compile that failed, the file is compiled locally instead
+)
+
+// Event is a single build event, written as one line of JSON.
+type Event struct {
+	Kind       Kind      `json:"event"`
+	Time       time.Time `json:"time"`
+	Target     string    `json:"target,omitempty"`
+	Config     string    `json:"config,omitempty"`
+	Project    string    `json:"project,omitempty"`
+	File       string    `json:"file,omitempty"`        // Source file of a compile, output file of an archive or link
+	Status     string    `json:"status,omitempty"`      // StatusOk, StatusFailed or StatusFallback, for end events
+	DurationMs float64   `json:"duration_ms,omitempty"` // For end events
+	ExitCode   int       `json:"exit_code,omitempty"`   // Of the compiler, for compile_end events
+	OutOfDate  int       `json:"out_of_date,omitempty"` // Number of out-of-date items of a build or project
+	Level      string    `json:"level,omitempty"`       // For log events (info, warning, error)
+	Message    string    `json:"message,omitempty"`     // Compiler output, error or log message
+	Causes     []Cause   `json:"causes,omitempty"`      // For explain events
+	TimeTrace  string    `json:"time_trace,omitempty"`  // The clang -ftime-trace file, for compile_end events
+	Worker     string    `json:"worker,omitempty"`      // The worker (host:port) of a remote compile, for compile_end events
+}
+
+// Cause is why an item is out of date, File is the item itself or one of its dependencies.
+type Cause struct {
+	Reason string `json:"reason"` // e.g. 'new', 'missing', 'changed' or 'args'
+	File   string `json:"file,omitempty"`
+}
+
+// End returns the end event of a start event, e.g. a compile_end event for a compile_start
+// event, with the duration since the start event and the status derived from err.
+func (e *Event) End(err error) *Event {
+	end := &Event{
+		Kind:    Kind(strings.TrimSuffix(string(e.Kind), "_start") + "_end"),
+		Target:  e.Target,
+		Config:  e.Config,
+		Project: e.Project,
+		File:    e.File,
+		Status:  StatusOk,
+	}
+	if !e.Time.IsZero() {
+		end.DurationMs = float64(time.Since(e.Time).Microseconds()) / 1000.0
+	}
+	if err != nil {
+		end.Status = StatusFailed
+		end.Message = err.Error()
+	}
+	return end
+}
+
+// Reporter receives the build events, the calls to Report are serialized.
+type Reporter interface {
+	Report(e *Event)
+	Close() error
+}
+
+var (
+	reportersMutex sync.Mutex
+	reporters      []Reporter
+)
+
+// AddReporter adds a reporter that receives all the events emitted from now on.
+func AddReporter(r Reporter) {
+	reportersMutex.Lock()
+	defer reportersMutex.Unlock()
+	reporters = append(reporters, r)
+}
+
+// Enabled returns true when there is at least one reporter.
+func Enabled() bool {
+	reportersMutex.Lock()
+	defer reportersMutex.Unlock()
+	return len(reporters) > 0
+}
+
+// Emit sends an event to all the reporters, the time of the event is set when it is zero.
+func Emit(e *Event) {
+	reportersMutex.Lock()
+	defer reportersMutex.Unlock()
+	if len(reporters) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, r := range reporters {
+		r.Report(e)
+	}
+}
+
+// Close closes and removes all the reporters.
+func Close() error {
+	reportersMutex.Lock()
+	defer reportersMutex.Unlock()
+	var firstErr error
+	for _, r := range reporters {
+		if err := r.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	reporters = nil
+	return firstErr
+}
+
+// JsonReporter writes every event as a line of JSON.
+type JsonReporter struct {
+	writer io.Writer
+	closer io.Closer // Closed on Close (nil = not owned, e.g. stdout)
+}
+
+// NewJsonReporter writes the events to w, w is not closed by Close.
+func NewJsonReporter(w io.Writer) *JsonReporter {
+	return &JsonReporter{writer: w}
+}
+
+// NewJsonFileReporter writes the events to w and closes w on Close.
+func NewJsonFileReporter(w io.WriteCloser) *JsonReporter {
+	return &JsonReporter{writer: w, closer: w}
+}
+
+func (r *JsonReporter) Report(e *Event) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.writer.Write(append(line, '\n'))
+}
+
+func (r *JsonReporter) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
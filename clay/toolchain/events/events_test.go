@@ -0,0 +1,46 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEventEnd(t *testing.T) {
+	var buffer bytes.Buffer
+	AddReporter(NewJsonReporter(&buffer))
+	defer Close()
+
+	start := &Event{Kind: CompileStart, Project: "mylib", File: "src/a.cpp"}
+	Emit(start)
+	Emit(start.End(errors.New("exit status 1")))
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines of JSON, got %d: %q", len(lines), buffer.String())
+	}
+
+	var end Event
+	if err := json.Unmarshal([]byte(lines[1]), &end); err != nil {
+		t.Fatalf("invalid JSON line %q: %v", lines[1], err)
+	}
+	if end.Kind != CompileEnd || end.Project != "mylib" || end.File != "src/a.cpp" {
+		t.Errorf("expected a compile_end event of src/a.cpp in mylib, got %+v", end)
+	}
+	if end.Status != StatusFailed || end.Message != "exit status 1" || end.Time.IsZero() {
+		t.Errorf("expected a failed end event with the error and a time, got %+v", end)
+	}
+}
+
+func TestEmitWithoutReporters(t *testing.T) {
+	start := &Event{Kind: LinkStart}
+	Emit(start)
+	if !start.Time.IsZero() || Enabled() {
+		t.Errorf("expected no reporters and no time to be set, got %+v", start)
+	}
+	if end := start.End(nil); end.Kind != LinkEnd || end.Status != StatusOk || end.DurationMs != 0 {
+		t.Errorf("expected an ok link_end event without duration, got %+v", end)
+	}
+}
@@ -0,0 +1,208 @@
+package events
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Span is a step of the build, the time between a start event and its end event.
+type Span struct {
+	Kind      string        // The kind of the step without '_start', e.g. 'compile' or 'link'
+	Project   string        // The project of the step, empty for the build itself
+	File      string        // The source file of a compile, the output file of an archive or link
+	Start     time.Time     // When the step started
+	Duration  time.Duration // How long the step took
+	Status    string        // StatusOk, StatusFailed or StatusFallback
+	TimeTrace string        // The clang -ftime-trace file of a compile
+	Worker    string        // The worker of a remote compile
+}
+
+// Process ids of the trace, the build and its projects are in one process and the steps
+// that run a tool (compile, archive, link) or copy files are in another.
+const (
+	traceBuildPid   = 1
+	traceWorkersPid = 2
+)
+
+// traceEvent is an event of the Chrome Trace Event Format, a complete event ('X') is a
+// span and a metadata event ('M') names a process or thread.
+type traceEvent struct {
+	Name     string         `json:"name"`
+	Category string         `json:"cat,omitempty"`
+	Phase    string         `json:"ph"`
+	Ts       int64          `json:"ts"`            // Start in microseconds since the first event
+	Dur      int64          `json:"dur,omitempty"` // Duration in microseconds
+	Pid      int            `json:"pid"`
+	Tid      int            `json:"tid"`
+	Args     map[string]any `json:"args,omitempty"`
+}
+
+// openSpan is a span of which the start event was reported and the end event not yet
+type openSpan struct {
+	start *Event
+	pid   int
+	tid   int
+}
+
+// TraceReporter collects the spans of the build and writes them, at the end of every
+// build and on Close, as a Chrome Trace Event Format file that can be viewed in Perfetto
+// (ui.perfetto.dev) or in chrome://tracing. Every lane (thread) of the workers process is a worker, a span gets
+// the first lane that is free when it starts, so the number of lanes is the maximum
+// number of steps that ran concurrently.
+type TraceReporter struct {
+	path   string // The trace file, empty = only collect the spans, e.g. for the slowest files
+	origin time.Time
+	open   map[string]*openSpan
+	lanes  map[int][]bool // The lanes of a process, true when in use
+	trace  []traceEvent
+	spans  []Span // The spans of the current build
+}
+
+// NewTraceReporter writes the trace to the file at path, an empty path only collects the
+// spans of the current build.
+func NewTraceReporter(path string) *TraceReporter {
+	r := &TraceReporter{path: path, open: map[string]*openSpan{}, lanes: map[int][]bool{}}
+	r.trace = append(r.trace,
+		traceEvent{Name: "process_name", Phase: "M", Pid: traceBuildPid, Args: map[string]any{"name": "clay"}},
+		traceEvent{Name: "process_name", Phase: "M", Pid: traceWorkersPid, Args: map[string]any{"name": "workers"}},
+	)
+	return r
+}
+
+func spanKey(kind string, e *Event) string {
+	return kind + "|" + e.Project + "|" + e.File
+}
+
+// acquireLane returns the first free lane of a process, a new lane is named after the
+// process, e.g. 'worker 3'.
+func (r *TraceReporter) acquireLane(pid int) int {
+	lanes := r.lanes[pid]
+	for i, used := range lanes {
+		if !used {
+			lanes[i] = true
+			return i + 1
+		}
+	}
+	r.lanes[pid] = append(lanes, true)
+	tid := len(r.lanes[pid])
+	name := fmt.Sprintf("worker %d", tid)
+	if pid == traceBuildPid {
+		name = fmt.Sprintf("build %d", tid)
+	}
+	r.trace = append(r.trace, traceEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: tid, Args: map[string]any{"name": name}})
+	return tid
+}
+
+func (r *TraceReporter) Report(e *Event) {
+	if r.origin.IsZero() {
+		r.origin = e.Time
+	}
+	kind := string(e.Kind)
+	if stem, ok := strings.CutSuffix(kind, "_start"); ok {
+		if e.Kind == BuildStart {
+			r.spans = r.spans[:0]
+		}
+		pid := traceWorkersPid
+		if e.Kind == BuildStart || e.Kind == ProjectStart {
+			pid = traceBuildPid
+		}
+		r.open[spanKey(stem, e)] = &openSpan{start: e, pid: pid, tid: r.acquireLane(pid)}
+		return
+	}
+
+	stem, ok := strings.CutSuffix(kind, "_end")
+	if !ok {
+		return
+	}
+	key := spanKey(stem, e)
+	open, ok := r.open[key]
+	if !ok {
+		return
+	}
+	delete(r.open, key)
+	r.lanes[open.pid][open.tid-1] = false
+
+	span := Span{
+		Kind:      stem,
+		Project:   e.Project,
+		File:      e.File,
+		Start:     open.start.Time,
+		Duration:  e.Time.Sub(open.start.Time),
+		Status:    e.Status,
+		TimeTrace: e.TimeTrace,
+		Worker:    e.Worker,
+	}
+	r.spans = append(r.spans, span)
+
+	name := filepath.Base(span.File)
+	switch {
+	case e.Kind == BuildEnd:
+		name = "build " + e.Target + " " + e.Config
+	case e.Kind == ProjectEnd || len(span.File) == 0:
+		name = span.Project
+	}
+	args := map[string]any{"status": span.Status}
+	if len(span.Project) > 0 {
+		args["project"] = span.Project
+	}
+	if len(span.File) > 0 {
+		args["file"] = span.File
+	}
+	if len(span.TimeTrace) > 0 {
+		args["time_trace"] = span.TimeTrace
+	}
+	if len(span.Worker) > 0 {
+		args["worker"] = span.Worker
+	}
+	r.trace = append(r.trace, traceEvent{
+		Name:     name,
+		Category: stem,
+		Phase:    "X",
+		Ts:       span.Start.Sub(r.origin).Microseconds(),
+		Dur:      max(span.Duration.Microseconds(), 1),
+		Pid:      open.pid,
+		Tid:      open.tid,
+		Args:     args,
+	})
+
+	// The trace is complete at the end of a build, also when the build failed
+	if e.Kind == BuildEnd {
+		r.write()
+	}
+}
+
+// Slowest returns the n slowest compiled files of the current build, the slowest first.
+func (r *TraceReporter) Slowest(n int) []Span {
+	compiles := []Span{}
+	for _, span := range r.spans {
+		if span.Kind == "compile" {
+			compiles = append(compiles, span)
+		}
+	}
+	slices.SortStableFunc(compiles, func(a, b Span) int {
+		return cmp.Compare(b.Duration, a.Duration)
+	})
+	return compiles[:min(n, len(compiles))]
+}
+
+// write writes the trace file, it is rewritten with all the spans so far
+func (r *TraceReporter) write() error {
+	if len(r.path) == 0 {
+		return nil
+	}
+	content, err := json.Marshal(map[string]any{"traceEvents": r.trace, "displayTimeUnit": "ms"})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(content, '\n'), 0644)
+}
+
+func (r *TraceReporter) Close() error {
+	return r.write()
+}
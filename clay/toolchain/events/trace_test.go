@@ -0,0 +1,110 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTraceReporter(t *testing.T) {
+	tracePath := filepath.Join(t.TempDir(), "trace.json")
+	r := NewTraceReporter(tracePath)
+
+	origin := time.Now()
+	at := func(ms int, e *Event) *Event {
+		e.Time = origin.Add(time.Duration(ms) * time.Millisecond)
+		return e
+	}
+	compile := func(file string) *Event { return &Event{Kind: CompileStart, Project: "mylib", File: file} }
+
+	// a.cpp and b.cpp compile concurrently, c.cpp starts when a.cpp is done and gets its lane
+	build := at(0, &Event{Kind: BuildStart, Target: "linux-x64", Config: "debug"})
+	r.Report(build)
+	a, b := at(0, compile("/src/a.cpp")), at(1, compile("/src/b.cpp"))
+	r.Report(a)
+	r.Report(b)
+	r.Report(at(300, a.End(nil)))
+	c := at(300, compile("/src/c.cpp"))
+	r.Report(c)
+	r.Report(at(400, b.End(nil)))
+	cEnd := at(350, c.End(nil))
+	cEnd.TimeTrace = "build/mylib/c.cpp.json"
+	r.Report(cEnd)
+	r.Report(at(500, build.End(nil)))
+
+	slowest := r.Slowest(2)
+	if len(slowest) != 2 || filepath.Base(slowest[0].File) != "b.cpp" || filepath.Base(slowest[1].File) != "a.cpp" {
+		t.Fatalf("expected b.cpp and a.cpp to be the slowest, got %+v", slowest)
+	}
+	if slowest[0].Duration != 399*time.Millisecond {
+		t.Errorf("expected b.cpp to take 399ms, got %s", slowest[0].Duration)
+	}
+
+	// The trace is written at the end of the build
+	content, err := os.ReadFile(tracePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(content, &trace); err != nil {
+		t.Fatalf("invalid trace: %v", err)
+	}
+	lanes := map[string]int{}
+	for _, e := range trace.TraceEvents {
+		if e.Phase == "X" {
+			lanes[e.Name] = e.Tid
+		}
+	}
+	expected := map[string]int{"a.cpp": 1, "b.cpp": 2, "c.cpp": 1, "build linux-x64 debug": 1}
+	for name, tid := range expected {
+		if lanes[name] != tid {
+			t.Errorf("expected %s in lane %d, got lanes %v", name, tid, lanes)
+		}
+	}
+
+	// A new build starts with no spans
+	r.Report(at(600, &Event{Kind: BuildStart}))
+	if slowest := r.Slowest(10); len(slowest) != 0 {
+		t.Errorf("expected no spans in a new build, got %+v", slowest)
+	}
+}
+
+func TestTraceReporterFailedBuild(t *testing.T) {
+	tracePath := filepath.Join(t.TempDir(), "trace.json")
+	r := NewTraceReporter(tracePath)
+
+	// The trace is written at the end of a failed build, clay exits without closing it
+	build := &Event{Kind: BuildStart, Time: time.Now()}
+	r.Report(build)
+	compile := &Event{Kind: CompileStart, Project: "mylib", File: "/src/a.cpp", Time: build.Time}
+	r.Report(compile)
+	failed := errors.New("compile failed")
+	compileEnd := compile.End(failed)
+	compileEnd.Time = build.Time.Add(time.Second)
+	r.Report(compileEnd)
+	buildEnd := build.End(failed)
+	buildEnd.Time = compileEnd.Time
+	r.Report(buildEnd)
+
+	content, err := os.ReadFile(tracePath)
+	if err != nil {
+		t.Fatalf("expected the trace of a failed build to be written, got %v", err)
+	}
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(content, &trace); err != nil {
+		t.Fatalf("invalid trace: %v", err)
+	}
+	for _, e := range trace.TraceEvents {
+		if e.Name == "a.cpp" && e.Args["status"] == StatusFailed {
+			return
+		}
+	}
+	t.Errorf("expected the failed compile of a.cpp in the trace, got %s", content)
+}
@@ -0,0 +1,131 @@
+package toolchain
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// jobSlots is the global job budget, every running compiler, archiver or linker process
+// occupies one slot. Projects that are built in parallel share the same budget.
+var jobSlots = make(chan struct{}, runtime.NumCPU())
+
+// jobLogMutex makes sure that the output of concurrently running jobs does not interleave.
+var jobLogMutex sync.Mutex
+
+// SetMaxJobs sets the maximum number of compiler processes that can run concurrently.
+// A value of 0 or less means 'the number of CPUs'.
+func SetMaxJobs(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	jobSlots = make(chan struct{}, n)
+}
+
+// MaxJobs returns the maximum number of compiler processes that can run concurrently.
+func MaxJobs() int {
+	return cap(jobSlots)
+}
+
+// RunWithJobSlot runs fn while occupying one slot of the global job budget, this is
+// used for the steps of a build that are not a compile job, like archiving and linking.
+func RunWithJobSlot(fn func() error) error {
+	slots := jobSlots
+	slots <- struct{}{}
+	defer func() { <-slots }()
+	return fn()
+}
+
+// compileJob is a single compiler invocation for one source file.
+// Note: The command-line has to be fully resolved before the job is scheduled, resolving
+// with corepkg.Vars is not thread-safe.
+type compileJob struct {
+	message        string   // Logged together with the output, e.g. "Compiling (debug) file.cpp"
+	project        string   // The project the source file belongs to, reported with the compile events
+	srcFilepath    string   // The source file being compiled
+	toolPath       string   // The compiler executable
+	toolArgs       []string // The fully resolved compiler arguments
+	env            []string // Environment of the compiler process (nil = inherit)
+	quietOnSuccess bool     // Do not log the output of a successful compile (e.g. msvc echoes the filename)
+	timeTrace      string   // The trace the compiler writes (clang -ftime-trace), reported with the compile end event
+}
+
+// runCompileJobs runs the jobs on a pool of workers bounded by the global job budget.
+// It returns, in the order of the jobs, if each file compiled successfully and a
+// boolean indicating that all files compiled successfully.
+func runCompileJobs(jobs []*compileJob) ([]bool, bool) {
+	compiled := make([]bool, len(jobs))
+
+	slots := jobSlots
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, job *compileJob) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			compileEvent := &events.Event{Kind: events.CompileStart, Project: job.project, File: job.srcFilepath}
+			events.Emit(compileEvent)
+
+			cmd := exec.Command(job.toolPath, job.toolArgs...)
+			if job.env != nil {
+				cmd.Env = job.env
+			}
+			out, err := cmd.CombinedOutput()
+
+			compileEndEvent := compileEvent.End(err)
+			if err != nil {
+				compileEndEvent.ExitCode = -1
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					compileEndEvent.ExitCode = exitErr.ExitCode()
+				}
+			}
+			if len(out) > 0 {
+				compileEndEvent.Message = string(out)
+			}
+			if err == nil {
+				compileEndEvent.TimeTrace = job.timeTrace
+			}
+			events.Emit(compileEndEvent)
+
+			// Log everything of a single file in one go, so that the output of
+			// concurrently running compilers does not interleave.
+			jobLogMutex.Lock()
+			defer jobLogMutex.Unlock()
+			corepkg.LogInfo(job.message)
+			if err != nil {
+				corepkg.LogInfof("Compile failed for %s, output:\n%s", filepath.Base(job.srcFilepath), string(out))
+			} else {
+				if len(out) > 0 && !job.quietOnSuccess {
+					corepkg.LogInfof("Compile output:\n%s", string(out))
+				}
+				compiled[i] = true
+			}
+		}(i, job)
+	}
+	wg.Wait()
+
+	for _, ok := range compiled {
+		if !ok {
+			return compiled, false
+		}
+	}
+	return compiled, true
+}
+
+// emitToolOutput reports the output of an archiver or linker as a tool_output event, the
+// output file identifies the archive or link step (and thus the project) it belongs to.
+func emitToolOutput(outputFilepath string, out []byte) {
+	if len(out) > 0 {
+		events.Emit(&events.Event{Kind: events.ToolOutput, File: outputFilepath, Message: string(out)})
+	}
+}
@@ -0,0 +1,72 @@
+package toolchain
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess is not a real test, it is the 'compiler' that the jobs of the tests run.
+// It sleeps for the number of milliseconds of its first argument and then exits with the
+// exit code of its second argument.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("CLAY_TEST_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	sleep, _ := strconv.Atoi(args[1])
+	exitCode, _ := strconv.Atoi(args[2])
+	time.Sleep(time.Duration(sleep) * time.Millisecond)
+	os.Exit(exitCode)
+}
+
+func helperJob(name string, sleep int, exitCode int) *compileJob {
+	return &compileJob{
+		message:     "Compiling " + name,
+		srcFilepath: name,
+		toolPath:    os.Args[0],
+		toolArgs:    []string{"-test.run=TestHelperProcess", "--", strconv.Itoa(sleep), strconv.Itoa(exitCode)},
+		env:         append(os.Environ(), "CLAY_TEST_HELPER_PROCESS=1"),
+	}
+}
+
+func TestRunCompileJobs(t *testing.T) {
+	defer SetMaxJobs(MaxJobs())
+	SetMaxJobs(2)
+
+	// More jobs than slots, the first jobs take the longest so that they finish last
+	const numJobs = 7
+	jobs := make([]*compileJob, numJobs)
+	expected := make([]bool, numJobs)
+	for i := range jobs {
+		exitCode := 0
+		if i%3 == 1 {
+			exitCode = 1
+		}
+		jobs[i] = helperJob(fmt.Sprintf("file%d.cpp", i), (numJobs-i)*20, exitCode)
+		expected[i] = exitCode == 0
+	}
+
+	compiled, ok := runCompileJobs(jobs)
+	if ok {
+		t.Errorf("expected the failing jobs to fail the compile")
+	}
+	if len(compiled) != numJobs {
+		t.Fatalf("expected %d results, got %d", numJobs, len(compiled))
+	}
+	for i := range compiled {
+		if compiled[i] != expected[i] {
+			t.Errorf("expected the result of job %d to be %v, got %v", i, expected[i], compiled[i])
+		}
+	}
+
+	compiled, ok = runCompileJobs([]*compileJob{helperJob("a.cpp", 10, 0), helperJob("b.cpp", 0, 0), helperJob("c.cpp", 0, 0)})
+	if !ok || len(compiled) != 3 || !compiled[0] || !compiled[1] || !compiled[2] {
+		t.Errorf("expected all jobs to compile, got %v", compiled)
+	}
+}
@@ -13,6 +13,11 @@ type Linker interface {
 	// SetupArgs prepares the linker arguments based on the provided options.
 	SetupArgs(libraryPaths []string, libraryFiles []string)
 
+	// ArgsHash returns a hash of the fully resolved command-line that links the executable.
+	// The hash is stored with the executable in the dependency tracker, any change to the
+	// command-line makes the executable out-of-date.
+	ArgsHash(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) []byte
+
 	// Link takes a list of input object file paths and an output file path
 	Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error
 }
@@ -0,0 +1,456 @@
+package objcache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A local compilation cache, shared by all build directories, that works like ccache in
+// 'direct mode'.
+//
+// The cache key of a translation unit is the hash of the compiler identity, the resolved
+// command-line (without the build directory specific output paths) and the content of the
+// source file. This key refers to a manifest, a manifest holds a list of results where
+// each result is the list of dependencies (header files) with the digest of their content
+// at the time the object file was compiled. A result matches when all of its dependencies
+// still have the same content, the object file and dependency file of the result are then
+// restored instead of compiling the source file.
+//
+// Layout of the cache directory:
+//
+//	manifests/<2 hex>/<key>.json   the results of a cache key
+//	objects/<2 hex>/<result>.o     the object file of a result
+//	objects/<2 hex>/<result>.d     the dependency file of a result
+//	stats.json                     statistics (hits, misses, size, ...)
+//
+// Files are evicted in least-recently-used order when the size of the cache exceeds the
+// maximum size, a cache hit updates the modification time of the files it used.
+
+const (
+	maxResultsPerManifest = 8
+	objPathPlaceholder    = "{{clay.object.path}}"
+)
+
+// DefaultMaxSize is the maximum size of the cache when CLAY_CACHE_MAX_SIZE is not set.
+const DefaultMaxSize = int64(5 * 1024 * 1024 * 1024)
+
+// Stats are the statistics of the cache, they are accumulated over all builds.
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Stores    int64 `json:"stores"`
+	Evictions int64 `json:"evictions"`
+	Size      int64 `json:"size"` // Size of the cache in bytes
+}
+
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) * 100.0 / float64(s.Hits+s.Misses)
+}
+
+type manifestDep struct {
+	Path   string `json:"path"`
+	Digest string `json:"digest"`
+}
+
+type manifestResult struct {
+	Result string        `json:"result"` // Name of the object and dependency file in the cache
+	Deps   []manifestDep `json:"deps"`
+	Output string        `json:"output,omitempty"` // Output of the compiler (diagnostics)
+}
+
+type manifest struct {
+	Results []manifestResult `json:"results"`
+}
+
+// Cache is a compilation cache, it is safe for concurrent use by projects that are
+// built in parallel.
+type Cache struct {
+	mutex   sync.Mutex
+	dirpath string
+	maxSize int64
+	delta   Stats             // Statistics of this build, merged into stats.json on Close
+	digests map[string][]byte // Content digests of files, computed once per build
+}
+
+// DefaultDirpath returns the directory of the cache, this is CLAY_CACHE_DIR or 'clay'
+// in the user cache directory.
+func DefaultDirpath() string {
+	if dirpath := os.Getenv("CLAY_CACHE_DIR"); len(dirpath) > 0 {
+		return dirpath
+	}
+	if userCacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(userCacheDir, "clay")
+	}
+	return filepath.Join(os.TempDir(), "clay-cache")
+}
+
+// DefaultMaxSizeFromEnv returns CLAY_CACHE_MAX_SIZE (e.g. 500M, 10G) or DefaultMaxSize.
+func DefaultMaxSizeFromEnv() int64 {
+	if value := os.Getenv("CLAY_CACHE_MAX_SIZE"); len(value) > 0 {
+		if size, err := ParseSize(value); err == nil {
+			return size
+		}
+	}
+	return DefaultMaxSize
+}
+
+// ParseSize parses a size in bytes with an optional K, M or G suffix.
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "B")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1024
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1024 * 1024
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1024 * 1024 * 1024
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * multiplier, nil
+}
+
+// Open opens (and creates) the cache in dirpath with a maximum size in bytes.
+func Open(dirpath string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dirpath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &Cache{
+		dirpath: dirpath,
+		maxSize: maxSize,
+		digests: map[string][]byte{},
+	}, nil
+}
+
+func (c *Cache) Dirpath() string {
+	return c.dirpath
+}
+
+func (c *Cache) MaxSize() int64 {
+	return c.maxSize
+}
+
+// Key returns the cache key of compiling a source file, compilerKey identifies the compiler
+// and the command-line. It returns nil when the source file cannot be read.
+func (c *Cache) Key(compilerKey []byte, srcFilepath string) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	srcDigest, err := c.fileDigest(srcFilepath)
+	if err != nil {
+		return nil
+	}
+	hasher := sha1.New()
+	hasher.Write(compilerKey)
+	hasher.Write(srcDigest)
+	return hasher.Sum(nil)
+}
+
+// Restore writes the object file and dependency file of a matching result to objFilepath
+// and depFilepath, it returns the dependencies and the compiler output of the result. On a
+// miss ok is false.
+func (c *Cache) Restore(key []byte, objFilepath string, depFilepath string) (deps []string, output string, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	m := c.loadManifest(key)
+	for _, r := range m.Results {
+		if !c.depsUnchanged(r.Deps) {
+			continue
+		}
+
+		objCacheFilepath, depCacheFilepath := c.resultFilepaths(r.Result)
+		if err := copyFile(objCacheFilepath, objFilepath); err != nil {
+			break
+		}
+		if content, err := os.ReadFile(depCacheFilepath); err == nil {
+			content = bytes.ReplaceAll(content, []byte(objPathPlaceholder), []byte(objFilepath))
+			os.WriteFile(depFilepath, content, 0644)
+		}
+
+		// Mark the files of this result as recently used
+		now := time.Now()
+		os.Chtimes(c.manifestFilepath(key), now, now)
+		os.Chtimes(objCacheFilepath, now, now)
+		os.Chtimes(depCacheFilepath, now, now)
+
+		deps = make([]string, 0, len(r.Deps))
+		for _, dep := range r.Deps {
+			deps = append(deps, dep.Path)
+		}
+		c.delta.Hits++
+		return deps, r.Output, true
+	}
+	c.delta.Misses++
+	return nil, "", false
+}
+
+// Store adds the object file and dependency file of a compiled source file to the cache,
+// deps are the dependencies as parsed from the dependency file and output is the output of
+// the compiler, which is returned by Restore so that its warnings are not lost.
+func (c *Cache) Store(key []byte, objFilepath string, depFilepath string, deps []string, output string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	result := manifestResult{Deps: make([]manifestDep, 0, len(deps)), Output: output}
+
+	hasher := sha1.New()
+	hasher.Write(key)
+	for _, dep := range deps {
+		digest, err := c.fileDigest(dep)
+		if err != nil {
+			return err
+		}
+		hasher.Write([]byte(dep))
+		hasher.Write(digest)
+		result.Deps = append(result.Deps, manifestDep{Path: dep, Digest: hex.EncodeToString(digest)})
+	}
+	result.Result = hex.EncodeToString(hasher.Sum(nil))
+
+	objCacheFilepath, depCacheFilepath := c.resultFilepaths(result.Result)
+	if err := os.MkdirAll(filepath.Dir(objCacheFilepath), os.ModePerm); err != nil {
+		return err
+	}
+
+	// The dependency file (may) contain the path of the object file, which is different
+	// for every build directory.
+	depContent, err := os.ReadFile(depFilepath)
+	if err != nil {
+		return err
+	}
+	depContent = bytes.ReplaceAll(depContent, []byte(objFilepath), []byte(objPathPlaceholder))
+	if err := writeFileAtomic(depCacheFilepath, depContent); err != nil {
+		return err
+	}
+	if err := copyFile(objFilepath, objCacheFilepath); err != nil {
+		return err
+	}
+
+	// Add the result to the manifest, the most recent result first
+	m := c.loadManifest(key)
+	m.Results = slices.DeleteFunc(m.Results, func(r manifestResult) bool { return r.Result == result.Result })
+	m.Results = slices.Insert(m.Results, 0, result)
+	if len(m.Results) > maxResultsPerManifest {
+		m.Results = m.Results[:maxResultsPerManifest]
+	}
+	manifestContent, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	manifestFilepath := c.manifestFilepath(key)
+	if err := os.MkdirAll(filepath.Dir(manifestFilepath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(manifestFilepath, manifestContent); err != nil {
+		return err
+	}
+
+	c.delta.Stores++
+	c.delta.Size += fileSize(objCacheFilepath) + int64(len(depContent)) + int64(len(manifestContent))
+	return nil
+}
+
+// Close merges the statistics of this build into the statistics of the cache and evicts
+// the least recently used files when the cache exceeds its maximum size.
+func (c *Cache) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.loadStats()
+	stats.Hits += c.delta.Hits
+	stats.Misses += c.delta.Misses
+	stats.Stores += c.delta.Stores
+	stats.Size += c.delta.Size
+	c.delta = Stats{}
+
+	if stats.Size > c.maxSize {
+		size, evicted, err := c.evict(c.maxSize - c.maxSize/10)
+		if err != nil {
+			return err
+		}
+		stats.Size = size
+		stats.Evictions += evicted
+	}
+	return c.saveStats(stats)
+}
+
+// Stats returns the statistics of the cache, the size is measured on disk.
+func (c *Cache) Stats() (stats Stats, numFiles int) {
+	stats = c.loadStats()
+	stats.Size = 0
+	c.walk(func(path string, info fs.FileInfo) {
+		stats.Size += info.Size()
+		numFiles++
+	})
+	return stats, numFiles
+}
+
+// Clear removes all the cached files and resets the statistics.
+func (c *Cache) Clear() error {
+	for _, dir := range []string{"manifests", "objects"} {
+		if err := os.RemoveAll(filepath.Join(c.dirpath, dir)); err != nil {
+			return err
+		}
+	}
+	return c.saveStats(Stats{})
+}
+
+func (c *Cache) evict(targetSize int64) (size int64, evicted int64, err error) {
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	c.walk(func(path string, info fs.FileInfo) {
+		files = append(files, file{path: path, size: info.Size(), modTime: info.ModTime()})
+		size += info.Size()
+	})
+
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	for _, f := range files {
+		if size <= targetSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return size, evicted, err
+		}
+		size -= f.size
+		evicted++
+	}
+	return size, evicted, nil
+}
+
+func (c *Cache) walk(fileFunc func(path string, info fs.FileInfo)) {
+	for _, dir := range []string{"manifests", "objects"} {
+		filepath.WalkDir(filepath.Join(c.dirpath, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				fileFunc(path, info)
+			}
+			return nil
+		})
+	}
+}
+
+func (c *Cache) depsUnchanged(deps []manifestDep) bool {
+	for _, dep := range deps {
+		digest, err := c.fileDigest(dep.Path)
+		if err != nil || hex.EncodeToString(digest) != dep.Digest {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cache) fileDigest(filepath string) ([]byte, error) {
+	if digest, ok := c.digests[filepath]; ok {
+		return digest, nil
+	}
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return nil, err
+	}
+	digest := hasher.Sum(nil)
+	c.digests[filepath] = digest
+	return digest, nil
+}
+
+func (c *Cache) manifestFilepath(key []byte) string {
+	name := hex.EncodeToString(key)
+	return filepath.Join(c.dirpath, "manifests", name[:2], name+".json")
+}
+
+func (c *Cache) resultFilepaths(result string) (objFilepath string, depFilepath string) {
+	base := filepath.Join(c.dirpath, "objects", result[:2], result)
+	return base + ".o", base + ".d"
+}
+
+func (c *Cache) loadManifest(key []byte) (m manifest) {
+	if content, err := os.ReadFile(c.manifestFilepath(key)); err == nil {
+		json.Unmarshal(content, &m)
+	}
+	return m
+}
+
+func (c *Cache) loadStats() (stats Stats) {
+	if content, err := os.ReadFile(filepath.Join(c.dirpath, "stats.json")); err == nil {
+		json.Unmarshal(content, &stats)
+	}
+	return stats
+}
+
+func (c *Cache) saveStats(stats Stats) error {
+	content, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dirpath, "stats.json"), content)
+}
+
+func fileSize(filepath string) int64 {
+	if info, err := os.Stat(filepath); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+func copyFile(srcFilepath string, dstFilepath string) error {
+	content, err := os.ReadFile(srcFilepath)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dstFilepath, content)
+}
+
+// writeFileAtomic writes the file next to its destination and then renames it, so that
+// a concurrently running build never sees a partially written file.
+func writeFileAtomic(dstFilepath string, content []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(dstFilepath), filepath.Base(dstFilepath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil || f.Chmod(0644) != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write %q", dstFilepath)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), dstFilepath); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
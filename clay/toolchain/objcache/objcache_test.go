@@ -0,0 +1,131 @@
+package objcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheStoreAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := Open(filepath.Join(dir, "cache"), DefaultMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcFilepath := filepath.Join(dir, "src", "test.cpp")
+	hdrFilepath := filepath.Join(dir, "src", "test.h")
+	writeTestFile(t, srcFilepath, "#include \"test.h\"\n")
+	writeTestFile(t, hdrFilepath, "int test();\n")
+
+	// Compile 'test.cpp' in the debug build directory
+	objFilepath := filepath.Join(dir, "build", "debug", "test.cpp.o")
+	depFilepath := filepath.Join(dir, "build", "debug", "test.cpp.d")
+	writeTestFile(t, objFilepath, "object")
+	writeTestFile(t, depFilepath, objFilepath+": "+srcFilepath+" "+hdrFilepath+"\n")
+
+	key := cache.Key([]byte("compiler"), srcFilepath)
+	if _, _, ok := cache.Restore(key, objFilepath, depFilepath); ok {
+		t.Fatalf("Expected a cache miss for an empty cache")
+	}
+	output := "test.h:1:5: warning: old-style declaration\n"
+	if err := cache.Store(key, objFilepath, depFilepath, []string{srcFilepath, hdrFilepath}, output); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another build directory restores the object file and dependency file
+	otherObjFilepath := filepath.Join(dir, "build", "debug-dev", "test.cpp.o")
+	otherDepFilepath := filepath.Join(dir, "build", "debug-dev", "test.cpp.d")
+	os.MkdirAll(filepath.Dir(otherObjFilepath), os.ModePerm)
+	deps, restoredOutput, ok := cache.Restore(key, otherObjFilepath, otherDepFilepath)
+	if !ok {
+		t.Fatalf("Expected a cache hit")
+	}
+	if restoredOutput != output {
+		t.Errorf("Expected the compiler output of the result, got %q", restoredOutput)
+	}
+	if len(deps) != 2 || deps[1] != hdrFilepath {
+		t.Errorf("Expected the dependencies of the result, got %v", deps)
+	}
+	if content, _ := os.ReadFile(otherObjFilepath); string(content) != "object" {
+		t.Errorf("Expected the object file to be restored, got %q", string(content))
+	}
+	if content, _ := os.ReadFile(otherDepFilepath); string(content) != otherObjFilepath+": "+srcFilepath+" "+hdrFilepath+"\n" {
+		t.Errorf("Expected the dependency file to refer to the restored object file, got %q", string(content))
+	}
+
+	// A different compiler or command-line is a miss
+	if _, _, ok := cache.Restore(cache.Key([]byte("other compiler"), srcFilepath), otherObjFilepath, otherDepFilepath); ok {
+		t.Errorf("Expected a cache miss for a different compiler key")
+	}
+
+	// A changed header file is a miss (digests are computed once per build, so reopen)
+	writeTestFile(t, hdrFilepath, "int test(int);\n")
+	cache, _ = Open(filepath.Join(dir, "cache"), DefaultMaxSize)
+	if _, _, ok := cache.Restore(key, otherObjFilepath, otherDepFilepath); ok {
+		t.Errorf("Expected a cache miss after changing a dependency")
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, numFiles := cache.Stats()
+	if stats.Misses != 1 || numFiles != 3 {
+		t.Errorf("Expected 1 miss and 3 files, got %d misses and %d files", stats.Misses, numFiles)
+	}
+
+	if err := cache.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, numFiles := cache.Stats(); numFiles != 0 {
+		t.Errorf("Expected an empty cache after clear, got %d files", numFiles)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := Open(filepath.Join(dir, "cache"), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 8 {
+		srcFilepath := filepath.Join(dir, "src", string(rune('a'+i))+".cpp")
+		objFilepath := filepath.Join(dir, "build", string(rune('a'+i))+".cpp.o")
+		depFilepath := filepath.Join(dir, "build", string(rune('a'+i))+".cpp.d")
+		writeTestFile(t, srcFilepath, srcFilepath)
+		writeTestFile(t, objFilepath, string(make([]byte, 512)))
+		writeTestFile(t, depFilepath, objFilepath+": "+srcFilepath+"\n")
+		if err := cache.Store(cache.Key([]byte("compiler"), srcFilepath), objFilepath, depFilepath, []string{srcFilepath}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, _ := cache.Stats()
+	if stats.Size > 1024 || stats.Evictions == 0 {
+		t.Errorf("Expected the cache to be evicted below 1024 bytes, size is %d with %d evictions", stats.Size, stats.Evictions)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for value, expected := range map[string]int64{"100": 100, "2K": 2048, "5M": 5 * 1024 * 1024, "1GB": 1024 * 1024 * 1024} {
+		if size, err := ParseSize(value); err != nil || size != expected {
+			t.Errorf("ParseSize(%q) = %d, %v, expected %d", value, size, err, expected)
+		}
+	}
+	if _, err := ParseSize("lots"); err == nil {
+		t.Errorf("Expected an error for an invalid size")
+	}
+}
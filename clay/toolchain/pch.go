@@ -0,0 +1,98 @@
+package toolchain
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// Pch is the precompiled header of a project, see Compiler.SetupPch. It is compiled with
+// Compiler.Compile like a source file, before the source files that use it.
+type Pch struct {
+	HeaderAbsFilepath string // The header that is precompiled
+	SrcAbsFilepath    string // The file to compile, the header itself or a generated source file (msvc)
+	ObjRelFilepath    string // The precompiled header (gcc, clang) or the object file created with it (msvc)
+	DepRelFilepath    string // The dependency file that is written when compiling SrcAbsFilepath
+	Link              bool   // ObjRelFilepath is an object file that needs to be linked (msvc)
+}
+
+// UsedBy returns true when the source file uses the precompiled header, the header is
+// precompiled as C++ and is thus only used by C++ source files.
+func (p *Pch) UsedBy(sourceAbsFilepath string) bool {
+	switch filepath.Ext(sourceAbsFilepath) {
+	case ".c", ".m", ".mm":
+		return false
+	}
+	return true
+}
+
+// pchDirpath returns the directory in the build directory of the project that holds the
+// precompiled header and the files generated for it.
+func pchDirpath(buildPath string) string {
+	return filepath.Join(buildPath, "pch")
+}
+
+// newGccPch returns the precompiled header of gcc and clang, 'pch.h' is compiled into
+// '<build>/pch/pch.h.gch' (gcc) or '<build>/pch/pch.h.pch' (clang). What is compiled is
+// the generated header '<build>/pch/pch.h' that includes the real header, compiling the
+// real header would warn about '#pragma once' in the main file. Gcc uses the precompiled
+// header when this generated header is force-included, and falls back to parsing it when
+// the precompiled header is unusable.
+func newGccPch(headerAbsFilepath string, buildPath string, clang bool) *Pch {
+	includeFilepath := filepath.Join(pchDirpath(buildPath), filepath.Base(headerAbsFilepath))
+	if err := WriteGeneratedFile(includeFilepath, "#include \""+filepath.ToSlash(headerAbsFilepath)+"\"\n"); err != nil {
+		corepkg.LogErrorf(err, "Failed to write %q", includeFilepath)
+		return nil
+	}
+
+	srcAbsFilepath, err := filepath.Abs(includeFilepath)
+	if err != nil {
+		srcAbsFilepath = includeFilepath
+	}
+	ext := ".gch"
+	if clang {
+		ext = ".pch"
+	}
+	return &Pch{
+		HeaderAbsFilepath: headerAbsFilepath,
+		SrcAbsFilepath:    srcAbsFilepath,
+		ObjRelFilepath:    includeFilepath + ext,
+		DepRelFilepath:    includeFilepath + ".d",
+	}
+}
+
+// gccPchIncludeFilepath returns the header that is force-included to use the precompiled
+// header of gcc, which is the precompiled header without the '.gch' extension.
+func gccPchIncludeFilepath(pch *Pch) string {
+	return strings.TrimSuffix(pch.ObjRelFilepath, filepath.Ext(pch.ObjRelFilepath))
+}
+
+// gccPchArgs returns the arguments that compile a source file with the precompiled header,
+// or that compile the precompiled header itself.
+func gccPchArgs(pch *Pch, clang bool, sourceAbsFilepath string) []string {
+	if pch == nil || !pch.UsedBy(sourceAbsFilepath) {
+		return nil
+	}
+	if sourceAbsFilepath == pch.SrcAbsFilepath {
+		return []string{"-x", "c++-header"}
+	}
+	if clang {
+		return []string{"-include-pch", pch.ObjRelFilepath}
+	}
+	return []string{"-Winvalid-pch", "-include", gccPchIncludeFilepath(pch)}
+}
+
+// WriteGeneratedFile writes a generated file when its content differs, so that the
+// modification time only changes when the content changes.
+func WriteGeneratedFile(path string, content string) error {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, []byte(content)) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
@@ -0,0 +1,89 @@
+package toolchain
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+func TestPchUsedBy(t *testing.T) {
+	pch := &Pch{HeaderAbsFilepath: "/src/pch.h"}
+	for source, expected := range map[string]bool{"/src/main.cpp": true, "/src/lib.cc": true, "/src/lib.cxx": true, "/src/lib.c": false, "/src/view.m": false, "/src/view.mm": false} {
+		if usedBy := pch.UsedBy(source); usedBy != expected {
+			t.Errorf("expected the precompiled header to be used by %s = %v, got %v", source, expected, usedBy)
+		}
+	}
+}
+
+func TestGccPchArgs(t *testing.T) {
+	buildPath := t.TempDir()
+	headerAbsFilepath := filepath.Join(t.TempDir(), "pch.h")
+
+	gccPch := newGccPch(headerAbsFilepath, buildPath, false)
+	if gccPch == nil {
+		t.Fatal("expected a precompiled header")
+	}
+	includeFilepath := filepath.Join(buildPath, "pch", "pch.h")
+	if content, err := os.ReadFile(includeFilepath); err != nil || string(content) != "#include \""+filepath.ToSlash(headerAbsFilepath)+"\"\n" {
+		t.Errorf("expected the generated header to include the real header, got %q (%v)", content, err)
+	}
+	if gccPch.ObjRelFilepath != includeFilepath+".gch" || gccPch.DepRelFilepath != includeFilepath+".d" {
+		t.Errorf("expected the precompiled header %s.gch, got %s and %s", includeFilepath, gccPch.ObjRelFilepath, gccPch.DepRelFilepath)
+	}
+	clangPch := newGccPch(headerAbsFilepath, buildPath, true)
+	if clangPch.ObjRelFilepath != includeFilepath+".pch" {
+		t.Errorf("expected the precompiled header %s.pch, got %s", includeFilepath, clangPch.ObjRelFilepath)
+	}
+
+	tests := []struct {
+		pch      *Pch
+		clang    bool
+		source   string
+		expected []string
+	}{
+		{gccPch, false, gccPch.SrcAbsFilepath, []string{"-x", "c++-header"}},
+		{gccPch, false, "/src/main.cpp", []string{"-Winvalid-pch", "-include", includeFilepath}},
+		{gccPch, false, "/src/lib.c", nil},
+		{clangPch, true, clangPch.SrcAbsFilepath, []string{"-x", "c++-header"}},
+		{clangPch, true, "/src/main.cpp", []string{"-include-pch", includeFilepath + ".pch"}},
+		{clangPch, true, "/src/lib.c", nil},
+		{nil, false, "/src/main.cpp", nil},
+	}
+	for _, test := range tests {
+		if args := gccPchArgs(test.pch, test.clang, test.source); !slices.Equal(args, test.expected) {
+			t.Errorf("clang=%v %s: expected %v, got %v", test.clang, test.source, test.expected, args)
+		}
+	}
+}
+
+func TestMsdevPchArgs(t *testing.T) {
+	buildPath := t.TempDir()
+	headerAbsFilepath := filepath.Join(t.TempDir(), "pch.h")
+	cl := &WinMsdevCompiler{
+		toolChain:       &WinMsdev{},
+		objFileSuffix:   ".obj",
+		cCompilerArgs:   corepkg.NewArguments(0),
+		cppCompilerArgs: corepkg.NewArguments(0),
+	}
+	pch := cl.SetupPch(headerAbsFilepath, buildPath)
+	if pch == nil || !pch.Link {
+		t.Fatalf("expected a precompiled header with an object file to link, got %+v", pch)
+	}
+	pchFilepath := filepath.Join(buildPath, "pch", "pch.h.pch")
+
+	_, createArgs := cl.commandLine(pch.SrcAbsFilepath, pch.ObjRelFilepath)
+	if !slices.Contains(createArgs, "/Yc"+headerAbsFilepath) || !slices.Contains(createArgs, "/Fp"+pchFilepath) {
+		t.Errorf("expected the precompiled header to be created with /Yc, got %v", createArgs)
+	}
+	_, useArgs := cl.commandLine("/src/main.cpp", "main.cpp.obj")
+	if !slices.Contains(useArgs, "/Yu"+headerAbsFilepath) || !slices.Contains(useArgs, "/FI"+headerAbsFilepath) || !slices.Contains(useArgs, "/Fp"+pchFilepath) {
+		t.Errorf("expected a C++ source file to use the precompiled header with /Yu, got %v", useArgs)
+	}
+	_, cArgs := cl.commandLine("/src/lib.c", "lib.c.obj")
+	if slices.ContainsFunc(cArgs, func(arg string) bool { return arg == "/Yu"+headerAbsFilepath || arg == "/FI"+headerAbsFilepath }) {
+		t.Errorf("expected a C source file not to use the precompiled header, got %v", cArgs)
+	}
+}
@@ -0,0 +1,25 @@
+package toolchain
+
+import "slices"
+
+// PositionIndependentSupport is implemented by the toolchains whose compiler needs to be told
+// to compile position independent code, which is the case for the source files of a shared
+// library and of the static libraries that are linked into it.
+type PositionIndependentSupport interface {
+	// SetPositionIndependent makes the compiler compile position independent code whatever the
+	// flags of the package are. With hiddenVisibility (a shared library) a symbol is only
+	// exported when its declaration says so, e.g. depending on the <NAME>_EXPORTS define.
+	SetPositionIndependent(hiddenVisibility bool)
+}
+
+// gccPositionIndependentArgs appends the arguments of gcc and clang to compile position
+// independent code, and to hide the symbols that are not exported, unless already present.
+func gccPositionIndependentArgs(args []string, positionIndependent bool, hiddenVisibility bool) []string {
+	if positionIndependent && !slices.Contains(args, "-fPIC") {
+		args = append(args, "-fPIC")
+	}
+	if hiddenVisibility && !slices.Contains(args, "-fvisibility=hidden") {
+		args = append(args, "-fvisibility=hidden")
+	}
+	return args
+}
@@ -0,0 +1,270 @@
+package toolchain
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/events"
+	"github.com/jurgen-kluft/ccode/clay/toolchain/worker"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+// RemoteCompiler compiles the source files of a compiler on the workers of a pool (clay
+// build --workers). A source file is preprocessed locally, which also writes its dependency
+// file, and the preprocessed source file is compiled on a worker. Every source file that
+// cannot be compiled remotely, e.g. the precompiled header, a file that is compiled with
+// coverage or by a compiler that is not gcc or clang, or that could not be sent to a worker,
+// is compiled locally by the compiler itself. A compile error on a worker is not retried locally.
+type RemoteCompiler struct {
+	Compiler
+	pool        *worker.Pool
+	projectName string
+	buildConfig denv.BuildConfig
+}
+
+// NewRemoteCompiler returns the compiler that compiles on the workers of the pool, setup
+// (SetupArgs, SetupPch) is done on the compiler itself.
+func NewRemoteCompiler(compiler Compiler, pool *worker.Pool, projectName string, buildConfig denv.BuildConfig) *RemoteCompiler {
+	return &RemoteCompiler{Compiler: compiler, pool: pool, projectName: projectName, buildConfig: buildConfig}
+}
+
+// compilerVersions caches the version of every compiler executable, it is sent with every
+// compile request so that a worker only compiles with the same compiler.
+var compilerVersions sync.Map
+
+func compilerVersion(compilerPath string) (string, error) {
+	if version, ok := compilerVersions.Load(compilerPath); ok {
+		return version.(string), nil
+	}
+	version, err := worker.CompilerVersion(compilerPath)
+	if err != nil {
+		return "", err
+	}
+	compilerVersions.Store(compilerPath, version)
+	return version, nil
+}
+
+// remoteCompileJob is a source file that is preprocessed locally and compiled on a worker
+type remoteCompileJob struct {
+	srcFilepath    string
+	objFilepath    string
+	compilerPath   string
+	preprocessArgs []string
+	request        *worker.CompileRequest
+}
+
+// Compile compiles the source files on the workers, bounded by the global job budget, and
+// then compiles the source files that could not be compiled remotely with the compiler itself.
+func (rc *RemoteCompiler) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
+	compiled := make([]bool, len(sourceAbsFilepaths))
+	local := make([]bool, len(sourceAbsFilepaths))
+	jobs := make([]*remoteCompileJob, len(sourceAbsFilepaths))
+	for i, sourceAbsFilepath := range sourceAbsFilepaths {
+		jobs[i] = rc.newJob(sourceAbsFilepath, objRelFilepaths[i])
+		local[i] = jobs[i] == nil
+	}
+
+	slots := jobSlots
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, job *remoteCompileJob) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			compiled[i], local[i] = rc.compileRemote(job)
+		}(i, job)
+	}
+	wg.Wait()
+
+	localSourceFilepaths := []string{}
+	localObjFilepaths := []string{}
+	localIndices := []int{}
+	for i := range compiled {
+		if local[i] {
+			localSourceFilepaths = append(localSourceFilepaths, sourceAbsFilepaths[i])
+			localObjFilepaths = append(localObjFilepaths, objRelFilepaths[i])
+			localIndices = append(localIndices, i)
+		}
+	}
+	if len(localIndices) > 0 {
+		localCompiled, _ := rc.Compiler.Compile(localSourceFilepaths, localObjFilepaths)
+		for j, i := range localIndices {
+			compiled[i] = localCompiled[j]
+		}
+	}
+	return compiled, !slices.Contains(compiled, false)
+}
+
+// newJob returns the remote compile job of a source file, nil when it can only be compiled locally
+func (rc *RemoteCompiler) newJob(sourceAbsFilepath string, objRelFilepath string) *remoteCompileJob {
+	language := "c++"
+	switch strings.ToLower(filepath.Ext(sourceAbsFilepath)) {
+	case ".c":
+		language = "c"
+	case ".cpp", ".cc", ".cxx", ".c++":
+	default:
+		return nil
+	}
+
+	compilerPath, compilerArgs := rc.Compiler.CompileCommand(sourceAbsFilepath, objRelFilepath)
+	preprocessArgs, remoteArgs, ok := splitRemoteCompileArgs(compilerArgs, sourceAbsFilepath, objRelFilepath)
+	if !ok {
+		return nil
+	}
+	version, err := compilerVersion(compilerPath)
+	if err != nil {
+		return nil
+	}
+	workingDirpath, _ := os.Getwd()
+
+	return &remoteCompileJob{
+		srcFilepath:    sourceAbsFilepath,
+		objFilepath:    objRelFilepath,
+		compilerPath:   compilerPath,
+		preprocessArgs: preprocessArgs,
+		request: &worker.CompileRequest{
+			Compiler:        filepath.Base(compilerPath),
+			CompilerVersion: version,
+			Args:            remoteArgs,
+			Language:        language,
+			Dirpath:         workingDirpath,
+		},
+	}
+}
+
+// compileRemote preprocesses the source file and compiles it on a worker, it returns whether
+// the source file compiled and whether it has to be compiled locally instead, which is only
+// the case when it could not be preprocessed or a worker could not be used. A compile error
+// on a worker is a failed compile, like it is when compiling locally.
+func (rc *RemoteCompiler) compileRemote(job *remoteCompileJob) (compiled bool, local bool) {
+	if !rc.pool.Available() {
+		return false, true
+	}
+
+	compileEvent := &events.Event{Kind: events.CompileStart, Project: rc.projectName, File: job.srcFilepath}
+	events.Emit(compileEvent)
+
+	var reply *worker.CompileReply
+	var address string
+	cmd := exec.Command(job.compilerPath, job.preprocessArgs...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	source, err := cmd.Output()
+	if err == nil {
+		job.request.Source = source
+		if reply, address, err = rc.pool.Compile(job.request); err == nil && reply.ExitCode == 0 {
+			err = os.WriteFile(job.objFilepath, reply.Object, 0644)
+		}
+	}
+
+	compileEndEvent := compileEvent.End(nil)
+	compileEndEvent.Worker = address
+	if err != nil {
+		compileEndEvent.Status = events.StatusFallback
+		compileEndEvent.Message = err.Error()
+		events.Emit(compileEndEvent)
+		return false, true
+	}
+	output := stderr.String() + reply.Output
+	if reply.ExitCode != 0 {
+		compileEndEvent.Status = events.StatusFailed
+		compileEndEvent.ExitCode = reply.ExitCode
+		compileEndEvent.Message = fmt.Sprintf("exit code %d", reply.ExitCode)
+	}
+	if len(output) > 0 {
+		compileEndEvent.Message = output
+	}
+	events.Emit(compileEndEvent)
+
+	jobLogMutex.Lock()
+	defer jobLogMutex.Unlock()
+	corepkg.LogInfof("Compiling (%s) %s on %s", rc.buildConfig.String(), filepath.Base(job.srcFilepath), address)
+	if reply.ExitCode != 0 {
+		corepkg.LogInfof("Compile failed for %s, output:\n%s", filepath.Base(job.srcFilepath), output)
+		return false, false
+	}
+	if len(output) > 0 {
+		corepkg.LogInfof("Compile output:\n%s", output)
+	}
+	return true, false
+}
+
+// splitRemoteCompileArgs splits the arguments that compile the source file into the object
+// file into the arguments that preprocess the source file locally (to stdout) and the arguments
+// that compile the preprocessed source file on a worker. It returns false when the source
+// file cannot be compiled remotely, e.g. when the compiler writes files next to the object file.
+func splitRemoteCompileArgs(compilerArgs []string, sourceAbsFilepath string, objRelFilepath string) (preprocessArgs []string, remoteArgs []string, ok bool) {
+	// Preprocessor arguments that are followed by a value
+	preprocessorValueArgs := []string{"-I", "-D", "-U", "-include", "-imacros", "-isystem", "-iquote", "-idirafter", "-isysroot", "-MF", "-MT", "-MQ"}
+	// Preprocessor arguments with the value attached
+	preprocessorPrefixArgs := []string{"-I", "-D", "-U", "-isystem", "-iquote", "-idirafter", "-M", "--sysroot"}
+	// Arguments that produce more than the object file, or that a worker cannot handle
+	localArgs := []string{"-x", "-include-pch", "--coverage", "-fprofile-", "-fcoverage-", "-ftime-trace", "-save-temps", "@"}
+
+	hasSource, hasOutput, hasCompile := false, false, false
+	hasDepFile, hasDepTarget, writesDepFile := false, false, false
+	for i := 0; i < len(compilerArgs); i++ {
+		arg := compilerArgs[i]
+		switch {
+		case arg == sourceAbsFilepath:
+			hasSource = true
+		case arg == "-o":
+			if i+1 >= len(compilerArgs) || compilerArgs[i+1] != objRelFilepath {
+				return nil, nil, false
+			}
+			hasOutput = true
+			i++
+		case arg == "-c":
+			hasCompile = true
+			remoteArgs = append(remoteArgs, arg)
+		case slices.ContainsFunc(localArgs, func(prefix string) bool { return strings.HasPrefix(arg, prefix) }):
+			return nil, nil, false
+		case slices.Contains(preprocessorValueArgs, arg):
+			if i+1 >= len(compilerArgs) {
+				return nil, nil, false
+			}
+			hasDepFile = hasDepFile || arg == "-MF"
+			hasDepTarget = hasDepTarget || arg == "-MT" || arg == "-MQ"
+			preprocessArgs = append(preprocessArgs, arg, compilerArgs[i+1])
+			i++
+		case slices.ContainsFunc(preprocessorPrefixArgs, func(prefix string) bool { return strings.HasPrefix(arg, prefix) }):
+			hasDepFile = hasDepFile || strings.HasPrefix(arg, "-MF")
+			hasDepTarget = hasDepTarget || strings.HasPrefix(arg, "-MT") || strings.HasPrefix(arg, "-MQ")
+			writesDepFile = writesDepFile || arg == "-MD" || arg == "-MMD"
+			preprocessArgs = append(preprocessArgs, arg)
+		case !worker.AllowedArg(arg):
+			// A worker rejects it, e.g. an argument that names a file or a program
+			return nil, nil, false
+		default:
+			preprocessArgs = append(preprocessArgs, arg)
+			remoteArgs = append(remoteArgs, arg)
+		}
+	}
+	if !hasSource || !hasOutput || !hasCompile {
+		return nil, nil, false
+	}
+
+	// The dependency file is written where the compiler writes it when compiling, with the
+	// object file as its target, 'file.cpp.o' results in 'file.cpp.d'.
+	if writesDepFile && !hasDepFile {
+		preprocessArgs = append(preprocessArgs, "-MF", strings.TrimSuffix(objRelFilepath, filepath.Ext(objRelFilepath))+".d")
+	}
+	if writesDepFile && !hasDepTarget {
+		preprocessArgs = append(preprocessArgs, "-MT", objRelFilepath)
+	}
+	preprocessArgs = append(preprocessArgs, "-E", sourceAbsFilepath)
+	return preprocessArgs, remoteArgs, true
+}
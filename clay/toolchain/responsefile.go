@@ -0,0 +1,123 @@
+package toolchain
+
+import (
+	"os"
+	"strings"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// ResponseFileSyntax is how a tool reads the arguments from an '@file' response file
+type ResponseFileSyntax int
+
+const (
+	ResponseFileNone ResponseFileSyntax = iota // The tool does not support response files (e.g. the ar of macOS)
+	ResponseFileGnu                            // gcc, clang, ar and ld, a '\' escapes any character
+	ResponseFileMsvc                           // cl, lib and link, quoted like a Windows command-line
+)
+
+// DefaultResponseFileThreshold is the length of a command-line above which the arguments are
+// passed in a response file, well below the 32K characters that Windows allows.
+const DefaultResponseFileThreshold = 8192
+
+// ResponseFiles configures when the compilers, archivers and linkers of a toolchain pass
+// their arguments in a response file instead of on the command-line.
+type ResponseFiles struct {
+	Always    bool // Always use a response file, e.g. for the hundreds of include directories of an SDK
+	Threshold int  // Use a response file when the command-line is longer (0 = DefaultResponseFileThreshold)
+}
+
+// ResponseFilepath returns the response file that the tool producing the output is run with,
+// e.g. 'file.cpp.o.rsp' for 'file.cpp.o'. The response file only exists when it is used.
+func ResponseFilepath(outputFilepath string) string {
+	return outputFilepath + ".rsp"
+}
+
+// toolArgs returns the arguments to run the tool with, which produces the output. When the
+// command-line is too long (or always) these are '@<response file>' and the arguments are
+// written to the response file of the output, otherwise these are the arguments themselves
+// and an earlier response file of the output is removed. The response file is only written
+// when its content changes, so that it does not make the output out-of-date.
+func (r ResponseFiles) toolArgs(syntax ResponseFileSyntax, toolPath string, args []string, outputFilepath string) []string {
+	responseFilepath := ResponseFilepath(outputFilepath)
+	if syntax == ResponseFileNone || !r.needed(toolPath, args) {
+		if err := os.Remove(responseFilepath); err != nil && !os.IsNotExist(err) {
+			corepkg.LogErrorf(err, "Failed to remove response file %q", responseFilepath)
+		}
+		return args
+	}
+
+	content := &strings.Builder{}
+	for _, arg := range args {
+		content.WriteString(quoteResponseFileArg(syntax, arg))
+		content.WriteByte('\n')
+	}
+	if err := WriteGeneratedFile(responseFilepath, content.String()); err != nil {
+		corepkg.LogErrorf(err, "Failed to write response file %q, passing the arguments on the command-line", responseFilepath)
+		return args
+	}
+	return []string{"@" + responseFilepath}
+}
+
+// needed returns true when the arguments have to be passed in a response file
+func (r ResponseFiles) needed(toolPath string, args []string) bool {
+	if r.Always {
+		return true
+	}
+	threshold := r.Threshold
+	if threshold <= 0 {
+		threshold = DefaultResponseFileThreshold
+	}
+	length := len(toolPath)
+	for _, arg := range args {
+		length += 1 + len(arg)
+	}
+	return length > threshold
+}
+
+// quoteResponseFileArg quotes an argument, so that the tool reads it back as a single
+// argument from a response file of the syntax.
+func quoteResponseFileArg(syntax ResponseFileSyntax, arg string) string {
+	if len(arg) == 0 {
+		return `""`
+	}
+	switch syntax {
+	case ResponseFileGnu:
+		if !strings.ContainsAny(arg, " \t\r\n'\"\\") {
+			return arg
+		}
+		quoted := &strings.Builder{}
+		for _, c := range arg {
+			if strings.ContainsRune(" \t\r\n'\"\\", c) {
+				quoted.WriteByte('\\')
+			}
+			quoted.WriteRune(c)
+		}
+		return quoted.String()
+	case ResponseFileMsvc:
+		if !strings.ContainsAny(arg, " \t\r\n\"") {
+			return arg
+		}
+		// Backslashes are literal, unless they precede a '"' (or the closing '"'), then they
+		// are doubled and the '"' is escaped
+		quoted := &strings.Builder{}
+		quoted.WriteByte('"')
+		backslashes := 0
+		for _, c := range arg {
+			switch c {
+			case '\\':
+				backslashes++
+			case '"':
+				quoted.WriteString(strings.Repeat(`\`, backslashes+1))
+				backslashes = 0
+			default:
+				backslashes = 0
+			}
+			quoted.WriteRune(c)
+		}
+		quoted.WriteString(strings.Repeat(`\`, backslashes))
+		quoted.WriteByte('"')
+		return quoted.String()
+	}
+	return arg
+}
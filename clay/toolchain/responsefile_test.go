@@ -0,0 +1,91 @@
+package toolchain
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuoteResponseFileArg(t *testing.T) {
+	tests := []struct {
+		syntax   ResponseFileSyntax
+		arg      string
+		expected string
+	}{
+		{ResponseFileGnu, "", `""`},
+		{ResponseFileGnu, "-DNDEBUG", `-DNDEBUG`},
+		{ResponseFileGnu, "src dir/main.cpp", `src\ dir/main.cpp`},
+		{ResponseFileGnu, `-DMSG="a 'b'"`, `-DMSG=\"a\ \'b\'\"`},
+		{ResponseFileGnu, `C:\src\main.cpp`, `C:\\src\\main.cpp`},
+		{ResponseFileGnu, "a\tb", "a\\\tb"},
+		{ResponseFileMsvc, "", `""`},
+		{ResponseFileMsvc, `/IC:\sdk\include`, `/IC:\sdk\include`},
+		{ResponseFileMsvc, `C:\src dir\main.cpp`, `"C:\src dir\main.cpp"`},
+		{ResponseFileMsvc, `/DMSG="a b"`, `"/DMSG=\"a b\""`},
+		{ResponseFileMsvc, `/DPATH=C:\a\"b c`, `"/DPATH=C:\a\\\"b c"`},
+		{ResponseFileMsvc, `C:\src dir\`, `"C:\src dir\\"`},
+		{ResponseFileMsvc, `C:\src dir\\`, `"C:\src dir\\\\"`},
+		{ResponseFileNone, "a b", "a b"},
+	}
+	for _, test := range tests {
+		if quoted := quoteResponseFileArg(test.syntax, test.arg); quoted != test.expected {
+			t.Errorf("syntax %d: expected %q to be quoted as %q, got %q", test.syntax, test.arg, test.expected, quoted)
+		}
+	}
+}
+
+func TestResponseFilesToolArgs(t *testing.T) {
+	outputFilepath := filepath.Join(t.TempDir(), "main.cpp.o")
+	responseFilepath := ResponseFilepath(outputFilepath)
+	args := []string{"-c", "-DMSG=\"a b\"", "-o", outputFilepath, "main.cpp"}
+
+	// A short command-line is passed as it is
+	r := ResponseFiles{}
+	if toolArgs := r.toolArgs(ResponseFileGnu, "g++", args, outputFilepath); !slices.Equal(toolArgs, args) {
+		t.Errorf("expected the arguments on the command-line, got %v", toolArgs)
+	}
+	if _, err := os.Stat(responseFilepath); !os.IsNotExist(err) {
+		t.Errorf("expected no response file, got %v", err)
+	}
+
+	// Above the threshold the arguments are written to the response file, one per line
+	r = ResponseFiles{Threshold: 16}
+	if toolArgs := r.toolArgs(ResponseFileGnu, "g++", args, outputFilepath); !slices.Equal(toolArgs, []string{"@" + responseFilepath}) {
+		t.Fatalf("expected the response file, got %v", toolArgs)
+	}
+	content, err := os.ReadFile(responseFilepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{"-c", `-DMSG=\"a\ b\"`, "-o", outputFilepath, "main.cpp"}, "\n") + "\n"
+	if string(content) != expected {
+		t.Errorf("expected the response file %q, got %q", expected, content)
+	}
+
+	// An unchanged response file is not rewritten, so that it does not make the output out-of-date
+	earlier := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(responseFilepath, earlier, earlier); err != nil {
+		t.Fatal(err)
+	}
+	r.toolArgs(ResponseFileGnu, "g++", args, outputFilepath)
+	if info, err := os.Stat(responseFilepath); err != nil || !info.ModTime().Equal(earlier) {
+		t.Errorf("expected the unchanged response file to keep its modification time, got %v (%v)", info.ModTime(), err)
+	}
+
+	// Always, also for a short command-line, unless the tool does not support response files
+	r = ResponseFiles{Always: true}
+	if toolArgs := r.toolArgs(ResponseFileMsvc, "cl.exe", []string{"/c"}, outputFilepath); !slices.Equal(toolArgs, []string{"@" + responseFilepath}) {
+		t.Errorf("expected the response file, got %v", toolArgs)
+	}
+	if toolArgs := r.toolArgs(ResponseFileNone, "ar", args, outputFilepath); !slices.Equal(toolArgs, args) {
+		t.Errorf("expected the arguments on the command-line for a tool without response files, got %v", toolArgs)
+	}
+
+	// The response file of an earlier build is removed when it is no longer used
+	if _, err := os.Stat(responseFilepath); !os.IsNotExist(err) {
+		t.Errorf("expected the response file to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package toolchain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Sanitizer is a runtime error detector that the source files are compiled with and the
+// executables and shared libraries are linked with.
+type Sanitizer string
+
+const (
+	SanitizerAddress   Sanitizer = "address"   // AddressSanitizer, memory errors and leaks
+	SanitizerUndefined Sanitizer = "undefined" // UndefinedBehaviorSanitizer
+	SanitizerThread    Sanitizer = "thread"    // ThreadSanitizer, data races
+)
+
+// sanitizerSuffixes are the names of the sanitizers in the name of a build directory
+var sanitizerSuffixes = map[Sanitizer]string{
+	SanitizerAddress:   "asan",
+	SanitizerUndefined: "ubsan",
+	SanitizerThread:    "tsan",
+}
+
+// SanitizerSupport is implemented by the toolchains that can build with sanitizers
+type SanitizerSupport interface {
+	// SetSanitizers makes the compilers, archivers and linkers of the toolchain build with
+	// the sanitizers, it returns an error when a sanitizer is not supported.
+	SetSanitizers(sanitizers []Sanitizer) error
+}
+
+// ParseSanitizers parses a comma separated list of sanitizers, e.g. 'address,undefined'.
+// The result is sorted and has no duplicates, so that a combination of sanitizers always
+// results in the same build directory.
+func ParseSanitizers(value string) ([]Sanitizer, error) {
+	sanitizers := []Sanitizer{}
+	for _, name := range strings.Split(value, ",") {
+		sanitizer := Sanitizer(strings.ToLower(strings.TrimSpace(name)))
+		if len(sanitizer) == 0 {
+			continue
+		}
+		if _, ok := sanitizerSuffixes[sanitizer]; !ok {
+			return nil, fmt.Errorf("unknown sanitizer %q, expected address, undefined or thread", sanitizer)
+		}
+		if !slices.Contains(sanitizers, sanitizer) {
+			sanitizers = append(sanitizers, sanitizer)
+		}
+	}
+	if slices.Contains(sanitizers, SanitizerThread) && slices.Contains(sanitizers, SanitizerAddress) {
+		return nil, fmt.Errorf("the thread sanitizer cannot be combined with the address sanitizer")
+	}
+	slices.Sort(sanitizers)
+	return sanitizers, nil
+}
+
+// SanitizersSuffix returns the suffix of the build directory for a combination of
+// sanitizers, e.g. '-asan-ubsan', or an empty string without sanitizers.
+func SanitizersSuffix(sanitizers []Sanitizer) string {
+	suffix := ""
+	for _, sanitizer := range sanitizers {
+		suffix += "-" + sanitizerSuffixes[sanitizer]
+	}
+	return suffix
+}
+
+// gccSanitizeCompileArgs returns the arguments of gcc and clang to compile a source file
+// with the sanitizers, with debug information and frame pointers for symbolized stacks.
+func gccSanitizeCompileArgs(sanitizers []Sanitizer) []string {
+	if len(sanitizers) == 0 {
+		return nil
+	}
+	return append(gccSanitizeLinkArgs(sanitizers), "-fno-omit-frame-pointer", "-g")
+}
+
+// gccSanitizeLinkArgs returns the arguments of gcc and clang to link with the runtime
+// libraries of the sanitizers.
+func gccSanitizeLinkArgs(sanitizers []Sanitizer) []string {
+	if len(sanitizers) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(sanitizers))
+	for _, sanitizer := range sanitizers {
+		names = append(names, string(sanitizer))
+	}
+	return []string{"-fsanitize=" + strings.Join(names, ",")}
+}
@@ -0,0 +1,31 @@
+package toolchain
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// TimeTraceSupport is implemented by the toolchains whose compiler can write a trace of
+// where the time of compiling a source file goes (clay build --time-trace).
+type TimeTraceSupport interface {
+	// SetTimeTrace makes the compiler write a trace per source file, it returns an error
+	// when the compiler cannot.
+	SetTimeTrace() error
+}
+
+// clangTimeTraceArgs returns the argument of clang to write the time trace of a source file
+func clangTimeTraceArgs(enabled bool) []string {
+	if !enabled {
+		return nil
+	}
+	return []string{"-ftime-trace"}
+}
+
+// clangTimeTraceFilepath returns the trace that 'clang -ftime-trace' writes for an object
+// file, which is next to the object file with the '.json' extension instead of '.o'.
+func clangTimeTraceFilepath(enabled bool, objRelFilepath string) string {
+	if !enabled {
+		return ""
+	}
+	return strings.TrimSuffix(objRelFilepath, filepath.Ext(objRelFilepath)) + ".json"
+}
@@ -1,6 +1,8 @@
 package toolchain
 
 import (
+	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
@@ -12,8 +14,45 @@ import (
 )
 
 type DarwinClangv2 struct {
-	Name string
-	Vars *corepkg.Vars
+	Name       string
+	Vars       *corepkg.Vars
+	Sanitizers []Sanitizer // Compile and link with these sanitizers (clay build --sanitize)
+	Coverage   bool        // Compile and link with coverage instrumentation (clay coverage)
+	TimeTrace  bool        // Write a time trace per source file (clay build --time-trace)
+
+	ResponseFiles ResponseFiles // When to pass the arguments of a tool in a response file
+}
+
+// SetSanitizers builds with the sanitizers, clang supports all of them
+func (t *DarwinClangv2) SetSanitizers(sanitizers []Sanitizer) error {
+	t.Sanitizers = sanitizers
+	return nil
+}
+
+// SetTimeTrace makes clang write a time trace per source file
+func (t *DarwinClangv2) SetTimeTrace() error {
+	t.TimeTrace = true
+	return nil
+}
+
+// SetCoverage builds with the source-based coverage instrumentation of clang
+func (t *DarwinClangv2) SetCoverage() error {
+	t.Coverage = true
+	return nil
+}
+
+// NewCoverageCollector returns the collector that uses the llvm-profdata and llvm-cov of
+// Xcode, which can be overridden with the LLVM_PROFDATA and LLVM_COV environment variables.
+func (t *DarwinClangv2) NewCoverageCollector(buildPath string) CoverageCollector {
+	profdataCmd := []string{"xcrun", "llvm-profdata"}
+	if path := os.Getenv("LLVM_PROFDATA"); len(path) > 0 {
+		profdataCmd = []string{path}
+	}
+	covCmd := []string{"xcrun", "llvm-cov"}
+	if path := os.Getenv("LLVM_COV"); len(path) > 0 {
+		covCmd = []string{path}
+	}
+	return newLlvmCoverageCollector(profdataCmd, covCmd, buildPath)
 }
 
 // --------------------------------------------------------------------------------------------------
@@ -29,12 +68,14 @@ func (t *DarwinClangv2) NewFileCommander(buildConfig denv.BuildConfig, buildTarg
 
 type ToolchainDarwinClangCompilerv2 struct {
 	toolChain       *DarwinClangv2
+	projectName     string
 	buildConfig     denv.BuildConfig
 	buildTarget     denv.BuildTarget
 	cCompilerPath   string
 	cCompilerArgs   *corepkg.Arguments
 	cppCompilerPath string
 	cppCompilerArgs *corepkg.Arguments
+	pch             *Pch
 	vars            *corepkg.Vars
 }
 
@@ -60,6 +101,7 @@ func (cl *ToolchainDarwinClangCompilerv2) DepFilepath(objRelFilepath string) str
 }
 
 func (cl *ToolchainDarwinClangCompilerv2) SetupArgs(projectName string, buildPath string, _defines []string, _includes []string) {
+	cl.projectName = projectName
 	for i, inc := range _includes {
 		if !strings.HasPrefix(inc, "-I") {
 			_includes[i] = "-I" + inc
@@ -99,47 +141,69 @@ func (cl *ToolchainDarwinClangCompilerv2) SetupArgs(projectName string, buildPat
 	}
 }
 
+// SetupPch compiles the header into '<build>/pch/<header>.pch'
+func (cl *ToolchainDarwinClangCompilerv2) SetupPch(headerAbsFilepath string, buildPath string) *Pch {
+	cl.pch = newGccPch(headerAbsFilepath, buildPath, true)
+	return cl.pch
+}
+
+// commandLine returns the compiler and the fully resolved arguments to compile a source file
+func (cl *ToolchainDarwinClangCompilerv2) commandLine(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	if strings.HasSuffix(sourceAbsFilepath, ".c") {
+		compilerPath = cl.cCompilerPath
+		compilerArgs = slices.Clone(cl.cCompilerArgs.Args)
+	} else if strings.HasSuffix(sourceAbsFilepath, ".m") || strings.HasSuffix(sourceAbsFilepath, ".mm") {
+		// Remove the -std=c11 flag if it exists, as Objective-C does not support it
+		compilerPath = cl.cCompilerPath
+		compilerArgs = slices.Clone(cl.cCompilerArgs.Args)
+		compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.HasPrefix(s, "-std=") })
+		compilerArgs = append(compilerArgs, "-ObjC")
+	} else {
+		compilerPath = cl.cppCompilerPath
+		compilerArgs = slices.Clone(cl.cppCompilerArgs.Args)
+	}
+
+	compilerArgs = append(compilerArgs, gccSanitizeCompileArgs(cl.toolChain.Sanitizers)...)
+	compilerArgs = append(compilerArgs, gccCoverageCompileArgs(cl.toolChain.Coverage, true)...)
+	compilerArgs = append(compilerArgs, clangTimeTraceArgs(cl.toolChain.TimeTrace)...)
+	compilerArgs = append(compilerArgs, gccPchArgs(cl.pch, true, sourceAbsFilepath)...)
+
+	// TODO would like this to be part of the resolve step
+	compilerArgs = append(compilerArgs, "-o", objRelFilepath)
+	compilerArgs = append(compilerArgs, sourceAbsFilepath)
+	return compilerPath, compilerArgs
+}
+
+func (cl *ToolchainDarwinClangCompilerv2) ArgsHash(sourceAbsFilepath string, objRelFilepath string) []byte {
+	return argumentsHash(cl.commandLine(sourceAbsFilepath, objRelFilepath))
+}
+
+func (cl *ToolchainDarwinClangCompilerv2) CacheKey(sourceAbsFilepath string, objRelFilepath string) []byte {
+	compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepath)
+	return compilerCacheKey(compilerPath, compilerArgs, objRelFilepath)
+}
+
+func (cl *ToolchainDarwinClangCompilerv2) CompileCommand(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	return cl.commandLine(sourceAbsFilepath, objRelFilepath)
+}
+
 func (cl *ToolchainDarwinClangCompilerv2) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
-	errors := 0
-	compiled := make([]bool, len(sourceAbsFilepaths))
+	jobs := make([]*compileJob, 0, len(sourceAbsFilepaths))
 	for i, sourceAbsFilepath := range sourceAbsFilepaths {
-		var compilerPath string
-		var compilerArgs []string
-		if strings.HasSuffix(sourceAbsFilepath, ".c") {
-			compilerPath = cl.cCompilerPath
-			compilerArgs = slices.Clone(cl.cCompilerArgs.Args)
-		} else if strings.HasSuffix(sourceAbsFilepath, ".m") || strings.HasSuffix(sourceAbsFilepath, ".mm") {
-			// Remove the -std=c11 flag if it exists, as Objective-C does not support it
-			compilerPath = cl.cCompilerPath
-			compilerArgs = slices.Clone(cl.cCompilerArgs.Args)
-			compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.HasPrefix(s, "-std=") })
-			compilerArgs = append(compilerArgs, "-ObjC")
-		} else {
-			compilerPath = cl.cppCompilerPath
-			compilerArgs = slices.Clone(cl.cppCompilerArgs.Args)
-		}
-
-		// TODO would like this to be part of the resolve step
-		compilerArgs = append(compilerArgs, "-o", objRelFilepaths[i])
-		compilerArgs = append(compilerArgs, sourceAbsFilepath)
+		compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepaths[i])
 
 		//fmt.Printf("Compiler path %s and args %v\n", compilerPath, compilerArgs)
 
-		cmd := exec.Command(compilerPath, compilerArgs...)
-
-		corepkg.LogInfof("Compiling (%s) %s", cl.buildConfig.String(), filepath.Base(sourceAbsFilepath))
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			corepkg.LogInfof("Compile failed for %s, output:\n%s", filepath.Base(sourceAbsFilepath), string(out))
-			errors = errors + 1
-		} else {
-			if len(out) > 0 {
-				corepkg.LogInfof("Compile output:\n%s", string(out))
-			}
-			compiled[i] = true
-		}
+		jobs = append(jobs, &compileJob{
+			message:     fmt.Sprintf("Compiling (%s) %s", cl.buildConfig.String(), filepath.Base(sourceAbsFilepath)),
+			project:     cl.projectName,
+			srcFilepath: sourceAbsFilepath,
+			toolPath:    compilerPath,
+			toolArgs:    cl.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, compilerPath, compilerArgs, objRelFilepaths[i]),
+			timeTrace:   clangTimeTraceFilepath(cl.toolChain.TimeTrace, objRelFilepaths[i]),
+		})
 	}
-	return compiled, errors == 0
+	return runCompileJobs(jobs)
 }
 
 // --------------------------------------------------------------------------------------------------
@@ -179,6 +243,10 @@ func (t *ToolchainDarwinClangStaticArchiverv2) LibFilepath(_filepath string) str
 	return filepath.Join(dirpath, "lib"+filename+".a") // The file extension for the archive on Darwin is typically ".a"
 }
 
+func (t *ToolchainDarwinClangStaticArchiverv2) RuntimeFilepath(libFilepath string) string {
+	return ""
+}
+
 func (t *ToolchainDarwinClangStaticArchiverv2) SetupArgs() {
 	if archiver_args, ok := t.toolChain.Vars.Get(`recipe.ar.pattern`); ok {
 		t.arPath = archiver_args[0]
@@ -191,17 +259,29 @@ func (t *ToolchainDarwinClangStaticArchiverv2) SetupArgs() {
 	}
 }
 
-func (t *ToolchainDarwinClangStaticArchiverv2) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
-	archiverPath := t.arPath
-	archiverArgs := t.arArgs.Args
+// commandLine returns the archiver and the fully resolved arguments to create the archive
+func (t *ToolchainDarwinClangStaticArchiverv2) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (archiverPath string, archiverArgs []string) {
+	archiverPath = t.arPath
+	archiverArgs = slices.Clone(t.arArgs.Args)
 
 	// TODO would like this to be part of the resolve step
 	archiverArgs = append(archiverArgs, outputArchiveFilepath)
 	archiverArgs = append(archiverArgs, inputObjectFilepaths...)
+	return archiverPath, archiverArgs
+}
+
+func (t *ToolchainDarwinClangStaticArchiverv2) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(t.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
+
+func (t *ToolchainDarwinClangStaticArchiverv2) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
+	archiverPath, archiverArgs := t.commandLine(inputObjectFilepaths, outputArchiveFilepath)
 
-	cmd := exec.Command(archiverPath, archiverArgs...)
+	// The ar of macOS does not read response files
+	cmd := exec.Command(archiverPath, t.toolChain.ResponseFiles.toolArgs(ResponseFileNone, archiverPath, archiverArgs, outputArchiveFilepath)...)
 
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
 
 	if err != nil {
 		return corepkg.LogErrorf(err, "Archiving failed: ", string(out))
@@ -216,27 +296,54 @@ func (t *ToolchainDarwinClangDynamicArchiverv2) LibFilepath(_filepath string) st
 	return filepath.Join(dirpath, "lib"+filename+".dylib")
 }
 
+func (t *ToolchainDarwinClangDynamicArchiverv2) RuntimeFilepath(libFilepath string) string {
+	return libFilepath
+}
+
+// SetupArgs uses 'recipe.dylib.pattern' when it is defined, otherwise the dynamic library
+// is linked by the C++ compiler driver of 'recipe.cpp.pattern'.
 func (t *ToolchainDarwinClangDynamicArchiverv2) SetupArgs() {
-	if archiver_args, ok := t.toolChain.Vars.Get(`recipe.ar.pattern`); ok {
+	t.arArgs = corepkg.NewArguments(0)
+	if archiver_args, ok := t.toolChain.Vars.Get(`recipe.dylib.pattern`); ok {
 		t.arPath = archiver_args[0]
-		t.arArgs = corepkg.NewArguments(0)
 		t.arArgs.Args = archiver_args[1:]
 
 		t.arPath = t.toolChain.Vars.FinalResolveString(t.arPath, " ")
 		t.arArgs.Args = t.toolChain.Vars.FinalResolveArray(t.arArgs.Args)
+
+		t.arArgs.Args = slices.DeleteFunc(t.arArgs.Args, func(s string) bool { return strings.TrimSpace(s) == "" })
+	} else if compiler_args, ok := t.toolChain.Vars.Get(`recipe.cpp.pattern`); ok {
+		t.arPath = t.toolChain.Vars.FinalResolveString(compiler_args[0], " ")
 	}
 }
 
-func (t *ToolchainDarwinClangDynamicArchiverv2) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
-	archiverPath := t.arPath
-	archiverArgs := t.arArgs.Args
+// commandLine returns the archiver and the fully resolved arguments to create the dynamic library
+func (t *ToolchainDarwinClangDynamicArchiverv2) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (archiverPath string, archiverArgs []string) {
+	archiverPath = t.arPath
+	archiverArgs = slices.Clone(t.arArgs.Args)
+
+	archiverArgs = append(archiverArgs, gccSanitizeLinkArgs(t.toolChain.Sanitizers)...)
+	archiverArgs = append(archiverArgs, gccCoverageLinkArgs(t.toolChain.Coverage, true)...)
 
 	// TODO would like this to be part of the resolve step
-	archiverArgs = append(archiverArgs, "-dynamiclib", "-o", outputArchiveFilepath)
+	archiverArgs = append(archiverArgs, "-dynamiclib", "-install_name", "@rpath/"+filepath.Base(outputArchiveFilepath))
+	archiverArgs = append(archiverArgs, "-o", outputArchiveFilepath)
 	archiverArgs = append(archiverArgs, inputObjectFilepaths...)
+	return archiverPath, archiverArgs
+}
+
+func (t *ToolchainDarwinClangDynamicArchiverv2) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(t.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
 
-	cmd := exec.Command(archiverPath, archiverArgs...)
+func (t *ToolchainDarwinClangDynamicArchiverv2) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
+	archiverPath, archiverArgs := t.commandLine(inputObjectFilepaths, outputArchiveFilepath)
+
+	corepkg.LogInff("Linking (%s) %s", t.buildConfig.String(), filepath.Base(outputArchiveFilepath))
+
+	cmd := exec.Command(archiverPath, t.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, archiverPath, archiverArgs, outputArchiveFilepath)...)
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
 
 	if err != nil {
 		return corepkg.LogErrorf(err, "Archiving failed")
@@ -308,26 +415,44 @@ func (l *ToolchainDarwinClangLinkerv2) SetupArgs(libraryPaths []string, libraryF
 	}
 }
 
-func (l *ToolchainDarwinClangLinkerv2) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error {
+// commandLine returns the linker and the fully resolved arguments to link the executable
+func (l *ToolchainDarwinClangLinkerv2) commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) (linkerPath string, linkerArgs []string) {
+	linkerPath = l.linkerPath
+	linkerArgs = slices.Clone(l.linkerArgs.Args)
 
-	linkerPath := l.linkerPath
-	linkerArgs := l.linkerArgs.Args
+	linkerArgs = append(linkerArgs, gccSanitizeLinkArgs(l.toolChain.Sanitizers)...)
+	linkerArgs = append(linkerArgs, gccCoverageLinkArgs(l.toolChain.Coverage, true)...)
 
 	// TODO would like this to be part of the resolve step
 	linkerArgs = append(linkerArgs, "-Wl,-map,"+outputAppRelFilepathNoExt+".map")
 	linkerArgs = append(linkerArgs, "-o", l.LinkedFilepath(outputAppRelFilepathNoExt))
 	linkerArgs = append(linkerArgs, inputObjectsAbsFilepaths...)
 	linkerArgs = append(linkerArgs, inputArchivesAbsFilepaths...)
+
+	// Dynamic libraries are copied next to the executable, their install name is '@rpath/<name>'
+	if slices.ContainsFunc(inputArchivesAbsFilepaths, func(s string) bool { return strings.HasSuffix(s, ".dylib") }) {
+		linkerArgs = append(linkerArgs, "-Wl,-rpath,@executable_path")
+	}
 	for _, libFile := range l.libraryFiles {
 		linkerArgs = append(linkerArgs, libFile)
 	}
+	return linkerPath, linkerArgs
+}
+
+func (l *ToolchainDarwinClangLinkerv2) ArgsHash(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) []byte {
+	return argumentsHash(l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt))
+}
+
+func (l *ToolchainDarwinClangLinkerv2) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error {
+	linkerPath, linkerArgs := l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt)
 
 	corepkg.LogInff("Linking (%s) %s", l.buildConfig.String(), filepath.Base(outputAppRelFilepathNoExt))
 
 	//corepkg.LogInfof("Linker command: %s %s", linkerPath, strings.Join(linkerArgs, " "))
 
-	cmd := exec.Command(linkerPath, linkerArgs...)
+	cmd := exec.Command(linkerPath, l.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, linkerPath, linkerArgs, outputAppRelFilepathNoExt)...)
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputAppRelFilepathNoExt, out)
 
 	if err != nil {
 		corepkg.LogInff("Link failed, output:\n%s", string(out))
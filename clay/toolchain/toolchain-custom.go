@@ -0,0 +1,647 @@
+package toolchain
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/deptrackr"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+// CustomToolchain is a toolchain that is described by a JSON descriptor instead of Go code,
+// so that a compiler (e.g. a cross compiler for aarch64-linux-gnu) can be added without
+// writing a toolchain. The descriptor is selected with 'clay build --toolchain <file|name>',
+// see LoadCustomToolchainDescriptor.
+//
+// Like the other toolchains the command-lines come from the 'recipe.c.pattern',
+// 'recipe.cpp.pattern', 'recipe.ar.pattern', 'recipe.so.pattern' and 'recipe.link.pattern'
+// vars, which are resolved with {build.defines}, {build.includes}, {build.config.flags},
+// {library.paths}, {project.name}, {build.path}, {build.arch} and {toolchain.dir}. The
+// arguments that differ per invocation are appended from the 'args' of the descriptor, these
+// are resolved with the file variables only:
+//
+//	compile:         {source.file}, {object.file}, {dep.file}
+//	archive, shared: {archive.file}, {archive.name}, {object.files}
+//	link:            {executable.file}, {map.file}, {object.files}, {archive.files}, {library.files}
+type CustomToolchain struct {
+	Name       string
+	Vars       *corepkg.Vars
+	Descriptor *CustomToolchainDescriptor
+	Env        []string // Environment of the tool processes (nil = inherit)
+
+	PositionIndependent bool // Compile position independent code, for a shared library or a library linked into one
+	HiddenVisibility    bool // Hide the symbols that are not exported, for a shared library
+
+	ResponseFiles ResponseFiles // When to pass the arguments of a tool in a response file
+}
+
+// CustomToolchainDescriptor is the JSON descriptor of a custom toolchain
+type CustomToolchainDescriptor struct {
+	Name          string                       `json:"name"`                   // Also the suffix of the build directory, e.g. 'linux-arm64-debug-aarch64-linux-gnu'
+	Description   string                       `json:"description,omitempty"`  // What the toolchain is and where to get it
+	Vars          map[string][]string          `json:"vars"`                   // The commands, flags and recipes
+	ConfigFlags   map[string][]string          `json:"config.flags,omitempty"` // {build.config.flags} of the 'debug', 'release' and 'final' build configs
+	Args          CustomToolchainArgs          `json:"args"`                   // The per invocation arguments appended to the recipes
+	Prefixes      CustomToolchainPrefixes      `json:"prefixes"`               // Prefixes of the include directories, defines and libraries
+	Extensions    CustomToolchainExtensions    `json:"extensions"`             // The file extensions of the outputs
+	Deps          string                       `json:"deps,omitempty"`         // The dependency files, 'gcc' (.d, -MMD) or 'msvc' (JSON, /sourceDependencies), default 'gcc'
+	Pch           string                       `json:"pch,omitempty"`          // Precompiled headers, 'gcc' (.gch), 'clang' (.pch) or none
+	ResponseFiles CustomToolchainResponseFiles `json:"response.files"`         // How the tools read a response file
+	Env           map[string]string            `json:"env,omitempty"`          // Environment of the tools, $VAR or ${VAR} of clay and then the vars are resolved
+
+	Dirpath string `json:"-"` // The directory of the descriptor ({toolchain.dir}), empty for a built-in descriptor
+}
+
+// CustomToolchainArgs are the argument templates that are appended to the recipes
+type CustomToolchainArgs struct {
+	Compile []string `json:"compile"`          // e.g. ["-o", "{object.file}", "{source.file}"]
+	Archive []string `json:"archive"`          // e.g. ["{archive.file}", "{object.files}"]
+	Shared  []string `json:"shared,omitempty"` // e.g. ["-o", "{archive.file}", "{object.files}"], required with 'recipe.so.pattern'
+	Link    []string `json:"link"`             // e.g. ["-o", "{executable.file}", "{object.files}", "{archive.files}", "{library.files}"]
+}
+
+// CustomToolchainPrefixes are the prefixes of the include directories, defines, library
+// paths and libraries, the defaults are those of gcc ('-I', '-D', '-L' and '-l').
+type CustomToolchainPrefixes struct {
+	Include     string `json:"include,omitempty"`
+	Define      string `json:"define,omitempty"`
+	LibraryPath string `json:"library.path,omitempty"`
+	Library     string `json:"library,omitempty"`
+}
+
+// CustomToolchainExtensions are the file extensions of the outputs, the defaults are those
+// of gcc on Linux ('.o', '.d', 'lib' + '.a', 'lib' + '.so' and no executable extension).
+type CustomToolchainExtensions struct {
+	Object     string  `json:"object,omitempty"`
+	Dep        string  `json:"dep,omitempty"` // Default '.json' with the 'msvc' dependency files
+	LibPrefix  *string `json:"lib.prefix,omitempty"`
+	Lib        string  `json:"lib,omitempty"`
+	Shared     string  `json:"shared,omitempty"`
+	Executable string  `json:"executable,omitempty"` // e.g. '.elf'
+}
+
+// CustomToolchainResponseFiles is how the tools read a response file
+type CustomToolchainResponseFiles struct {
+	Syntax string `json:"syntax,omitempty"` // 'gnu' (default), 'msvc' or 'none'
+	Always bool   `json:"always,omitempty"` // Always use a response file, not only for long command-lines
+}
+
+const (
+	CustomDepsGcc  = "gcc"
+	CustomDepsMsvc = "msvc"
+)
+
+//go:embed descriptors/*.json
+var builtinCustomToolchains embed.FS
+
+var customToolchainNameRegex = regexp.MustCompile(`^[A-Za-z0-9._+-]+$`)
+
+// LoadCustomToolchainDescriptor loads the descriptor of a custom toolchain, the argument is
+// the path of a JSON file or the name of a descriptor. A name is looked up as '<name>.json'
+// in the 'toolchains' directory of the package, then in 'clay/toolchains' in the user config
+// directory and finally in the descriptors that come with clay.
+func LoadCustomToolchainDescriptor(fileOrName string) (*CustomToolchainDescriptor, error) {
+	descriptorFilepath, data, err := readCustomToolchainDescriptor(fileOrName)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &CustomToolchainDescriptor{}
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("failed to parse toolchain descriptor %q: %w", fileOrName, err)
+	}
+	if len(descriptorFilepath) > 0 {
+		if d.Dirpath, err = filepath.Abs(filepath.Dir(descriptorFilepath)); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.validate(); err != nil {
+		return nil, fmt.Errorf("invalid toolchain descriptor %q: %w", fileOrName, err)
+	}
+	d.setDefaults()
+	return d, nil
+}
+
+// readCustomToolchainDescriptor returns the path and content of a descriptor, the path is
+// empty for a descriptor that comes with clay.
+func readCustomToolchainDescriptor(fileOrName string) (string, []byte, error) {
+	if strings.HasSuffix(fileOrName, ".json") || strings.ContainsAny(fileOrName, `/\`) {
+		data, err := os.ReadFile(fileOrName)
+		return fileOrName, data, err
+	}
+
+	dirpaths := []string{"toolchains"}
+	if userConfigDir, err := os.UserConfigDir(); err == nil {
+		dirpaths = append(dirpaths, filepath.Join(userConfigDir, "clay", "toolchains"))
+	}
+	for _, dirpath := range dirpaths {
+		descriptorFilepath := filepath.Join(dirpath, fileOrName+".json")
+		if data, err := os.ReadFile(descriptorFilepath); err == nil {
+			return descriptorFilepath, data, nil
+		}
+	}
+	if data, err := builtinCustomToolchains.ReadFile("descriptors/" + fileOrName + ".json"); err == nil {
+		return "", data, nil
+	}
+	return "", nil, fmt.Errorf("unknown toolchain %q, expected a descriptor file or one of %s", fileOrName, strings.Join(BuiltinCustomToolchains(), ", "))
+}
+
+// BuiltinCustomToolchains returns the names of the descriptors that come with clay
+func BuiltinCustomToolchains() []string {
+	names := []string{}
+	entries, _ := builtinCustomToolchains.ReadDir("descriptors")
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	return names
+}
+
+func (d *CustomToolchainDescriptor) validate() error {
+	if !customToolchainNameRegex.MatchString(d.Name) {
+		return fmt.Errorf("the name %q is not a valid directory name", d.Name)
+	}
+	for _, recipe := range []string{"recipe.c.pattern", "recipe.cpp.pattern", "recipe.ar.pattern", "recipe.link.pattern"} {
+		if len(d.Vars[recipe]) == 0 {
+			return fmt.Errorf("%s is missing", recipe)
+		}
+	}
+	if len(d.Args.Compile) == 0 || len(d.Args.Archive) == 0 || len(d.Args.Link) == 0 {
+		return fmt.Errorf("the compile, archive and link args are required")
+	}
+	if len(d.Vars["recipe.so.pattern"]) > 0 && len(d.Args.Shared) == 0 {
+		return fmt.Errorf("the shared args are required with recipe.so.pattern")
+	}
+	if d.Deps != "" && d.Deps != CustomDepsGcc && d.Deps != CustomDepsMsvc {
+		return fmt.Errorf("unknown deps %q, expected gcc or msvc", d.Deps)
+	}
+	if d.Pch != "" && d.Pch != "gcc" && d.Pch != "clang" {
+		return fmt.Errorf("unknown pch %q, expected gcc or clang", d.Pch)
+	}
+	switch d.ResponseFiles.Syntax {
+	case "", "gnu", "msvc", "none":
+	default:
+		return fmt.Errorf("unknown response file syntax %q, expected gnu, msvc or none", d.ResponseFiles.Syntax)
+	}
+	return nil
+}
+
+func (d *CustomToolchainDescriptor) setDefaults() {
+	setDefault := func(value *string, defaultValue string) {
+		if len(*value) == 0 {
+			*value = defaultValue
+		}
+	}
+	setDefault(&d.Deps, CustomDepsGcc)
+	setDefault(&d.Prefixes.Include, "-I")
+	setDefault(&d.Prefixes.Define, "-D")
+	setDefault(&d.Prefixes.LibraryPath, "-L")
+	setDefault(&d.Prefixes.Library, "-l")
+	setDefault(&d.Extensions.Object, ".o")
+	if d.Deps == CustomDepsMsvc {
+		setDefault(&d.Extensions.Dep, ".json")
+	} else {
+		setDefault(&d.Extensions.Dep, ".d")
+	}
+	if d.Extensions.LibPrefix == nil {
+		libPrefix := "lib"
+		d.Extensions.LibPrefix = &libPrefix
+	}
+	setDefault(&d.Extensions.Lib, ".a")
+	setDefault(&d.Extensions.Shared, ".so")
+	setDefault(&d.ResponseFiles.Syntax, "gnu")
+}
+
+// responseFileSyntax returns the syntax of the response files of the tools
+func (d *CustomToolchainDescriptor) responseFileSyntax() ResponseFileSyntax {
+	switch d.ResponseFiles.Syntax {
+	case "msvc":
+		return ResponseFileMsvc
+	case "none":
+		return ResponseFileNone
+	}
+	return ResponseFileGnu
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Recipes and arguments
+
+// resolveRecipe returns the tool and the fully resolved arguments of a recipe, and false when
+// the toolchain does not have the recipe.
+func (t *CustomToolchain) resolveRecipe(recipe string, vars ...*corepkg.Vars) (toolPath string, toolArgs []string, ok bool) {
+	pattern, ok := t.Vars.Get(recipe)
+	if !ok || len(pattern) == 0 {
+		return "", nil, false
+	}
+	toolPath = t.lookPath(t.Vars.FinalResolveString(pattern[0], " ", vars...))
+	toolArgs = t.Vars.FinalResolveArray(pattern[1:], vars...)
+	toolArgs = slices.DeleteFunc(toolArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+	return toolPath, toolArgs, true
+}
+
+// resolveArgs resolves argument templates with the file variables, e.g. {object.file}
+func resolveArgs(templates []string, fileVars map[string][]string) []string {
+	vars := corepkg.NewVars(corepkg.VarsFormatCurlyBraces)
+	for key, value := range fileVars {
+		vars.Set(key, value...)
+	}
+	args := vars.FinalResolveArray(slices.Clone(templates))
+	return slices.DeleteFunc(args, func(s string) bool { return strings.TrimSpace(s) == "" })
+}
+
+// lookPath returns the path of a tool that is found in the PATH of the environment of the
+// tools, exec.Command only searches the PATH of clay itself.
+func (t *CustomToolchain) lookPath(tool string) string {
+	if t.Env == nil || strings.ContainsAny(tool, `/\`) {
+		return tool
+	}
+	for _, e := range t.Env {
+		key, value, _ := strings.Cut(e, "=")
+		if !strings.EqualFold(key, "PATH") {
+			continue
+		}
+		for _, dirpath := range filepath.SplitList(value) {
+			if path, err := exec.LookPath(filepath.Join(dirpath, tool)); err == nil {
+				return path
+			}
+		}
+	}
+	return tool
+}
+
+// withPrefix prefixes every value that does not yet start with the prefix
+func withPrefix(prefix string, values []string) []string {
+	for i, value := range values {
+		if !strings.HasPrefix(value, prefix) {
+			values[i] = prefix + value
+		}
+	}
+	return values
+}
+
+// SetPositionIndependent compiles with -fPIC, and -fvisibility=hidden for a shared library,
+// when the compiler is like gcc or clang (gcc dependency files).
+func (t *CustomToolchain) SetPositionIndependent(hiddenVisibility bool) {
+	if t.Descriptor.Deps != CustomDepsGcc {
+		return
+	}
+	t.PositionIndependent = true
+	t.HiddenVisibility = hiddenVisibility
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// File Commander
+func (t *CustomToolchain) NewFileCommander(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) FileCommander {
+	return &BasicFileCommander{}
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// C/C++ Compiler
+
+type ToolchainCustomCompiler struct {
+	toolChain       *CustomToolchain
+	projectName     string
+	buildConfig     denv.BuildConfig
+	buildTarget     denv.BuildTarget
+	cCompilerPath   string
+	cCompilerArgs   []string
+	cppCompilerPath string
+	cppCompilerArgs []string
+	pch             *Pch
+	vars            *corepkg.Vars
+}
+
+func (t *CustomToolchain) NewCompiler(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) Compiler {
+	return &ToolchainCustomCompiler{
+		toolChain:   t,
+		buildConfig: buildConfig,
+		buildTarget: buildTarget,
+		vars:        corepkg.NewVars(corepkg.VarsFormatCurlyBraces),
+	}
+}
+
+func (cl *ToolchainCustomCompiler) ObjFilepath(srcRelFilepath string) string {
+	return srcRelFilepath + cl.toolChain.Descriptor.Extensions.Object
+}
+
+// DepFilepath returns the dependency file of a source file, 'file.cpp' results in 'file.cpp.d',
+// which is where gcc writes it with -MMD for the object file 'file.cpp.o'.
+func (cl *ToolchainCustomCompiler) DepFilepath(objRelFilepath string) string {
+	return objRelFilepath + cl.toolChain.Descriptor.Extensions.Dep
+}
+
+func (cl *ToolchainCustomCompiler) SetupArgs(projectName string, buildPath string, _defines []string, _includes []string) {
+	cl.projectName = projectName
+	cl.vars.Set("build.includes", withPrefix(cl.toolChain.Descriptor.Prefixes.Include, _includes)...)
+	cl.vars.Set("build.defines", withPrefix(cl.toolChain.Descriptor.Prefixes.Define, _defines)...)
+
+	config := "release"
+	if cl.buildConfig.IsDebug() {
+		config = "debug"
+	} else if cl.buildConfig.IsFinal() {
+		config = "final"
+	}
+	cl.vars.Set("build.config.flags", cl.toolChain.Descriptor.ConfigFlags[config]...)
+
+	cl.cCompilerPath, cl.cCompilerArgs, _ = cl.toolChain.resolveRecipe(`recipe.c.pattern`, cl.vars)
+	cl.cppCompilerPath, cl.cppCompilerArgs, _ = cl.toolChain.resolveRecipe(`recipe.cpp.pattern`, cl.vars)
+
+	cl.cCompilerArgs = gccPositionIndependentArgs(cl.cCompilerArgs, cl.toolChain.PositionIndependent, cl.toolChain.HiddenVisibility)
+	cl.cppCompilerArgs = gccPositionIndependentArgs(cl.cppCompilerArgs, cl.toolChain.PositionIndependent, cl.toolChain.HiddenVisibility)
+}
+
+// SetupPch compiles the header like gcc or clang do, or returns nil when the descriptor does
+// not specify how the compiler precompiles headers.
+func (cl *ToolchainCustomCompiler) SetupPch(headerAbsFilepath string, buildPath string) *Pch {
+	if len(cl.toolChain.Descriptor.Pch) == 0 {
+		return nil
+	}
+	cl.pch = newGccPch(headerAbsFilepath, buildPath, cl.toolChain.Descriptor.Pch == "clang")
+	return cl.pch
+}
+
+// commandLine returns the compiler and the fully resolved arguments to compile a source file
+func (cl *ToolchainCustomCompiler) commandLine(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	switch filepath.Ext(sourceAbsFilepath) {
+	case ".c", ".m":
+		compilerPath = cl.cCompilerPath
+		compilerArgs = slices.Clone(cl.cCompilerArgs)
+	default:
+		compilerPath = cl.cppCompilerPath
+		compilerArgs = slices.Clone(cl.cppCompilerArgs)
+	}
+
+	compilerArgs = append(compilerArgs, gccPchArgs(cl.pch, cl.toolChain.Descriptor.Pch == "clang", sourceAbsFilepath)...)
+	compilerArgs = append(compilerArgs, resolveArgs(cl.toolChain.Descriptor.Args.Compile, map[string][]string{
+		"source.file": {sourceAbsFilepath},
+		"object.file": {objRelFilepath},
+		"dep.file":    {cl.DepFilepath(strings.TrimSuffix(objRelFilepath, cl.toolChain.Descriptor.Extensions.Object))},
+	})...)
+	return compilerPath, compilerArgs
+}
+
+func (cl *ToolchainCustomCompiler) ArgsHash(sourceAbsFilepath string, objRelFilepath string) []byte {
+	return argumentsHash(cl.commandLine(sourceAbsFilepath, objRelFilepath))
+}
+
+func (cl *ToolchainCustomCompiler) CacheKey(sourceAbsFilepath string, objRelFilepath string) []byte {
+	compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepath)
+	return compilerCacheKey(compilerPath, compilerArgs, objRelFilepath)
+}
+
+func (cl *ToolchainCustomCompiler) CompileCommand(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	return cl.commandLine(sourceAbsFilepath, objRelFilepath)
+}
+
+func (cl *ToolchainCustomCompiler) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
+	jobs := make([]*compileJob, 0, len(sourceAbsFilepaths))
+	for i, sourceAbsFilepath := range sourceAbsFilepaths {
+		compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepaths[i])
+		jobs = append(jobs, &compileJob{
+			message:     fmt.Sprintf("Compiling (%s) %s", cl.buildConfig.String(), filepath.Base(sourceAbsFilepath)),
+			project:     cl.projectName,
+			srcFilepath: sourceAbsFilepath,
+			toolPath:    compilerPath,
+			toolArgs:    cl.toolChain.ResponseFiles.toolArgs(cl.toolChain.Descriptor.responseFileSyntax(), compilerPath, compilerArgs, objRelFilepaths[i]),
+			env:         cl.toolChain.Env,
+		})
+	}
+	return runCompileJobs(jobs)
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Archiver
+
+type ToolchainCustomArchiver struct {
+	toolChain   *CustomToolchain
+	buildConfig denv.BuildConfig
+	buildTarget denv.BuildTarget
+	shared      bool
+	arPath      string
+	arArgs      []string
+}
+
+func (t *CustomToolchain) NewArchiver(at ArchiverType, buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) Archiver {
+	return &ToolchainCustomArchiver{toolChain: t, buildConfig: buildConfig, buildTarget: buildTarget, shared: at == ArchiverTypeDynamic}
+}
+
+func (t *ToolchainCustomArchiver) LibFilepath(_filepath string) string {
+	filename := corepkg.PathFilename(_filepath, true)
+	dirpath := corepkg.PathDirname(_filepath)
+	extensions := t.toolChain.Descriptor.Extensions
+	if t.shared {
+		return filepath.Join(dirpath, *extensions.LibPrefix+filename+extensions.Shared)
+	}
+	return filepath.Join(dirpath, *extensions.LibPrefix+filename+extensions.Lib)
+}
+
+// RuntimeFilepath returns the shared library itself, it is also what executables link with
+func (t *ToolchainCustomArchiver) RuntimeFilepath(libFilepath string) string {
+	if t.shared {
+		return libFilepath
+	}
+	return ""
+}
+
+func (t *ToolchainCustomArchiver) SetupArgs() {
+	if t.shared {
+		t.arPath, t.arArgs, _ = t.toolChain.resolveRecipe(`recipe.so.pattern`)
+	} else {
+		t.arPath, t.arArgs, _ = t.toolChain.resolveRecipe(`recipe.ar.pattern`)
+	}
+}
+
+// commandLine returns the archiver and the fully resolved arguments to create the archive
+// or the shared library
+func (t *ToolchainCustomArchiver) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (archiverPath string, archiverArgs []string) {
+	templates := t.toolChain.Descriptor.Args.Archive
+	if t.shared {
+		templates = t.toolChain.Descriptor.Args.Shared
+	}
+	archiverArgs = append(slices.Clone(t.arArgs), resolveArgs(templates, map[string][]string{
+		"archive.file": {outputArchiveFilepath},
+		"archive.name": {filepath.Base(outputArchiveFilepath)},
+		"object.files": inputObjectFilepaths,
+	})...)
+	return t.arPath, archiverArgs
+}
+
+func (t *ToolchainCustomArchiver) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(t.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
+
+func (t *ToolchainCustomArchiver) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
+	if len(t.arPath) == 0 {
+		return corepkg.LogErrorf(os.ErrInvalid, "error, toolchain %s has no recipe.so.pattern to create shared libraries", t.toolChain.Name)
+	}
+	archiverPath, archiverArgs := t.commandLine(inputObjectFilepaths, outputArchiveFilepath)
+
+	// An archiver like 'ar' adds to or replaces members of an existing archive, remove the
+	// archive first so that object files of deleted source files do not linger in the archive.
+	if !t.shared {
+		if err := os.Remove(outputArchiveFilepath); err != nil && !os.IsNotExist(err) {
+			return corepkg.LogErrorf(err, "Failed to remove archive %q", outputArchiveFilepath)
+		}
+		corepkg.LogInff("Archiving (%s) %s", t.buildConfig.String(), filepath.Base(outputArchiveFilepath))
+	} else {
+		corepkg.LogInff("Linking (%s) %s", t.buildConfig.String(), filepath.Base(outputArchiveFilepath))
+	}
+
+	cmd := exec.Command(archiverPath, t.toolChain.ResponseFiles.toolArgs(t.toolChain.Descriptor.responseFileSyntax(), archiverPath, archiverArgs, outputArchiveFilepath)...)
+	cmd.Env = t.toolChain.Env
+	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
+
+	if err != nil {
+		return corepkg.LogErrorf(err, "Archiving failed: %s", string(out))
+	}
+	if len(out) > 0 {
+		corepkg.LogInfof("Archive output:\n%s", string(out))
+	}
+
+	return nil
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Linker
+
+type ToolchainCustomLinker struct {
+	toolChain    *CustomToolchain
+	buildConfig  denv.BuildConfig
+	buildTarget  denv.BuildTarget
+	linkerPath   string
+	linkerArgs   []string
+	vars         *corepkg.Vars
+	libraryFiles []string
+}
+
+func (t *CustomToolchain) NewLinker(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) Linker {
+	return &ToolchainCustomLinker{
+		toolChain:    t,
+		buildConfig:  buildConfig,
+		buildTarget:  buildTarget,
+		vars:         corepkg.NewVars(corepkg.VarsFormatCurlyBraces),
+		libraryFiles: []string{},
+	}
+}
+
+func (l *ToolchainCustomLinker) LinkedFilepath(filepath string) string {
+	return filepath + l.toolChain.Descriptor.Extensions.Executable
+}
+
+func (l *ToolchainCustomLinker) SetupArgs(libraryPaths []string, libraryFiles []string) {
+	l.vars.Prepend("library.paths", withPrefix(l.toolChain.Descriptor.Prefixes.LibraryPath, libraryPaths)...)
+	l.libraryFiles = append(l.libraryFiles, withPrefix(l.toolChain.Descriptor.Prefixes.Library, libraryFiles)...)
+
+	l.linkerPath, l.linkerArgs, _ = l.toolChain.resolveRecipe(`recipe.link.pattern`, l.vars)
+}
+
+// commandLine returns the linker and the fully resolved arguments to link the executable
+func (l *ToolchainCustomLinker) commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) (linkerPath string, linkerArgs []string) {
+	linkerArgs = append(slices.Clone(l.linkerArgs), resolveArgs(l.toolChain.Descriptor.Args.Link, map[string][]string{
+		"executable.file": {l.LinkedFilepath(outputAppRelFilepathNoExt)},
+		"map.file":        {outputAppRelFilepathNoExt + ".map"},
+		"object.files":    inputObjectsAbsFilepaths,
+		"archive.files":   inputArchivesAbsFilepaths,
+		"library.files":   l.libraryFiles,
+	})...)
+	return l.linkerPath, linkerArgs
+}
+
+func (l *ToolchainCustomLinker) ArgsHash(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) []byte {
+	return argumentsHash(l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt))
+}
+
+func (l *ToolchainCustomLinker) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error {
+	linkerPath, linkerArgs := l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt)
+
+	corepkg.LogInff("Linking (%s) %s", l.buildConfig.String(), filepath.Base(outputAppRelFilepathNoExt))
+
+	cmd := exec.Command(linkerPath, l.toolChain.ResponseFiles.toolArgs(l.toolChain.Descriptor.responseFileSyntax(), linkerPath, linkerArgs, outputAppRelFilepathNoExt)...)
+	cmd.Env = l.toolChain.Env
+	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputAppRelFilepathNoExt, out)
+
+	if err != nil {
+		corepkg.LogInff("Link failed, output:\n%s", string(out))
+		return corepkg.LogError(err, "Linking failed")
+	}
+	if len(out) > 0 {
+		corepkg.LogInfof("Link output:\n%s", string(out))
+	}
+
+	return nil
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Burner
+
+func (t *CustomToolchain) NewBurner(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) Burner {
+	return &EmptyBurner{}
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Dependency Tracker
+func (t *CustomToolchain) NewDependencyTracker(dirpath string) deptrackr.FileTrackr {
+	if t.Descriptor.Deps == CustomDepsMsvc {
+		return deptrackr.LoadJsonFileTrackr(filepath.Join(dirpath, "deptrackr"))
+	}
+	return deptrackr.LoadDepFileTrackr(filepath.Join(dirpath, "deptrackr"))
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Toolchain from a descriptor
+func NewCustomToolchain(descriptor *CustomToolchainDescriptor, projectName string, buildPath string, arch string) *CustomToolchain {
+	vars := corepkg.NewVars(corepkg.VarsFormatCurlyBraces)
+	vars.SetMany(descriptor.Vars)
+
+	vars.Set("project.name", projectName)
+	vars.Set("build.path", buildPath)
+	vars.Set("build.arch", arch)
+	vars.Set("toolchain.dir", descriptor.Dirpath)
+
+	t := &CustomToolchain{
+		Name:          descriptor.Name,
+		Vars:          vars,
+		Descriptor:    descriptor,
+		ResponseFiles: ResponseFiles{Always: descriptor.ResponseFiles.Always},
+	}
+
+	// The environment variables of the descriptor replace those of clay
+	if len(descriptor.Env) > 0 {
+		t.Env = slices.DeleteFunc(os.Environ(), func(e string) bool {
+			key, _, _ := strings.Cut(e, "=")
+			for envKey := range descriptor.Env {
+				if strings.EqualFold(key, envKey) {
+					return true
+				}
+			}
+			return false
+		})
+		keys := make([]string, 0, len(descriptor.Env))
+		for key := range descriptor.Env {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			value := vars.FinalResolveString(os.ExpandEnv(descriptor.Env[key]), " ")
+			t.Env = append(t.Env, key+"="+value)
+		}
+	}
+	return t
+}
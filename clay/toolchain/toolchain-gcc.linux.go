@@ -0,0 +1,545 @@
+package toolchain
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain/deptrackr"
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+// LinuxGcc is the toolchain for building native Linux executables and libraries with
+// gcc or clang. The recipes are taken from the package vars, when the package does not
+// provide them a default set of recipes is used. The compilers can be overridden with
+// the CC, CXX and AR environment variables, e.g. 'CC=clang CXX=clang++ clay build'.
+type LinuxGcc struct {
+	Name       string
+	Vars       *corepkg.Vars
+	Sanitizers []Sanitizer // Compile and link with these sanitizers (clay build --sanitize)
+	Coverage   bool        // Compile and link with coverage instrumentation (clay coverage)
+	TimeTrace  bool        // Write a time trace per source file, clang only (clay build --time-trace)
+
+	PositionIndependent bool // Compile position independent code, for a shared library or a library linked into one
+	HiddenVisibility    bool // Hide the symbols that are not exported, for a shared library
+
+	ResponseFiles ResponseFiles // When to pass the arguments of a tool in a response file
+}
+
+// SetSanitizers builds with the sanitizers, gcc and clang support all of them
+func (t *LinuxGcc) SetSanitizers(sanitizers []Sanitizer) error {
+	t.Sanitizers = sanitizers
+	return nil
+}
+
+// SetTimeTrace makes clang write a time trace per source file, gcc does not support it
+func (t *LinuxGcc) SetTimeTrace() error {
+	if t.Name != "clang" {
+		return fmt.Errorf("a time trace per source file requires clang, the compiler is %s", t.Name)
+	}
+	t.TimeTrace = true
+	return nil
+}
+
+// SetPositionIndependent compiles with -fPIC, and -fvisibility=hidden for a shared library
+func (t *LinuxGcc) SetPositionIndependent(hiddenVisibility bool) {
+	t.PositionIndependent = true
+	t.HiddenVisibility = hiddenVisibility
+}
+
+// SetCoverage builds with the coverage instrumentation of gcc (gcov) or clang (llvm-cov)
+func (t *LinuxGcc) SetCoverage() error {
+	t.Coverage = true
+	return nil
+}
+
+// NewCoverageCollector returns the collector that uses gcov, or llvm-profdata and llvm-cov
+// when the compiler is clang, the version of the tools matches the version of the compiler.
+func (t *LinuxGcc) NewCoverageCollector(buildPath string) CoverageCollector {
+	compilerPath := t.Vars.GetFirstOrEmpty("compiler.cpp.cmd")
+	if t.Name == "clang" {
+		profdataPath := coverageToolPath("LLVM_PROFDATA", compilerPath, "llvm-profdata")
+		covPath := coverageToolPath("LLVM_COV", compilerPath, "llvm-cov")
+		return newLlvmCoverageCollector([]string{profdataPath}, []string{covPath}, buildPath)
+	}
+	return newGccCoverageCollector(compilerPath, buildPath)
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// File Commander
+func (t *LinuxGcc) NewFileCommander(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) FileCommander {
+	return &BasicFileCommander{}
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// C/C++ Compiler
+
+type ToolchainLinuxGccCompiler struct {
+	toolChain       *LinuxGcc
+	projectName     string
+	buildConfig     denv.BuildConfig
+	buildTarget     denv.BuildTarget
+	cCompilerPath   string
+	cCompilerArgs   *corepkg.Arguments
+	cppCompilerPath string
+	cppCompilerArgs *corepkg.Arguments
+	pch             *Pch
+	vars            *corepkg.Vars
+}
+
+func (t *LinuxGcc) NewCompiler(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) Compiler {
+	return &ToolchainLinuxGccCompiler{
+		toolChain:       t,
+		buildConfig:     buildConfig,
+		buildTarget:     buildTarget,
+		cCompilerPath:   "",
+		cCompilerArgs:   nil,
+		cppCompilerPath: "",
+		cppCompilerArgs: nil,
+		vars:            corepkg.NewVars(corepkg.VarsFormatCurlyBraces),
+	}
+}
+
+func (cl *ToolchainLinuxGccCompiler) ObjFilepath(srcRelFilepath string) string {
+	return srcRelFilepath + ".o"
+}
+
+// DepFilepath returns the .d file that gcc/clang write next to the object file when
+// compiling with -MMD, the source file 'file.cpp' (object file 'file.cpp.o') results
+// in 'file.cpp.d'.
+func (cl *ToolchainLinuxGccCompiler) DepFilepath(srcRelFilepath string) string {
+	return srcRelFilepath + ".d"
+}
+
+func (cl *ToolchainLinuxGccCompiler) SetupArgs(projectName string, buildPath string, _defines []string, _includes []string) {
+	cl.projectName = projectName
+	for i, inc := range _includes {
+		if !strings.HasPrefix(inc, "-I") {
+			_includes[i] = "-I" + inc
+		}
+	}
+	for i, def := range _defines {
+		if !strings.HasPrefix(def, "-D") {
+			_defines[i] = "-D" + def
+		}
+	}
+	cl.vars.Set("build.includes", _includes...)
+	cl.vars.Set("build.defines", _defines...)
+
+	if cl.buildConfig.IsDebug() {
+		cl.vars.Set("build.config.flags", "-g", "-O0")
+	} else if cl.buildConfig.IsFinal() {
+		cl.vars.Set("build.config.flags", "-O3")
+	} else {
+		cl.vars.Set("build.config.flags", "-g", "-O2")
+	}
+
+	cl.cCompilerPath = ""
+	cl.cCompilerArgs = corepkg.NewArguments(0)
+	if c_compiler_args, ok := cl.toolChain.Vars.Get(`recipe.c.pattern`); ok {
+		cl.cCompilerPath = c_compiler_args[0]
+		cl.cCompilerArgs.Args = c_compiler_args[1:]
+
+		cl.cCompilerPath = cl.toolChain.Vars.FinalResolveString(cl.cCompilerPath, " ", cl.vars)
+		cl.cCompilerArgs.Args = cl.toolChain.Vars.FinalResolveArray(cl.cCompilerArgs.Args, cl.vars)
+
+		cl.cCompilerArgs.Args = slices.DeleteFunc(cl.cCompilerArgs.Args, func(s string) bool { return strings.TrimSpace(s) == "" })
+	}
+
+	cl.cppCompilerPath = ""
+	cl.cppCompilerArgs = corepkg.NewArguments(0)
+	if cpp_compiler_args, ok := cl.toolChain.Vars.Get(`recipe.cpp.pattern`); ok {
+		cl.cppCompilerPath = cpp_compiler_args[0]
+		cl.cppCompilerArgs.Args = cpp_compiler_args[1:]
+
+		cl.cppCompilerPath = cl.toolChain.Vars.FinalResolveString(cl.cppCompilerPath, " ", cl.vars)
+		cl.cppCompilerArgs.Args = cl.toolChain.Vars.FinalResolveArray(cl.cppCompilerArgs.Args, cl.vars)
+
+		cl.cppCompilerArgs.Args = slices.DeleteFunc(cl.cppCompilerArgs.Args, func(s string) bool { return strings.TrimSpace(s) == "" })
+	}
+
+	cl.cCompilerArgs.Args = gccPositionIndependentArgs(cl.cCompilerArgs.Args, cl.toolChain.PositionIndependent, cl.toolChain.HiddenVisibility)
+	cl.cppCompilerArgs.Args = gccPositionIndependentArgs(cl.cppCompilerArgs.Args, cl.toolChain.PositionIndependent, cl.toolChain.HiddenVisibility)
+}
+
+// SetupPch compiles the header into '<build>/pch/<header>.gch', or '.pch' when the compiler
+// is clang.
+func (cl *ToolchainLinuxGccCompiler) SetupPch(headerAbsFilepath string, buildPath string) *Pch {
+	cl.pch = newGccPch(headerAbsFilepath, buildPath, cl.toolChain.Name == "clang")
+	return cl.pch
+}
+
+// commandLine returns the compiler and the fully resolved arguments to compile a source file
+func (cl *ToolchainLinuxGccCompiler) commandLine(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	if strings.HasSuffix(sourceAbsFilepath, ".c") {
+		compilerPath = cl.cCompilerPath
+		compilerArgs = slices.Clone(cl.cCompilerArgs.Args)
+	} else {
+		compilerPath = cl.cppCompilerPath
+		compilerArgs = slices.Clone(cl.cppCompilerArgs.Args)
+	}
+
+	compilerArgs = append(compilerArgs, gccSanitizeCompileArgs(cl.toolChain.Sanitizers)...)
+	compilerArgs = append(compilerArgs, gccCoverageCompileArgs(cl.toolChain.Coverage, cl.toolChain.Name == "clang")...)
+	compilerArgs = append(compilerArgs, clangTimeTraceArgs(cl.toolChain.TimeTrace)...)
+	compilerArgs = append(compilerArgs, gccPchArgs(cl.pch, cl.toolChain.Name == "clang", sourceAbsFilepath)...)
+	compilerArgs = append(compilerArgs, "-o", objRelFilepath)
+	compilerArgs = append(compilerArgs, sourceAbsFilepath)
+	return compilerPath, compilerArgs
+}
+
+func (cl *ToolchainLinuxGccCompiler) ArgsHash(sourceAbsFilepath string, objRelFilepath string) []byte {
+	return argumentsHash(cl.commandLine(sourceAbsFilepath, objRelFilepath))
+}
+
+func (cl *ToolchainLinuxGccCompiler) CacheKey(sourceAbsFilepath string, objRelFilepath string) []byte {
+	compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepath)
+	return compilerCacheKey(compilerPath, compilerArgs, objRelFilepath)
+}
+
+func (cl *ToolchainLinuxGccCompiler) CompileCommand(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	return cl.commandLine(sourceAbsFilepath, objRelFilepath)
+}
+
+func (cl *ToolchainLinuxGccCompiler) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
+	jobs := make([]*compileJob, 0, len(sourceAbsFilepaths))
+	for i, sourceAbsFilepath := range sourceAbsFilepaths {
+		compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepaths[i])
+		jobs = append(jobs, &compileJob{
+			message:     fmt.Sprintf("Compiling (%s) %s", cl.buildConfig.String(), filepath.Base(sourceAbsFilepath)),
+			project:     cl.projectName,
+			srcFilepath: sourceAbsFilepath,
+			toolPath:    compilerPath,
+			toolArgs:    cl.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, compilerPath, compilerArgs, objRelFilepaths[i]),
+			timeTrace:   clangTimeTraceFilepath(cl.toolChain.TimeTrace, objRelFilepaths[i]),
+		})
+	}
+	return runCompileJobs(jobs)
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Archiver
+
+type ToolchainLinuxGccStaticArchiver struct {
+	toolChain   *LinuxGcc
+	buildConfig denv.BuildConfig
+	buildTarget denv.BuildTarget
+	arPath      string
+	arArgs      *corepkg.Arguments
+}
+
+type ToolchainLinuxGccDynamicArchiver struct {
+	toolChain   *LinuxGcc
+	buildConfig denv.BuildConfig
+	buildTarget denv.BuildTarget
+	soPath      string
+	soArgs      *corepkg.Arguments
+}
+
+func (t *LinuxGcc) NewArchiver(at ArchiverType, buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) Archiver {
+	args := corepkg.NewArguments(512)
+	switch at {
+	case ArchiverTypeStatic:
+		return &ToolchainLinuxGccStaticArchiver{toolChain: t, buildConfig: buildConfig, buildTarget: buildTarget, arArgs: args}
+	case ArchiverTypeDynamic:
+		return &ToolchainLinuxGccDynamicArchiver{toolChain: t, buildConfig: buildConfig, buildTarget: buildTarget, soArgs: args}
+	}
+	return nil
+}
+
+func (t *ToolchainLinuxGccStaticArchiver) LibFilepath(_filepath string) string {
+	filename := corepkg.PathFilename(_filepath, true)
+	dirpath := corepkg.PathDirname(_filepath)
+	return filepath.Join(dirpath, "lib"+filename+".a")
+}
+
+func (t *ToolchainLinuxGccStaticArchiver) RuntimeFilepath(libFilepath string) string {
+	return ""
+}
+
+func (t *ToolchainLinuxGccStaticArchiver) SetupArgs() {
+	if archiver_args, ok := t.toolChain.Vars.Get(`recipe.ar.pattern`); ok {
+		t.arPath = archiver_args[0]
+		t.arArgs = corepkg.NewArguments(0)
+		t.arArgs.Args = archiver_args[1:]
+		t.arPath = t.toolChain.Vars.FinalResolveString(t.arPath, " ")
+		t.arArgs.Args = t.toolChain.Vars.FinalResolveArray(t.arArgs.Args)
+
+		t.arArgs.Args = slices.DeleteFunc(t.arArgs.Args, func(s string) bool { return strings.TrimSpace(s) == "" })
+	}
+}
+
+// commandLine returns the archiver and the fully resolved arguments to create the archive
+func (t *ToolchainLinuxGccStaticArchiver) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (archiverPath string, archiverArgs []string) {
+	archiverPath = t.arPath
+	archiverArgs = slices.Clone(t.arArgs.Args)
+
+	archiverArgs = append(archiverArgs, outputArchiveFilepath)
+	archiverArgs = append(archiverArgs, inputObjectFilepaths...)
+	return archiverPath, archiverArgs
+}
+
+func (t *ToolchainLinuxGccStaticArchiver) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(t.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
+
+func (t *ToolchainLinuxGccStaticArchiver) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
+	archiverPath, archiverArgs := t.commandLine(inputObjectFilepaths, outputArchiveFilepath)
+
+	// 'ar' adds to or replaces members of an existing archive, remove the archive first
+	// so that object files of deleted source files do not linger in the archive.
+	if err := os.Remove(outputArchiveFilepath); err != nil && !os.IsNotExist(err) {
+		return corepkg.LogErrorf(err, "Failed to remove archive %q", outputArchiveFilepath)
+	}
+
+	corepkg.LogInff("Archiving (%s) %s", t.buildConfig.String(), filepath.Base(outputArchiveFilepath))
+
+	cmd := exec.Command(archiverPath, t.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, archiverPath, archiverArgs, outputArchiveFilepath)...)
+	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
+
+	if err != nil {
+		return corepkg.LogErrorf(err, "Archiving failed: ", string(out))
+	}
+
+	return nil
+}
+
+func (t *ToolchainLinuxGccDynamicArchiver) LibFilepath(_filepath string) string {
+	filename := corepkg.PathFilename(_filepath, true)
+	dirpath := corepkg.PathDirname(_filepath)
+	return filepath.Join(dirpath, "lib"+filename+".so")
+}
+
+func (t *ToolchainLinuxGccDynamicArchiver) RuntimeFilepath(libFilepath string) string {
+	return libFilepath
+}
+
+func (t *ToolchainLinuxGccDynamicArchiver) SetupArgs() {
+	if so_args, ok := t.toolChain.Vars.Get(`recipe.so.pattern`); ok {
+		t.soPath = so_args[0]
+		t.soArgs = corepkg.NewArguments(0)
+		t.soArgs.Args = so_args[1:]
+
+		t.soPath = t.toolChain.Vars.FinalResolveString(t.soPath, " ")
+		t.soArgs.Args = t.toolChain.Vars.FinalResolveArray(t.soArgs.Args)
+
+		t.soArgs.Args = slices.DeleteFunc(t.soArgs.Args, func(s string) bool { return strings.TrimSpace(s) == "" })
+	}
+}
+
+// commandLine returns the compiler driver and the fully resolved arguments to create the shared library
+func (t *ToolchainLinuxGccDynamicArchiver) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (soPath string, soArgs []string) {
+	soPath = t.soPath
+	soArgs = slices.Clone(t.soArgs.Args)
+	soArgs = append(soArgs, gccSanitizeLinkArgs(t.toolChain.Sanitizers)...)
+	soArgs = append(soArgs, gccCoverageLinkArgs(t.toolChain.Coverage, t.toolChain.Name == "clang")...)
+
+	// The soname is the filename only, an executable then finds the library through its rpath
+	soArgs = append(soArgs, "-Wl,-soname,"+filepath.Base(outputArchiveFilepath))
+	soArgs = append(soArgs, "-o", outputArchiveFilepath)
+	soArgs = append(soArgs, inputObjectFilepaths...)
+	return soPath, soArgs
+}
+
+func (t *ToolchainLinuxGccDynamicArchiver) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(t.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
+
+func (t *ToolchainLinuxGccDynamicArchiver) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
+	soPath, soArgs := t.commandLine(inputObjectFilepaths, outputArchiveFilepath)
+
+	corepkg.LogInff("Linking (%s) %s", t.buildConfig.String(), filepath.Base(outputArchiveFilepath))
+
+	cmd := exec.Command(soPath, t.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, soPath, soArgs, outputArchiveFilepath)...)
+	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
+
+	if err != nil {
+		return corepkg.LogErrorf(err, "Archiving failed: ", string(out))
+	}
+	if len(out) > 0 {
+		corepkg.LogInfof("Archive output:\n%s", string(out))
+	}
+
+	return nil
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Linker
+
+type ToolchainLinuxGccLinker struct {
+	toolChain    *LinuxGcc
+	buildConfig  denv.BuildConfig
+	buildTarget  denv.BuildTarget
+	linkerPath   string
+	linkerArgs   *corepkg.Arguments
+	vars         *corepkg.Vars
+	libraryFiles []string
+}
+
+func (t *LinuxGcc) NewLinker(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) Linker {
+	args := corepkg.NewArguments(512)
+	return &ToolchainLinuxGccLinker{
+		toolChain:    t,
+		buildConfig:  buildConfig,
+		buildTarget:  buildTarget,
+		linkerPath:   "",
+		linkerArgs:   args,
+		vars:         corepkg.NewVars(corepkg.VarsFormatCurlyBraces),
+		libraryFiles: []string{},
+	}
+}
+
+func (l *ToolchainLinuxGccLinker) LinkedFilepath(filepath string) string {
+	return filepath
+}
+
+func (l *ToolchainLinuxGccLinker) SetupArgs(libraryPaths []string, libraryFiles []string) {
+	for i, libPath := range libraryPaths {
+		libraryPaths[i] = "-L" + libPath
+	}
+	for i, libFile := range libraryFiles {
+		libraryFiles[i] = "-l" + libFile
+	}
+
+	l.vars.Prepend("library.paths", libraryPaths...)
+	l.libraryFiles = append(l.libraryFiles, libraryFiles...)
+
+	if linker_args, ok := l.toolChain.Vars.Get(`recipe.link.pattern`); ok {
+		l.linkerPath = linker_args[0]
+		l.linkerArgs = corepkg.NewArguments(0)
+		l.linkerArgs.Args = linker_args[1:]
+
+		l.linkerPath = l.toolChain.Vars.FinalResolveString(l.linkerPath, " ", l.vars)
+		l.linkerArgs.Args = l.toolChain.Vars.FinalResolveArray(l.linkerArgs.Args, l.vars)
+
+		l.linkerArgs.Args = slices.DeleteFunc(l.linkerArgs.Args, func(s string) bool { return strings.TrimSpace(s) == "" })
+	}
+}
+
+// commandLine returns the linker and the fully resolved arguments to link the executable
+func (l *ToolchainLinuxGccLinker) commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) (linkerPath string, linkerArgs []string) {
+	linkerPath = l.linkerPath
+	linkerArgs = slices.Clone(l.linkerArgs.Args)
+	linkerArgs = append(linkerArgs, gccSanitizeLinkArgs(l.toolChain.Sanitizers)...)
+	linkerArgs = append(linkerArgs, gccCoverageLinkArgs(l.toolChain.Coverage, l.toolChain.Name == "clang")...)
+
+	linkerArgs = append(linkerArgs, "-Wl,-Map,"+outputAppRelFilepathNoExt+".map")
+	linkerArgs = append(linkerArgs, "-o", l.LinkedFilepath(outputAppRelFilepathNoExt))
+	linkerArgs = append(linkerArgs, inputObjectsAbsFilepaths...)
+
+	// GNU ld resolves symbols of archives in command-line order, the dependencies of
+	// a project are not ordered by 'who uses who', so let ld search them as a group.
+	if len(inputArchivesAbsFilepaths) > 0 {
+		linkerArgs = append(linkerArgs, "-Wl,--start-group")
+		linkerArgs = append(linkerArgs, inputArchivesAbsFilepaths...)
+		linkerArgs = append(linkerArgs, "-Wl,--end-group")
+	}
+
+	// Shared libraries are copied next to the executable, let the loader look there
+	if slices.ContainsFunc(inputArchivesAbsFilepaths, func(s string) bool { return strings.HasSuffix(s, ".so") }) {
+		linkerArgs = append(linkerArgs, "-Wl,-rpath,$ORIGIN")
+	}
+	linkerArgs = append(linkerArgs, l.libraryFiles...)
+	return linkerPath, linkerArgs
+}
+
+func (l *ToolchainLinuxGccLinker) ArgsHash(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) []byte {
+	return argumentsHash(l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt))
+}
+
+func (l *ToolchainLinuxGccLinker) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error {
+	linkerPath, linkerArgs := l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt)
+
+	corepkg.LogInff("Linking (%s) %s", l.buildConfig.String(), filepath.Base(outputAppRelFilepathNoExt))
+
+	cmd := exec.Command(linkerPath, l.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, linkerPath, linkerArgs, outputAppRelFilepathNoExt)...)
+	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputAppRelFilepathNoExt, out)
+
+	if err != nil {
+		corepkg.LogInff("Link failed, output:\n%s", string(out))
+		return corepkg.LogError(err, "Linking failed")
+	}
+	if len(out) > 0 {
+		corepkg.LogInfof("Link output:\n%s", string(out))
+	}
+
+	return nil
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Burner
+
+func (t *LinuxGcc) NewBurner(buildConfig denv.BuildConfig, buildTarget denv.BuildTarget) Burner {
+	return &EmptyBurner{}
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Dependency Tracker
+func (t *LinuxGcc) NewDependencyTracker(dirpath string) deptrackr.FileTrackr {
+	return deptrackr.LoadDepFileTrackr(filepath.Join(dirpath, "deptrackr"))
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Default recipes
+
+// setLinuxGccDefaultVars sets the compilers and recipes that are not provided by the package.
+func setLinuxGccDefaultVars(vars *corepkg.Vars) {
+	setDefault := func(key string, envVar string, value ...string) {
+		if vars.Has(key) {
+			return
+		}
+		if envValue := os.Getenv(envVar); len(envVar) > 0 && len(envValue) > 0 {
+			value = []string{envValue}
+		}
+		vars.Set(key, value...)
+	}
+
+	setDefault("compiler.c.cmd", "CC", "gcc")
+	setDefault("compiler.cpp.cmd", "CXX", "g++")
+	setDefault("compiler.ar.cmd", "AR", "ar")
+
+	setDefault("compiler.c.flags", "", "-c", "-MMD", "-std=c11", "-Wall", "-fPIC")
+	setDefault("compiler.cpp.flags", "", "-c", "-MMD", "-std=c++17", "-Wall", "-fPIC")
+	setDefault("compiler.ar.flags", "", "rcs")
+	setDefault("compiler.so.flags", "", "-shared")
+	setDefault("compiler.link.flags", "", "-pthread")
+
+	setDefault("recipe.c.pattern", "", "{compiler.c.cmd}", "{compiler.c.flags}", "{build.config.flags}", "{build.defines}", "{build.includes}")
+	setDefault("recipe.cpp.pattern", "", "{compiler.cpp.cmd}", "{compiler.cpp.flags}", "{build.config.flags}", "{build.defines}", "{build.includes}")
+	setDefault("recipe.ar.pattern", "", "{compiler.ar.cmd}", "{compiler.ar.flags}")
+	setDefault("recipe.so.pattern", "", "{compiler.cpp.cmd}", "{compiler.so.flags}")
+	setDefault("recipe.link.pattern", "", "{compiler.cpp.cmd}", "{compiler.link.flags}", "{library.paths}")
+}
+
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Toolchain for GCC/Clang on Linux
+func NewLinuxGcc(vars *corepkg.Vars, projectName string, buildPath string, arch string) *LinuxGcc {
+
+	setLinuxGccDefaultVars(vars)
+
+	vars.Set("project.name", projectName)
+	vars.Set("build.path", buildPath)
+	vars.Set("build.arch", arch)
+
+	name := "gcc"
+	if strings.Contains(filepath.Base(vars.GetFirstOrEmpty("compiler.cpp.cmd")), "clang") {
+		name = "clang"
+	}
+
+	return &LinuxGcc{Name: name, Vars: vars}
+}
@@ -1,6 +1,7 @@
 package toolchain
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,14 +14,29 @@ import (
 )
 
 type WinMsdev struct {
-	Name string
-	Vars *corepkg.Vars
+	Name       string
+	Vars       *corepkg.Vars
+	Sanitizers []Sanitizer // Compile with these sanitizers (clay build --sanitize)
+
+	ResponseFiles ResponseFiles // When to pass the arguments of a tool in a response file
 
 	// Environment variables for the toolchain processes, these are environment variables necessary
 	// to configure Microsoft Visual Studio command line tools.
 	Env []string
 }
 
+// SetSanitizers builds with the sanitizers, msvc only supports the address sanitizer. The
+// linker adds the runtime libraries of the address sanitizer by itself (/INFERASANLIBS).
+func (t *WinMsdev) SetSanitizers(sanitizers []Sanitizer) error {
+	for _, sanitizer := range sanitizers {
+		if sanitizer != SanitizerAddress {
+			return fmt.Errorf("the %s sanitizer is not supported by msvc, only the address sanitizer is", sanitizer)
+		}
+	}
+	t.Sanitizers = sanitizers
+	return nil
+}
+
 // --------------------------------------------------------------------------------------------------
 // --------------------------------------------------------------------------------------------------
 // File Commander
@@ -39,6 +55,7 @@ func (t *WinMsdev) ChangeFileExtension(_filepath string, newExt string) string {
 
 type WinMsdevCompiler struct {
 	toolChain       *WinMsdev
+	projectName     string
 	buildConfig     denv.BuildConfig
 	buildTarget     denv.BuildTarget
 	objFilePrefix   string
@@ -49,6 +66,8 @@ type WinMsdevCompiler struct {
 	cCompilerArgs   *corepkg.Arguments
 	cppCompilerPath string
 	cppCompilerArgs *corepkg.Arguments
+	pch             *Pch
+	pchFilepath     string
 	vars            *corepkg.Vars
 }
 
@@ -85,6 +104,7 @@ func (cl *WinMsdevCompiler) DepFilepath(objRelFilepath string) string {
 }
 
 func (cl *WinMsdevCompiler) SetupArgs(projectName string, buildPath string, _defines []string, _includes []string) {
+	cl.projectName = projectName
 	for i, inc := range _includes {
 		if !strings.HasPrefix(inc, "/I") {
 			_includes[i] = "/I" + inc
@@ -137,32 +157,86 @@ func (cl *WinMsdevCompiler) SetupArgs(projectName string, buildPath string, _def
 	}
 }
 
-func (cl *WinMsdevCompiler) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
-	errors := 0
-	compiled := make([]bool, len(sourceAbsFilepaths))
-	for s, sourceAbsFilepath := range sourceAbsFilepaths {
-		var compilerPath string
-		var compilerArgs []string
-		if strings.HasSuffix(sourceAbsFilepath, ".c") {
-			compilerPath = cl.cCompilerPath
-			compilerArgs = cl.cCompilerArgs.Args
+// SetupPch creates the precompiled header '<build>/pch/<header>.pch' (/Yc) by compiling a
+// generated source file that force-includes the header, the object file of this source
+// file needs to be linked. The C++ source files use the precompiled header with /Yu.
+func (cl *WinMsdevCompiler) SetupPch(headerAbsFilepath string, buildPath string) *Pch {
+	dirpath := pchDirpath(buildPath)
+	srcFilepath := filepath.Join(dirpath, filepath.Base(headerAbsFilepath)+".cpp")
+	if err := WriteGeneratedFile(srcFilepath, "// Generated by clay, creates the precompiled header\n"); err != nil {
+		corepkg.LogErrorf(err, "Failed to write %q", srcFilepath)
+		return nil
+	}
+	if srcAbsFilepath, err := filepath.Abs(srcFilepath); err == nil {
+		srcFilepath = srcAbsFilepath
+	}
+
+	objRelFilepath := filepath.Join(dirpath, filepath.Base(headerAbsFilepath)+".cpp"+cl.objFileSuffix)
+	cl.pchFilepath = filepath.Join(dirpath, filepath.Base(headerAbsFilepath)+".pch")
+	cl.pch = &Pch{
+		HeaderAbsFilepath: headerAbsFilepath,
+		SrcAbsFilepath:    srcFilepath,
+		ObjRelFilepath:    objRelFilepath,
+		DepRelFilepath:    cl.toolChain.ChangeFileExtension(objRelFilepath, ".json"),
+		Link:              true,
+	}
+	return cl.pch
+}
+
+// commandLine returns the compiler and the fully resolved arguments to compile a source file
+func (cl *WinMsdevCompiler) commandLine(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	if strings.HasSuffix(sourceAbsFilepath, ".c") {
+		compilerPath = cl.cCompilerPath
+		compilerArgs = slices.Clone(cl.cCompilerArgs.Args)
+	} else {
+		compilerPath = cl.cppCompilerPath
+		compilerArgs = slices.Clone(cl.cppCompilerArgs.Args)
+	}
+
+	// The address sanitizer cannot be combined with the runtime checks (/RTC)
+	if slices.Contains(cl.toolChain.Sanitizers, SanitizerAddress) {
+		compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.HasPrefix(s, "/RTC") })
+		compilerArgs = append(compilerArgs, "/fsanitize=address")
+	}
+
+	if cl.pch != nil && cl.pch.UsedBy(sourceAbsFilepath) {
+		if sourceAbsFilepath == cl.pch.SrcAbsFilepath {
+			compilerArgs = append(compilerArgs, "/Yc"+cl.pch.HeaderAbsFilepath)
 		} else {
-			compilerPath = cl.cppCompilerPath
-			compilerArgs = cl.cppCompilerArgs.Args
+			compilerArgs = append(compilerArgs, "/Yu"+cl.pch.HeaderAbsFilepath)
 		}
+		compilerArgs = append(compilerArgs, "/FI"+cl.pch.HeaderAbsFilepath, "/Fp"+cl.pchFilepath)
+	}
 
-		compilerArgs = append(compilerArgs, "/sourceDependencies")
-		compilerArgs = append(compilerArgs, cl.toolChain.ChangeFileExtension(objRelFilepaths[s], ".json"))
+	compilerArgs = append(compilerArgs, "/sourceDependencies")
+	compilerArgs = append(compilerArgs, cl.toolChain.ChangeFileExtension(objRelFilepath, ".json"))
 
-		compilerArgs = append(compilerArgs, "/Fo"+objRelFilepaths[s])
+	compilerArgs = append(compilerArgs, "/Fo"+objRelFilepath)
 
-		compilerArgs = append(compilerArgs, sourceAbsFilepath)
-		compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+	compilerArgs = append(compilerArgs, sourceAbsFilepath)
+	compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+	return compilerPath, compilerArgs
+}
 
-		//fmt.Printf("Compiler path %s and args %v\n", compilerPath, compilerArgs)
+func (cl *WinMsdevCompiler) ArgsHash(sourceAbsFilepath string, objRelFilepath string) []byte {
+	return argumentsHash(cl.commandLine(sourceAbsFilepath, objRelFilepath))
+}
 
-		cmd := exec.Command(compilerPath, compilerArgs...)
-		cmd.Env = cl.toolChain.Env
+func (cl *WinMsdevCompiler) CacheKey(sourceAbsFilepath string, objRelFilepath string) []byte {
+	compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepath)
+	return compilerCacheKey(compilerPath, compilerArgs, objRelFilepath)
+}
+
+func (cl *WinMsdevCompiler) CompileCommand(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	return cl.commandLine(sourceAbsFilepath, objRelFilepath)
+}
+
+func (cl *WinMsdevCompiler) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
+	jobs := make([]*compileJob, 0, len(sourceAbsFilepaths))
+	for s, sourceAbsFilepath := range sourceAbsFilepaths {
+		compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepaths[s])
+
+		//fmt.Printf("Compiler path %s and args %v\n", compilerPath, compilerArgs)
 
 		// path := make([]string, 0)
 		// path = append(path, `C:\Program Files\Microsoft Visual Studio\2022\Professional\VC\Tools\MSVC\14.44.35207\bin\HostX64\x64`)
@@ -177,21 +251,19 @@ func (cl *WinMsdevCompiler) Compile(sourceAbsFilepaths []string, objRelFilepaths
 		// 	}
 		// }
 
-		corepkg.LogInfof("Compiling (%s) %s", cl.buildConfig.String(), filepath.Base(sourceAbsFilepath))
-
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			corepkg.LogInfof("Compile failed, output:\n%s", string(out))
-			compiled[s] = false
-			errors = errors + 1
-		} else {
-			//if len(out) > 0 {
-			//	corepkg.LogInfof("Compile output:\n%s", string(out))
-			//}
-			compiled[s] = true
-		}
+		// The msvc compiler always echoes the name of the source file, so the output of a
+		// successful compile is not logged.
+		jobs = append(jobs, &compileJob{
+			message:        fmt.Sprintf("Compiling (%s) %s", cl.buildConfig.String(), filepath.Base(sourceAbsFilepath)),
+			project:        cl.projectName,
+			srcFilepath:    sourceAbsFilepath,
+			toolPath:       compilerPath,
+			toolArgs:       cl.toolChain.ResponseFiles.toolArgs(ResponseFileMsvc, compilerPath, compilerArgs, objRelFilepaths[s]),
+			env:            cl.toolChain.Env,
+			quietOnSuccess: true,
+		})
 	}
-	return compiled, errors == 0
+	return runCompileJobs(jobs)
 }
 
 // --------------------------------------------------------------------------------------------------
@@ -212,7 +284,7 @@ func (t *WinMsdev) NewArchiver(at ArchiverType, buildConfig denv.BuildConfig, bu
 	case ArchiverTypeStatic:
 		return &WinMsdevArchiver{toolChain: t, buildConfig: buildConfig, buildTarget: buildTarget, arArgs: args}
 	case ArchiverTypeDynamic:
-		return &WinMsdevArchiver{toolChain: t, buildConfig: buildConfig, buildTarget: buildTarget, arArgs: args}
+		return &WinMsdevDynamicArchiver{toolChain: t, buildConfig: buildConfig, buildTarget: buildTarget, dllArgs: args}
 	}
 	return nil
 }
@@ -223,6 +295,10 @@ func (t *WinMsdevArchiver) LibFilepath(_filepath string) string {
 	return filepath.Join(dirpath, filename+".lib") // The file extension for the archive on Windows is typically ".lib"
 }
 
+func (t *WinMsdevArchiver) RuntimeFilepath(libFilepath string) string {
+	return ""
+}
+
 func (t *WinMsdevArchiver) SetupArgs() {
 	if archiver_args, ok := t.toolChain.Vars.Get(`recipe.lib.pattern`); ok {
 		t.arPath = archiver_args[0]
@@ -235,18 +311,29 @@ func (t *WinMsdevArchiver) SetupArgs() {
 	}
 }
 
-func (t *WinMsdevArchiver) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
-	archiverPath := t.arPath
-	archiverArgs := t.arArgs.Args
+// commandLine returns the archiver and the fully resolved arguments to create the archive
+func (t *WinMsdevArchiver) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (archiverPath string, archiverArgs []string) {
+	archiverPath = t.arPath
+	archiverArgs = slices.Clone(t.arArgs.Args)
 
 	// TODO would like this to be part of the resolve step
 	archiverArgs = append(archiverArgs, "/OUT:"+outputArchiveFilepath)
 	archiverArgs = append(archiverArgs, inputObjectFilepaths...)
+	return archiverPath, archiverArgs
+}
 
-	cmd := exec.Command(archiverPath, archiverArgs...)
+func (t *WinMsdevArchiver) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(t.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
+
+func (t *WinMsdevArchiver) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
+	archiverPath, archiverArgs := t.commandLine(inputObjectFilepaths, outputArchiveFilepath)
+
+	cmd := exec.Command(archiverPath, t.toolChain.ResponseFiles.toolArgs(ResponseFileMsvc, archiverPath, archiverArgs, outputArchiveFilepath)...)
 	cmd.Env = t.toolChain.Env
 
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
 
 	if err != nil {
 		return corepkg.LogErrorf(err, "Archiving failed: ", string(out))
@@ -255,6 +342,83 @@ func (t *WinMsdevArchiver) Archive(inputObjectFilepaths []string, outputArchiveF
 	return nil
 }
 
+// --------------------------------------------------------------------------------------------------
+// --------------------------------------------------------------------------------------------------
+// Microsoft Visual Studio Dynamic Archiver (DLL)
+
+type WinMsdevDynamicArchiver struct {
+	toolChain   *WinMsdev
+	buildConfig denv.BuildConfig
+	buildTarget denv.BuildTarget
+	dllPath     string
+	dllArgs     *corepkg.Arguments
+}
+
+// LibFilepath returns the import library of the DLL, this is what executables link with,
+// the DLL itself is written next to it (see RuntimeFilepath).
+func (t *WinMsdevDynamicArchiver) LibFilepath(_filepath string) string {
+	filename := corepkg.PathFilename(_filepath, true)
+	dirpath := corepkg.PathDirname(_filepath)
+	return filepath.Join(dirpath, filename+".lib")
+}
+
+func (t *WinMsdevDynamicArchiver) RuntimeFilepath(libFilepath string) string {
+	return t.toolChain.ChangeFileExtension(libFilepath, ".dll")
+}
+
+// SetupArgs uses 'recipe.dll.pattern' when it is defined, otherwise the DLL is linked
+// with 'recipe.link.pattern' and the /DLL option.
+func (t *WinMsdevDynamicArchiver) SetupArgs() {
+	t.dllArgs = corepkg.NewArguments(0)
+	if dll_args, ok := t.toolChain.Vars.Get(`recipe.dll.pattern`); ok {
+		t.dllPath = dll_args[0]
+		t.dllArgs.Args = dll_args[1:]
+	} else if linker_args, ok := t.toolChain.Vars.Get(`recipe.link.pattern`); ok {
+		t.dllPath = linker_args[0]
+		t.dllArgs.Args = append(slices.Clone(linker_args[1:]), "/DLL")
+	}
+
+	vars := corepkg.NewVars(corepkg.VarsFormatCurlyBraces)
+	t.dllPath = t.toolChain.Vars.FinalResolveString(t.dllPath, " ", vars)
+	t.dllArgs.Args = t.toolChain.Vars.FinalResolveArray(t.dllArgs.Args, vars)
+
+	t.dllArgs.Args = slices.DeleteFunc(t.dllArgs.Args, func(s string) bool { return strings.TrimSpace(s) == "" })
+}
+
+// commandLine returns the linker and the fully resolved arguments to create the DLL and its import library
+func (t *WinMsdevDynamicArchiver) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (dllPath string, dllArgs []string) {
+	dllPath = t.dllPath
+	dllArgs = slices.Clone(t.dllArgs.Args)
+
+	dllArgs = append(dllArgs, "/OUT:"+t.RuntimeFilepath(outputArchiveFilepath))
+	dllArgs = append(dllArgs, "/IMPLIB:"+outputArchiveFilepath)
+	dllArgs = append(dllArgs, inputObjectFilepaths...)
+	return dllPath, dllArgs
+}
+
+func (t *WinMsdevDynamicArchiver) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(t.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
+
+func (t *WinMsdevDynamicArchiver) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
+	dllPath, dllArgs := t.commandLine(inputObjectFilepaths, outputArchiveFilepath)
+
+	corepkg.LogInff("Linking (%s) %s", t.buildConfig.String(), filepath.Base(t.RuntimeFilepath(outputArchiveFilepath)))
+
+	cmd := exec.Command(dllPath, t.toolChain.ResponseFiles.toolArgs(ResponseFileMsvc, dllPath, dllArgs, outputArchiveFilepath)...)
+	cmd.Env = t.toolChain.Env
+
+	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
+
+	if err != nil {
+		corepkg.LogInff("Link failed, output:\n%s", string(out))
+		return corepkg.LogError(err, "Linking failed")
+	}
+
+	return nil
+}
+
 // --------------------------------------------------------------------------------------------------
 // --------------------------------------------------------------------------------------------------
 
@@ -310,10 +474,10 @@ func (l *WinMsdevLinker) SetupArgs(libraryPaths []string, libraryFiles []string)
 	}
 }
 
-func (l *WinMsdevLinker) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepath string) error {
-
-	linkerPath := l.linkerPath
-	linkerArgs := l.linkerArgs.Args
+// commandLine returns the linker and the fully resolved arguments to link the executable
+func (l *WinMsdevLinker) commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepath string) (linkerPath string, linkerArgs []string) {
+	linkerPath = l.linkerPath
+	linkerArgs = slices.Clone(l.linkerArgs.Args)
 
 	// TODO would like this to be part of the resolve step
 	linkerArgs = append(linkerArgs, "/MAP:"+l.toolChain.ChangeFileExtension(outputAppRelFilepath, ".map"))
@@ -323,15 +487,25 @@ func (l *WinMsdevLinker) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepath
 	// for _, libFile := range l.libraryFiles {
 	// 	linkerArgs = append(linkerArgs, libFile)
 	// }
+	return linkerPath, linkerArgs
+}
+
+func (l *WinMsdevLinker) ArgsHash(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepath string) []byte {
+	return argumentsHash(l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepath))
+}
+
+func (l *WinMsdevLinker) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepath string) error {
+	linkerPath, linkerArgs := l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepath)
 
 	corepkg.LogInff("Linking (%s) %s", l.buildConfig.String(), filepath.Base(outputAppRelFilepath))
 
 	// corepkg.LogInfof("Linker command: %s %s", linkerPath, strings.Join(linkerArgs, " "))
 
-	cmd := exec.Command(linkerPath, linkerArgs...)
+	cmd := exec.Command(linkerPath, l.toolChain.ResponseFiles.toolArgs(ResponseFileMsvc, linkerPath, linkerArgs, outputAppRelFilepath)...)
 	cmd.Env = l.toolChain.Env
 
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputAppRelFilepath, out)
 
 	if err != nil {
 		corepkg.LogInff("Link failed, output:\n%s", string(out))
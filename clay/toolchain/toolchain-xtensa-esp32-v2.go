@@ -21,8 +21,9 @@ import (
 )
 
 type ArduinoEsp32Toolchainv2 struct {
-	ProjectName string // The name of the project, used for output files
-	Vars        *corepkg.Vars
+	ProjectName   string // The name of the project, used for output files
+	Vars          *corepkg.Vars
+	ResponseFiles ResponseFiles // When to pass the arguments of a tool in a response file
 }
 
 // --------------------------------------------------------------------------------------------------
@@ -127,6 +128,7 @@ func generatePartitions(filepath string, ota bool, otaSize int64, nvsSize int64,
 
 type ToolchainArduinoEsp32Compilerv2 struct {
 	toolChain   *ArduinoEsp32Toolchainv2
+	projectName string
 	buildConfig denv.BuildConfig // Configuration for the compiler, e.g., debug or release
 	buildTarget denv.BuildTarget
 	vars        *corepkg.Vars // Local variables for the compiler
@@ -150,6 +152,7 @@ func (cl *ToolchainArduinoEsp32Compilerv2) DepFilepath(objRelFilepath string) st
 }
 
 func (cl *ToolchainArduinoEsp32Compilerv2) SetupArgs(projectName string, buildPath string, defines []string, includes []string) {
+	cl.projectName = projectName
 	for i, inc := range includes {
 		includes[i] = "-I" + inc
 	}
@@ -165,63 +168,76 @@ func (cl *ToolchainArduinoEsp32Compilerv2) SetupArgs(projectName string, buildPa
 	cl.vars.Append("includes", includes...)
 }
 
-func (cl *ToolchainArduinoEsp32Compilerv2) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
-	errors := 0
-	compiled := make([]bool, len(sourceAbsFilepaths))
+// SetupPch is not supported, the compile recipes of the Arduino platform are used as is.
+func (cl *ToolchainArduinoEsp32Compilerv2) SetupPch(headerAbsFilepath string, buildPath string) *Pch {
+	return nil
+}
 
-	for i, sourceAbsFilepath := range sourceAbsFilepaths {
-		corepkg.LogInfof("Compiling %s", filepath.Base(sourceAbsFilepath))
-
-		objRelFilepath := objRelFilepaths[i]
-		cl.vars.Set("build.source.path", corepkg.PathDirname(sourceAbsFilepath))
-		cl.vars.Set("source_file", sourceAbsFilepath)
-		cl.vars.Set("object_file", objRelFilepath)
-
-		var compilerPath string
-		var compilerArgs []string
-		if strings.HasSuffix(sourceAbsFilepath, ".c") {
-			c_compiler, _ := cl.toolChain.Vars.Get(`recipe.c.o.pattern`)
-			compilerPath = c_compiler[0]
-			compilerArgs = c_compiler[1:]
-			compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
-			compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
-		} else {
-			cpp_compiler, _ := cl.toolChain.Vars.Get(`recipe.cpp.o.pattern`)
-			compilerPath = cpp_compiler[0]
-			compilerArgs = cpp_compiler[1:]
-			compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
-			compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
-		}
-		compilerPath = corepkg.StrTrimDelimiters(compilerPath, '"')
+// commandLine returns the compiler and the fully resolved arguments to compile a source file.
+// Note: Resolving modifies the vars of the compiler, it should not be called concurrently.
+func (cl *ToolchainArduinoEsp32Compilerv2) commandLine(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	cl.vars.Set("build.source.path", corepkg.PathDirname(sourceAbsFilepath))
+	cl.vars.Set("source_file", sourceAbsFilepath)
+	cl.vars.Set("object_file", objRelFilepath)
 
-		// Replace '-w' with a narrower warning suppression.
-		for j, arg := range compilerArgs {
-			if arg == "-w" {
-				compilerArgs[j] = "-Wno-deprecated-declarations"
-			}
+	if strings.HasSuffix(sourceAbsFilepath, ".c") {
+		c_compiler, _ := cl.toolChain.Vars.Get(`recipe.c.o.pattern`)
+		compilerPath = c_compiler[0]
+		compilerArgs = c_compiler[1:]
+		compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
+		compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
+	} else {
+		cpp_compiler, _ := cl.toolChain.Vars.Get(`recipe.cpp.o.pattern`)
+		compilerPath = cpp_compiler[0]
+		compilerArgs = cpp_compiler[1:]
+		compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
+		compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
+	}
+	compilerPath = corepkg.StrTrimDelimiters(compilerPath, '"')
+
+	// Replace '-w' with a narrower warning suppression.
+	for j, arg := range compilerArgs {
+		if arg == "-w" {
+			compilerArgs[j] = "-Wno-deprecated-declarations"
 		}
+	}
 
-		// Remove empty entries from compilerArgs
-		compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+	// Remove empty entries from compilerArgs
+	compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+	return compilerPath, compilerArgs
+}
 
-		//fmt.Printf("Compiler path %s and args %v\n", compilerPath, compilerArgs)
+func (cl *ToolchainArduinoEsp32Compilerv2) ArgsHash(sourceAbsFilepath string, objRelFilepath string) []byte {
+	return argumentsHash(cl.commandLine(sourceAbsFilepath, objRelFilepath))
+}
 
-		cmd := exec.Command(compilerPath, compilerArgs...)
-		out, err := cmd.CombinedOutput()
+func (cl *ToolchainArduinoEsp32Compilerv2) CacheKey(sourceAbsFilepath string, objRelFilepath string) []byte {
+	compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepath)
+	return compilerCacheKey(compilerPath, compilerArgs, objRelFilepath)
+}
 
-		if err != nil {
-			corepkg.LogInfof("Compile failed, output:\n%s", string(out))
-			errors++
-			compiled[i] = false
-		} else {
-			if len(out) > 0 {
-				corepkg.LogInfof("Compile output:\n%s", string(out))
-			}
-			compiled[i] = true
-		}
+func (cl *ToolchainArduinoEsp32Compilerv2) CompileCommand(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	return cl.commandLine(sourceAbsFilepath, objRelFilepath)
+}
+
+func (cl *ToolchainArduinoEsp32Compilerv2) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
+	// Resolving the command-lines is done up front, the shared vars are modified per file
+	jobs := make([]*compileJob, 0, len(sourceAbsFilepaths))
+	for i, sourceAbsFilepath := range sourceAbsFilepaths {
+		compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepaths[i])
+
+		//fmt.Printf("Compiler path %s and args %v\n", compilerPath, compilerArgs)
+
+		jobs = append(jobs, &compileJob{
+			message:     "Compiling " + filepath.Base(sourceAbsFilepath),
+			project:     cl.projectName,
+			srcFilepath: sourceAbsFilepath,
+			toolPath:    compilerPath,
+			toolArgs:    cl.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, compilerPath, compilerArgs, objRelFilepaths[i]),
+		})
 	}
 
-	return compiled, errors == 0
+	return runCompileJobs(jobs)
 }
 
 // --------------------------------------------------------------------------------------------------
@@ -249,17 +265,20 @@ func (t *ToolchainArduinoEsp32Archiverv2) LibFilepath(filepath string) string {
 	return filepath + ".a"
 }
 
-func (a *ToolchainArduinoEsp32Archiverv2) SetupArgs() {
+func (t *ToolchainArduinoEsp32Archiverv2) RuntimeFilepath(libFilepath string) string {
+	return ""
 }
 
-func (a *ToolchainArduinoEsp32Archiverv2) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
-	corepkg.LogInfof("Archiving %s", outputArchiveFilepath)
+func (a *ToolchainArduinoEsp32Archiverv2) SetupArgs() {
+}
 
+// commandLine returns the archiver and the fully resolved arguments to create the archive
+func (a *ToolchainArduinoEsp32Archiverv2) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (archiverPath string, archiverArgs []string) {
 	a.vars.Set("archive_file_path", outputArchiveFilepath)
 	a.vars.Set("object_file", inputObjectFilepaths...)
 
-	archiverArgs, _ := a.toolChain.Vars.Get(`recipe.ar.pattern`)
-	archiverPath := archiverArgs[0]
+	archiverArgs, _ = a.toolChain.Vars.Get(`recipe.ar.pattern`)
+	archiverPath = archiverArgs[0]
 	archiverArgs = archiverArgs[1:]
 
 	// Resolve archiverPath and archiverArgs
@@ -270,9 +289,21 @@ func (a *ToolchainArduinoEsp32Archiverv2) Archive(inputObjectFilepaths []string,
 	archiverArgs = slices.DeleteFunc(archiverArgs, func(s string) bool {
 		return strings.TrimSpace(s) == ""
 	})
+	return archiverPath, archiverArgs
+}
+
+func (a *ToolchainArduinoEsp32Archiverv2) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(a.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
+
+func (a *ToolchainArduinoEsp32Archiverv2) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
+	corepkg.LogInfof("Archiving %s", outputArchiveFilepath)
 
-	cmd := exec.Command(archiverPath, archiverArgs...)
+	archiverPath, archiverArgs := a.commandLine(inputObjectFilepaths, outputArchiveFilepath)
+
+	cmd := exec.Command(archiverPath, a.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, archiverPath, archiverArgs, outputArchiveFilepath)...)
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
 	if err != nil {
 		return corepkg.LogErrorf(err, "Archiving failed")
 	}
@@ -322,12 +353,11 @@ func (l *ToolchainArduinoEsp32Linkerv2) SetupArgs(libraryPaths []string, library
 	l.vars.Set("build.extra_libs", libraryFiles...)
 }
 
-func (l *ToolchainArduinoEsp32Linkerv2) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error {
-	corepkg.LogInfof("Linking '%s'...", outputAppRelFilepathNoExt)
-
-	linkerArgs, _ := l.toolChain.Vars.Get(`recipe.c.combine.pattern`)
+// commandLine returns the linker and the fully resolved arguments to link the executable
+func (l *ToolchainArduinoEsp32Linkerv2) commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) (linkerPath string, linkerArgs []string) {
+	linkerArgs, _ = l.toolChain.Vars.Get(`recipe.c.combine.pattern`)
 
-	linkerPath := linkerArgs[0]
+	linkerPath = linkerArgs[0]
 	linkerArgs = linkerArgs[1:]
 
 	l.vars.Set("object_files", inputObjectsAbsFilepaths...)
@@ -356,9 +386,21 @@ func (l *ToolchainArduinoEsp32Linkerv2) Link(inputObjectsAbsFilepaths, inputArch
 	linkerArgs = slices.DeleteFunc(linkerArgs, func(s string) bool {
 		return strings.TrimSpace(s) == ""
 	})
+	return linkerPath, linkerArgs
+}
+
+func (l *ToolchainArduinoEsp32Linkerv2) ArgsHash(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) []byte {
+	return argumentsHash(l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt))
+}
+
+func (l *ToolchainArduinoEsp32Linkerv2) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error {
+	corepkg.LogInfof("Linking '%s'...", outputAppRelFilepathNoExt)
+
+	linkerPath, linkerArgs := l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt)
 
-	cmd := exec.Command(linkerPath, linkerArgs...)
+	cmd := exec.Command(linkerPath, l.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, linkerPath, linkerArgs, outputAppRelFilepathNoExt)...)
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputAppRelFilepathNoExt, out)
 
 	if err != nil {
 		corepkg.LogInfof("Link failed, output:\n%s", string(out))
@@ -835,7 +877,8 @@ func (t *ArduinoEsp32Toolchainv2) NewDependencyTracker(dirpath string) deptrackr
 // --------------------------------------------------------------------------------------------------
 
 func NewArduinoEsp32Toolchainv2(boardVars *corepkg.Vars, projectName string, buildPath string) *ArduinoEsp32Toolchainv2 {
-	tc := &ArduinoEsp32Toolchainv2{ProjectName: projectName, Vars: boardVars}
+	// The Arduino SDK passes hundreds of include directories, always use response files
+	tc := &ArduinoEsp32Toolchainv2{ProjectName: projectName, Vars: boardVars, ResponseFiles: ResponseFiles{Always: true}}
 
 	boardVars.Set("project.name", projectName)
 	boardVars.Set("build.path", buildPath)
@@ -19,8 +19,9 @@ import (
 )
 
 type ArduinoEsp8266Toolchain struct {
-	Vars        *corepkg.Vars
-	ProjectName string // The name of the project, used for output files
+	Vars          *corepkg.Vars
+	ProjectName   string        // The name of the project, used for output files
+	ResponseFiles ResponseFiles // When to pass the arguments of a tool in a response file
 }
 
 // --------------------------------------------------------------------------------------------------
@@ -36,6 +37,7 @@ func (t *ArduinoEsp8266Toolchain) NewFileCommander(buildConfig denv.BuildConfig,
 
 type ToolchainArduinoEsp8266Compiler struct {
 	toolChain   *ArduinoEsp8266Toolchain
+	projectName string
 	buildConfig denv.BuildConfig
 	buildTarget denv.BuildTarget
 	// Configuration for the compiler, e.g., debug or release
@@ -68,6 +70,7 @@ func (cl *ToolchainArduinoEsp8266Compiler) DepFilepath(objRelFilepath string) st
 }
 
 func (cl *ToolchainArduinoEsp8266Compiler) SetupArgs(projectName string, buildPath string, defines []string, includes []string) {
+	cl.projectName = projectName
 	for i, inc := range includes {
 		includes[i] = "-I" + inc
 	}
@@ -83,64 +86,77 @@ func (cl *ToolchainArduinoEsp8266Compiler) SetupArgs(projectName string, buildPa
 	cl.vars.Append("build.extra_flags", defines...)
 }
 
-func (cl *ToolchainArduinoEsp8266Compiler) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
-	errors := 0
-	compiled := make([]bool, len(sourceAbsFilepaths))
-	for i, sourceAbsFilepath := range sourceAbsFilepaths {
-		corepkg.LogInfof("Compiling %s", filepath.Base(sourceAbsFilepath))
-
-		objRelFilepath := objRelFilepaths[i]
-		cl.vars.Set("build.source.path", corepkg.PathDirname(sourceAbsFilepath))
-		cl.vars.Set("source_file", sourceAbsFilepath)
-		cl.vars.Set("object_file", objRelFilepath)
-
-		var compilerPath string
-		var compilerArgs []string
-
-		fileExtension := filepath.Ext(sourceAbsFilepath)
-		if strings.EqualFold(fileExtension, ".c") {
-			c_compiler, _ := cl.toolChain.Vars.Get(`recipe.c.o.pattern`)
-			compilerPath = c_compiler[0]
-			compilerArgs = c_compiler[1:]
-			compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
-			compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
-		} else if strings.EqualFold(fileExtension, ".cpp") {
-			cpp_compiler, _ := cl.toolChain.Vars.Get(`recipe.cpp.o.pattern`)
-			compilerPath = cpp_compiler[0]
-			compilerArgs = cpp_compiler[1:]
-			compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
-			compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
-		} else if strings.EqualFold(fileExtension, ".s") {
-			asm_compiler, _ := cl.toolChain.Vars.Get(`recipe.S.o.pattern`)
-			compilerPath = asm_compiler[0]
-			compilerArgs = asm_compiler[1:]
-			compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
-			compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
-		}
+// SetupPch is not supported, the compile recipes of the Arduino platform are used as is.
+func (cl *ToolchainArduinoEsp8266Compiler) SetupPch(headerAbsFilepath string, buildPath string) *Pch {
+	return nil
+}
 
-		compilerPath = corepkg.StrTrimDelimiters(compilerPath, '"')
+// commandLine returns the compiler and the fully resolved arguments to compile a source file.
+// Note: Resolving modifies the vars of the compiler, it should not be called concurrently.
+func (cl *ToolchainArduinoEsp8266Compiler) commandLine(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	cl.vars.Set("build.source.path", corepkg.PathDirname(sourceAbsFilepath))
+	cl.vars.Set("source_file", sourceAbsFilepath)
+	cl.vars.Set("object_file", objRelFilepath)
+
+	fileExtension := filepath.Ext(sourceAbsFilepath)
+	if strings.EqualFold(fileExtension, ".c") {
+		c_compiler, _ := cl.toolChain.Vars.Get(`recipe.c.o.pattern`)
+		compilerPath = c_compiler[0]
+		compilerArgs = c_compiler[1:]
+		compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
+		compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
+	} else if strings.EqualFold(fileExtension, ".cpp") {
+		cpp_compiler, _ := cl.toolChain.Vars.Get(`recipe.cpp.o.pattern`)
+		compilerPath = cpp_compiler[0]
+		compilerArgs = cpp_compiler[1:]
+		compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
+		compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
+	} else if strings.EqualFold(fileExtension, ".s") {
+		asm_compiler, _ := cl.toolChain.Vars.Get(`recipe.S.o.pattern`)
+		compilerPath = asm_compiler[0]
+		compilerArgs = asm_compiler[1:]
+		compilerPath = cl.toolChain.Vars.FinalResolveString(compilerPath, " ", cl.vars)
+		compilerArgs = cl.toolChain.Vars.FinalResolveArray(compilerArgs, cl.vars)
+	}
+
+	compilerPath = corepkg.StrTrimDelimiters(compilerPath, '"')
+
+	// Remove empty entries from compilerArgs
+	compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+	return compilerPath, compilerArgs
+}
 
-		// Remove empty entries from compilerArgs
-		compilerArgs = slices.DeleteFunc(compilerArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+func (cl *ToolchainArduinoEsp8266Compiler) ArgsHash(sourceAbsFilepath string, objRelFilepath string) []byte {
+	return argumentsHash(cl.commandLine(sourceAbsFilepath, objRelFilepath))
+}
+
+func (cl *ToolchainArduinoEsp8266Compiler) CacheKey(sourceAbsFilepath string, objRelFilepath string) []byte {
+	compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepath)
+	return compilerCacheKey(compilerPath, compilerArgs, objRelFilepath)
+}
+
+func (cl *ToolchainArduinoEsp8266Compiler) CompileCommand(sourceAbsFilepath string, objRelFilepath string) (compilerPath string, compilerArgs []string) {
+	return cl.commandLine(sourceAbsFilepath, objRelFilepath)
+}
+
+func (cl *ToolchainArduinoEsp8266Compiler) Compile(sourceAbsFilepaths []string, objRelFilepaths []string) ([]bool, bool) {
+	// Resolving the command-lines is done up front, the shared vars are modified per file
+	jobs := make([]*compileJob, 0, len(sourceAbsFilepaths))
+	for i, sourceAbsFilepath := range sourceAbsFilepaths {
+		compilerPath, compilerArgs := cl.commandLine(sourceAbsFilepath, objRelFilepaths[i])
 
 		// corepkg.LogInfof("Using compiler: %s", compilerPath)
 		// corepkg.LogInfof("Compiler args: %s", strings.Join(compilerArgs, " "))
 
-		cmd := exec.Command(compilerPath, compilerArgs...)
-		out, err := cmd.CombinedOutput()
-
-		if err != nil {
-			corepkg.LogInfof("Compile failed, output:\n%s", string(out))
-			errors += 1
-			compiled[i] = false
-		} else {
-			if len(out) > 0 {
-				corepkg.LogInfof("Compile output:\n%s", string(out))
-			}
-			compiled[i] = true
-		}
+		jobs = append(jobs, &compileJob{
+			message:     "Compiling " + filepath.Base(sourceAbsFilepath),
+			project:     cl.projectName,
+			srcFilepath: sourceAbsFilepath,
+			toolPath:    compilerPath,
+			toolArgs:    cl.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, compilerPath, compilerArgs, objRelFilepaths[i]),
+		})
 	}
-	return compiled, errors == 0
+	return runCompileJobs(jobs)
 }
 
 // --------------------------------------------------------------------------------------------------
@@ -168,18 +184,20 @@ func (t *ToolchainArduinoEsp8266Archiver) LibFilepath(filepath string) string {
 	return filepath + ".a"
 }
 
-func (a *ToolchainArduinoEsp8266Archiver) SetupArgs() {
+func (t *ToolchainArduinoEsp8266Archiver) RuntimeFilepath(libFilepath string) string {
+	return ""
 }
 
-func (a *ToolchainArduinoEsp8266Archiver) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
-
-	corepkg.LogInfof("Archiving %s", outputArchiveFilepath)
+func (a *ToolchainArduinoEsp8266Archiver) SetupArgs() {
+}
 
+// commandLine returns the archiver and the fully resolved arguments to create the archive
+func (a *ToolchainArduinoEsp8266Archiver) commandLine(inputObjectFilepaths []string, outputArchiveFilepath string) (archiverPath string, archiverArgs []string) {
 	a.vars.Set("archive_file_path", outputArchiveFilepath)
 	a.vars.Set("object_file", inputObjectFilepaths...)
 
-	archiverArgs, _ := a.toolChain.Vars.Get(`recipe.ar.pattern`)
-	archiverPath := archiverArgs[0]
+	archiverArgs, _ = a.toolChain.Vars.Get(`recipe.ar.pattern`)
+	archiverPath = archiverArgs[0]
 	archiverArgs = archiverArgs[1:]
 
 	// Resolve archiverPath and archiverArgs
@@ -190,9 +208,22 @@ func (a *ToolchainArduinoEsp8266Archiver) Archive(inputObjectFilepaths []string,
 	archiverArgs = slices.DeleteFunc(archiverArgs, func(s string) bool {
 		return strings.TrimSpace(s) == ""
 	})
+	return archiverPath, archiverArgs
+}
+
+func (a *ToolchainArduinoEsp8266Archiver) ArgsHash(inputObjectFilepaths []string, outputArchiveFilepath string) []byte {
+	return argumentsHash(a.commandLine(inputObjectFilepaths, outputArchiveFilepath))
+}
+
+func (a *ToolchainArduinoEsp8266Archiver) Archive(inputObjectFilepaths []string, outputArchiveFilepath string) error {
 
-	cmd := exec.Command(archiverPath, archiverArgs...)
+	corepkg.LogInfof("Archiving %s", outputArchiveFilepath)
+
+	archiverPath, archiverArgs := a.commandLine(inputObjectFilepaths, outputArchiveFilepath)
+
+	cmd := exec.Command(archiverPath, a.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, archiverPath, archiverArgs, outputArchiveFilepath)...)
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputArchiveFilepath, out)
 	if err != nil {
 		return corepkg.LogErrorf(err, "Archiving failed")
 	}
@@ -242,9 +273,8 @@ func (l *ToolchainArduinoEsp8266Linker) SetupArgs(libraryPaths []string, library
 	l.vars.Set("build.extra_libs", libraryFiles...)
 }
 
-func (l *ToolchainArduinoEsp8266Linker) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error {
-	corepkg.LogInfof("Linking '%s'...", outputAppRelFilepathNoExt)
-
+// setupLinkVars sets the vars that are used by the pre-link hooks and the link recipe
+func (l *ToolchainArduinoEsp8266Linker) setupLinkVars(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) {
 	l.vars.Set("object_files", inputObjectsAbsFilepaths...)
 	l.vars.Append("object_files", inputArchivesAbsFilepaths...)
 
@@ -255,6 +285,34 @@ func (l *ToolchainArduinoEsp8266Linker) Link(inputObjectsAbsFilepaths, inputArch
 
 	l.toolChain.Vars.Set("build.path", outputDir)
 	l.toolChain.Vars.Set("build.project_name", outputFile)
+}
+
+// commandLine returns the linker and the fully resolved arguments to link the executable
+func (l *ToolchainArduinoEsp8266Linker) commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) (linkerPath string, linkerArgs []string) {
+	l.setupLinkVars(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt)
+
+	linkerArgs, _ = l.toolChain.Vars.Get(`recipe.c.combine.pattern`)
+
+	linkerPath = linkerArgs[0]
+	linkerArgs = linkerArgs[1:]
+
+	// Resolve linkerPath and linkerArgs
+	linkerPath = l.toolChain.Vars.FinalResolveString(linkerPath, " ", l.vars)
+	linkerArgs = l.toolChain.Vars.FinalResolveArray(linkerArgs, l.vars)
+
+	// Remove any empty entries from linkerArgs
+	linkerArgs = slices.DeleteFunc(linkerArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+	return linkerPath, linkerArgs
+}
+
+func (l *ToolchainArduinoEsp8266Linker) ArgsHash(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) []byte {
+	return argumentsHash(l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt))
+}
+
+func (l *ToolchainArduinoEsp8266Linker) Link(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths []string, outputAppRelFilepathNoExt string) error {
+	corepkg.LogInfof("Linking '%s'...", outputAppRelFilepathNoExt)
+
+	l.setupLinkVars(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt)
 
 	// Copy linker script 'eagle.flash.1m64.ld' to 'ld_h/local.eagle.flash.ld.h'
 	//                /Users/obnosis5/Library/Arduino15/packages/esp8266/tools/python3/3.7.2-post1/python3
@@ -383,20 +441,11 @@ func (l *ToolchainArduinoEsp8266Linker) Link(inputObjectsAbsFilepaths, inputArch
 	// -Wl,--end-group
 	// -L/Users/obnosis5/Library/Caches/arduino/sketches/1C5B914ED5195AE28CD84B76EBD00CED
 
-	linkerArgs, _ := l.toolChain.Vars.Get(`recipe.c.combine.pattern`)
-
-	linkerPath := linkerArgs[0]
-	linkerArgs = linkerArgs[1:]
-
-	// Resolve linkerPath and linkerArgs
-	linkerPath = l.toolChain.Vars.FinalResolveString(linkerPath, " ", l.vars)
-	linkerArgs = l.toolChain.Vars.FinalResolveArray(linkerArgs, l.vars)
-
-	// Remove any empty entries from linkerArgs
-	linkerArgs = slices.DeleteFunc(linkerArgs, func(s string) bool { return strings.TrimSpace(s) == "" })
+	linkerPath, linkerArgs := l.commandLine(inputObjectsAbsFilepaths, inputArchivesAbsFilepaths, outputAppRelFilepathNoExt)
 
-	cmd := exec.Command(linkerPath, linkerArgs...)
+	cmd := exec.Command(linkerPath, l.toolChain.ResponseFiles.toolArgs(ResponseFileGnu, linkerPath, linkerArgs, outputAppRelFilepathNoExt)...)
 	out, err := cmd.CombinedOutput()
+	emitToolOutput(outputAppRelFilepathNoExt, out)
 
 	if err != nil {
 		corepkg.LogInfof("Link failed, output:\n%s", string(out))
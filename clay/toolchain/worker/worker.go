@@ -0,0 +1,404 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Distributed compilation, a worker ('clay worker') compiles preprocessed translation units
+// for the Clay clients that connect to it.
+//
+// The client preprocesses a source file locally, so that the worker does not need the
+// headers of the client, and sends the preprocessed source file with the compiler and the
+// compiler arguments that are not about preprocessing. The worker compiles it with the
+// compiler of the same name and version that it finds in its PATH, and sends back the
+// object file and the output (diagnostics) of the compiler.
+//
+// The protocol is Go's net/rpc (gob encoded) over TCP, the service is named 'Worker'.
+// A worker only runs the compiler arguments that change the code that is generated or the
+// diagnostics (see AllowedArg), a request with an argument that names a file, a directory
+// or a program is rejected. A worker still compiles for any client that can connect to it,
+// it should only listen on a trusted network.
+
+// DefaultAddress is the address that a worker listens on by default
+const DefaultAddress = "127.0.0.1:7700"
+
+// DefaultAllow is the list of compilers (globs of the executable name) that a worker runs
+var DefaultAllow = []string{"gcc", "g++", "cc", "c++", "clang", "clang++", "*-gcc", "*-g++", "gcc-*", "g++-*", "clang-*", "clang++-*"}
+
+// deniedArgPrefixes are the -f and -m arguments that read or write files, or that load a
+// program into the compiler, e.g. -fplugin=name loads a plugin from the plugin directory.
+var deniedArgPrefixes = []string{
+	"-fplugin", "-fpass-plugin", "-fuse-ld", "-fdump-", "-fopt-info", "-fsave-optimization-record",
+	"-fcallgraph-info", "-fstack-usage", "-fprofile-", "-fauto-profile", "-fcoverage-", "-ftime-trace",
+	"-fcrash-diagnostics", "-fmodule", "-fdebug-prefix-map", "-ffile-prefix-map", "-fmacro-prefix-map",
+	"-fdebug-compilation-dir", "-fsanitize-blacklist", "-fsanitize-ignorelist", "-fsanitize-coverage-",
+	"-fxray-attr-list", "-fxray-always-instrument", "-fxray-never-instrument", "-fbasic-block-sections=list",
+}
+
+// AllowedArg returns true when a compiler argument may be sent to a worker, these are -c,
+// -std=, the optimization (-O), debug (-g) and warning (-W, -w, -pedantic) arguments and
+// the code generation arguments (-f, -m) that do not name a file, a directory or a program.
+func AllowedArg(arg string) bool {
+	if strings.ContainsAny(arg, `/\`) {
+		return false
+	}
+	switch {
+	case arg == "-c", arg == "-w", arg == "-pedantic", arg == "-pedantic-errors", arg == "-pthread", arg == "-ansi":
+		return true
+	case strings.HasPrefix(arg, "-std="), strings.HasPrefix(arg, "-O"), strings.HasPrefix(arg, "-g"):
+		return true
+	case strings.HasPrefix(arg, "-W"):
+		// -Wa, -Wl and -Wp pass arguments on to the assembler, the linker and the preprocessor
+		return !strings.HasPrefix(arg, "-Wa,") && !strings.HasPrefix(arg, "-Wl,") && !strings.HasPrefix(arg, "-Wp,")
+	case strings.HasPrefix(arg, "-f"), strings.HasPrefix(arg, "-m"):
+		for _, prefix := range deniedArgPrefixes {
+			if strings.HasPrefix(arg, prefix) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// CompileRequest is a preprocessed translation unit to compile
+type CompileRequest struct {
+	Compiler        string   // The name of the compiler executable, e.g. 'g++' or 'aarch64-linux-gnu-gcc'
+	CompilerVersion string   // The first line of 'compiler --version' on the client
+	Args            []string // The compiler arguments without the source file and the output file (-o)
+	Language        string   // The language of the source file, 'c' or 'c++'
+	Source          []byte   // The preprocessed source file
+	Dirpath         string   // The working directory of the client, the debug information refers to it
+}
+
+// CompileReply is the result of compiling a translation unit
+type CompileReply struct {
+	Object   []byte // The object file, empty when the compile failed
+	Output   string // The output of the compiler (diagnostics)
+	ExitCode int    // The exit code of the compiler, 0 = success
+}
+
+// Service is the RPC service of a worker
+type Service struct {
+	jobs  chan struct{} // Bounds the number of compilers that run concurrently
+	allow []string      // Globs of the compiler executable names that are allowed
+
+	versionsMutex sync.Mutex
+	versions      map[string]string // The version of every compiler executable that was used
+}
+
+// NewService returns a service that runs at most jobs compilers concurrently (0 = the
+// number of CPUs), an empty allow uses DefaultAllow.
+func NewService(jobs int, allow []string) *Service {
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+	if len(allow) == 0 {
+		allow = DefaultAllow
+	}
+	return &Service{jobs: make(chan struct{}, jobs), allow: allow, versions: map[string]string{}}
+}
+
+// Compile compiles the preprocessed source file of the request, a compile error is not an
+// error of the call, it is reported by the exit code and the output of the reply.
+func (s *Service) Compile(request *CompileRequest, reply *CompileReply) error {
+	for _, arg := range request.Args {
+		if !AllowedArg(arg) {
+			return fmt.Errorf("compiler argument %q is not allowed on this worker", arg)
+		}
+	}
+
+	compilerPath, err := s.compilerPath(request.Compiler, request.CompilerVersion)
+	if err != nil {
+		return err
+	}
+
+	s.jobs <- struct{}{}
+	defer func() { <-s.jobs }()
+
+	dirpath, err := os.MkdirTemp("", "clay-worker-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dirpath)
+
+	inputFilename := "input.ii"
+	if request.Language == "c" {
+		inputFilename = "input.i"
+	}
+	if err := os.WriteFile(filepath.Join(dirpath, inputFilename), request.Source, 0644); err != nil {
+		return err
+	}
+
+	args := append([]string{}, request.Args...)
+	if len(request.Dirpath) > 0 {
+		args = append(args, "-fdebug-prefix-map="+dirpath+"="+request.Dirpath)
+	}
+	args = append(args, "-o", "output.o", inputFilename)
+	cmd := exec.Command(compilerPath, args...)
+	cmd.Dir = dirpath
+	out, err := cmd.CombinedOutput()
+	reply.Output = string(out)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return err
+		}
+		reply.ExitCode = exitErr.ExitCode()
+		return nil
+	}
+
+	reply.Object, err = os.ReadFile(filepath.Join(dirpath, "output.o"))
+	return err
+}
+
+// compilerPath returns the path of an allowed compiler, which has to be the same version
+// as the compiler of the client.
+func (s *Service) compilerPath(name string, version string) (string, error) {
+	if name != filepath.Base(name) || !s.allowed(name) {
+		return "", fmt.Errorf("compiler %q is not allowed on this worker", name)
+	}
+	compilerPath, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	s.versionsMutex.Lock()
+	defer s.versionsMutex.Unlock()
+	workerVersion, ok := s.versions[compilerPath]
+	if !ok {
+		if workerVersion, err = CompilerVersion(compilerPath); err != nil {
+			return "", err
+		}
+		s.versions[compilerPath] = workerVersion
+	}
+	if workerVersion != version {
+		return "", fmt.Errorf("compiler %q of this worker is %q, not %q", name, workerVersion, version)
+	}
+	return compilerPath, nil
+}
+
+func (s *Service) allowed(name string) bool {
+	name = strings.TrimSuffix(name, ".exe")
+	for _, pattern := range s.allow {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// CompilerVersion returns the first line of 'compiler --version', compilers of which it
+// is the same are assumed to produce the same object files.
+func CompilerVersion(compilerPath string) (string, error) {
+	out, err := exec.Command(compilerPath, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the version of %q: %w", compilerPath, err)
+	}
+	version, _, _ := strings.Cut(string(bytes.TrimSpace(out)), "\n")
+	return strings.TrimSpace(version), nil
+}
+
+// Serve serves the compile requests of the connections of the listener until the listener
+// is closed.
+func Serve(listener net.Listener, service *Service) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Worker", service); err != nil {
+		return err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// ErrNoWorkers is returned by Pool.Compile when none of the workers can be used
+var ErrNoWorkers = errors.New("no workers available")
+
+// Pool distributes compile requests over the workers, a request goes to the worker with
+// the fewest requests in flight. A worker that fails a request, or cannot be reached, is
+// not used for the rest of the build and the request is sent to another worker.
+type Pool struct {
+	DialTimeout time.Duration                   // Time to connect to a worker
+	CallTimeout time.Duration                   // Time to wait for the reply of a worker
+	OnDown      func(address string, err error) // Called once for every worker that is no longer used (nil = ignore)
+
+	mutex    sync.Mutex
+	workers  []*poolWorker
+	compiled int // Number of files compiled successfully by the workers
+}
+
+type poolWorker struct {
+	address  string
+	inflight int
+	down     bool
+
+	dialMutex sync.Mutex
+	client    *rpc.Client
+}
+
+// NewPool returns a pool of the workers at the addresses (host:port), it connects to a
+// worker when it is first used.
+func NewPool(addresses []string) *Pool {
+	p := &Pool{DialTimeout: 5 * time.Second, CallTimeout: 5 * time.Minute}
+	for _, address := range addresses {
+		p.workers = append(p.workers, &poolWorker{address: address})
+	}
+	return p
+}
+
+// ParseAddresses parses a comma separated list of worker addresses, a port is required
+func ParseAddresses(value string) ([]string, error) {
+	addresses := []string{}
+	for _, address := range strings.Split(value, ",") {
+		address = strings.TrimSpace(address)
+		if len(address) == 0 {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid worker address %q, expected host:port", address)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// Compile sends the request to a worker, it returns the reply and the address of the
+// worker. A compile error is not an error, it is reported by the exit code of the reply.
+// ErrNoWorkers is returned when all the workers failed.
+func (p *Pool) Compile(request *CompileRequest) (*CompileReply, string, error) {
+	for {
+		w := p.acquire()
+		if w == nil {
+			return nil, "", ErrNoWorkers
+		}
+		reply, err := p.call(w, request)
+		p.release(w)
+		if err != nil {
+			p.markDown(w, err)
+			continue
+		}
+
+		if reply.ExitCode == 0 {
+			p.mutex.Lock()
+			p.compiled++
+			p.mutex.Unlock()
+		}
+		return reply, w.address, nil
+	}
+}
+
+// call sends the request to the worker and waits for the reply
+func (p *Pool) call(w *poolWorker, request *CompileRequest) (*CompileReply, error) {
+	client, err := w.connect(p.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	reply := &CompileReply{}
+	call := client.Go("Worker.Compile", request, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return reply, call.Error
+	case <-time.After(p.CallTimeout):
+		return nil, fmt.Errorf("no reply after %s", p.CallTimeout)
+	}
+}
+
+// Available returns true when at least one of the workers is still used
+func (p *Pool) Available() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, w := range p.workers {
+		if !w.down {
+			return true
+		}
+	}
+	return false
+}
+
+// Compiled returns the number of files that the workers compiled successfully
+func (p *Pool) Compiled() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.compiled
+}
+
+// Close closes the connections to the workers
+func (p *Pool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, w := range p.workers {
+		w.dialMutex.Lock()
+		if w.client != nil {
+			w.client.Close()
+			w.client = nil
+		}
+		w.dialMutex.Unlock()
+	}
+	return nil
+}
+
+// acquire returns the worker with the fewest requests in flight, nil when all workers are down
+func (p *Pool) acquire() *poolWorker {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var best *poolWorker
+	for _, w := range p.workers {
+		if !w.down && (best == nil || w.inflight < best.inflight) {
+			best = w
+		}
+	}
+	if best != nil {
+		best.inflight++
+	}
+	return best
+}
+
+func (p *Pool) release(w *poolWorker) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	w.inflight--
+}
+
+// markDown stops using the worker, OnDown is called when the worker was still in use
+func (p *Pool) markDown(w *poolWorker, err error) {
+	p.mutex.Lock()
+	wasDown := w.down
+	w.down = true
+	p.mutex.Unlock()
+	if !wasDown && p.OnDown != nil {
+		p.OnDown(w.address, err)
+	}
+}
+
+// connect returns the connection to the worker, it connects on first use
+func (w *poolWorker) connect(timeout time.Duration) (*rpc.Client, error) {
+	w.dialMutex.Lock()
+	defer w.dialMutex.Unlock()
+	if w.client == nil {
+		conn, err := net.DialTimeout("tcp", w.address, timeout)
+		if err != nil {
+			return nil, err
+		}
+		w.client = rpc.NewClient(conn)
+	}
+	return w.client, nil
+}
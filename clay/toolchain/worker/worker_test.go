@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func startTestWorker(t *testing.T, allow []string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go Serve(listener, NewService(2, allow))
+	return listener.Addr().String()
+}
+
+func TestWorkerCompile(t *testing.T) {
+	compilerPath, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("gcc is not available")
+	}
+	version, err := CompilerVersion(compilerPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewPool([]string{startTestWorker(t, nil)})
+	defer pool.Close()
+	down := []string{}
+	pool.OnDown = func(address string, err error) { down = append(down, address) }
+
+	request := &CompileRequest{Compiler: "gcc", CompilerVersion: version, Args: []string{"-c", "-O1"}, Language: "c", Source: []byte("int add(int a, int b) { return a + b; }\n")}
+	reply, _, err := pool.Compile(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.ExitCode != 0 || len(reply.Object) == 0 {
+		t.Fatalf("expected an object file, got exit code %d and output %q", reply.ExitCode, reply.Output)
+	}
+
+	// A compile error is a reply, the worker is still used
+	request.Source = []byte("int add(int a, int b) { return a + ; }\n")
+	if reply, _, err = pool.Compile(request); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ExitCode == 0 || !strings.Contains(reply.Output, "error") {
+		t.Errorf("expected a compile error, got exit code %d and output %q", reply.ExitCode, reply.Output)
+	}
+	if compiled := pool.Compiled(); compiled != 1 {
+		t.Errorf("expected 1 compiled file, got %d", compiled)
+	}
+
+	// Another compiler version is an error, after which the worker is no longer used
+	request.CompilerVersion = "gcc 0.1"
+	if _, _, err = pool.Compile(request); !errors.Is(err, ErrNoWorkers) {
+		t.Errorf("expected no workers after a version mismatch, got %v", err)
+	}
+	if len(down) != 1 || pool.Available() {
+		t.Errorf("expected the worker to be down once, got %v", down)
+	}
+}
+
+func TestWorkerAllow(t *testing.T) {
+	pool := NewPool([]string{startTestWorker(t, []string{"clang*"})})
+	defer pool.Close()
+	var downErr error
+	pool.OnDown = func(address string, err error) { downErr = err }
+	if _, _, err := pool.Compile(&CompileRequest{Compiler: "gcc"}); !errors.Is(err, ErrNoWorkers) || downErr == nil || !strings.Contains(downErr.Error(), "not allowed") {
+		t.Errorf("expected gcc not to be allowed, got %v", downErr)
+	}
+
+	service := NewService(1, nil)
+	for _, name := range []string{"gcc", "g++", "aarch64-linux-gnu-g++", "clang++-17", "gcc.exe"} {
+		if !service.allowed(name) {
+			t.Errorf("expected %s to be allowed", name)
+		}
+	}
+	for _, name := range []string{"sh", "python3", "ld"} {
+		if service.allowed(name) {
+			t.Errorf("expected %s not to be allowed", name)
+		}
+	}
+}
+
+func TestWorkerAllowedArgs(t *testing.T) {
+	for _, arg := range []string{"-c", "-std=c++17", "-O2", "-g", "-Wall", "-Wno-unused", "-Werror=format", "-fPIC", "-fvisibility=hidden", "-fsanitize=address", "-march=native", "-pthread"} {
+		if !AllowedArg(arg) {
+			t.Errorf("expected %s to be allowed", arg)
+		}
+	}
+	for _, arg := range []string{"-wrapper", "-fplugin=evil", "-specs=evil.specs", "-B/tmp", "-MF", "-save-temps", "-dumpdir", "-o", "@args.txt", "-fdebug-prefix-map=a=b", "-fprofile-generate", "-Wl,-rpath", "-Wp,-MD,deps.d", "-I/usr/include", "/etc/passwd"} {
+		if AllowedArg(arg) {
+			t.Errorf("expected %s not to be allowed", arg)
+		}
+	}
+
+	// A request with an argument that is not allowed is rejected before anything is run
+	pool := NewPool([]string{startTestWorker(t, nil)})
+	defer pool.Close()
+	var downErr error
+	pool.OnDown = func(address string, err error) { downErr = err }
+	if _, _, err := pool.Compile(&CompileRequest{Compiler: "gcc", Args: []string{"-c", "-wrapper", "sh,-c,id"}}); !errors.Is(err, ErrNoWorkers) || downErr == nil || !strings.Contains(downErr.Error(), "not allowed") {
+		t.Errorf("expected -wrapper not to be allowed, got %v", downErr)
+	}
+}
+
+func TestPoolUnreachableWorker(t *testing.T) {
+	// A listener that is closed again leaves an address that nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	// The request goes to the other worker after the unreachable one, which does not know the compiler
+	pool := NewPool([]string{address, startTestWorker(t, nil)})
+	down := []string{}
+	pool.OnDown = func(address string, err error) { down = append(down, address) }
+	if _, replyAddress, err := pool.Compile(&CompileRequest{Compiler: "no-such-compiler"}); !errors.Is(err, ErrNoWorkers) || replyAddress != "" {
+		t.Errorf("expected both workers to fail, got %v", err)
+	}
+	if len(down) != 2 || down[0] != address {
+		t.Errorf("expected the unreachable worker to be down first, got %v", down)
+	}
+	if pool.Available() {
+		t.Errorf("expected no available workers")
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	addresses, err := ParseAddresses("127.0.0.1:7700, build-2:7701,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 2 || addresses[0] != "127.0.0.1:7700" || addresses[1] != "build-2:7701" {
+		t.Errorf("unexpected addresses %v", addresses)
+	}
+	if _, err := ParseAddresses("build-2"); err == nil {
+		t.Errorf("expected an address without a port to fail")
+	}
+}
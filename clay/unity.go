@@ -0,0 +1,152 @@
+package clay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jurgen-kluft/ccode/clay/toolchain"
+	corepkg "github.com/jurgen-kluft/go-core"
+)
+
+// DefaultUnityFiles is the number of unity translation units of a project when --unity is
+// given without a number.
+const DefaultUnityFiles = 8
+
+// unityFlag is the value of the --unity[=N] flag, it can be given without a value.
+type unityFlag struct {
+	files *int
+}
+
+func (f unityFlag) String() string {
+	if f.files == nil {
+		return "0"
+	}
+	return strconv.Itoa(*f.files)
+}
+
+func (f unityFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*f.files = DefaultUnityFiles
+	case "false":
+		*f.files = 0
+	default:
+		files, err := strconv.Atoi(value)
+		if err != nil || files < 0 {
+			return fmt.Errorf("invalid number of unity files %q", value)
+		}
+		*f.files = files
+	}
+	return nil
+}
+
+func (f unityFlag) IsBoolFlag() bool { return true }
+
+// isUnitySourceFile returns true when a source file can be part of a unity translation unit,
+// these are the C++ source files that do not match any of the exclude globs.
+func isUnitySourceFile(src SourceFile, excludeGlobs []string) bool {
+	switch filepath.Ext(src.SrcAbsPath) {
+	case ".cpp", ".cc", ".cxx", ".c++":
+	default:
+		return false
+	}
+	relPath := corepkg.PathNormalize(src.SrcRelPath)
+	for _, glob := range excludeGlobs {
+		if corepkg.GlobMatching(relPath, corepkg.PathNormalize(glob)) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupUnitySourceFiles divides the source files in at most numUnits groups of about the same
+// size, the source files are ordered by directory and path and each group is a contiguous
+// range of them, so that the grouping is deterministic and files of the same directory end up
+// in the same group. sizeOf returns the size of a source file.
+func groupUnitySourceFiles(sources []SourceFile, numUnits int, sizeOf func(src SourceFile) int64) [][]SourceFile {
+	if numUnits <= 0 || len(sources) == 0 {
+		return nil
+	}
+
+	sources = slices.Clone(sources)
+	slices.SortFunc(sources, func(a, b SourceFile) int {
+		if c := strings.Compare(filepath.Dir(a.SrcRelPath), filepath.Dir(b.SrcRelPath)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.SrcRelPath, b.SrcRelPath)
+	})
+
+	sizes := make([]int64, len(sources))
+	totalSize := int64(0)
+	for i, src := range sources {
+		sizes[i] = max(sizeOf(src), 1)
+		totalSize += sizes[i]
+	}
+	unitSize := (totalSize + int64(numUnits) - 1) / int64(numUnits)
+
+	groups := make([][]SourceFile, 0, numUnits)
+	groupSize := int64(0)
+	for i, src := range sources {
+		if len(groups) == 0 || (groupSize+sizes[i] > unitSize && groupSize > 0 && len(groups) < numUnits) {
+			groups = append(groups, []SourceFile{})
+			groupSize = 0
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], src)
+		groupSize += sizes[i]
+	}
+	return groups
+}
+
+// unitySourceFiles returns the source files to compile in unity mode, the C++ source files
+// are combined into generated unity translation units (in '<build>/unity') that include
+// them, the other source files are compiled as they are. A generated file is only written
+// when its content changes, the dependency tracker then tracks the included source files
+// of the object file of a unity translation unit like headers.
+func (p *Project) unitySourceFiles(projectBuildPath string, numUnits int) ([]SourceFile, error) {
+	sources := make([]SourceFile, 0, len(p.SourceFiles))
+	unitySources := make([]SourceFile, 0, len(p.SourceFiles))
+	for _, src := range p.SourceFiles {
+		if isUnitySourceFile(src, p.UnityExclude) {
+			unitySources = append(unitySources, src)
+		} else {
+			sources = append(sources, src)
+		}
+	}
+
+	// A unity translation unit of a single source file only adds an indirection
+	if len(unitySources) < 2 {
+		return p.SourceFiles, nil
+	}
+
+	sizeOf := func(src SourceFile) int64 {
+		if info, err := os.Stat(src.SrcAbsPath); err == nil {
+			return info.Size()
+		}
+		return 0
+	}
+
+	unityDirpath := filepath.Join(projectBuildPath, "unity")
+	for i, group := range groupUnitySourceFiles(unitySources, numUnits, sizeOf) {
+		content := strings.Builder{}
+		fmt.Fprintf(&content, "// Generated by clay, unity translation unit %d of project %s\n", i, p.DevProject.Name)
+		for _, src := range group {
+			fmt.Fprintf(&content, "#include \"%s\"\n", filepath.ToSlash(src.SrcAbsPath))
+		}
+
+		unityRelFilepath := filepath.Join("unity", fmt.Sprintf("unity_%d.cpp", i))
+		unityFilepath := filepath.Join(unityDirpath, fmt.Sprintf("unity_%d.cpp", i))
+		if err := toolchain.WriteGeneratedFile(unityFilepath, content.String()); err != nil {
+			return nil, err
+		}
+		unityAbsFilepath, err := filepath.Abs(unityFilepath)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, SourceFile{SrcAbsPath: unityAbsFilepath, SrcRelPath: unityRelFilepath})
+	}
+	return sources, nil
+}
@@ -0,0 +1,109 @@
+package clay
+
+import (
+	"slices"
+	"testing"
+
+	corepkg "github.com/jurgen-kluft/go-core"
+	"github.com/jurgen-kluft/go-ide/denv"
+)
+
+func TestGroupUnitySourceFiles(t *testing.T) {
+	sources := []SourceFile{
+		{SrcAbsPath: "/src/b/y.cpp", SrcRelPath: "b/y.cpp"},
+		{SrcAbsPath: "/src/a/x.cpp", SrcRelPath: "a/x.cpp"},
+		{SrcAbsPath: "/src/b/z.cpp", SrcRelPath: "b/z.cpp"},
+		{SrcAbsPath: "/src/a/w.cpp", SrcRelPath: "a/w.cpp"},
+	}
+	sizes := map[string]int64{"a/w.cpp": 100, "a/x.cpp": 100, "b/y.cpp": 100, "b/z.cpp": 100}
+	sizeOf := func(src SourceFile) int64 { return sizes[src.SrcRelPath] }
+
+	names := func(groups [][]SourceFile) [][]string {
+		result := [][]string{}
+		for _, group := range groups {
+			names := []string{}
+			for _, src := range group {
+				names = append(names, src.SrcRelPath)
+			}
+			result = append(result, names)
+		}
+		return result
+	}
+
+	groups := names(groupUnitySourceFiles(sources, 2, sizeOf))
+	expected := [][]string{{"a/w.cpp", "a/x.cpp"}, {"b/y.cpp", "b/z.cpp"}}
+	if !slices.EqualFunc(groups, expected, slices.Equal) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+
+	// The order of the source files does not change the grouping
+	slices.Reverse(sources)
+	if reversed := names(groupUnitySourceFiles(sources, 2, sizeOf)); !slices.EqualFunc(reversed, expected, slices.Equal) {
+		t.Errorf("expected %v, got %v", expected, reversed)
+	}
+
+	// A large file gets a unit of its own, the number of units is never exceeded
+	sizes["a/w.cpp"] = 1000
+	groups = names(groupUnitySourceFiles(sources, 3, sizeOf))
+	expected = [][]string{{"a/w.cpp"}, {"a/x.cpp", "b/y.cpp", "b/z.cpp"}}
+	if !slices.EqualFunc(groups, expected, slices.Equal) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+	if groups := groupUnitySourceFiles(sources, 1, sizeOf); len(groups) != 1 || len(groups[0]) != 4 {
+		t.Errorf("expected a single unit with all source files, got %v", names(groups))
+	}
+}
+
+func TestIsUnitySourceFile(t *testing.T) {
+	exclude := []string{"**/platform_*.cpp"}
+	tests := map[string]bool{
+		"main.cpp":              true,
+		"core/string.cc":        true,
+		"core/c_api.c":          false,
+		"mac/window.mm":         false,
+		"mac/window.m":          false,
+		"os/platform_linux.cpp": false,
+	}
+	for relPath, expected := range tests {
+		src := SourceFile{SrcAbsPath: "/src/" + relPath, SrcRelPath: relPath}
+		if isUnitySourceFile(src, exclude) != expected {
+			t.Errorf("expected isUnitySourceFile(%q) to be %v", relPath, expected)
+		}
+	}
+}
+
+func TestUnitySettings(t *testing.T) {
+	vars := corepkg.NewVars(corepkg.VarsFormatCurlyBraces)
+	vars.Set("lib.unity", "4")
+	vars.Set("lib.unity.exclude", "platform/*.cpp, **/simd_*.cpp")
+
+	tests := []struct {
+		project  string
+		flag     string // Value of --unity (empty = not given)
+		expected int
+	}{
+		{"lib", "", 4},
+		{"lib", "2", 2},
+		{"lib", "0", 0},
+		{"lib", "true", DefaultUnityFiles},
+		{"app", "", 0},
+		{"app", "true", DefaultUnityFiles},
+	}
+	for _, test := range tests {
+		app := NewApp(nil)
+		if len(test.flag) > 0 {
+			if err := (unityFlag{files: &app.Options.Unity}).Set(test.flag); err != nil {
+				t.Fatal(err)
+			}
+		}
+		prj := newTestProject(test.project, denv.BuildTypeStaticLibrary)
+		prj.SetUnity(vars)
+		app.applyUnityOptions(prj)
+		if prj.Unity != test.expected {
+			t.Errorf("%s with --unity=%q: expected %d unity files, got %d", test.project, test.flag, test.expected, prj.Unity)
+		}
+		if test.project == "lib" && !slices.Equal(prj.UnityExclude, []string{"platform/*.cpp", "**/simd_*.cpp"}) {
+			t.Errorf("expected the exclude globs of the package, got %v", prj.UnityExclude)
+		}
+	}
+}